@@ -11,6 +11,9 @@ import (
 // algorithm is applied.  The same algorithm applies regardless of the
 // direction of the translation, e.g., the same steps are applied to
 // mask the data as to unmask the data.
+//
+// On amd64 and arm64 large payloads are processed by SIMD instructions, unless
+// the purego build tag is set.
 func Cipher(payload []byte, mask [4]byte, offset int) {
 	n := len(payload)
 	if n < 8 {
@@ -22,6 +25,20 @@ func Cipher(payload []byte, mask [4]byte, offset int) {
 
 	// Calculate position in mask due to previously processed bytes number.
 	mpos := offset % 4
+
+	if n >= cipherVectorMin && cipherVector != nil {
+		// Rotate the mask to make it applicable from the first byte.
+		key := uint32(mask[mpos]) |
+			uint32(mask[(mpos+1)%4])<<8 |
+			uint32(mask[(mpos+2)%4])<<16 |
+			uint32(mask[(mpos+3)%4])<<24
+		if k := cipherVector(payload, key); k > 0 {
+			// Number of processed bytes is a multiple of 4, thus position
+			// in mask is the same for the rest of payload.
+			Cipher(payload[k:], mask, mpos)
+			return
+		}
+	}
 	// Count number of bytes will processed one by one from the beginning of payload.
 	ln := remain[mpos]
 	// Count number of bytes will processed one by one from the end of payload.
@@ -56,6 +73,15 @@ func Cipher(payload []byte, mask [4]byte, offset int) {
 	}
 }
 
+// cipherVectorMin is the minimum payload size processed by cipherVector.
+const cipherVectorMin = 64
+
+// cipherVector is a platform-specific implementation of XOR cipher. It ciphers
+// some prefix of payload with little-endian key applied from the first byte
+// and returns the length of that prefix, which is a multiple of 4. It is nil
+// if there is no such implementation.
+var cipherVector func(payload []byte, key uint32) int
+
 // remain maps position in masking key [0,4) to number
 // of bytes that need to be processed manually inside Cipher().
 var remain = [4]int{0, 3, 2, 1}
@@ -0,0 +1,30 @@
+//go:build !purego
+// +build !purego
+
+package ws
+
+import "github.com/gobwas/ws/internal/cpu"
+
+// cipherVectors lists available implementations of cipherVector by name.
+var cipherVectors = map[string]func([]byte, uint32) int{
+	"sse2": cipherSSE2,
+}
+
+func init() {
+	cipherVector = cipherSSE2
+	if cpu.HasAVX2 {
+		cipherVectors["avx2"] = cipherAVX2
+		cipherVector = cipherAVX2
+	}
+}
+
+// cipherSSE2 ciphers payload by 16 bytes blocks. SSE2 is always available on
+// amd64.
+//
+//go:noescape
+func cipherSSE2(payload []byte, key uint32) int
+
+// cipherAVX2 ciphers payload by 32 bytes blocks.
+//
+//go:noescape
+func cipherAVX2(payload []byte, key uint32) int
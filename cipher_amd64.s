@@ -0,0 +1,84 @@
+//go:build !purego
+// +build !purego
+
+#include "textflag.h"
+
+// func cipherSSE2(payload []byte, key uint32) int
+TEXT ·cipherSSE2(SB), NOSPLIT, $0-40
+	MOVQ payload_base+0(FP), SI
+	MOVQ payload_len+8(FP), CX
+	MOVL key+24(FP), AX
+	ANDQ $-16, CX
+	MOVQ CX, ret+32(FP)
+	MOVQ AX, X0
+	PSHUFD $0, X0, X0
+	XORQ BX, BX
+
+loop64:
+	LEAQ 64(BX), DX
+	CMPQ DX, CX
+	JA   loop16
+	MOVOU 0(SI)(BX*1), X1
+	MOVOU 16(SI)(BX*1), X2
+	MOVOU 32(SI)(BX*1), X3
+	MOVOU 48(SI)(BX*1), X4
+	PXOR  X0, X1
+	PXOR  X0, X2
+	PXOR  X0, X3
+	PXOR  X0, X4
+	MOVOU X1, 0(SI)(BX*1)
+	MOVOU X2, 16(SI)(BX*1)
+	MOVOU X3, 32(SI)(BX*1)
+	MOVOU X4, 48(SI)(BX*1)
+	MOVQ  DX, BX
+	JMP   loop64
+
+loop16:
+	CMPQ  BX, CX
+	JAE   done
+	MOVOU (SI)(BX*1), X1
+	PXOR  X0, X1
+	MOVOU X1, (SI)(BX*1)
+	ADDQ  $16, BX
+	JMP   loop16
+
+done:
+	RET
+
+// func cipherAVX2(payload []byte, key uint32) int
+TEXT ·cipherAVX2(SB), NOSPLIT, $0-40
+	MOVQ payload_base+0(FP), SI
+	MOVQ payload_len+8(FP), CX
+	MOVL key+24(FP), AX
+	ANDQ $-32, CX
+	MOVQ CX, ret+32(FP)
+	MOVQ AX, X0
+	VPBROADCASTD X0, Y0
+	XORQ BX, BX
+
+loop128:
+	LEAQ 128(BX), DX
+	CMPQ DX, CX
+	JA   loop32
+	VPXOR   0(SI)(BX*1), Y0, Y1
+	VPXOR   32(SI)(BX*1), Y0, Y2
+	VPXOR   64(SI)(BX*1), Y0, Y3
+	VPXOR   96(SI)(BX*1), Y0, Y4
+	VMOVDQU Y1, 0(SI)(BX*1)
+	VMOVDQU Y2, 32(SI)(BX*1)
+	VMOVDQU Y3, 64(SI)(BX*1)
+	VMOVDQU Y4, 96(SI)(BX*1)
+	MOVQ    DX, BX
+	JMP     loop128
+
+loop32:
+	CMPQ    BX, CX
+	JAE     done
+	VPXOR   (SI)(BX*1), Y0, Y1
+	VMOVDQU Y1, (SI)(BX*1)
+	ADDQ    $32, BX
+	JMP     loop32
+
+done:
+	VZEROUPPER
+	RET
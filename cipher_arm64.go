@@ -0,0 +1,19 @@
+//go:build !purego
+// +build !purego
+
+package ws
+
+// cipherVectors lists available implementations of cipherVector by name.
+var cipherVectors = map[string]func([]byte, uint32) int{
+	"neon": cipherNEON,
+}
+
+func init() {
+	// NEON is always available on arm64.
+	cipherVector = cipherNEON
+}
+
+// cipherNEON ciphers payload by 16 bytes blocks.
+//
+//go:noescape
+func cipherNEON(payload []byte, key uint32) int
@@ -0,0 +1,37 @@
+//go:build !purego
+// +build !purego
+
+#include "textflag.h"
+
+// func cipherNEON(payload []byte, key uint32) int
+TEXT ·cipherNEON(SB), NOSPLIT, $0-40
+	MOVD  payload_base+0(FP), R0
+	MOVD  payload_len+8(FP), R1
+	MOVWU key+24(FP), R2
+	AND   $~15, R1, R1
+	MOVD  R1, ret+32(FP)
+	VDUP  R2, V0.S4
+	AND   $~63, R1, R3
+	SUB   R3, R1, R1
+	CBZ   R3, loop16
+
+loop64:
+	VLD1   (R0), [V1.B16, V2.B16, V3.B16, V4.B16]
+	VEOR   V0.B16, V1.B16, V1.B16
+	VEOR   V0.B16, V2.B16, V2.B16
+	VEOR   V0.B16, V3.B16, V3.B16
+	VEOR   V0.B16, V4.B16, V4.B16
+	VST1.P [V1.B16, V2.B16, V3.B16, V4.B16], 64(R0)
+	SUBS   $64, R3, R3
+	BNE    loop64
+
+loop16:
+	CBZ    R1, done
+	VLD1   (R0), [V1.B16]
+	VEOR   V0.B16, V1.B16, V1.B16
+	VST1.P [V1.B16], 16(R0)
+	SUB    $16, R1, R1
+	B      loop16
+
+done:
+	RET
@@ -0,0 +1,7 @@
+//go:build purego || (!amd64 && !arm64)
+// +build purego !amd64,!arm64
+
+package ws
+
+// cipherVectors lists available implementations of cipherVector by name.
+var cipherVectors map[string]func([]byte, uint32) int
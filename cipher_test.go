@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math/rand"
 	"reflect"
+	"sort"
 	"testing"
 )
 
@@ -28,7 +29,9 @@ func TestCipher(t *testing.T) {
 	}
 	for offset := 0; offset < 4; offset++ {
 		for tail := 0; tail < 8; tail++ {
-			for b64 := 0; b64 < 3; b64++ {
+			// Large numbers of words make payloads processed by SIMD
+			// implementations with unaligned lengths.
+			for _, b64 := range []int{0, 1, 2, 7, 8, 15, 16, 17, 33} {
 				var (
 					ln = remain[offset]
 					rn = tail
@@ -49,25 +52,33 @@ func TestCipher(t *testing.T) {
 			}
 		}
 	}
-	for _, test := range cases {
-		t.Run(test.name, func(t *testing.T) {
-			// naive implementation of xor-cipher
-			exp := cipherNaive(test.in, test.mask, test.offset)
-
-			res := make([]byte, len(test.in))
-			copy(res, test.in)
-			Cipher(res, test.mask, test.offset)
-
-			if !reflect.DeepEqual(res, exp) {
-				t.Errorf("Cipher(%v, %v):\nact:\t%v\nexp:\t%v\n", test.in, test.mask, res, exp)
-			}
-		})
-	}
+	cipherImpls(func(impl string) {
+		for _, test := range cases {
+			t.Run(impl+"/"+test.name, func(t *testing.T) {
+				// naive implementation of xor-cipher
+				exp := cipherNaive(test.in, test.mask, test.offset)
+
+				res := make([]byte, len(test.in))
+				copy(res, test.in)
+				Cipher(res, test.mask, test.offset)
+
+				if !reflect.DeepEqual(res, exp) {
+					t.Errorf("Cipher(%v, %v):\nact:\t%v\nexp:\t%v\n", test.in, test.mask, res, exp)
+				}
+			})
+		}
+	})
 }
 
 func TestCipherChops(t *testing.T) {
+	cipherImpls(func(impl string) {
+		testCipherChops(t, impl)
+	})
+}
+
+func testCipherChops(t *testing.T, impl string) {
 	for n := 2; n <= 1024; n <<= 1 {
-		t.Run(fmt.Sprintf("%d", n), func(t *testing.T) {
+		t.Run(fmt.Sprintf("%s/%d", impl, n), func(t *testing.T) {
 			p := make([]byte, n)
 			b := make([]byte, n)
 			var m [4]byte
@@ -177,19 +188,42 @@ func BenchmarkCipher(b *testing.B) {
 			}
 			sinkValue(sink)
 		})
-		b.Run(fmt.Sprintf("bytes=%d;offset=%d", bench.size, bench.offset), func(b *testing.B) {
-			var sink int64
-			b.SetBytes(int64(bench.size))
-			b.ResetTimer()
-			for i := 0; i < b.N; i++ {
-				Cipher(bts, mask, bench.offset)
-				sink += int64(len(bts))
-			}
-			sinkValue(sink)
+		cipherImpls(func(impl string) {
+			b.Run(fmt.Sprintf("%s_bytes=%d;offset=%d", impl, bench.size, bench.offset), func(b *testing.B) {
+				var sink int64
+				b.SetBytes(int64(bench.size))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					Cipher(bts, mask, bench.offset)
+					sink += int64(len(bts))
+				}
+				sinkValue(sink)
+			})
 		})
 	}
 }
 
+// cipherImpls calls f for each available implementation of Cipher(), making
+// it used by Cipher() during the call.
+func cipherImpls(f func(impl string)) {
+	defer func(v func([]byte, uint32) int) {
+		cipherVector = v
+	}(cipherVector)
+
+	cipherVector = nil
+	f("generic")
+
+	names := make([]string, 0, len(cipherVectors))
+	for name := range cipherVectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		cipherVector = cipherVectors[name]
+		f(name)
+	}
+}
+
 // sinkValue makes variable used and prevents dead code elimination.
 func sinkValue(v int64) {
 	if r := rand.Float32(); r > 2 {
@@ -16,6 +16,7 @@ import (
 
 	"github.com/gobwas/httphead"
 	"github.com/gobwas/pool/pbufio"
+	"github.com/gobwas/pool/pbytes"
 )
 
 // Constants used by Dialer.
@@ -31,6 +32,27 @@ type Handshake struct {
 
 	// Extensions is the list of negotiated extensions.
 	Extensions []httphead.Option
+
+	// Header contains the response headers received by the Dialer with
+	// CaptureHeader option set. It is always nil on the server side.
+	Header http.Header
+
+	// Hops is the list of responses followed by the Dialer before the
+	// successful handshake, such as redirects and authentication challenges.
+	// The oldest response is first. It is always empty on the server side.
+	Hops []Hop
+
+	// Proxy is the PROXY protocol header received by the Upgrader with
+	// non-nil ProxyProtocol option. It is always nil on the client side.
+	Proxy *ProxyHeader
+
+	// RemoteAddr is the address of the client determined by the Upgrader
+	// with non-nil ProxyProtocol or non-empty TrustedProxies option. That is,
+	// it is either the source address from the PROXY protocol header, the
+	// address from the Forwarded or X-Forwarded-For headers sent by the
+	// trusted proxy, or the remote address of the connection. It is always
+	// nil on the client side.
+	RemoteAddr net.Addr
 }
 
 // Errors used by the websocket client.
@@ -76,15 +98,31 @@ type Dialer struct {
 	// shallow copies of the items from this list. That is, internals of
 	// Extensions items are shared during Dial().
 	//
+	// ClientExtensions could be used to make offers of a list of extensions
+	// and to negotiate them with OnExtension.
+	//
 	// See https://tools.ietf.org/html/rfc6455#section-4.1
 	// See https://tools.ietf.org/html/rfc6455#section-9.1
 	Extensions []httphead.Option
 
+	// OnExtension is the callback that will be called for each extension
+	// selected by the server which matches by name one of the Extensions.
+	// It could be used to validate parameters of the selected extension (for
+	// example, with wsflate.ClientExtension's Negotiate method).
+	//
+	// The argument is only valid until the callback returns.
+	//
+	// Returned value could be used to prevent processing response.
+	OnExtension func(opt httphead.Option) (err error)
+
 	// Header is an optional HandshakeHeader instance that could be used to
 	// write additional headers to the handshake request.
 	//
 	// It used instead of any key-value mappings to avoid allocations in user
 	// land.
+	//
+	// If Jar provides cookies for the request, Cookie header lines of Header
+	// are merged with them into a single line.
 	Header HandshakeHeader
 
 	// Host is an optional string that could be used to specify the host during
@@ -113,10 +151,79 @@ type Dialer struct {
 	// Returned value could be used to prevent processing response.
 	OnHeader func(key, value []byte) (err error)
 
+	// Redirect is an optional policy of following redirect responses with
+	// 301, 302, 303, 307 and 308 status codes. If it is nil, then redirects
+	// are not followed and Dial() returns StatusError.
+	Redirect *RedirectPolicy
+
+	// Authenticators is an optional list of authenticators used to respond
+	// to the server's authentication challenges received within 401
+	// response. Dial() tries the challenges in order they were received and
+	// uses the first authenticator that supports a challenge.
+	//
+	// If the dialed url contains user info, then BasicAuth and DigestAuth
+	// with that credentials are tried after Authenticators.
+	//
+	// Each url is authenticated at most once. That is, if server responds
+	// with 401 even after authentication, Dial() returns StatusError.
+	Authenticators []Authenticator
+
+	// Jar specifies the cookie jar. If it is non-nil, then cookies from the
+	// jar are sent within the upgrade request, and cookies received within
+	// handshake responses (including followed redirects and authentication
+	// challenges) are stored in the jar.
+	//
+	// Note that the jar is accessed with "ws" and "wss" url schemes replaced
+	// by "http" and "https" respectively.
+	Jar http.CookieJar
+
+	// Rand is an optional source of random bytes used to generate the
+	// handshake nonce (Sec-WebSocket-Key header value). If it is nil, then
+	// DefaultRand is used.
+	//
+	// Note that the source of masking keys of the frames written by the
+	// client is configured separately, e.g. by wsutil.Writer's SetRand().
+	Rand io.Reader
+
+	// CaptureHeader enables capturing of the handshake response headers.
+	// If it is true, then headers of successful response are stored in
+	// Handshake.Header, and response with non-101 status code is reported
+	// as *ResponseError instead of StatusError.
+	//
+	// Note that OnHeader and OnStatusError callbacks are still called.
+	CaptureHeader bool
+
+	// MaxErrorBodySize is the maximum number of bytes of non-101 response
+	// body stored in ResponseError. It is used only if CaptureHeader is true.
+	//
+	// If it is zero, then DefaultMaxErrorBodySize is used. Negative value
+	// means that response body is not read at all.
+	MaxErrorBodySize int
+
 	// NetDial is the function that is used to get plain tcp connection.
 	// If it is not nil, then it is used instead of net.Dialer.
 	NetDial func(ctx context.Context, network, addr string) (net.Conn, error)
 
+	// Proxy specifies a function to return a proxy for a given url. If the
+	// function returns a non-nil error, then Dial() is aborted with that
+	// error. If Proxy is nil or returns a nil url, then no proxy is used.
+	//
+	// Supported proxy url schemes are "http", "https", "socks5" and
+	// "socks5h". For HTTP proxies the tunnel is established with CONNECT
+	// method; user info of the proxy url, if present, is sent in the
+	// Proxy-Authorization header using Basic scheme. For SOCKS5 proxies user
+	// info is used for username/password authentication (RFC1929). With
+	// "socks5" scheme the target host name is resolved locally and its IP
+	// address is sent to the proxy, while with "socks5h" the host name is
+	// resolved by the proxy.
+	//
+	// Note that NetDial is used to connect to the proxy server, and TLS (for
+	// "wss" scheme) is started over the established tunnel.
+	//
+	// ProxyFromEnvironment could be used to respect HTTP_PROXY, HTTPS_PROXY
+	// and NO_PROXY environment variables.
+	Proxy func(*url.URL) (*url.URL, error)
+
 	// TLSClient is the callback that will be called after successful dial with
 	// received connection and its remote host name. If it is nil, then the
 	// default tls.Client() will be used.
@@ -170,6 +277,23 @@ func (d Dialer) Dial(ctx context.Context, urlstr string) (conn net.Conn, br *buf
 			defer cancel()
 		}
 	}
+	if d.Redirect == nil && len(d.Authenticators) == 0 && u.User == nil {
+		return d.dialUpgrade(ctx, dialctx, deadline, u, d.Header, nil)
+	}
+	return d.dialFollow(ctx, dialctx, deadline, u)
+}
+
+// dialUpgrade dials the url u and upgrades established connection to
+// WebSocket. Non-nil onStatus is passed to the upgrade() call.
+func (d Dialer) dialUpgrade(
+	ctx, dialctx context.Context,
+	deadline time.Time,
+	u *url.URL,
+	header HandshakeHeader,
+	onStatus statusHook,
+) (
+	conn net.Conn, br *bufio.Reader, hs Handshake, err error,
+) {
 	if conn, err = d.dial(dialctx, u); err != nil {
 		return conn, nil, hs, err
 	}
@@ -195,7 +319,7 @@ func (d Dialer) Dial(ctx context.Context, urlstr string) (conn net.Conn, br *buf
 		}()
 	}
 
-	br, hs, err = d.Upgrade(conn, u)
+	br, hs, err = d.upgrade(conn, u, header, onStatus)
 
 	return conn, br, hs, err
 }
@@ -228,6 +352,17 @@ func (d Dialer) dial(ctx context.Context, u *url.URL) (conn net.Conn, err error)
 	if dial == nil {
 		dial = netEmptyDialer.DialContext
 	}
+	if p := d.Proxy; p != nil {
+		proxy, err := p(u)
+		if err != nil {
+			return nil, err
+		}
+		if proxy != nil {
+			dial = func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return d.dialProxy(ctx, proxy, addr)
+			}
+		}
+	}
 	switch u.Scheme {
 	case "ws":
 		_, addr := hostport(u.Host, ":80")
@@ -283,6 +418,19 @@ var (
 // It returns handshake info and some bytes which could be written by the peer
 // right after response and be caught by us during buffered read.
 func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Handshake, err error) {
+	return d.upgrade(conn, u, d.Header, nil)
+}
+
+// statusHook is a callback used by Dialer.upgrade() to handle non-101
+// responses with 3xx or 401 status code. It receives the status code, the
+// Location header value and the WWW-Authenticate header values. Arguments are
+// only valid until the callback returns.
+//
+// If returned error is non-nil, then upgrade() returns it instead of
+// StatusError and does not call the OnStatusError callback.
+type statusHook func(status int, location []byte, challenges [][]byte) error
+
+func (d Dialer) upgrade(conn io.ReadWriter, u *url.URL, header HandshakeHeader, onStatus statusHook) (br *bufio.Reader, hs Handshake, err error) {
 	// headerSeen constants helps to report whether or not some header was seen
 	// during reading request bytes.
 	const (
@@ -315,9 +463,15 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	}()
 
 	nonce := make([]byte, nonceSize)
-	initNonce(nonce)
+	if err := initNonceFrom(nonce, d.Rand); err != nil {
+		return br, hs, err
+	}
 
-	httpWriteUpgradeRequest(bw, u, nonce, d.Protocols, d.Extensions, d.Header, d.Host)
+	var cookies []*http.Cookie
+	if jar := d.Jar; jar != nil {
+		cookies = jar.Cookies(cookieURL(u))
+	}
+	httpWriteUpgradeRequest(bw, u, nonce, d.Protocols, d.Extensions, header, d.Host, cookies)
 	if err := bw.Flush(); err != nil {
 		return br, hs, err
 	}
@@ -342,15 +496,49 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	}
 	if resp.status != http.StatusSwitchingProtocols {
 		err = StatusError(resp.status)
-		if onStatusError := d.OnStatusError; onStatusError != nil {
-			// Invoke callback with multireader of status-line bytes br.
-			onStatusError(resp.status, resp.reason,
-				io.MultiReader(
-					bytes.NewReader(sl),
-					strings.NewReader(crlf),
-					br,
-				),
-			)
+		if onStatus != nil || d.CaptureHeader || d.Jar != nil {
+			// Copy status-line bytes since they will be overwritten by the
+			// next reads from br.
+			sl = append(make([]byte, 0, len(sl)), sl...)
+			resp, _ = httpParseResponseLine(sl)
+		}
+		var head []byte
+		follow := onStatus != nil && (resp.status == http.StatusUnauthorized || isRedirect(resp.status))
+		if follow || d.Jar != nil {
+			var sh statusHeaders
+			if head, sh, err = readStatusHeaders(br, d.Jar != nil); err != nil {
+				return br, hs, err
+			}
+			if len(sh.cookies) > 0 {
+				d.Jar.SetCookies(cookieURL(u), readSetCookies(sh.cookies))
+			}
+			if follow {
+				if err = onStatus(resp.status, sh.location, sh.challenges); err != nil {
+					return br, hs, err
+				}
+			}
+			err = StatusError(resp.status)
+		}
+		onStatusError := d.OnStatusError
+		if onStatusError == nil && !d.CaptureHeader {
+			return br, hs, err
+		}
+		// Prepare multireader of status-line bytes, already read headers
+		// and br.
+		var raw io.Reader = io.MultiReader(
+			bytes.NewReader(sl),
+			strings.NewReader(crlf),
+			bytes.NewReader(head),
+			br,
+		)
+		if d.CaptureHeader {
+			var consumed []byte
+			avail := len(sl) + len(crlf) + len(head) + br.Buffered()
+			consumed, err = readResponseError(raw, avail, d.MaxErrorBodySize)
+			raw = io.MultiReader(bytes.NewReader(consumed), br)
+		}
+		if onStatusError != nil {
+			onStatusError(resp.status, resp.reason, raw)
 		}
 		return br, hs, err
 	}
@@ -358,7 +546,19 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 	// valid. If not, then we stop processing response without giving user
 	// ability to read non-technical headers. That is, we do not distinguish
 	// technical errors (such as parsing error) and protocol errors.
-	var headerSeen byte
+	var (
+		headerSeen byte
+		// capture holds the raw header lines if CaptureHeader is set.
+		capture []byte
+		// setCookies holds the Set-Cookie header values if Jar is set.
+		setCookies []string
+	)
+	if d.CaptureHeader {
+		capture = pbytes.GetCap(nonZero(d.ReadBufferSize, DefaultClientReadBufferSize))
+		defer func() {
+			pbytes.Put(capture)
+		}()
+	}
 	for {
 		line, e := readLine(br)
 		if e != nil {
@@ -369,6 +569,10 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			// Blank line, no more lines to read.
 			break
 		}
+		if d.CaptureHeader {
+			capture = append(capture, line...)
+			capture = append(capture, '\n')
+		}
 
 		k, v, ok := httpParseHeaderLine(line)
 		if !ok {
@@ -421,12 +625,15 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			}
 
 		case headerSecExtensionsCanonical:
-			hs.Extensions, err = matchSelectedExtensions(v, d.Extensions, hs.Extensions)
+			hs.Extensions, err = matchSelectedExtensions(v, d.Extensions, hs.Extensions, d.OnExtension)
 			if err != nil {
 				return br, hs, err
 			}
 
 		default:
+			if d.Jar != nil && btsToString(k) == headerSetCookieCanonical {
+				setCookies = append(setCookies, string(v))
+			}
 			if onHeader := d.OnHeader; onHeader != nil {
 				if e := onHeader(k, v); e != nil {
 					err = e
@@ -447,9 +654,57 @@ func (d Dialer) Upgrade(conn io.ReadWriter, u *url.URL) (br *bufio.Reader, hs Ha
 			panic("unknown headers state")
 		}
 	}
+	if len(setCookies) > 0 {
+		d.Jar.SetCookies(cookieURL(u), readSetCookies(setCookies))
+	}
+	if err == nil && d.CaptureHeader {
+		hs.Header = parseHeaderLines(capture)
+	}
 	return br, hs, err
 }
 
+// statusHeaders holds the values of non-101 response headers related to
+// redirects, authentication and cookies.
+type statusHeaders struct {
+	location   []byte
+	challenges [][]byte
+	cookies    []string
+}
+
+// readStatusHeaders reads response headers from br and returns raw bytes of
+// read headers along with the values of headers related to redirects and
+// authentication. Set-Cookie header values are collected only if cookies is
+// true.
+func readStatusHeaders(br *bufio.Reader, cookies bool) (head []byte, sh statusHeaders, err error) {
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return head, sh, err
+		}
+		head = append(head, line...)
+		head = append(head, crlf...)
+		if len(line) == 0 {
+			break
+		}
+		k, v, ok := httpParseHeaderLine(line)
+		if !ok {
+			return head, sh, ErrMalformedResponse
+		}
+		// NOTE: v is only valid until next readLine() call.
+		switch btsToString(k) {
+		case headerLocationCanonical:
+			sh.location = append(sh.location[:0], v...)
+		case headerWWWAuthenticateCanonical:
+			sh.challenges = append(sh.challenges, append([]byte(nil), v...))
+		case headerSetCookieCanonical:
+			if cookies {
+				sh.cookies = append(sh.cookies, string(v))
+			}
+		}
+	}
+	return head, sh, nil
+}
+
 // PutReader returns bufio.Reader instance to the inner reuse pool.
 // It is useful in rare cases, when Dialer.Dial() returns non-nil buffer which
 // contains unprocessed buffered data, that was sent by the server quickly
@@ -470,7 +725,7 @@ func isTimeoutError(err error) bool {
 	return ok && t.Timeout()
 }
 
-func matchSelectedExtensions(selected []byte, wanted, received []httphead.Option) ([]httphead.Option, error) {
+func matchSelectedExtensions(selected []byte, wanted, received []httphead.Option, check func(httphead.Option) error) ([]httphead.Option, error) {
 	if len(selected) == 0 {
 		return received, nil
 	}
@@ -490,6 +745,11 @@ func matchSelectedExtensions(selected []byte, wanted, received []httphead.Option
 			// constitutes a valid response by a server to a requested set of
 			// parameters by a client, will be defined by each such extension.
 			if bytes.Equal(option.Name, want.Name) {
+				if check != nil {
+					if err = check(option); err != nil {
+						return false
+					}
+				}
 				// Check parsed extension to be present in client
 				// requested extensions. We move matched extension
 				// from client list to avoid allocation of httphead.Option.Name,
@@ -506,8 +766,10 @@ func matchSelectedExtensions(selected []byte, wanted, received []httphead.Option
 			// Met next option.
 			index = i
 			if i != 0 && !match() {
-				// Server returned non-requested extension.
-				err = ErrHandshakeBadExtensions
+				if err == nil {
+					// Server returned non-requested extension.
+					err = ErrHandshakeBadExtensions
+				}
 				return httphead.ControlBreak
 			}
 			option = httphead.Option{Name: name}
@@ -521,10 +783,16 @@ func matchSelectedExtensions(selected []byte, wanted, received []httphead.Option
 		err = ErrMalformedResponse
 		return received, err
 	}
+	if err != nil {
+		return received, err
+	}
 	if !match() {
-		return received, ErrHandshakeBadExtensions
+		if err == nil {
+			err = ErrHandshakeBadExtensions
+		}
+		return received, err
 	}
-	return received, err
+	return received, nil
 }
 
 // setupContextDeadliner is a helper function that starts connection I/O
@@ -0,0 +1,291 @@
+package ws
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// AuthChallenge represents an authentication challenge received from the
+// server within WWW-Authenticate header.
+//
+// See https://tools.ietf.org/html/rfc7235#section-4.1
+type AuthChallenge struct {
+	// Scheme is the authentication scheme name, such as "Basic".
+	Scheme string
+
+	// Params contains challenge parameters with lower cased names.
+	Params map[string]string
+}
+
+// Authenticator is the interface that responds to the server's
+// authentication challenges.
+type Authenticator interface {
+	// Authorization returns the Authorization header value for the request
+	// to the url u in response to the challenge c. It returns false if c is
+	// not supported by the Authenticator.
+	Authorization(u *url.URL, c AuthChallenge) (string, bool)
+}
+
+// BasicAuth is an Authenticator that responds to "Basic" challenges.
+//
+// See https://tools.ietf.org/html/rfc7617
+type BasicAuth struct {
+	Username, Password string
+}
+
+// Authorization implements Authenticator.
+func (a BasicAuth) Authorization(u *url.URL, c AuthChallenge) (string, bool) {
+	if !strings.EqualFold(c.Scheme, "Basic") {
+		return "", false
+	}
+	return basicAuth(a.Username, a.Password), true
+}
+
+// BearerAuth is an Authenticator that responds to "Bearer" challenges.
+//
+// See https://tools.ietf.org/html/rfc6750
+type BearerAuth struct {
+	Token string
+}
+
+// Authorization implements Authenticator.
+func (a BearerAuth) Authorization(u *url.URL, c AuthChallenge) (string, bool) {
+	if !strings.EqualFold(c.Scheme, "Bearer") {
+		return "", false
+	}
+	return "Bearer " + a.Token, true
+}
+
+// DigestAuth is an Authenticator that responds to "Digest" challenges.
+// It supports MD5 and SHA-256 algorithms (and their session variants) with
+// "auth" quality of protection.
+//
+// See https://tools.ietf.org/html/rfc7616
+type DigestAuth struct {
+	Username, Password string
+}
+
+// Authorization implements Authenticator.
+func (a DigestAuth) Authorization(u *url.URL, c AuthChallenge) (string, bool) {
+	if !strings.EqualFold(c.Scheme, "Digest") {
+		return "", false
+	}
+	var (
+		realm     = c.Params["realm"]
+		nonce     = c.Params["nonce"]
+		opaque    = c.Params["opaque"]
+		algorithm = c.Params["algorithm"]
+	)
+	if nonce == "" {
+		return "", false
+	}
+	var newHash func() hash.Hash
+	alg := strings.ToUpper(algorithm)
+	sess := strings.HasSuffix(alg, "-SESS")
+	switch strings.TrimSuffix(alg, "-SESS") {
+	case "", "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	default:
+		return "", false
+	}
+	var qop string
+	if q, ok := c.Params["qop"]; ok {
+		for _, v := range strings.Split(q, ",") {
+			if strings.TrimSpace(v) == "auth" {
+				qop = "auth"
+				break
+			}
+		}
+		if qop == "" {
+			// Only "auth-int" is offered, which is not supported.
+			return "", false
+		}
+	}
+	h := func(s ...string) string {
+		hh := newHash()
+		io.WriteString(hh, strings.Join(s, ":"))
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+
+	const nc = "00000001"
+	var cnonce string
+	if sess || qop != "" {
+		var p [16]byte
+		if _, err := rand.Read(p[:]); err != nil {
+			return "", false
+		}
+		cnonce = hex.EncodeToString(p[:])
+	}
+	uri := u.RequestURI()
+
+	ha1 := h(a.Username, realm, a.Password)
+	if sess {
+		ha1 = h(ha1, nonce, cnonce)
+	}
+	ha2 := h(http.MethodGet, uri)
+
+	var response string
+	if qop != "" {
+		response = h(ha1, nonce, nc, cnonce, qop, ha2)
+	} else {
+		response = h(ha1, nonce, ha2)
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Digest ")
+	writeAuthParam(&sb, "username", a.Username, true)
+	writeAuthParam(&sb, "realm", realm, true)
+	writeAuthParam(&sb, "nonce", nonce, true)
+	writeAuthParam(&sb, "uri", uri, true)
+	if algorithm != "" {
+		writeAuthParam(&sb, "algorithm", algorithm, false)
+	}
+	writeAuthParam(&sb, "response", response, true)
+	if opaque != "" {
+		writeAuthParam(&sb, "opaque", opaque, true)
+	}
+	if qop != "" {
+		writeAuthParam(&sb, "qop", qop, false)
+		writeAuthParam(&sb, "nc", nc, false)
+		writeAuthParam(&sb, "cnonce", cnonce, true)
+	}
+	return sb.String(), true
+}
+
+func writeAuthParam(sb *strings.Builder, key, value string, quote bool) {
+	if sb.Len() > len("Digest ") {
+		sb.WriteString(commaAndSpace)
+	}
+	sb.WriteString(key)
+	sb.WriteByte('=')
+	if !quote {
+		sb.WriteString(value)
+		return
+	}
+	sb.WriteByte('"')
+	for i := 0; i < len(value); i++ {
+		if c := value[i]; c == '"' || c == '\\' {
+			sb.WriteByte('\\')
+		}
+		sb.WriteByte(value[i])
+	}
+	sb.WriteByte('"')
+}
+
+// authorize returns the Authorization header value in response to the given
+// WWW-Authenticate header values. It returns empty string if none of the
+// challenges is supported.
+func (d Dialer) authorize(u *url.URL, challenges [][]byte) string {
+	var userinfo [2]Authenticator
+	if user := u.User; user != nil {
+		pass, _ := user.Password()
+		userinfo[0] = BasicAuth{user.Username(), pass}
+		userinfo[1] = DigestAuth{user.Username(), pass}
+	}
+	for _, c := range parseAuthChallenges(challenges) {
+		for _, a := range d.Authenticators {
+			if v, ok := a.Authorization(u, c); ok {
+				return v
+			}
+		}
+		for _, a := range userinfo {
+			if a == nil {
+				continue
+			}
+			if v, ok := a.Authorization(u, c); ok {
+				return v
+			}
+		}
+	}
+	return ""
+}
+
+// parseAuthChallenges parses WWW-Authenticate header values.
+// It stops parsing of a value at the first syntax error.
+func parseAuthChallenges(values [][]byte) (cs []AuthChallenge) {
+	for _, v := range values {
+		s := string(v)
+		for {
+			scheme, rest := cutToken(strings.TrimLeft(s, " \t,"))
+			if scheme == "" {
+				break
+			}
+			c := AuthChallenge{
+				Scheme: scheme,
+				Params: make(map[string]string),
+			}
+			s = rest
+			for {
+				key, rest := cutToken(strings.TrimLeft(s, " \t,"))
+				rest = strings.TrimLeft(rest, " \t")
+				if key == "" || !strings.HasPrefix(rest, "=") {
+					// Next challenge.
+					break
+				}
+				rest = strings.TrimLeft(rest[1:], " \t")
+				var (
+					val string
+					ok  = true
+				)
+				if strings.HasPrefix(rest, `"`) {
+					val, rest, ok = cutQuoted(rest)
+				} else {
+					val, rest = cutToken(rest)
+				}
+				if !ok {
+					return append(cs, c)
+				}
+				c.Params[strings.ToLower(key)] = val
+				s = rest
+			}
+			cs = append(cs, c)
+		}
+	}
+	return cs
+}
+
+func cutToken(s string) (token, rest string) {
+	i := 0
+	for i < len(s) && isTokenChar(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func cutQuoted(s string) (value, rest string, ok bool) {
+	var (
+		sb     strings.Builder
+		escape bool
+	)
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case escape:
+			escape = false
+		case c == '\\':
+			escape = true
+			continue
+		case c == '"':
+			return sb.String(), s[i+1:], true
+		}
+		sb.WriteByte(c)
+	}
+	return "", "", false
+}
+
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) != -1
+}
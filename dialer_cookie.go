@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// cookieURL returns the url used to access the cookie jar. That is, it
+// replaces websocket schemes with their http counterparts.
+func cookieURL(u *url.URL) *url.URL {
+	c := *u
+	switch u.Scheme {
+	case "ws":
+		c.Scheme = "http"
+	case "wss":
+		c.Scheme = "https"
+	}
+	return &c
+}
+
+// readSetCookies parses given Set-Cookie header values.
+func readSetCookies(values []string) []*http.Cookie {
+	resp := http.Response{
+		Header: http.Header{
+			headerSetCookie: values,
+		},
+	}
+	return resp.Cookies()
+}
+
+// splitCookieHeader extracts values of Cookie header lines from h. It returns
+// the values joined with "; " and the rest of the header.
+func splitCookieHeader(h HandshakeHeader) (cookie string, rest HandshakeHeader) {
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		// Let the request writing fail as usual.
+		return "", h
+	}
+	var (
+		values []string
+		other  []byte
+		lines  = buf.Bytes()
+	)
+	for len(lines) > 0 {
+		line := lines
+		if i := bytes.IndexByte(lines, '\n'); i != -1 {
+			line = lines[:i+1]
+		}
+		lines = lines[len(line):]
+
+		k, v, ok := httpParseHeaderLine(bytes.TrimRight(line, "\r\n"))
+		if ok && btsToString(k) == headerCookieCanonical {
+			if len(v) > 0 {
+				values = append(values, string(v))
+			}
+			continue
+		}
+		other = append(other, line...)
+	}
+	if values == nil {
+		return "", h
+	}
+	return strings.Join(values, "; "), HandshakeHeaderBytes(other)
+}
+
+// writeCookie writes c as a Cookie header pair sanitized the same way as
+// http.Request.AddCookie() does.
+func writeCookie(bw *bufio.Writer, c *http.Cookie) {
+	for i := 0; i < len(c.Name); i++ {
+		b := c.Name[i]
+		if b == '\r' || b == '\n' {
+			b = '-'
+		}
+		bw.WriteByte(b)
+	}
+	bw.WriteByte('=')
+
+	v := c.Value
+	quote := strings.IndexAny(v, " ,") != -1
+	if quote {
+		bw.WriteByte('"')
+	}
+	for i := 0; i < len(v); i++ {
+		if b := v[i]; validCookieValueByte(b) {
+			bw.WriteByte(b)
+		}
+	}
+	if quote {
+		bw.WriteByte('"')
+	}
+}
+
+// validCookieValueByte reports whether b could be used within the cookie
+// value. See https://tools.ietf.org/html/rfc6265#section-4.1.1
+func validCookieValueByte(b byte) bool {
+	return 0x20 <= b && b < 0x7f && b != '"' && b != ';' && b != '\\'
+}
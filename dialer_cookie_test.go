@@ -0,0 +1,152 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDialerJar(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s3cr3t", Path: "/"})
+		http.Redirect(w, r, "/auth", http.StatusFound)
+	})
+	mux.HandleFunc("/auth", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("session"); err != nil {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if _, err := r.Cookie("nonce"); err != nil {
+			http.SetCookie(w, &http.Cookie{Name: "nonce", Value: "42", Path: "/"})
+			w.Header().Set("WWW-Authenticate", `Bearer realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		u := HTTPUpgrader{
+			Header: http.Header{
+				"Set-Cookie": {(&http.Cookie{Name: "upgraded", Value: "1", Path: "/"}).String()},
+			},
+		}
+		if _, _, _, err := u.Upgrade(r, w); err != nil {
+			t.Errorf("upgrade error: %v", err)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Dialer{
+		Jar:            jar,
+		Redirect:       &RedirectPolicy{},
+		Authenticators: []Authenticator{BearerAuth{"token"}},
+	}
+	conn, _, hs, err := d.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http")+"/login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if act, exp := len(hs.Hops), 2; act != exp {
+		t.Errorf("unexpected number of hops: %d; want %d", act, exp)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	cookies := make(map[string]string)
+	for _, c := range jar.Cookies(u) {
+		cookies[c.Name] = c.Value
+	}
+	for name, exp := range map[string]string{
+		"session":  "s3cr3t",
+		"nonce":    "42",
+		"upgraded": "1",
+	} {
+		if act := cookies[name]; act != exp {
+			t.Errorf("unexpected %q cookie value: %q; want %q", name, act, exp)
+		}
+	}
+}
+
+func TestHTTPWriteUpgradeRequestCookies(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		header  HandshakeHeader
+		cookies []*http.Cookie
+		exp     string
+		other   string
+	}{
+		{
+			name: "jar",
+			cookies: []*http.Cookie{
+				{Name: "a", Value: "1"},
+				{Name: "b", Value: "2"},
+			},
+			exp: "a=1; b=2",
+		},
+		{
+			name: "merge",
+			header: HandshakeHeaderHTTP(http.Header{
+				"Cookie":  {"x=1"},
+				"X-Other": {"yes"},
+			}),
+			cookies: []*http.Cookie{
+				{Name: "a", Value: "1"},
+			},
+			exp:   "x=1; a=1",
+			other: "yes",
+		},
+		{
+			name:   "merge string",
+			header: HandshakeHeaderString("X-Other: yes\r\ncookie: x=1\r\nCookie: y=2\r\n"),
+			cookies: []*http.Cookie{
+				{Name: "a", Value: "1"},
+			},
+			exp:   "x=1; y=2; a=1",
+			other: "yes",
+		},
+		{
+			name: "sanitize",
+			cookies: []*http.Cookie{
+				{Name: "a\r\n", Value: "x;\"y\\z\r\n"},
+				{Name: "b", Value: "hello, world"},
+			},
+			exp: `a--=xyz; b="hello, world"`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf strings.Builder
+			bw := bufio.NewWriter(&buf)
+			nonce := make([]byte, nonceSize)
+			initNonce(nonce)
+			httpWriteUpgradeRequest(bw,
+				makeURL("ws://example.org"),
+				nonce,
+				nil, nil, test.header, "",
+				test.cookies,
+			)
+			bw.Flush()
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(buf.String())))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act, exp := req.Header["Cookie"], []string{test.exp}; !reflect.DeepEqual(act, exp) {
+				t.Errorf("unexpected Cookie header: %q; want %q", act, exp)
+			}
+			if act, exp := req.Header.Get("X-Other"), test.other; act != exp {
+				t.Errorf("unexpected X-Other header: %q; want %q", act, exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !purego
+// +build !purego
+
+package ws
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+)
+
+// NOTE: strToBytes() allocates with purego build tag.
+func TestHTTPWriteUpgradeRequestAllocs(t *testing.T) {
+	// Ensure that no allocations are made when no cookies are given (that
+	// is, when Dialer.Jar is nil).
+	var (
+		bw    = bufio.NewWriter(ioutil.Discard)
+		u     = makeURL("ws://example.org")
+		nonce = make([]byte, nonceSize)
+	)
+	allocs := testing.AllocsPerRun(100, func() {
+		httpWriteUpgradeRequest(bw, u, nonce, nil, nil, nil, "", nil)
+	})
+	if allocs != 0 {
+		t.Errorf("unexpected allocations: %v", allocs)
+	}
+}
@@ -0,0 +1,420 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gobwas/pool/pbufio"
+)
+
+// Errors used by the Dialer when establishing a tunnel through a proxy.
+var (
+	ErrProxyBadScheme    = errors.New("unexpected proxy scheme")
+	ErrProxyBadResponse  = errors.New("malformed proxy response")
+	ErrProxyBadAuth      = errors.New("proxy authentication failed")
+	ErrProxyNoAuthMethod = errors.New("no acceptable proxy authentication method")
+)
+
+// ProxyStatusError contains an unexpected status-line code received from the
+// HTTP proxy server in response to CONNECT request.
+type ProxyStatusError struct {
+	// Code is the HTTP status code.
+	Code int
+	// Reason is the status-line reason phrase.
+	Reason string
+}
+
+func (p ProxyStatusError) Error() string {
+	return "unexpected proxy response status: " + strconv.Itoa(p.Code) + " " + p.Reason
+}
+
+// SOCKSReplyError contains an unsuccessful reply code received from the
+// SOCKS5 proxy server.
+//
+// See https://tools.ietf.org/html/rfc1928#section-6
+type SOCKSReplyError byte
+
+func (s SOCKSReplyError) Error() string {
+	var reason string
+	switch s {
+	case 0x01:
+		reason = "general SOCKS server failure"
+	case 0x02:
+		reason = "connection not allowed by ruleset"
+	case 0x03:
+		reason = "network unreachable"
+	case 0x04:
+		reason = "host unreachable"
+	case 0x05:
+		reason = "connection refused"
+	case 0x06:
+		reason = "TTL expired"
+	case 0x07:
+		reason = "command not supported"
+	case 0x08:
+		reason = "address type not supported"
+	default:
+		reason = "unknown code"
+	}
+	return "unexpected socks reply: " + strconv.Itoa(int(s)) + " " + reason
+}
+
+// ProxyFromEnvironment returns the url of the proxy to use for a given
+// websocket url u, as indicated by the environment variables HTTP_PROXY,
+// HTTPS_PROXY and NO_PROXY (or the lowercase versions thereof). The "ws"
+// scheme is treated as "http" and "wss" as "https".
+//
+// It is a wrapper around http.ProxyFromEnvironment and could be used as a
+// Dialer.Proxy value.
+func ProxyFromEnvironment(u *url.URL) (*url.URL, error) {
+	scheme := u.Scheme
+	switch scheme {
+	case "ws":
+		scheme = "http"
+	case "wss":
+		scheme = "https"
+	}
+	return http.ProxyFromEnvironment(&http.Request{
+		URL: &url.URL{
+			Scheme: scheme,
+			Host:   u.Host,
+		},
+	})
+}
+
+// ProxyURL returns a proxy function (for use in a Dialer) that always returns
+// the same url.
+func ProxyURL(fixed *url.URL) func(*url.URL) (*url.URL, error) {
+	return func(*url.URL) (*url.URL, error) {
+		return fixed, nil
+	}
+}
+
+// dialProxy dials the proxy server and establishes a tunnel to the addr
+// through it.
+func (d Dialer) dialProxy(ctx context.Context, proxy *url.URL, addr string) (_ net.Conn, err error) {
+	dial := d.NetDial
+	if dial == nil {
+		dial = netEmptyDialer.DialContext
+	}
+	var (
+		conn     net.Conn
+		hostname string
+		tunnel   func(net.Conn, *url.URL, string) (net.Conn, error)
+	)
+	switch proxy.Scheme {
+	case "http":
+		hostname, conn, err = dialHostport(ctx, dial, proxy.Host, ":80")
+		tunnel = httpConnect
+	case "https":
+		hostname, conn, err = dialHostport(ctx, dial, proxy.Host, ":443")
+		if err == nil {
+			tlsClient := d.TLSClient
+			if tlsClient == nil {
+				tlsClient = d.tlsClient
+			}
+			conn = tlsClient(conn, hostname)
+		}
+		tunnel = httpConnect
+	case "socks5", "socks5h":
+		if proxy.Scheme == "socks5" {
+			// Host name must be resolved locally.
+			if addr, err = resolveAddr(ctx, addr); err != nil {
+				return nil, err
+			}
+		}
+		_, conn, err = dialHostport(ctx, dial, proxy.Host, ":1080")
+		tunnel = socksConnect
+	default:
+		return nil, ErrProxyBadScheme
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			conn.Close()
+		}
+	}()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(noDeadline)
+	}
+	if ctx.Done() != nil {
+		done := setupContextDeadliner(ctx, conn)
+		defer func() {
+			done(&err)
+		}()
+	}
+	return tunnel(conn, proxy, addr)
+}
+
+func dialHostport(
+	ctx context.Context,
+	dial func(context.Context, string, string) (net.Conn, error),
+	host, defaultPort string,
+) (
+	hostname string, conn net.Conn, err error,
+) {
+	hostname, addr := hostport(host, defaultPort)
+	conn, err = dial(ctx, "tcp", addr)
+	return hostname, conn, err
+}
+
+// lookupIPAddr is used to resolve host names for "socks5" proxies. It is a
+// variable to be replaced in tests.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// resolveAddr replaces host name in addr with its IP address. As curl and
+// x/net/proxy do, it prefers IPv4 address.
+func resolveAddr(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	if net.ParseIP(host) != nil {
+		return addr, nil
+	}
+	ips, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return "", err
+	}
+	if len(ips) == 0 {
+		return "", &net.DNSError{
+			Err:        "no such host",
+			Name:       host,
+			IsNotFound: true,
+		}
+	}
+	ip := ips[0].IP
+	for _, a := range ips {
+		if a.IP.To4() != nil {
+			ip = a.IP
+			break
+		}
+	}
+	return net.JoinHostPort(ip.String(), port), nil
+}
+
+// httpConnect establishes a tunnel to the addr through the HTTP proxy using
+// CONNECT method.
+//
+// See https://tools.ietf.org/html/rfc7231#section-4.3.6
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (_ net.Conn, err error) {
+	bw := pbufio.GetWriter(conn, DefaultClientWriteBufferSize)
+	defer pbufio.PutWriter(bw)
+
+	bw.WriteString("CONNECT ")
+	bw.WriteString(addr)
+	bw.WriteString(" HTTP/1.1")
+	bw.WriteString(crlf)
+	httpWriteHeader(bw, headerHost, addr)
+	if user := proxy.User; user != nil {
+		pass, _ := user.Password()
+		httpWriteHeader(bw, headerProxyAuthorization, basicAuth(user.Username(), pass))
+	}
+	bw.WriteString(crlf)
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	br := pbufio.GetReader(conn, DefaultClientReadBufferSize)
+	defer pbufio.PutReader(br)
+
+	sl, err := readLine(br)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpParseResponseLine(sl)
+	if err != nil {
+		return nil, ErrProxyBadResponse
+	}
+	if resp.major != 1 {
+		return nil, ErrProxyBadResponse
+	}
+	if resp.status < 200 || resp.status > 299 {
+		return nil, ProxyStatusError{
+			Code:   resp.status,
+			Reason: string(resp.reason),
+		}
+	}
+	for {
+		line, err := readLine(br)
+		if err != nil {
+			return nil, err
+		}
+		if len(line) == 0 {
+			break
+		}
+	}
+	if n := br.Buffered(); n > 0 {
+		// Proxy sent some data from the tunnel right after the response.
+		// Copy it to not lose it after returning br to the pool.
+		p, _ := br.Peek(n)
+		return prefixConn{
+			Conn: conn,
+			r:    io.MultiReader(bytes.NewReader(append([]byte(nil), p...)), conn),
+		}, nil
+	}
+	return conn, nil
+}
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+// SOCKS5 protocol constants.
+// See https://tools.ietf.org/html/rfc1928
+const (
+	socksVersion5 = 0x05
+
+	socksAuthNone         = 0x00
+	socksAuthPassword     = 0x02
+	socksAuthNoAcceptable = 0xff
+
+	socksAuthPasswordVersion = 0x01
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded = 0x00
+)
+
+// socksConnect establishes a tunnel to the addr through the SOCKS5 proxy.
+//
+// See https://tools.ietf.org/html/rfc1928
+// See https://tools.ietf.org/html/rfc1929
+func socksConnect(conn net.Conn, proxy *url.URL, addr string) (_ net.Conn, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	portnum, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("bad port: %q", port)
+	}
+
+	// Note that buffer is big enough to hold any of messages below.
+	buf := make([]byte, 0, 3+255+255)
+
+	methods := []byte{socksAuthNone}
+	if proxy.User != nil {
+		methods = append(methods, socksAuthPassword)
+	}
+	buf = append(buf, socksVersion5, byte(len(methods)))
+	buf = append(buf, methods...)
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksVersion5 {
+		return nil, ErrProxyBadResponse
+	}
+	switch buf[1] {
+	case socksAuthNone:
+		// Nothing to do.
+	case socksAuthPassword:
+		if proxy.User == nil {
+			return nil, ErrProxyBadResponse
+		}
+		var (
+			username    = proxy.User.Username()
+			password, _ = proxy.User.Password()
+		)
+		if len(username) == 0 || len(username) > 255 || len(password) > 255 {
+			return nil, fmt.Errorf("bad socks username or password length")
+		}
+		buf = append(buf[:0], socksAuthPasswordVersion, byte(len(username)))
+		buf = append(buf, username...)
+		buf = append(buf, byte(len(password)))
+		buf = append(buf, password...)
+		if _, err := conn.Write(buf); err != nil {
+			return nil, err
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		if buf[0] != socksAuthPasswordVersion {
+			return nil, ErrProxyBadResponse
+		}
+		if buf[1] != 0 {
+			return nil, ErrProxyBadAuth
+		}
+	case socksAuthNoAcceptable:
+		return nil, ErrProxyNoAuthMethod
+	default:
+		return nil, ErrProxyBadResponse
+	}
+
+	buf = append(buf[:0], socksVersion5, socksCmdConnect, 0)
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("too long host name: %q", host)
+		}
+		buf = append(buf, socksAddrDomain, byte(len(host)))
+		buf = append(buf, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		buf = append(buf, socksAddrIPv4)
+		buf = append(buf, ip4...)
+	} else {
+		buf = append(buf, socksAddrIPv6)
+		buf = append(buf, ip.To16()...)
+	}
+	buf = append(buf, byte(portnum>>8), byte(portnum))
+	if _, err := conn.Write(buf); err != nil {
+		return nil, err
+	}
+
+	// Read reply header: VER, REP, RSV and ATYP.
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return nil, err
+	}
+	if buf[0] != socksVersion5 {
+		return nil, ErrProxyBadResponse
+	}
+	if rep := buf[1]; rep != socksReplySucceeded {
+		return nil, SOCKSReplyError(rep)
+	}
+	// Skip bound address and port.
+	var n int
+	switch buf[3] {
+	case socksAddrIPv4:
+		n = net.IPv4len
+	case socksAddrIPv6:
+		n = net.IPv6len
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, buf[:1]); err != nil {
+			return nil, err
+		}
+		n = int(buf[0])
+	default:
+		return nil, ErrProxyBadResponse
+	}
+	if _, err := io.ReadFull(conn, buf[:n+2]); err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
+
+// prefixConn is a net.Conn which reads from r instead of underlying conn.
+type prefixConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (p prefixConn) Read(b []byte) (int, error) {
+	return p.r.Read(b)
+}
@@ -0,0 +1,308 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDialerProxy(t *testing.T) {
+	defer func(lookup func(context.Context, string) ([]net.IPAddr, error)) {
+		lookupIPAddr = lookup
+	}(lookupIPAddr)
+	lookupIPAddr = func(_ context.Context, host string) ([]net.IPAddr, error) {
+		if host != "example.org" {
+			return nil, &net.DNSError{Err: "no such host", Name: host}
+		}
+		return []net.IPAddr{
+			{IP: net.ParseIP("2001:db8::1")},
+			{IP: net.ParseIP("192.0.2.1")},
+		}, nil
+	}
+	for _, test := range []struct {
+		name    string
+		proxy   func(net.Conn) (addr string, err error)
+		url     *url.URL
+		expAddr string
+		expErr  error
+	}{
+		{
+			name: "http",
+			url: &url.URL{
+				Scheme: "http",
+				User:   url.UserPassword("user", "pass"),
+			},
+			proxy: httpProxy(http.StatusOK, "user", "pass"),
+		},
+		{
+			name: "http no auth",
+			url: &url.URL{
+				Scheme: "http",
+			},
+			proxy:  httpProxy(http.StatusOK, "user", "pass"),
+			expErr: ProxyStatusError{http.StatusProxyAuthRequired, "Proxy Authentication Required"},
+		},
+		{
+			name: "http forbidden",
+			url: &url.URL{
+				Scheme: "http",
+			},
+			proxy:  httpProxy(http.StatusForbidden, "", ""),
+			expErr: ProxyStatusError{http.StatusForbidden, "Forbidden"},
+		},
+		{
+			name: "socks5",
+			url: &url.URL{
+				Scheme: "socks5",
+			},
+			proxy:   socksProxy(0, "", ""),
+			expAddr: "192.0.2.1:80",
+		},
+		{
+			name: "socks5h",
+			url: &url.URL{
+				Scheme: "socks5h",
+			},
+			proxy: socksProxy(0, "", ""),
+		},
+		{
+			name: "socks5 auth",
+			url: &url.URL{
+				Scheme: "socks5h",
+				User:   url.UserPassword("user", "pass"),
+			},
+			proxy: socksProxy(0, "user", "pass"),
+		},
+		{
+			name: "socks5 bad auth",
+			url: &url.URL{
+				Scheme: "socks5",
+				User:   url.UserPassword("user", "bad"),
+			},
+			proxy:  socksProxy(0, "user", "pass"),
+			expErr: ErrProxyBadAuth,
+		},
+		{
+			name: "socks5 no auth",
+			url: &url.URL{
+				Scheme: "socks5",
+			},
+			proxy:  socksProxy(0, "user", "pass"),
+			expErr: ErrProxyNoAuthMethod,
+		},
+		{
+			name: "socks5 refused",
+			url: &url.URL{
+				Scheme: "socks5",
+			},
+			proxy:  socksProxy(0x05, "", ""),
+			expErr: SOCKSReplyError(0x05),
+		},
+		{
+			name: "bad scheme",
+			url: &url.URL{
+				Scheme: "ftp",
+			},
+			expErr: ErrProxyBadScheme,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			target := listen(t)
+			go func() {
+				conn, err := target.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				if _, err := Upgrade(conn); err != nil {
+					t.Errorf("upgrade error: %v", err)
+					return
+				}
+				WriteFrame(conn, NewTextFrame([]byte("hello")))
+			}()
+
+			exp := test.expAddr
+			if exp == "" {
+				exp = "example.org:80"
+			}
+			proxy := listen(t)
+			go func() {
+				conn, err := proxy.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				addr, err := test.proxy(conn)
+				if err != nil {
+					return
+				}
+				if addr != exp {
+					t.Errorf("unexpected tunnel address: %q; want %q", addr, exp)
+					return
+				}
+				tunnel, err := net.Dial("tcp", target.Addr().String())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				defer tunnel.Close()
+				go io.Copy(tunnel, conn)
+				io.Copy(conn, tunnel)
+			}()
+
+			test.url.Host = proxy.Addr().String()
+			d := Dialer{
+				Timeout: time.Second,
+				Proxy:   ProxyURL(test.url),
+			}
+			conn, br, _, err := d.Dial(context.Background(), "ws://example.org/chat")
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			var r io.Reader = conn
+			if br != nil {
+				r = br
+			}
+			f, err := ReadFrame(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(f.Payload) != "hello" {
+				t.Errorf("unexpected payload: %q", f.Payload)
+			}
+		})
+	}
+}
+
+func TestDialerProxyError(t *testing.T) {
+	exp := &net.AddrError{Err: "test"}
+	d := Dialer{
+		Proxy: func(*url.URL) (*url.URL, error) {
+			return nil, exp
+		},
+	}
+	_, _, _, err := d.Dial(context.Background(), "ws://example.org")
+	if err != exp {
+		t.Fatalf("unexpected error: %v; want %v", err, exp)
+	}
+}
+
+func listen(tb testing.TB) net.Listener {
+	ln, err := net.Listen("tcp", "localhost:")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() { ln.Close() })
+	return ln
+}
+
+func httpProxy(status int, user, pass string) func(net.Conn) (string, error) {
+	return func(conn net.Conn) (string, error) {
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return "", err
+		}
+		if user != "" {
+			u, p, ok := (&http.Request{Header: http.Header{
+				"Authorization": req.Header["Proxy-Authorization"],
+			}}).BasicAuth()
+			if !ok || u != user || p != pass {
+				status = http.StatusProxyAuthRequired
+			}
+		}
+		resp := http.Response{
+			StatusCode: status,
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+		}
+		if err := resp.Write(conn); err != nil {
+			return "", err
+		}
+		if req.Method != http.MethodConnect || status != http.StatusOK {
+			return "", io.EOF
+		}
+		return req.Host, nil
+	}
+}
+
+func socksProxy(reply byte, user, pass string) func(net.Conn) (string, error) {
+	return func(conn net.Conn) (string, error) {
+		buf := make([]byte, 512)
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return "", err
+		}
+		methods := buf[2 : 2+buf[1]]
+		if _, err := io.ReadFull(conn, methods); err != nil {
+			return "", err
+		}
+		want := byte(socksAuthNone)
+		if user != "" {
+			want = socksAuthPassword
+		}
+		method := byte(socksAuthNoAcceptable)
+		for _, m := range methods {
+			if m == want {
+				method = m
+			}
+		}
+		conn.Write([]byte{socksVersion5, method})
+		switch method {
+		case socksAuthNoAcceptable:
+			return "", io.EOF
+		case socksAuthPassword:
+			if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+				return "", err
+			}
+			u := make([]byte, buf[1])
+			io.ReadFull(conn, u)
+			io.ReadFull(conn, buf[:1])
+			p := make([]byte, buf[0])
+			io.ReadFull(conn, p)
+			if string(u) != user || string(p) != pass {
+				conn.Write([]byte{socksAuthPasswordVersion, 1})
+				return "", io.EOF
+			}
+			conn.Write([]byte{socksAuthPasswordVersion, 0})
+		}
+		if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+			return "", err
+		}
+		var host string
+		switch buf[3] {
+		case socksAddrDomain:
+			io.ReadFull(conn, buf[:1])
+			h := make([]byte, buf[0])
+			io.ReadFull(conn, h)
+			host = string(h)
+		case socksAddrIPv4:
+			ip := make(net.IP, net.IPv4len)
+			io.ReadFull(conn, ip)
+			host = ip.String()
+		case socksAddrIPv6:
+			ip := make(net.IP, net.IPv6len)
+			io.ReadFull(conn, ip)
+			host = ip.String()
+		}
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return "", err
+		}
+		port := binary.BigEndian.Uint16(buf[:2])
+
+		conn.Write([]byte{socksVersion5, reply, 0, socksAddrIPv4, 127, 0, 0, 1, 0, 0})
+		if reply != socksReplySucceeded {
+			return "", io.EOF
+		}
+		return net.JoinHostPort(host, strconv.Itoa(int(port))), nil
+	}
+}
@@ -0,0 +1,231 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultMaxRedirects is the default number of redirects followed by Dialer
+// with non-nil RedirectPolicy.
+const DefaultMaxRedirects = 10
+
+// Errors used by the Dialer when following redirects.
+var (
+	ErrTooManyRedirects    = errors.New("too many redirects")
+	ErrRedirectInsecure    = errors.New("redirect from secure to insecure url")
+	ErrRedirectCrossOrigin = errors.New("redirect to another origin")
+	ErrRedirectBadLocation = fmt.Errorf("bad %q header in redirect response", headerLocation)
+)
+
+// errRedial is a sentinel error used by the dialFollow() to signal that
+// response is handled and connection must be dialed again.
+var errRedial = errors.New("redial")
+
+// RedirectPolicy contains options for following redirect responses.
+//
+// Regardless of the options, Dialer never follows redirect from "wss" to
+// "ws" url. Location headers with "http" and "https" schemes are treated as
+// "ws" and "wss" respectively.
+type RedirectPolicy struct {
+	// MaxRedirects is the maximum number of redirects to follow.
+	// If it is zero, then DefaultMaxRedirects is used.
+	MaxRedirects int
+
+	// CrossOrigin allows following redirects to the urls with another
+	// origin. By default only redirects to the same host and port are
+	// followed. Note that redirect from "ws" to "wss" url with the same host
+	// is treated as same origin one regardless of the port.
+	CrossOrigin bool
+
+	// Check is an optional callback that is called before following the
+	// redirect to the url u. The via argument contains responses which were
+	// followed so far, the oldest first.
+	//
+	// If returned error is non-nil, then Dial() returns it.
+	Check func(u *url.URL, via []Hop) error
+}
+
+func (p *RedirectPolicy) check(from, to *url.URL, via []Hop) error {
+	if from.Scheme == "wss" && to.Scheme == "ws" {
+		return ErrRedirectInsecure
+	}
+	var n int
+	for _, h := range via {
+		if h.Location != nil {
+			n++
+		}
+	}
+	if n >= nonZero(p.MaxRedirects, DefaultMaxRedirects) {
+		return ErrTooManyRedirects
+	}
+	if !p.CrossOrigin && !sameOrigin(from, to) {
+		return ErrRedirectCrossOrigin
+	}
+	if check := p.Check; check != nil {
+		return check(to, via)
+	}
+	return nil
+}
+
+// Hop describes the response which made Dialer to dial again. That is, it
+// describes either followed redirect or authentication challenge.
+type Hop struct {
+	// URL is the requested url.
+	URL *url.URL
+
+	// Status is the response status code.
+	Status int
+
+	// Location is the url of the redirect. It is nil for authentication
+	// challenges.
+	Location *url.URL
+}
+
+// dialFollow is like dialUpgrade() but follows the redirects and
+// authentication challenges.
+func (d Dialer) dialFollow(
+	ctx, dialctx context.Context,
+	deadline time.Time,
+	u *url.URL,
+) (
+	conn net.Conn, br *bufio.Reader, hs Handshake, err error,
+) {
+	var (
+		hops   []Hop
+		header = d.Header
+		// authorized reports whether the request to u was made with
+		// credentials.
+		authorized bool
+	)
+	for {
+		var (
+			next *url.URL
+			auth string
+		)
+		onStatus := func(status int, location []byte, challenges [][]byte) error {
+			if status == http.StatusUnauthorized {
+				if authorized {
+					return nil
+				}
+				if auth = d.authorize(u, challenges); auth == "" {
+					return nil
+				}
+				hops = append(hops, Hop{
+					URL:    u,
+					Status: status,
+				})
+				return errRedial
+			}
+			p := d.Redirect
+			if p == nil {
+				return nil
+			}
+			loc, err := redirectLocation(u, location)
+			if err != nil {
+				return err
+			}
+			if err := p.check(u, loc, hops); err != nil {
+				return err
+			}
+			hops = append(hops, Hop{
+				URL:      u,
+				Status:   status,
+				Location: loc,
+			})
+			next = loc
+			return errRedial
+		}
+		conn, br, hs, err = d.dialUpgrade(ctx, dialctx, deadline, u, header, onStatus)
+		hs.Hops = hops
+		if err != errRedial {
+			return conn, br, hs, err
+		}
+		switch {
+		case next != nil:
+			if next.User == nil && sameOrigin(u, next) {
+				next.User = u.User
+			}
+			u = next
+			header = d.Header
+			authorized = false
+
+		case auth != "":
+			header = handshakeHeader{
+				d.Header,
+				HandshakeHeaderString(headerAuthorization + ": " + auth + crlf),
+			}
+			authorized = true
+		}
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case
+		http.StatusMovedPermanently,
+		http.StatusFound,
+		http.StatusSeeOther,
+		http.StatusTemporaryRedirect,
+		http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectLocation resolves the Location header value relative to the url u.
+func redirectLocation(u *url.URL, location []byte) (*url.URL, error) {
+	if len(location) == 0 {
+		return nil, ErrRedirectBadLocation
+	}
+	loc, err := u.Parse(string(location))
+	if err != nil {
+		return nil, ErrRedirectBadLocation
+	}
+	switch strings.ToLower(loc.Scheme) {
+	case "ws", "http":
+		loc.Scheme = "ws"
+	case "wss", "https":
+		loc.Scheme = "wss"
+	default:
+		return nil, ErrRedirectBadLocation
+	}
+	if loc.Host == "" {
+		return nil, ErrRedirectBadLocation
+	}
+	loc.Fragment = ""
+	return loc, nil
+}
+
+// sameOrigin reports whether urls a and b have the same host and port.
+// Upgrade from "ws" to "wss" on the same host is treated as the same origin if
+// both urls use default ports or the same explicit port.
+func sameOrigin(a, b *url.URL) bool {
+	if !strings.EqualFold(a.Hostname(), b.Hostname()) {
+		return false
+	}
+	pa, pb := urlPort(a), urlPort(b)
+	if a.Scheme != b.Scheme {
+		if a.Scheme != "ws" || b.Scheme != "wss" {
+			return false
+		}
+		return pa == pb || (pa == "80" && pb == "443")
+	}
+	return pa == pb
+}
+
+func urlPort(u *url.URL) string {
+	if p := u.Port(); p != "" {
+		return p
+	}
+	if u.Scheme == "wss" {
+		return "443"
+	}
+	return "80"
+}
@@ -0,0 +1,333 @@
+package ws
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDialerRedirect(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
+		if _, _, _, err := UpgradeHTTP(r, w); err != nil {
+			t.Errorf("upgrade error: %v", err)
+		}
+	})
+	mux.HandleFunc("/redirect/", func(w http.ResponseWriter, r *http.Request) {
+		// Redirects /redirect/N to /redirect/N-1 and /redirect/0 to /ws.
+		n := strings.TrimPrefix(r.URL.Path, "/redirect/")
+		if n == "0" {
+			http.Redirect(w, r, "/ws", http.StatusFound)
+			return
+		}
+		next := []byte(n)
+		next[0]--
+		http.Redirect(w, r, "/redirect/"+string(next), http.StatusTemporaryRedirect)
+	})
+	mux.HandleFunc("/cross", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "ws://example.org/ws", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/bad", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "ftp://example.org/")
+		w.WriteHeader(http.StatusPermanentRedirect)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	base := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	for _, test := range []struct {
+		name    string
+		path    string
+		policy  *RedirectPolicy
+		expErr  error
+		expHops int
+	}{
+		{
+			name:   "no policy",
+			path:   "/redirect/0",
+			expErr: StatusError(http.StatusFound),
+		},
+		{
+			name:    "single",
+			path:    "/redirect/0",
+			policy:  &RedirectPolicy{},
+			expHops: 1,
+		},
+		{
+			name:    "chain",
+			path:    "/redirect/3",
+			policy:  &RedirectPolicy{},
+			expHops: 4,
+		},
+		{
+			name: "too many",
+			path: "/redirect/3",
+			policy: &RedirectPolicy{
+				MaxRedirects: 3,
+			},
+			expErr:  ErrTooManyRedirects,
+			expHops: 3,
+		},
+		{
+			name:   "cross origin",
+			path:   "/cross",
+			policy: &RedirectPolicy{},
+			expErr: ErrRedirectCrossOrigin,
+		},
+		{
+			name:   "bad location",
+			path:   "/bad",
+			policy: &RedirectPolicy{},
+			expErr: ErrRedirectBadLocation,
+		},
+		{
+			name: "check",
+			path: "/redirect/1",
+			policy: &RedirectPolicy{
+				Check: func(u *url.URL, via []Hop) error {
+					if len(via) > 0 {
+						return ErrTooManyRedirects
+					}
+					return nil
+				},
+			},
+			expErr:  ErrTooManyRedirects,
+			expHops: 1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var statusErrors int
+			d := Dialer{
+				Redirect: test.policy,
+				OnStatusError: func(int, []byte, io.Reader) {
+					statusErrors++
+				},
+			}
+			conn, _, hs, err := d.Dial(context.Background(), base+test.path)
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if err == nil {
+				conn.Close()
+			}
+			if act, exp := len(hs.Hops), test.expHops; act != exp {
+				t.Fatalf("unexpected number of hops: %d; want %d", act, exp)
+			}
+			for i, hop := range hs.Hops {
+				if hop.Location == nil {
+					t.Errorf("#%d hop has no location", i)
+				}
+				if i > 0 && hs.Hops[i-1].Location.String() != hop.URL.String() {
+					t.Errorf("#%d hop is not chained: %s", i, hop.URL)
+				}
+			}
+			if _, ok := err.(StatusError); ok && statusErrors != 1 {
+				t.Errorf("OnStatusError called %d times; want 1", statusErrors)
+			}
+		})
+	}
+}
+
+func TestRedirectPolicyInsecure(t *testing.T) {
+	var (
+		from = &url.URL{Scheme: "wss", Host: "example.org"}
+		to   = &url.URL{Scheme: "ws", Host: "example.org"}
+	)
+	p := RedirectPolicy{CrossOrigin: true}
+	if err := p.check(from, to, nil); err != ErrRedirectInsecure {
+		t.Errorf("unexpected error: %v; want %v", err, ErrRedirectInsecure)
+	}
+	if err := p.check(to, from, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestSameOrigin(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		exp  bool
+	}{
+		{"ws://example.org", "ws://EXAMPLE.org:80", true},
+		{"ws://example.org", "ws://example.org:8080", false},
+		{"ws://example.org", "wss://example.org", true},
+		{"ws://example.org:80", "wss://example.org:443", true},
+		{"ws://example.org:8080", "wss://example.org:8080", true},
+		{"ws://example.org:8080", "wss://example.org:9443", false},
+		{"ws://example.org:8080", "wss://example.org", false},
+		{"ws://example.org", "wss://example.org:9443", false},
+		{"wss://example.org", "ws://example.org", false},
+		{"wss://example.org", "wss://example.com", false},
+	} {
+		a, _ := url.Parse(test.a)
+		b, _ := url.Parse(test.b)
+		if act := sameOrigin(a, b); act != test.exp {
+			t.Errorf("sameOrigin(%s, %s) = %t; want %t", a, b, act, test.exp)
+		}
+	}
+}
+
+func TestDialerAuth(t *testing.T) {
+	const (
+		user  = "gopher"
+		pass  = "secret"
+		token = "t0ken"
+		realm = "test"
+		nonce = "dcd98b7102dd2f0e8b11d0f600bfb0c093"
+	)
+	check := map[string]func(string) bool{
+		"Basic": func(v string) bool {
+			r := http.Request{Header: http.Header{"Authorization": {v}}}
+			u, p, ok := r.BasicAuth()
+			return ok && u == user && p == pass
+		},
+		"Bearer": func(v string) bool {
+			return v == "Bearer "+token
+		},
+		"Digest": func(v string) bool {
+			cs := parseAuthChallenges([][]byte{[]byte(v)})
+			if len(cs) != 1 {
+				return false
+			}
+			p := cs[0].Params
+			h := func(s ...string) string {
+				sum := md5.Sum([]byte(strings.Join(s, ":")))
+				return hex.EncodeToString(sum[:])
+			}
+			exp := h(
+				h(user, realm, pass),
+				nonce, p["nc"], p["cnonce"], p["qop"],
+				h(http.MethodGet, p["uri"]),
+			)
+			return p["username"] == user && p["response"] == exp && p["opaque"] == "xyz"
+		},
+	}
+	for _, test := range []struct {
+		name      string
+		challenge string
+		auth      []Authenticator
+		userinfo  *url.Userinfo
+		expErr    error
+	}{
+		{
+			name:      "no credentials",
+			challenge: `Basic realm="test"`,
+			expErr:    StatusError(http.StatusUnauthorized),
+		},
+		{
+			name:      "basic userinfo",
+			challenge: `Basic realm="test"`,
+			userinfo:  url.UserPassword(user, pass),
+		},
+		{
+			name:      "basic wrong",
+			challenge: `Basic realm="test"`,
+			userinfo:  url.UserPassword(user, "wrong"),
+			expErr:    StatusError(http.StatusUnauthorized),
+		},
+		{
+			name:      "bearer",
+			challenge: `Bearer realm="test", Basic realm="test"`,
+			auth:      []Authenticator{BearerAuth{token}},
+		},
+		{
+			name:      "digest",
+			challenge: `Digest realm="test", qop="auth,auth-int", nonce="` + nonce + `", opaque="xyz"`,
+			auth:      []Authenticator{DigestAuth{user, pass}},
+		},
+		{
+			name:      "digest userinfo",
+			challenge: `Newauth realm="apps", type=1, title="Login to \"apps\"", Digest realm="test", qop="auth", nonce="` + nonce + `", opaque="xyz"`,
+			userinfo:  url.UserPassword(user, pass),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				v := r.Header.Get("Authorization")
+				scheme := strings.SplitN(v, " ", 2)[0]
+				if f := check[scheme]; f == nil || !f(v) {
+					w.Header().Set("WWW-Authenticate", test.challenge)
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				if _, _, _, err := UpgradeHTTP(r, w); err != nil {
+					t.Errorf("upgrade error: %v", err)
+				}
+			}))
+			defer srv.Close()
+
+			u, _ := url.Parse("ws" + strings.TrimPrefix(srv.URL, "http") + "/ws?x=1")
+			u.User = test.userinfo
+			d := Dialer{
+				Authenticators: test.auth,
+			}
+			conn, _, hs, err := d.Dial(context.Background(), u.String())
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			conn.Close()
+			if len(hs.Hops) != 1 || hs.Hops[0].Status != http.StatusUnauthorized {
+				t.Errorf("unexpected hops: %+v", hs.Hops)
+			}
+		})
+	}
+}
+
+func TestParseAuthChallenges(t *testing.T) {
+	for _, test := range []struct {
+		in  []string
+		exp []AuthChallenge
+	}{
+		{
+			in: []string{`Basic realm="simple"`},
+			exp: []AuthChallenge{
+				{"Basic", map[string]string{"realm": "simple"}},
+			},
+		},
+		{
+			in: []string{
+				`Newauth realm="apps", type=1, title="Login to \"apps\"", Basic realm="simple"`,
+				`Bearer`,
+			},
+			exp: []AuthChallenge{
+				{"Newauth", map[string]string{
+					"realm": "apps",
+					"type":  "1",
+					"title": `Login to "apps"`,
+				}},
+				{"Basic", map[string]string{"realm": "simple"}},
+				{"Bearer", map[string]string{}},
+			},
+		},
+		{
+			in: []string{`Digest Realm = "a" ,qop="auth, auth-int"`},
+			exp: []AuthChallenge{
+				{"Digest", map[string]string{"realm": "a", "qop": "auth, auth-int"}},
+			},
+		},
+		{
+			in: []string{`Basic realm="unterminated`},
+			exp: []AuthChallenge{
+				{"Basic", map[string]string{}},
+			},
+		},
+	} {
+		values := make([][]byte, len(test.in))
+		for i, s := range test.in {
+			values[i] = []byte(s)
+		}
+		if act := parseAuthChallenges(values); !reflect.DeepEqual(act, test.exp) {
+			t.Errorf("parseAuthChallenges(%q):\nact: %+v\nexp: %+v", test.in, act, test.exp)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxErrorBodySize is the default number of bytes of response body
+// stored in ResponseError.
+const DefaultMaxErrorBodySize = 4096
+
+// ResponseError contains the non-101 response received from the server
+// during the handshake. It is returned by Dialer with CaptureHeader option
+// set instead of StatusError.
+type ResponseError struct {
+	// StatusCode and Reason are the status-line code and reason phrase.
+	StatusCode int
+	Reason     string
+
+	// Header contains the response headers.
+	Header http.Header
+
+	// Body contains the beginning of response body, limited by
+	// Dialer.MaxErrorBodySize.
+	Body []byte
+}
+
+// Error implements error interface.
+func (e *ResponseError) Error() string {
+	return StatusError(e.StatusCode).Error()
+}
+
+// Unwrap returns StatusError with the response status code. That is, it
+// makes errors.Is(err, StatusError(code)) checks work for ResponseError.
+func (e *ResponseError) Unwrap() error {
+	return StatusError(e.StatusCode)
+}
+
+// RetryAfter returns the delay after which client may retry the handshake,
+// as indicated by the Retry-After response header. It returns false if the
+// header is not present or malformed.
+//
+// See https://tools.ietf.org/html/rfc7231#section-7.1.3
+func (e *ResponseError) RetryAfter() (time.Duration, bool) {
+	return parseRetryAfter(e.Header.Get("Retry-After"), time.Now())
+}
+
+func parseRetryAfter(v string, now time.Time) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if n, err := strconv.ParseUint(v, 10, 32); err == nil {
+		return time.Duration(n) * time.Second, true
+	}
+	t, err := http.ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := t.Sub(now)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// readResponseError reads the HTTP response from r and returns it as a
+// *ResponseError. It also returns all bytes read from r, which are not
+// necessarily the bytes of the response only.
+//
+// Avail is the number of bytes which could be read from r without blocking.
+// If response body size is unknown (that is, the body is delimited by
+// connection close), then only those bytes are read from the body.
+func readResponseError(r io.Reader, avail, limit int) (consumed []byte, err error) {
+	var buf bytes.Buffer
+	br := bufio.NewReader(io.TeeReader(r, &buf))
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		return buf.Bytes(), ErrMalformedResponse
+	}
+	// NOTE: we do not close the body here since it leads to reading the
+	// whole body, which size is unknown.
+
+	rerr := &ResponseError{
+		StatusCode: resp.StatusCode,
+		Reason:     strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" "),
+		Header:     resp.Header,
+	}
+	if limit == 0 {
+		limit = DefaultMaxErrorBodySize
+	}
+	if limit > 0 && resp.ContentLength < 0 && !chunked(resp.TransferEncoding) {
+		// Reading the body until connection close might block forever.
+		limit = min(limit, br.Buffered()+avail-buf.Len())
+	}
+	if limit > 0 {
+		// Ignore read error since we are interested only in the beginning of
+		// the body.
+		rerr.Body, _ = ioutil.ReadAll(io.LimitReader(resp.Body, int64(limit)))
+	}
+	return buf.Bytes(), rerr
+}
+
+func chunked(te []string) bool {
+	return len(te) > 0 && te[0] == "chunked"
+}
+
+// parseHeaderLines parses header lines separated by '\n' into the
+// http.Header.
+func parseHeaderLines(p []byte) http.Header {
+	var (
+		// Convert whole block once to reuse its memory for header values.
+		s = string(p)
+		h = make(http.Header)
+	)
+	for len(s) > 0 {
+		var line string
+		if i := strings.IndexByte(s, '\n'); i != -1 {
+			line, s = s[:i], s[i+1:]
+		} else {
+			line, s = s, ""
+		}
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+		k := textproto.CanonicalMIMEHeaderKey(strings.TrimRight(line[:colon], " \t"))
+		v := strings.Trim(line[colon+1:], " \t")
+		h[k] = append(h[k], v)
+	}
+	return h
+}
@@ -0,0 +1,209 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDialerCaptureHeader(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u := HTTPUpgrader{
+			Header: http.Header{
+				"X-Ratelimit-Remaining": {"42"},
+				"Set-Cookie":            {"a=1", "b=2"},
+			},
+		}
+		if _, _, _, err := u.Upgrade(r, w); err != nil {
+			t.Errorf("upgrade error: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	d := Dialer{
+		CaptureHeader: true,
+	}
+	conn, _, hs, err := d.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	if act, exp := hs.Header.Get("X-Ratelimit-Remaining"), "42"; act != exp {
+		t.Errorf("unexpected header value: %q; want %q", act, exp)
+	}
+	if act, exp := hs.Header["Set-Cookie"], []string{"a=1", "b=2"}; !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected header values: %q; want %q", act, exp)
+	}
+	if act, exp := hs.Header.Get("Upgrade"), "websocket"; act != exp {
+		t.Errorf("unexpected header value: %q; want %q", act, exp)
+	}
+}
+
+func TestDialerResponseError(t *testing.T) {
+	const body = `{"error":"too many requests"}`
+	for _, test := range []struct {
+		name    string
+		chunked bool
+		limit   int
+		expBody string
+	}{
+		{
+			name:    "default",
+			expBody: body,
+		},
+		{
+			name:    "chunked",
+			chunked: true,
+			expBody: body,
+		},
+		{
+			name:    "limit",
+			limit:   8,
+			expBody: body[:8],
+		},
+		{
+			name:  "no body",
+			limit: -1,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Retry-After", "120")
+				if !test.chunked {
+					w.Header().Set("Content-Length", "29")
+				}
+				w.WriteHeader(http.StatusTooManyRequests)
+				io.WriteString(w, body)
+			}))
+			defer srv.Close()
+
+			var raw []byte
+			d := Dialer{
+				CaptureHeader:    true,
+				MaxErrorBodySize: test.limit,
+				OnStatusError: func(status int, reason []byte, r io.Reader) {
+					resp, err := http.ReadResponse(bufio.NewReader(r), nil)
+					if err != nil {
+						t.Errorf("read response error: %v", err)
+						return
+					}
+					raw, _ = ioutil.ReadAll(resp.Body)
+				},
+			}
+			_, _, _, err := d.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+
+			var rerr *ResponseError
+			if !errors.As(err, &rerr) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !errors.Is(err, StatusError(http.StatusTooManyRequests)) {
+				t.Errorf("error does not match StatusError")
+			}
+			if act, exp := rerr.Reason, "Too Many Requests"; act != exp {
+				t.Errorf("unexpected reason: %q; want %q", act, exp)
+			}
+			if act, exp := rerr.Header.Get("Content-Type"), "application/json"; act != exp {
+				t.Errorf("unexpected header value: %q; want %q", act, exp)
+			}
+			if act, exp := string(rerr.Body), test.expBody; act != exp {
+				t.Errorf("unexpected body: %q; want %q", act, exp)
+			}
+			if act, ok := rerr.RetryAfter(); !ok || act != 2*time.Minute {
+				t.Errorf("unexpected RetryAfter(): %v, %t", act, ok)
+			}
+			if !bytes.Equal(raw, []byte(body)) {
+				t.Errorf("unexpected body in OnStatusError: %q", raw)
+			}
+		})
+	}
+}
+
+func TestDialerResponseErrorUnknownLength(t *testing.T) {
+	const body = "service is down"
+	ln := listen(t)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		http.ReadRequest(bufio.NewReader(conn))
+		io.WriteString(conn, ""+
+			"HTTP/1.1 503 Service Unavailable\r\n"+
+			"Content-Type: text/plain\r\n"+
+			"\r\n"+
+			body,
+		)
+		// Keep the connection open; body is delimited by connection close.
+		io.Copy(ioutil.Discard, conn)
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		d := Dialer{
+			CaptureHeader: true,
+		}
+		_, _, _, err := d.Dial(context.Background(), "ws://"+ln.Addr().String())
+		done <- err
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Dial() is blocked by reading response body")
+	}
+	var rerr *ResponseError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if act := string(rerr.Body); act != body {
+		t.Errorf("unexpected body: %q; want %q", act, body)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2015, 10, 21, 7, 28, 0, 0, time.UTC)
+	for _, test := range []struct {
+		in  string
+		exp time.Duration
+		ok  bool
+	}{
+		{"", 0, false},
+		{"0", 0, true},
+		{"120", 2 * time.Minute, true},
+		{"-1", 0, false},
+		{"soon", 0, false},
+		{"Wed, 21 Oct 2015 07:30:00 GMT", 2 * time.Minute, true},
+		{"Wed, 21 Oct 2015 07:00:00 GMT", 0, true},
+	} {
+		act, ok := parseRetryAfter(test.in, now)
+		if act != test.exp || ok != test.ok {
+			t.Errorf(
+				"parseRetryAfter(%q) = %v, %t; want %v, %t",
+				test.in, act, ok, test.exp, test.ok,
+			)
+		}
+	}
+}
+
+func TestParseHeaderLines(t *testing.T) {
+	h := parseHeaderLines([]byte("content-type: text/plain\nX-Foo:bar \nx-foo: baz\nbad\n"))
+	exp := http.Header{
+		"Content-Type": {"text/plain"},
+		"X-Foo":        {"bar", "baz"},
+	}
+	if !reflect.DeepEqual(h, exp) {
+		t.Errorf("unexpected header: %v; want %v", h, exp)
+	}
+}
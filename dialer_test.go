@@ -141,6 +141,8 @@ func BenchmarkCheckNonce(b *testing.B) {
 	}
 }
 
+var errBadExtensionParam = fmt.Errorf("bad extension parameter")
+
 func TestDialerHandshake(t *testing.T) {
 	const (
 		acceptNo = iota
@@ -447,6 +449,32 @@ func TestDialerHandshake(t *testing.T) {
 			accept: acceptValid,
 			err:    ErrHandshakeBadExtensions,
 		},
+		{
+			name: "extension rejected",
+			dialer: Dialer{
+				Extensions: []httphead.Option{
+					httphead.NewOption("foo", nil),
+				},
+				OnExtension: func(opt httphead.Option) error {
+					if v, _ := opt.Parameters.Get("bar"); string(v) != "1" {
+						return nil
+					}
+					return errBadExtensionParam
+				},
+			},
+			res: &http.Response{
+				StatusCode: http.StatusSwitchingProtocols,
+				ProtoMajor: 1,
+				ProtoMinor: 1,
+				Header: http.Header{
+					headerConnection:    []string{"Upgrade"},
+					headerUpgrade:       []string{"websocket"},
+					headerSecExtensions: []string{"foo;bar=1"},
+				},
+			},
+			accept: acceptValid,
+			err:    errBadExtensionParam,
+		},
 	} {
 		t.Run(test.name, func(t *testing.T) {
 			client, server := net.Pipe()
@@ -0,0 +1,121 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"net"
+)
+
+// ErrEncoderBusy is returned by Encoder.Encode() when previous frame is not
+// completely written yet.
+var ErrEncoderBusy = fmt.Errorf("encoder has not written previous frame")
+
+// Encoder is an incremental WebSocket frame encoder which doesn't make any
+// I/O by itself. It holds the position of written bytes, thus writes could
+// be resumed after partial writes, e.g. to non-blocking sockets.
+//
+// Typical usage is:
+//
+//	if err := e.Encode(frame); err != nil {
+//		// handle error.
+//	}
+//	for e.Buffered() > 0 {
+//		n, err := conn.Write(e.Bytes())
+//		e.Advance(n)
+//		if err != nil {
+//			// handle error; writing might be resumed later.
+//		}
+//	}
+//
+// As WriteFrame() does, Encoder writes frame payload as is. That is, it must
+// be masked before if header is masked (see MaskFrameInPlace()).
+type Encoder struct {
+	hdr     [MaxHeaderSize]byte
+	hn      int // Number of header bytes.
+	payload []byte
+	pos     int // Number of written bytes.
+
+	buf  [2][]byte   // Backing array for bufs.
+	bufs net.Buffers // Used by WriteTo().
+}
+
+// Encode prepares frame f to be written. The frame payload is not copied, so
+// it must not be modified until the frame is written.
+//
+// It returns ErrEncoderBusy if previous frame is not written yet.
+func (e *Encoder) Encode(f Frame) error {
+	if e.Buffered() > 0 {
+		return ErrEncoderBusy
+	}
+	n, err := putHeader(e.hdr[:], f.Header)
+	if err != nil {
+		return err
+	}
+	e.hn = n
+	e.payload = f.Payload
+	e.pos = 0
+	return nil
+}
+
+// Buffered returns the number of bytes of the frame which are not written
+// yet.
+func (e *Encoder) Buffered() int {
+	return e.hn + len(e.payload) - e.pos
+}
+
+// Bytes returns the next chunk of bytes to be written. It is valid until the
+// next call to Advance() or Encode().
+func (e *Encoder) Bytes() []byte {
+	if e.pos < e.hn {
+		return e.hdr[e.pos:e.hn]
+	}
+	return e.payload[e.pos-e.hn:]
+}
+
+// Advance marks n bytes as written. It panics if n is greater than
+// Buffered().
+func (e *Encoder) Advance(n int) {
+	if n < 0 || n > e.Buffered() {
+		panic("ws: encoder advanced beyond the frame")
+	}
+	e.pos += n
+	if e.Buffered() == 0 {
+		e.hn = 0
+		e.payload = nil
+		e.pos = 0
+	}
+}
+
+// Reset drops the frame which is being written.
+func (e *Encoder) Reset() {
+	e.hn = 0
+	e.payload = nil
+	e.pos = 0
+}
+
+// WriteTo writes buffered bytes of the frame to w. If error occurs, writing
+// could be resumed by the next WriteTo() call.
+//
+// Header and payload are written as net.Buffers, thus if w is a *net.TCPConn
+// they are sent by a single writev(2) system call.
+func (e *Encoder) WriteTo(w io.Writer) (n int64, err error) {
+	buffered := e.Buffered()
+	if buffered == 0 {
+		return 0, nil
+	}
+	if e.pos < e.hn {
+		e.buf[0] = e.hdr[e.pos:e.hn]
+		e.buf[1] = e.payload
+		e.bufs = e.buf[:2]
+	} else {
+		e.buf[0] = e.payload[e.pos-e.hn:]
+		e.bufs = e.buf[:1]
+	}
+	n, err = e.bufs.WriteTo(w)
+	e.buf = [2][]byte{}
+	e.Advance(int(n))
+	if err == nil && n < int64(buffered) {
+		err = io.ErrShortWrite
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var errAgain = errors.New("try again")
+
+// limitedWriter writes at most n bytes per call and fails if more bytes are
+// given.
+type limitedWriter struct {
+	buf bytes.Buffer
+	n   int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > w.n {
+		w.buf.Write(p[:w.n])
+		return w.n, errAgain
+	}
+	return w.buf.Write(p)
+}
+
+func TestEncoder(t *testing.T) {
+	frames := []Frame{
+		NewTextFrame([]byte("hello, world!")),
+		NewPingFrame(nil),
+		MaskFrameWith(NewBinaryFrame(bytes.Repeat([]byte{'x'}, 300)), [4]byte{1, 2, 3, 4}),
+	}
+	for _, n := range []int{1, 3, 100, 1000} {
+		var (
+			e   Encoder
+			w   = limitedWriter{n: n}
+			exp []byte
+		)
+		for _, f := range frames {
+			exp = append(exp, MustCompileFrame(f)...)
+			if err := e.Encode(f); err != nil {
+				t.Fatal(err)
+			}
+			if e.Buffered() > 1 {
+				if err := e.Encode(f); err != ErrEncoderBusy {
+					t.Fatalf("unexpected Encode() error: %v; want %v", err, ErrEncoderBusy)
+				}
+			}
+			for {
+				_, err := e.WriteTo(&w)
+				if err == nil {
+					break
+				}
+				if err != errAgain {
+					t.Fatal(err)
+				}
+			}
+			if b := e.Buffered(); b != 0 {
+				t.Fatalf("unexpected buffered bytes: %d", b)
+			}
+		}
+		if act := w.buf.Bytes(); !bytes.Equal(act, exp) {
+			t.Errorf("limit %d: unexpected bytes:\n\tact: %v\n\texp: %v", n, act, exp)
+		}
+	}
+}
+
+func TestEncoderParser(t *testing.T) {
+	var (
+		e Encoder
+		p = NewParser(StateClientSide)
+	)
+	f := NewTextFrame([]byte("hello"))
+	if err := e.Encode(f); err != nil {
+		t.Fatal(err)
+	}
+	var payload []byte
+	for e.Buffered() > 0 {
+		// Feed the parser by one byte.
+		b := e.Bytes()[:1]
+		n, ev, err := p.Parse(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev == EventHeader && p.Header() != f.Header {
+			t.Errorf("unexpected header: %+v; want %+v", p.Header(), f.Header)
+		}
+		if ev == EventPayload {
+			payload = append(payload, b[:n]...)
+		}
+		e.Advance(n)
+	}
+	if _, ev, _ := p.Parse(nil); ev != EventFrameEnd {
+		t.Errorf("unexpected event: %s; want %s", ev, EventFrameEnd)
+	}
+	if !bytes.Equal(payload, f.Payload) {
+		t.Errorf("unexpected payload: %q", payload)
+	}
+}
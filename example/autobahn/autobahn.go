@@ -183,8 +183,12 @@ func wsflateHandler(w http.ResponseWriter, r *http.Request) {
 			ClientNoContextTakeover: true,
 		},
 	}
+	// Other extensions could be negotiated by adding them to the list.
+	xs := ws.ServerExtensions{
+		Extensions: []ws.ServerExtension{&e},
+	}
 	u := ws.HTTPUpgrader{
-		Negotiate: e.Negotiate,
+		Negotiate: xs.Negotiate,
 	}
 	conn, _, _, err := u.Upgrade(r, w)
 	if err != nil {
@@ -198,8 +202,8 @@ func wsflateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Using nil as a destination io.Writer since we will Reset() it in the
-	// loop below.
+	// Using nil as a destination io.Writer since Transform will Reset() it
+	// for each message.
 	fw := wsflate.NewWriter(nil, func(w io.Writer) wsflate.Compressor {
 		// As flat.NewWriter() docs says:
 		//   If level is in the range [-2, 9] then the error returned will
@@ -207,17 +211,22 @@ func wsflateHandler(w http.ResponseWriter, r *http.Request) {
 		f, _ := flate.NewWriter(w, 9)
 		return f
 	})
-	// Using nil as a source io.Reader since we will Reset() it in the loop
-	// below.
+	// Using nil as a source io.Reader since Transform will Reset() it for
+	// each message.
 	fr := wsflate.NewReader(nil, func(r io.Reader) wsflate.Decompressor {
 		return flate.NewReader(r)
 	})
 
-	// MessageState implements wsutil.Extension and is used to check whether
-	// received WebSocket message is compressed. That is, it's generally
-	// possible to receive uncompressed messaged even if compression extension
-	// was negotiated.
-	var msg wsflate.MessageState
+	// Chain of negotiated extensions handles RSV bits of frames and
+	// transforms payloads of messages. Reader and Writer apply it on their
+	// own, so application bytes are read and written as is. Note that it's
+	// generally possible to receive uncompressed messages even if
+	// compression extension was negotiated; Transform handles it too.
+	chain, err := wsutil.NewChain(wsflate.NewTransform(fr, fw))
+	if err != nil {
+		log.Printf("extensions error: %v", err)
+		return
+	}
 
 	// Note that control frames are all written without compression.
 	controlHandler := wsutil.ControlFrameHandler(conn, ws.StateServerSide)
@@ -226,11 +235,11 @@ func wsflateHandler(w http.ResponseWriter, r *http.Request) {
 		State:          ws.StateServerSide | ws.StateExtended,
 		CheckUTF8:      false,
 		OnIntermediate: controlHandler,
-		Extensions:     []wsutil.RecvExtension{&msg},
+		Extensions:     []wsutil.RecvExtension{chain},
 	}
 
 	wr := wsutil.NewWriter(conn, ws.StateServerSide|ws.StateExtended, 0)
-	wr.SetExtensions(&msg)
+	wr.SetExtensions(chain)
 
 	for {
 		h, err := rd.NextFrame()
@@ -248,29 +257,12 @@ func wsflateHandler(w http.ResponseWriter, r *http.Request) {
 
 		wr.ResetOp(h.OpCode)
 
-		var (
-			src io.Reader = &rd
-			dst io.Writer = wr
-		)
-		if msg.IsCompressed() {
-			fr.Reset(src)
-			fw.Reset(dst)
-			src = fr
-			dst = fw
-		}
-		// Copy incoming bytes right into writer, probably through decompressor
-		// and compressor.
-		if _, err = io.Copy(dst, src); err != nil {
+		// Copy decompressed bytes right into writer which compresses them.
+		if _, err = io.Copy(wr, &rd); err != nil {
 			log.Fatal(err)
 		}
-		if msg.IsCompressed() {
-			// Flush the flate writer.
-			if err = fw.Close(); err != nil {
-				log.Fatal(err)
-			}
-		}
-		// Flush WebSocket fragment writer. We could send multiple fragments
-		// for large messages.
+		// Flush the extensions and WebSocket fragment writer. We could send
+		// multiple fragments for large messages.
 		if err = wr.Flush(); err != nil {
 			log.Fatal(err)
 		}
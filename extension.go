@@ -0,0 +1,179 @@
+package ws
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/gobwas/httphead"
+)
+
+// ErrExtensionConflict is returned when extensions use the same RSV bits.
+var ErrExtensionConflict = errors.New("extensions use the same RSV bits")
+
+// ServerExtension is an extension negotiated by the server during handshake.
+// Its Negotiate method has the same semantics as Upgrader.Negotiate field:
+// it returns zero option (i.e. one which Size() returns 0) for declined
+// offers and offers of other extensions.
+type ServerExtension interface {
+	Negotiate(httphead.Option) (httphead.Option, error)
+}
+
+// ClientExtension is an extension negotiated by the client during handshake.
+// Offers returns extension offers ordered by preference; Negotiate has the
+// same semantics as Dialer.OnExtension field.
+type ClientExtension interface {
+	Offers() []httphead.Option
+	Negotiate(httphead.Option) error
+}
+
+// RsvExtension is an optional interface for extensions which use RSV bits of
+// frame headers.
+type RsvExtension interface {
+	// Rsv returns the RSV bits the extension sets in frame headers, in the
+	// same layout as Rsv() returns. The result must not depend on the
+	// negotiation state: it is used to detect conflicts between extensions
+	// before any of them accepts an offer.
+	Rsv() byte
+}
+
+// Resetter is an optional interface for extensions which must be reset
+// before the next handshake.
+type Resetter interface {
+	Reset()
+}
+
+// ServerExtensions negotiates a list of extensions on the server side.
+// Its Negotiate method could be used as Upgrader.Negotiate or
+// HTTPUpgrader.Negotiate field.
+//
+// Each offer is passed to the extensions in list order until one of them
+// accepts it. Extensions which implement RsvExtension are skipped if the RSV
+// bits they use are already used by previously accepted extensions. That is,
+// extensions using the same bits are accepted in order of client preference.
+//
+// It might be reused between different upgrades (but not concurrently) with
+// Reset() being called after each.
+type ServerExtensions struct {
+	Extensions []ServerExtension
+
+	rsv byte
+}
+
+// Negotiate implements Upgrader.Negotiate callback.
+func (s *ServerExtensions) Negotiate(opt httphead.Option) (accept httphead.Option, err error) {
+	for _, x := range s.Extensions {
+		bits := rsvBits(x)
+		if s.rsv&bits != 0 {
+			continue
+		}
+		accept, err = x.Negotiate(opt)
+		if err != nil {
+			return accept, err
+		}
+		if accept.Size() > 0 {
+			s.rsv |= bits
+			return accept, nil
+		}
+	}
+	return accept, nil
+}
+
+// Rsv returns RSV bits used by accepted extensions.
+func (s *ServerExtensions) Rsv() byte {
+	return s.rsv
+}
+
+// Reset resets s and extensions implementing Resetter for further reuse.
+func (s *ServerExtensions) Reset() {
+	s.rsv = 0
+	for _, x := range s.Extensions {
+		if r, ok := x.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+// ClientExtensions negotiates a list of extensions on the client side.
+// Its Offers and Negotiate methods could be used as Dialer.Extensions and
+// Dialer.OnExtension fields respectively.
+//
+// Each option of the response is passed to the extension which offered it.
+// Negotiate returns ErrExtensionConflict if accepted extensions implementing
+// RsvExtension use the same RSV bits.
+//
+// It might be reused between different handshakes (but not concurrently)
+// with Reset() being called after each.
+type ClientExtensions struct {
+	Extensions []ClientExtension
+
+	offers [][]httphead.Option
+	rsv    byte
+}
+
+// Offers returns offers of all extensions in list order.
+func (c *ClientExtensions) Offers() (ret []httphead.Option) {
+	c.offers = c.offers[:0]
+	for _, x := range c.Extensions {
+		opts := x.Offers()
+		c.offers = append(c.offers, opts)
+		ret = append(ret, opts...)
+	}
+	return ret
+}
+
+// Negotiate implements Dialer.OnExtension callback.
+func (c *ClientExtensions) Negotiate(opt httphead.Option) error {
+	if len(c.offers) != len(c.Extensions) {
+		// Offers() were not called; make them to know which extension
+		// offered the option.
+		c.Offers()
+	}
+	for i, x := range c.Extensions {
+		if !c.offered(i, opt.Name) {
+			continue
+		}
+		bits := rsvBits(x)
+		if c.rsv&bits != 0 {
+			return ErrExtensionConflict
+		}
+		if err := x.Negotiate(opt); err != nil {
+			return err
+		}
+		c.rsv |= bits
+		return nil
+	}
+	return nil
+}
+
+// Rsv returns RSV bits used by accepted extensions.
+func (c *ClientExtensions) Rsv() byte {
+	return c.rsv
+}
+
+// Reset resets c and extensions implementing Resetter for further reuse.
+func (c *ClientExtensions) Reset() {
+	c.rsv = 0
+	c.offers = c.offers[:0]
+	for _, x := range c.Extensions {
+		if r, ok := x.(Resetter); ok {
+			r.Reset()
+		}
+	}
+}
+
+// offered reports whether i-th extension offered extension with given name.
+func (c *ClientExtensions) offered(i int, name []byte) bool {
+	for _, opt := range c.offers[i] {
+		if bytes.Equal(opt.Name, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func rsvBits(x interface{}) byte {
+	if r, ok := x.(RsvExtension); ok {
+		return r.Rsv()
+	}
+	return 0
+}
@@ -0,0 +1,129 @@
+package ws
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+// stubExtension accepts offers of extension with given name and uses given
+// RSV bits.
+type stubExtension struct {
+	name     string
+	rsv      byte
+	accepted int
+}
+
+func (s *stubExtension) Negotiate(opt httphead.Option) (httphead.Option, error) {
+	if string(opt.Name) != s.name {
+		return httphead.Option{}, nil
+	}
+	s.accepted++
+	return httphead.NewOption(s.name, nil), nil
+}
+
+func (s *stubExtension) Offers() []httphead.Option {
+	return []httphead.Option{httphead.NewOption(s.name, nil)}
+}
+
+func (s *stubExtension) Accept(opt httphead.Option) error {
+	s.accepted++
+	return nil
+}
+
+func (s *stubExtension) Rsv() byte {
+	return s.rsv
+}
+
+func (s *stubExtension) Reset() {
+	s.accepted = 0
+}
+
+// stubClientExtension adapts stubExtension to ClientExtension interface.
+type stubClientExtension struct {
+	*stubExtension
+}
+
+func (s stubClientExtension) Negotiate(opt httphead.Option) error {
+	return s.Accept(opt)
+}
+
+func TestServerExtensions(t *testing.T) {
+	var (
+		a = &stubExtension{name: "a", rsv: Rsv(true, false, false)}
+		b = &stubExtension{name: "b", rsv: Rsv(true, false, false)}
+		c = &stubExtension{name: "c", rsv: Rsv(false, true, false)}
+		d = &stubExtension{name: "d"}
+	)
+	xs := ServerExtensions{
+		Extensions: []ServerExtension{a, b, c, d},
+	}
+	var accepted []string
+	for _, name := range []string{"b", "a", "c", "d", "x"} {
+		resp, err := xs.Negotiate(httphead.NewOption(name, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Size() > 0 {
+			accepted = append(accepted, string(resp.Name))
+		}
+	}
+	if exp := []string{"b", "c", "d"}; !equalStrings(accepted, exp) {
+		t.Errorf("unexpected accepted extensions: %v; want %v", accepted, exp)
+	}
+	if act, exp := xs.Rsv(), Rsv(true, true, false); act != exp {
+		t.Errorf("unexpected rsv bits: %#x; want %#x", act, exp)
+	}
+	xs.Reset()
+	if xs.Rsv() != 0 || b.accepted != 0 {
+		t.Errorf("extensions were not reset")
+	}
+}
+
+func TestClientExtensions(t *testing.T) {
+	var (
+		a = &stubExtension{name: "a", rsv: Rsv(true, false, false)}
+		b = &stubExtension{name: "b", rsv: Rsv(true, false, false)}
+		c = &stubExtension{name: "c", rsv: Rsv(false, true, false)}
+	)
+	xs := ClientExtensions{
+		Extensions: []ClientExtension{
+			stubClientExtension{a},
+			stubClientExtension{b},
+			stubClientExtension{c},
+		},
+	}
+	var names [][]byte
+	for _, opt := range xs.Offers() {
+		names = append(names, opt.Name)
+	}
+	if exp := [][]byte{[]byte("a"), []byte("b"), []byte("c")}; !bytes.Equal(
+		bytes.Join(names, []byte(",")), bytes.Join(exp, []byte(",")),
+	) {
+		t.Fatalf("unexpected offers: %q", names)
+	}
+	for _, name := range []string{"a", "c"} {
+		if err := xs.Negotiate(httphead.NewOption(name, nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if a.accepted != 1 || b.accepted != 0 || c.accepted != 1 {
+		t.Errorf("unexpected negotiation: %d %d %d", a.accepted, b.accepted, c.accepted)
+	}
+	if err := xs.Negotiate(httphead.NewOption("b", nil)); err != ErrExtensionConflict {
+		t.Errorf("unexpected error: %v; want %v", err, ErrExtensionConflict)
+	}
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
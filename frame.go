@@ -3,7 +3,7 @@ package ws
 import (
 	"bytes"
 	"encoding/binary"
-	"math/rand"
+	"io"
 )
 
 // Constants defined by specification.
@@ -375,12 +375,20 @@ func MaskFrameInPlaceWith(f Frame, m [4]byte) Frame {
 	return f
 }
 
-// NewMask creates new random mask.
+// NewMask creates new random mask using DefaultRand.
+// It panics if DefaultRand returns an error.
 func NewMask() (ret [4]byte) {
-	binary.BigEndian.PutUint32(ret[:], rand.Uint32())
+	mustReadRand(nil, ret[:])
 	return ret
 }
 
+// NewMaskFrom creates new random mask using bytes read from r.
+// If r is nil, then DefaultRand is used.
+func NewMaskFrom(r io.Reader) (ret [4]byte, err error) {
+	err = readRand(r, ret[:])
+	return ret, err
+}
+
 // CompileFrame returns byte representation of given frame.
 // In terms of memory consumption it is useful to precompile static frames
 // which are often used.
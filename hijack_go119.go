@@ -5,10 +5,14 @@ package ws
 
 import (
 	"bufio"
+	"errors"
 	"net"
 	"net/http"
+	"time"
 )
 
+var errDeadlineNotSupported = errors.New("setting deadlines is not supported")
+
 func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
 	hj, ok := w.(http.Hijacker)
 	if ok {
@@ -16,3 +20,18 @@ func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
 	}
 	return nil, nil, ErrNotHijacker
 }
+
+func responseFlush(w http.ResponseWriter) error {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+func responseSetReadDeadline(w http.ResponseWriter, t time.Time) error {
+	return errDeadlineNotSupported
+}
+
+func responseSetWriteDeadline(w http.ResponseWriter, t time.Time) error {
+	return errDeadlineNotSupported
+}
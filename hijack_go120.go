@@ -8,6 +8,7 @@ import (
 	"errors"
 	"net"
 	"net/http"
+	"time"
 )
 
 func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
@@ -17,3 +18,15 @@ func hijack(w http.ResponseWriter) (net.Conn, *bufio.ReadWriter, error) {
 	}
 	return conn, rw, err
 }
+
+func responseFlush(w http.ResponseWriter) error {
+	return http.NewResponseController(w).Flush()
+}
+
+func responseSetReadDeadline(w http.ResponseWriter, t time.Time) error {
+	return http.NewResponseController(w).SetReadDeadline(t)
+}
+
+func responseSetWriteDeadline(w http.ResponseWriter, t time.Time) error {
+	return http.NewResponseController(w).SetWriteDeadline(t)
+}
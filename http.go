@@ -48,6 +48,20 @@ const (
 	headerSecKey        = "Sec-WebSocket-Key"
 	headerSecAccept     = "Sec-WebSocket-Accept"
 
+	headerProxyAuthorization = "Proxy-Authorization"
+	headerAuthorization      = "Authorization"
+	headerLocation           = "Location"
+	headerWWWAuthenticate    = "WWW-Authenticate"
+	headerCookie             = "Cookie"
+	headerSetCookie          = "Set-Cookie"
+	headerOrigin             = "Origin"
+	headerForwarded          = "Forwarded"
+	headerXForwardedFor      = "X-Forwarded-For"
+
+	// headerConnectProtocol is the pseudo-header of HTTP/2 extended CONNECT
+	// method. Note that net/http puts it into the request headers as is.
+	headerConnectProtocol = ":protocol"
+
 	headerHostCanonical          = headerHost
 	headerUpgradeCanonical       = headerUpgrade
 	headerConnectionCanonical    = headerConnection
@@ -56,6 +70,14 @@ const (
 	headerSecExtensionsCanonical = "Sec-Websocket-Extensions"
 	headerSecKeyCanonical        = "Sec-Websocket-Key"
 	headerSecAcceptCanonical     = "Sec-Websocket-Accept"
+
+	headerLocationCanonical        = headerLocation
+	headerWWWAuthenticateCanonical = "Www-Authenticate"
+	headerSetCookieCanonical       = headerSetCookie
+	headerOriginCanonical          = headerOrigin
+	headerCookieCanonical          = headerCookie
+	headerForwardedCanonical       = headerForwarded
+	headerXForwardedForCanonical   = headerXForwardedFor
 )
 
 var (
@@ -287,6 +309,7 @@ func httpWriteUpgradeRequest(
 	extensions []httphead.Option,
 	header HandshakeHeader,
 	host string,
+	cookies []*http.Cookie,
 ) {
 	bw.WriteString("GET ")
 	bw.WriteString(u.RequestURI())
@@ -324,6 +347,24 @@ func httpWriteUpgradeRequest(
 		bw.WriteString(crlf)
 	}
 
+	if len(cookies) > 0 {
+		// Cookies must be sent within a single header line. See
+		// https://tools.ietf.org/html/rfc6265#section-5.4
+		var cookie string
+		if header != nil {
+			cookie, header = splitCookieHeader(header)
+		}
+		httpWriteHeaderKey(bw, headerCookie)
+		bw.WriteString(cookie)
+		for i, c := range cookies {
+			if i > 0 || cookie != "" {
+				bw.WriteString("; ")
+			}
+			writeCookie(bw, c)
+		}
+		bw.WriteString(crlf)
+	}
+
 	if header != nil {
 		header.WriteTo(bw)
 	}
@@ -74,6 +74,38 @@ func TestHeaderNames(t *testing.T) {
 			have: headerSecAccept,
 			want: headerSecAcceptCanonical,
 		},
+		{
+			have: headerConnectProtocol,
+			want: headerConnectProtocol,
+		},
+		{
+			have: headerLocation,
+			want: headerLocationCanonical,
+		},
+		{
+			have: headerWWWAuthenticate,
+			want: headerWWWAuthenticateCanonical,
+		},
+		{
+			have: headerSetCookie,
+			want: headerSetCookieCanonical,
+		},
+		{
+			have: headerOrigin,
+			want: headerOriginCanonical,
+		},
+		{
+			have: headerCookie,
+			want: headerCookieCanonical,
+		},
+		{
+			have: headerForwarded,
+			want: headerForwardedCanonical,
+		},
+		{
+			have: headerXForwardedFor,
+			want: headerXForwardedForCanonical,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -128,6 +160,7 @@ func BenchmarkHttpWriteUpgradeRequest(b *testing.B) {
 					test.extensions,
 					headers,
 					test.host,
+					nil,
 				)
 			}
 		})
@@ -0,0 +1,7 @@
+// Package cpu detects CPU features used by assembly implementations of the
+// ws packages.
+package cpu
+
+// HasAVX2 reports whether both CPU and operating system support AVX2
+// instructions. It is always false if the purego build tag is set.
+var HasAVX2 bool
@@ -0,0 +1,32 @@
+//go:build !purego
+// +build !purego
+
+package cpu
+
+func init() {
+	HasAVX2 = hasAVX2()
+}
+
+func cpuid(eaxArg, ecxArg uint32) (eax, ebx, ecx, edx uint32)
+
+func xgetbv() (eax, edx uint32)
+
+func hasAVX2() bool {
+	if max, _, _, _ := cpuid(0, 0); max < 7 {
+		return false
+	}
+	const (
+		osxsave = 1 << 27
+		avx     = 1 << 28
+		avx2    = 1 << 5
+	)
+	if _, _, ecx, _ := cpuid(1, 0); ecx&osxsave == 0 || ecx&avx == 0 {
+		return false
+	}
+	// Check that operating system saves XMM and YMM registers.
+	if eax, _ := xgetbv(); eax&0x6 != 0x6 {
+		return false
+	}
+	_, ebx, _, _ := cpuid(7, 0)
+	return ebx&avx2 != 0
+}
@@ -6,7 +6,7 @@ import (
 	"crypto/sha1"
 	"encoding/base64"
 	"fmt"
-	"math/rand"
+	"io"
 )
 
 const (
@@ -26,13 +26,23 @@ const (
 )
 
 // initNonce fills given slice with random base64-encoded nonce bytes.
+// It panics if DefaultRand returns an error.
 func initNonce(dst []byte) {
+	if err := initNonceFrom(dst, nil); err != nil {
+		panic(fmt.Sprintf("rand read error: %s", err))
+	}
+}
+
+// initNonceFrom fills given slice with base64-encoded nonce bytes read from
+// r. If r is nil, then DefaultRand is used.
+func initNonceFrom(dst []byte, r io.Reader) error {
 	// NOTE: bts does not escape.
 	bts := make([]byte, nonceKeySize)
-	if _, err := rand.Read(bts); err != nil {
-		panic(fmt.Sprintf("rand read error: %s", err))
+	if err := readRand(r, bts); err != nil {
+		return err
 	}
 	base64.StdEncoding.Encode(dst, bts)
+	return nil
 }
 
 // checkAcceptFromNonce reports whether given accept bytes are valid for given
@@ -0,0 +1,308 @@
+package ws
+
+import (
+	"encoding/binary"
+	"unicode/utf8"
+)
+
+// ErrProtocolInvalidUTF8Text is returned by Parser when text message contains
+// invalid utf8 sequence.
+var ErrProtocolInvalidUTF8Text = ProtocolError("invalid utf8 sequence in text message")
+
+// ParserEvent represents a result of Parser.Parse() call.
+type ParserEvent uint8
+
+// Parser events.
+const (
+	// EventNone means that more bytes are needed to make progress.
+	EventNone ParserEvent = iota
+	// EventHeader means that frame header was parsed. It is available
+	// through Parser.Header().
+	EventHeader
+	// EventPayload means that a chunk of frame payload was parsed.
+	EventPayload
+	// EventFrameEnd means that all payload of the frame was parsed.
+	EventFrameEnd
+)
+
+// String implements fmt.Stringer.
+func (e ParserEvent) String() string {
+	switch e {
+	case EventNone:
+		return "none"
+	case EventHeader:
+		return "header"
+	case EventPayload:
+		return "payload"
+	case EventFrameEnd:
+		return "frame end"
+	default:
+		return "unknown"
+	}
+}
+
+// Parser is an incremental WebSocket frames parser which doesn't make any
+// I/O. Instead, it is fed with arbitrary chunks of received bytes. That is,
+// it could be used with non-blocking sockets, user managed buffers or custom
+// transports.
+//
+// Parser checks each header with CheckHeader() and tracks fragmentation of
+// messages in its State field as wsutil.Reader does.
+//
+// Typical usage is:
+//
+//	for {
+//		n, e, err := p.Parse(buf)
+//		if err != nil {
+//			// handle error.
+//		}
+//		chunk := buf[:n]
+//		buf = buf[n:]
+//		switch e {
+//		case ws.EventNone:
+//			// All bytes are consumed; read more of them into buf.
+//			return
+//		case ws.EventHeader:
+//			// Handle p.Header().
+//		case ws.EventPayload:
+//			// Handle chunk of unmasked payload.
+//		case ws.EventFrameEnd:
+//			// Handle the end of frame.
+//		}
+//	}
+//
+// Note that EventFrameEnd is returned without consuming any bytes, so Parse()
+// must be called even if all bytes were consumed; it returns EventNone when it
+// needs more bytes.
+type Parser struct {
+	// State is the state of the endpoint. It is used to check received
+	// headers. Parser sets or clears StateFragmented bit accordingly to
+	// received data frames.
+	State State
+
+	// CheckUTF8 enables UTF-8 checks for text messages. Payload is checked
+	// incrementally, even if the sequence is split between frames.
+	CheckUTF8 bool
+
+	hdr   [MaxHeaderSize]byte
+	hn    int // Number of buffered header bytes.
+	h     Header
+	frame bool  // Header is parsed.
+	pos   int64 // Number of parsed payload bytes of the frame.
+	text  bool  // Current message is text.
+	utf8  utf8Validator
+	err   error
+}
+
+// NewParser creates a new Parser with given state.
+func NewParser(state State) *Parser {
+	return &Parser{
+		State: state,
+	}
+}
+
+// Reset resets the parser to parse new stream of frames with given state.
+func (p *Parser) Reset(state State) {
+	*p = Parser{
+		State:     state,
+		CheckUTF8: p.CheckUTF8,
+	}
+}
+
+// Header returns the header of the current frame. It is valid after
+// EventHeader was returned and until the next one.
+func (p *Parser) Header() Header {
+	return p.h
+}
+
+// Remaining returns the number of payload bytes of the current frame which
+// are not parsed yet.
+func (p *Parser) Remaining() int64 {
+	if !p.frame {
+		return 0
+	}
+	return p.h.Length - p.pos
+}
+
+// Parse parses bytes from b. It returns number of consumed bytes n and an
+// event describing them. If event is EventPayload, then b[:n] is the chunk of
+// the frame payload; note that masked payload is unmasked in place.
+//
+// Header bytes are buffered inside the Parser, so b may contain any part of
+// the stream. If EventNone is returned, all bytes of b are consumed.
+//
+// Once non-nil error is returned, all subsequent calls return it too.
+func (p *Parser) Parse(b []byte) (n int, e ParserEvent, err error) {
+	if p.err != nil {
+		return 0, EventNone, p.err
+	}
+	if !p.frame {
+		return p.parseHeader(b)
+	}
+	if p.pos == p.h.Length {
+		p.frame = false
+		if p.checkUTF8() && p.h.Fin && !p.utf8.valid() {
+			return p.fail(ErrProtocolInvalidUTF8Text)
+		}
+		return 0, EventFrameEnd, nil
+	}
+	if len(b) == 0 {
+		return 0, EventNone, nil
+	}
+	n = len(b)
+	if rem := p.h.Length - p.pos; int64(n) > rem {
+		n = int(rem)
+	}
+	chunk := b[:n]
+	if p.h.Masked {
+		Cipher(chunk, p.h.Mask, int(p.pos%4))
+	}
+	if p.checkUTF8() && !p.utf8.write(chunk) {
+		return p.fail(ErrProtocolInvalidUTF8Text)
+	}
+	p.pos += int64(n)
+	return n, EventPayload, nil
+}
+
+func (p *Parser) parseHeader(b []byte) (n int, e ParserEvent, err error) {
+	for {
+		need := headerSize(p.hdr[:p.hn])
+		if p.hn == need {
+			break
+		}
+		if n == len(b) {
+			return n, EventNone, nil
+		}
+		c := copy(p.hdr[p.hn:need], b[n:])
+		p.hn += c
+		n += c
+	}
+	h, err := decodeHeader(p.hdr[:p.hn])
+	if err == nil {
+		err = CheckHeader(h, p.State)
+	}
+	if err != nil {
+		_, _, err = p.fail(err)
+		return n, EventNone, err
+	}
+	if h.OpCode.IsData() {
+		if h.OpCode != OpContinuation {
+			p.text = h.OpCode == OpText
+			p.utf8.reset()
+		}
+		if h.Fin {
+			p.State = p.State.Clear(StateFragmented)
+		} else {
+			p.State = p.State.Set(StateFragmented)
+		}
+	}
+	p.h = h
+	p.hn = 0
+	p.pos = 0
+	p.frame = true
+	return n, EventHeader, nil
+}
+
+func (p *Parser) checkUTF8() bool {
+	return p.CheckUTF8 && p.text && p.h.OpCode.IsData()
+}
+
+func (p *Parser) fail(err error) (int, ParserEvent, error) {
+	p.err = err
+	return 0, EventNone, err
+}
+
+// headerSize returns the size of the header starting with bts. It returns
+// MinHeaderSize if bts is too short to decide.
+func headerSize(bts []byte) int {
+	if len(bts) < MinHeaderSize {
+		return MinHeaderSize
+	}
+	n := MinHeaderSize
+	switch bts[1] & 0x7f {
+	case 126:
+		n += 2
+	case 127:
+		n += 8
+	}
+	if bts[1]&bit0 != 0 {
+		n += 4
+	}
+	return n
+}
+
+// decodeHeader decodes header from bts which length is headerSize(bts).
+func decodeHeader(bts []byte) (h Header, err error) {
+	h.Fin = bts[0]&bit0 != 0
+	h.Rsv = (bts[0] & 0x70) >> 4
+	h.OpCode = OpCode(bts[0] & 0x0f)
+	h.Masked = bts[1]&bit0 != 0
+
+	ext := bts[2:]
+	switch length := bts[1] & 0x7f; length {
+	case 126:
+		h.Length = int64(binary.BigEndian.Uint16(ext))
+		ext = ext[2:]
+	case 127:
+		if ext[0]&0x80 != 0 {
+			return h, ErrHeaderLengthMSB
+		}
+		h.Length = int64(binary.BigEndian.Uint64(ext))
+		ext = ext[8:]
+	default:
+		h.Length = int64(length)
+	}
+	if h.Masked {
+		copy(h.Mask[:], ext)
+	}
+	return h, nil
+}
+
+// utf8Validator checks UTF-8 validity of the byte stream written in chunks.
+type utf8Validator struct {
+	buf [utf8.UTFMax]byte // Incomplete sequence from the previous chunk.
+	n   int
+}
+
+func (v *utf8Validator) reset() {
+	v.n = 0
+}
+
+// valid reports whether all written sequences are complete.
+func (v *utf8Validator) valid() bool {
+	return v.n == 0
+}
+
+// write checks the next chunk of bytes. It returns false if invalid
+// sequence is met.
+func (v *utf8Validator) write(p []byte) bool {
+	for v.n > 0 && len(p) > 0 {
+		v.buf[v.n] = p[0]
+		v.n++
+		p = p[1:]
+		if !utf8.FullRune(v.buf[:v.n]) {
+			continue
+		}
+		r, size := utf8.DecodeRune(v.buf[:v.n])
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		v.n = 0
+	}
+	for i := 0; i < len(p); {
+		if p[i] < utf8.RuneSelf {
+			i++
+			continue
+		}
+		if !utf8.FullRune(p[i:]) {
+			v.n = copy(v.buf[:], p[i:])
+			return true
+		}
+		r, size := utf8.DecodeRune(p[i:])
+		if r == utf8.RuneError && size == 1 {
+			return false
+		}
+		i += size
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package ws
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParser(t *testing.T) {
+	mask := [4]byte{0x01, 0x02, 0x03, 0x04}
+	frames := []Frame{
+		NewFrame(OpText, false, []byte("h\xc3")),
+		NewPingFrame([]byte("ping")),
+		NewFrame(OpContinuation, false, nil),
+		NewFrame(OpContinuation, true, []byte("\xa9llo")),
+		NewBinaryFrame(bytes.Repeat([]byte{0xff}, 300)),
+		NewCloseFrame(nil),
+	}
+	var stream []byte
+	for _, f := range frames {
+		stream = append(stream, MustCompileFrame(MaskFrameWith(f, mask))...)
+	}
+	for _, size := range []int{1, 2, 3, 7, len(stream)} {
+		t.Run(fmt.Sprintf("chunk%d", size), func(t *testing.T) {
+			p := NewParser(StateServerSide)
+			p.CheckUTF8 = true
+
+			var (
+				act     []Frame
+				payload []byte
+			)
+			data := append([]byte(nil), stream...)
+			for len(data) > 0 {
+				chunk := data[:min(size, len(data))]
+				data = data[len(chunk):]
+				for {
+					n, e, err := p.Parse(chunk)
+					if err != nil {
+						t.Fatal(err)
+					}
+					switch e {
+					case EventHeader:
+						payload = nil
+					case EventPayload:
+						payload = append(payload, chunk[:n]...)
+					case EventFrameEnd:
+						h := p.Header()
+						h.Masked = false
+						h.Mask = [4]byte{}
+						act = append(act, Frame{Header: h, Payload: payload})
+					}
+					chunk = chunk[n:]
+					if e == EventNone {
+						break
+					}
+				}
+			}
+			if len(act) != len(frames) {
+				t.Fatalf("unexpected number of frames: %d; want %d", len(act), len(frames))
+			}
+			for i := range frames {
+				if exp := frames[i]; !reflect.DeepEqual(act[i].Header, exp.Header) || !bytes.Equal(act[i].Payload, exp.Payload) {
+					t.Errorf("#%d: unexpected frame:\n\tact: %+v\n\texp: %+v", i, act[i], exp)
+				}
+			}
+			if p.State.Fragmented() {
+				t.Errorf("unexpected fragmented state")
+			}
+		})
+	}
+}
+
+func TestParserErrors(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		state State
+		utf8  bool
+		data  []byte
+		err   error
+	}{
+		{
+			name:  "mask required",
+			state: StateServerSide,
+			data:  MustCompileFrame(NewTextFrame([]byte("hello"))),
+			err:   ErrProtocolMaskRequired,
+		},
+		{
+			name: "continuation unexpected",
+			data: MustCompileFrame(NewFrame(OpContinuation, true, nil)),
+			err:  ErrProtocolContinuationUnexpected,
+		},
+		{
+			name: "continuation expected",
+			data: compileFrames(
+				NewFrame(OpText, false, nil),
+				NewBinaryFrame(nil),
+			),
+			err: ErrProtocolContinuationExpected,
+		},
+		{
+			name: "header length msb",
+			data: bits("1 000 0010 0 1111111 10000000 00000000 00000000 00000000 00000000 00000000 00000000 00000000"),
+			err:  ErrHeaderLengthMSB,
+		},
+		{
+			name: "invalid utf8",
+			utf8: true,
+			data: compileFrames(
+				NewFrame(OpText, false, []byte("\xc3")),
+				NewFrame(OpContinuation, true, []byte("\x28")),
+			),
+			err: ErrProtocolInvalidUTF8Text,
+		},
+		{
+			name: "incomplete utf8",
+			utf8: true,
+			data: MustCompileFrame(NewTextFrame([]byte("\xe2\x82"))),
+			err:  ErrProtocolInvalidUTF8Text,
+		},
+		{
+			name: "binary not checked",
+			utf8: true,
+			data: MustCompileFrame(NewBinaryFrame([]byte("\xe2\x82"))),
+		},
+		{
+			name: "utf8 not checked",
+			data: MustCompileFrame(NewTextFrame([]byte("\xe2\x82"))),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			p := NewParser(test.state)
+			p.CheckUTF8 = test.utf8
+			data := test.data
+			var err error
+			for {
+				var (
+					n int
+					e ParserEvent
+				)
+				n, e, err = p.Parse(data)
+				if err != nil || e == EventNone {
+					break
+				}
+				data = data[n:]
+			}
+			if err != test.err {
+				t.Fatalf("unexpected error: %v; want %v", err, test.err)
+			}
+			if err == nil {
+				return
+			}
+			if _, _, err := p.Parse(data); err != test.err {
+				t.Errorf("unexpected error on subsequent call: %v; want %v", err, test.err)
+			}
+			p.Reset(test.state)
+			if _, _, err := p.Parse(nil); err != nil {
+				t.Errorf("unexpected error after Reset(): %v", err)
+			}
+		})
+	}
+}
+
+func TestUTF8Validator(t *testing.T) {
+	for _, test := range []struct {
+		data  string
+		valid bool
+	}{
+		{"hello", true},
+		{"привет, мир", true},
+		{"日本語", true},
+		{"\xf0\x9f\x98\x80", true},
+		{"\xed\xa0\x80", false}, // Surrogate half.
+		{"\xc0\xaf", false},     // Overlong encoding.
+		{"\xf4\x90\x80\x80", false},
+		{"\xff", false},
+		{"hello\xe2\x82", false},
+	} {
+		for size := 1; size <= len(test.data); size++ {
+			var v utf8Validator
+			ok := true
+			for p := []byte(test.data); len(p) > 0 && ok; {
+				n := min(size, len(p))
+				ok = v.write(p[:n])
+				p = p[n:]
+			}
+			if act := ok && v.valid(); act != test.valid {
+				t.Errorf("%q by %d: valid is %t; want %t", test.data, size, act, test.valid)
+			}
+		}
+	}
+}
+
+func compileFrames(fs ...Frame) (ret []byte) {
+	for _, f := range fs {
+		ret = append(ret, MustCompileFrame(f)...)
+	}
+	return ret
+}
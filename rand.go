@@ -0,0 +1,56 @@
+package ws
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+)
+
+// DefaultRand is the source of random bytes used to generate masking keys
+// and handshake nonces when no other source is specified.
+//
+// By default it is a cryptographically strong source, which is safe for
+// concurrent use and does not serialize concurrent readers on a single lock.
+// It is backed by the pool of generators seeded from crypto/rand.
+//
+// See https://tools.ietf.org/html/rfc6455#section-10.3
+var DefaultRand io.Reader = fastRand{}
+
+// NewDeterministicRand returns a source of pseudo-random bytes which yields
+// the same sequence of bytes for the same seed. Returned source is safe for
+// concurrent use.
+//
+// It is intended to be used in tests only, since its output is predictable
+// and thus it does not meet RFC6455 requirements for masking keys.
+func NewDeterministicRand(seed int64) io.Reader {
+	return &lockedRand{
+		r: rand.New(rand.NewSource(seed)),
+	}
+}
+
+type lockedRand struct {
+	mu sync.Mutex
+	r  *rand.Rand
+}
+
+func (l *lockedRand) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.r.Read(p)
+}
+
+// readRand fills p with bytes read from r or from DefaultRand if r is nil.
+func readRand(r io.Reader, p []byte) error {
+	if r == nil {
+		r = DefaultRand
+	}
+	_, err := io.ReadFull(r, p)
+	return err
+}
+
+func mustReadRand(r io.Reader, p []byte) {
+	if err := readRand(r, p); err != nil {
+		panic(fmt.Sprintf("rand read error: %s", err))
+	}
+}
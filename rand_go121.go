@@ -0,0 +1,49 @@
+//go:build !go1.22
+// +build !go1.22
+
+package ws
+
+import (
+	"crypto/rand"
+	"sync"
+)
+
+// randBuffer holds bytes read from crypto/rand in advance to amortize the
+// cost of reading small chunks such as masking keys.
+type randBuffer struct {
+	buf [256]byte
+	pos int
+}
+
+// randBufferPool contains randBuffers. Since sync.Pool is sharded per
+// processor, concurrent readers do not contend.
+var randBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &randBuffer{pos: 256}
+	},
+}
+
+// fastRand is an io.Reader which reads from pooled buffers filled from
+// crypto/rand.
+type fastRand struct{}
+
+func (fastRand) Read(p []byte) (n int, err error) {
+	b := randBufferPool.Get().(*randBuffer)
+	defer randBufferPool.Put(b)
+	for n < len(p) {
+		if b.pos == len(b.buf) {
+			if _, err = rand.Read(b.buf[:]); err != nil {
+				return n, err
+			}
+			b.pos = 0
+		}
+		m := copy(p[n:], b.buf[b.pos:])
+		// Erase bytes which were given away.
+		for i := b.pos; i < b.pos+m; i++ {
+			b.buf[i] = 0
+		}
+		b.pos += m
+		n += m
+	}
+	return n, nil
+}
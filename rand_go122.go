@@ -0,0 +1,42 @@
+//go:build go1.22
+// +build go1.22
+
+package ws
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	mrand "math/rand/v2"
+	"sync"
+)
+
+// chacha8Pool contains ChaCha8 generators seeded from crypto/rand. Since
+// sync.Pool is sharded per processor, concurrent readers do not contend.
+var chacha8Pool = sync.Pool{
+	New: func() interface{} {
+		var seed [32]byte
+		if _, err := rand.Read(seed[:]); err != nil {
+			panic(fmt.Sprintf("rand read error: %s", err))
+		}
+		return mrand.NewChaCha8(seed)
+	},
+}
+
+// fastRand is an io.Reader which reads from pooled ChaCha8 generators.
+type fastRand struct{}
+
+func (fastRand) Read(p []byte) (int, error) {
+	c := chacha8Pool.Get().(*mrand.ChaCha8)
+	n := len(p)
+	for ; len(p) >= 8; p = p[8:] {
+		binary.LittleEndian.PutUint64(p, c.Uint64())
+	}
+	if len(p) > 0 {
+		var tail [8]byte
+		binary.LittleEndian.PutUint64(tail[:], c.Uint64())
+		copy(p, tail[:])
+	}
+	chacha8Pool.Put(c)
+	return n, nil
+}
@@ -0,0 +1,112 @@
+package ws
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestNewDeterministicRand(t *testing.T) {
+	read := func(r io.Reader) []byte {
+		p := make([]byte, 64)
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+	a := read(NewDeterministicRand(42))
+	b := read(NewDeterministicRand(42))
+	c := read(NewDeterministicRand(43))
+	if !bytes.Equal(a, b) {
+		t.Errorf("sources with the same seed produced different bytes")
+	}
+	if bytes.Equal(a, c) {
+		t.Errorf("sources with different seeds produced the same bytes")
+	}
+}
+
+func TestDefaultRand(t *testing.T) {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		seen = make(map[[4]byte]bool)
+	)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 300; n++ {
+				p := make([]byte, n)
+				if m, err := DefaultRand.Read(p); m != n || err != nil {
+					t.Errorf("Read() = %d, %v; want %d, <nil>", m, err, n)
+					return
+				}
+				mask := NewMask()
+				mu.Lock()
+				seen[mask] = true
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	// Probability of collision of 2400 random 32-bit masks is below 0.1%.
+	if len(seen) < 2400-1 {
+		t.Errorf("too many equal masks: %d unique of %d", len(seen), 2400)
+	}
+}
+
+func TestNewMaskFrom(t *testing.T) {
+	var exp [4]byte
+	io.ReadFull(NewDeterministicRand(42), exp[:])
+
+	act, err := NewMaskFrom(NewDeterministicRand(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act != exp {
+		t.Errorf("unexpected mask: %x; want %x", act, exp)
+	}
+	if _, err := NewMaskFrom(strings.NewReader("ab")); err != io.ErrUnexpectedEOF {
+		t.Errorf("unexpected error: %v; want %v", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestDialerRand(t *testing.T) {
+	keys := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys <- r.Header.Get("Sec-WebSocket-Key")
+		if _, _, _, err := UpgradeHTTP(r, w); err != nil {
+			t.Errorf("upgrade error: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	d := Dialer{
+		Rand: NewDeterministicRand(42),
+	}
+	conn, _, _, err := d.Dial(context.Background(), "ws"+strings.TrimPrefix(srv.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	key := make([]byte, nonceKeySize)
+	io.ReadFull(NewDeterministicRand(42), key)
+	if act, exp := <-keys, base64.StdEncoding.EncodeToString(key); act != exp {
+		t.Errorf("unexpected key: %q; want %q", act, exp)
+	}
+}
+
+func BenchmarkNewMask(b *testing.B) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = NewMask()
+		}
+	})
+}
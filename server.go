@@ -54,6 +54,10 @@ var (
 		RejectionStatus(http.StatusBadRequest),
 		RejectionReason(fmt.Sprintf("handshake error: bad %q header", headerSecVersion)),
 	)
+	ErrHandshakeBadConnectProtocol = RejectConnectionError(
+		RejectionStatus(http.StatusBadRequest),
+		RejectionReason(fmt.Sprintf("handshake error: bad %q pseudo-header", headerConnectProtocol)),
+	)
 )
 
 // ErrMalformedResponse is returned by Dialer to indicate that server response
@@ -144,7 +148,35 @@ type HTTPUpgrader struct {
 	// sent with appropriate HTTP error code and body set to error message.
 	//
 	// RejectConnectionError could be used to get more control on response.
+	//
+	// ServerExtensions could be used to negotiate a list of extensions.
 	Negotiate func(httphead.Option) (httphead.Option, error)
+
+	// OnNegotiated is an optional callback that is called after all
+	// extension offers are passed to Negotiate. It receives the selected
+	// subprotocol (if any) and the extensions accepted so far, and returns
+	// the list which is sent to the client.
+	// That is, it could be used to make decisions depending on all offers
+	// and the subprotocol, e.g. to drop an extension accepted before a
+	// preferred one was offered.
+	//
+	// If returned error is non-nil then connection is rejected and response is
+	// sent with appropriate HTTP error code and body set to error message.
+	OnNegotiated func(protocol string, accepted []httphead.Option) ([]httphead.Option, error)
+
+	// Origin is an optional policy that is used to check the request's Origin
+	// header and anti-CSRF token. If request does not satisfy the policy,
+	// then connection is rejected with 403 status code.
+	Origin *OriginPolicy
+
+	// Limits is an optional limits of the handshake request. If request
+	// exceeds any of them, then connection is rejected with appropriate 4xx
+	// status code.
+	//
+	// Note that the request is read by the net/http server before Upgrade is
+	// called. Thus read timeouts are not used and http.Server's
+	// ReadHeaderTimeout and MaxHeaderBytes should be used instead.
+	Limits *HandshakeLimits
 }
 
 // Upgrade upgrades http connection to the websocket connection.
@@ -152,7 +184,17 @@ type HTTPUpgrader struct {
 // It hijacks net.Conn from w and returns received net.Conn and
 // bufio.ReadWriter. On successful handshake it returns Handshake struct
 // describing handshake info.
+//
+// If r is an HTTP/2 extended CONNECT request (RFC8441), then no hijacking
+// is made. Instead, returned net.Conn is backed by the request's stream: it
+// reads from the request body and writes to w. Note that in this case the
+// stream is valid only until the http.Handler returns, thus the handler must
+// not return until it finishes working with the connection.
 func (u HTTPUpgrader) Upgrade(r *http.Request, w http.ResponseWriter) (conn net.Conn, rw *bufio.ReadWriter, hs Handshake, err error) {
+	if r.ProtoMajor >= 2 {
+		return u.upgradeStream(r, w)
+	}
+
 	// Hijack connection first to get the ability to write rejection errors the
 	// same way as in Upgrader.
 	conn, rw, err = hijack(w)
@@ -200,39 +242,19 @@ func (u HTTPUpgrader) Upgrade(r *http.Request, w http.ResponseWriter) (conn net.
 			err = ErrHandshakeBadSecVersion
 		}
 	}
-	if check := u.Protocol; err == nil && check != nil {
-		ps := r.Header[headerSecProtocolCanonical]
-		for i := 0; i < len(ps) && err == nil && hs.Protocol == ""; i++ {
-			var ok bool
-			hs.Protocol, ok = strSelectProtocol(ps[i], check)
-			if !ok {
-				err = ErrMalformedRequest
-			}
-		}
+	if err == nil {
+		err = u.Limits.checkRequest(r)
 	}
-	if f := u.Negotiate; err == nil && f != nil {
-		for _, h := range r.Header[headerSecExtensionsCanonical] {
-			hs.Extensions, err = negotiateExtensions(strToBytes(h), hs.Extensions, f)
-			if err != nil {
-				break
-			}
-		}
+	if p := u.Origin; err == nil && p != nil {
+		err = p.checkRequest(r)
 	}
-	// DEPRECATED path.
-	if check := u.Extension; err == nil && check != nil && u.Negotiate == nil {
-		xs := r.Header[headerSecExtensionsCanonical]
-		for i := 0; i < len(xs) && err == nil; i++ {
-			var ok bool
-			hs.Extensions, ok = btsSelectExtensions(strToBytes(xs[i]), hs.Extensions, check)
-			if !ok {
-				err = ErrMalformedRequest
-			}
-		}
+	if err == nil {
+		err = u.negotiate(r.Header, &hs)
 	}
 
 	// Clear deadlines set by server.
 	conn.SetDeadline(noDeadline)
-	if t := u.Timeout; t != 0 {
+	if t := u.writeTimeout(); t != 0 {
 		conn.SetWriteDeadline(time.Now().Add(t))
 		defer conn.SetWriteDeadline(noDeadline)
 	}
@@ -260,6 +282,51 @@ func (u HTTPUpgrader) Upgrade(r *http.Request, w http.ResponseWriter) (conn net.
 	return conn, rw, hs, err
 }
 
+func (u HTTPUpgrader) writeTimeout() time.Duration {
+	if l := u.Limits; l != nil && l.WriteTimeout != 0 {
+		return l.WriteTimeout
+	}
+	return u.Timeout
+}
+
+// negotiate selects subprotocol and extensions from the client's request
+// headers h and stores them in hs.
+func (u HTTPUpgrader) negotiate(h http.Header, hs *Handshake) (err error) {
+	if check := u.Protocol; check != nil {
+		ps := h[headerSecProtocolCanonical]
+		for i := 0; i < len(ps) && err == nil && hs.Protocol == ""; i++ {
+			var ok bool
+			hs.Protocol, ok = strSelectProtocol(ps[i], check)
+			if !ok {
+				err = ErrMalformedRequest
+			}
+		}
+	}
+	if f := u.Negotiate; err == nil && f != nil {
+		for _, x := range h[headerSecExtensionsCanonical] {
+			hs.Extensions, err = negotiateExtensions(strToBytes(x), hs.Extensions, f)
+			if err != nil {
+				break
+			}
+		}
+	}
+	// DEPRECATED path.
+	if check := u.Extension; err == nil && check != nil && u.Negotiate == nil {
+		xs := h[headerSecExtensionsCanonical]
+		for i := 0; i < len(xs) && err == nil; i++ {
+			var ok bool
+			hs.Extensions, ok = btsSelectExtensions(strToBytes(xs[i]), hs.Extensions, check)
+			if !ok {
+				err = ErrMalformedRequest
+			}
+		}
+	}
+	if f := u.OnNegotiated; err == nil && f != nil {
+		hs.Extensions, err = f(hs.Protocol, hs.Extensions)
+	}
+	return err
+}
+
 // Upgrader contains options for upgrading connection to websocket.
 type Upgrader struct {
 	// ReadBufferSize and WriteBufferSize is an I/O buffer sizes.
@@ -335,8 +402,22 @@ type Upgrader struct {
 	// sent with appropriate HTTP error code and body set to error message.
 	//
 	// RejectConnectionError could be used to get more control on response.
+	//
+	// ServerExtensions could be used to negotiate a list of extensions.
 	Negotiate func(httphead.Option) (httphead.Option, error)
 
+	// OnNegotiated is an optional callback that is called after all
+	// extension offers are passed to Negotiate. It receives the selected
+	// subprotocol (if any) and the extensions accepted so far, and returns
+	// the list which is sent to the client.
+	// That is, it could be used to make decisions depending on all offers
+	// and the subprotocol, e.g. to drop an extension accepted before a
+	// preferred one was offered.
+	//
+	// If returned error is non-nil then connection is rejected and response is
+	// sent with appropriate HTTP error code and body set to error message.
+	OnNegotiated func(protocol string, accepted []httphead.Option) ([]httphead.Option, error)
+
 	// Header is an optional HandshakeHeader instance that could be used to
 	// write additional headers to the handshake response.
 	//
@@ -399,6 +480,54 @@ type Upgrader struct {
 	//
 	// RejectConnectionError could be used to get more control on response.
 	OnBeforeUpgrade func() (header HandshakeHeader, err error)
+
+	// Origin is an optional policy that is used to check the request's Origin
+	// header and anti-CSRF token. If request does not satisfy the policy,
+	// then connection is rejected with 403 status code.
+	//
+	// Note that Origin and Cookie headers are still passed to the OnHeader
+	// callback. Policy is checked after all headers are read and before the
+	// OnBeforeUpgrade callback.
+	Origin *OriginPolicy
+
+	// ProxyProtocol is an optional PROXY protocol options. If non-nil,
+	// Upgrade reads the PROXY protocol header before the request line and
+	// stores it in the returned Handshake.
+	//
+	// Note that the addresses are not available from the connection itself,
+	// e.g. conn.RemoteAddr() still returns the address of the proxy. Use
+	// ProxyListener instead to make them available to any code using the
+	// connection.
+	ProxyProtocol *ProxyProtocol
+
+	// OnProxy is a callback that will be called after PROXY protocol header
+	// successful parsing. It is called after the request line is read and
+	// before OnRequest, OnHost and OnHeader callbacks, so it could be used
+	// to make received addresses available to them.
+	//
+	// If returned error is non-nil then connection is rejected and response is
+	// sent with appropriate HTTP error code and body set to error message.
+	//
+	// RejectConnectionError could be used to get more control on response.
+	OnProxy func(h *ProxyHeader) error
+
+	// TrustedProxies is an optional list of networks of the proxies which
+	// are trusted to send Forwarded and X-Forwarded-For headers. If the
+	// client address belongs to one of them, then the address from those
+	// headers is stored in the returned Handshake's RemoteAddr field.
+	//
+	// Note that the client address is known only when Upgrade receives
+	// net.Conn or when the PROXY protocol header is received.
+	TrustedProxies []*net.IPNet
+
+	// Limits is an optional limits of the handshake request. If request
+	// exceeds any of them, then connection is rejected with appropriate 4xx
+	// status code.
+	//
+	// Note that if any of the timeouts is set and Upgrade receives net.Conn,
+	// then Upgrade manages the deadlines of the connection and clears them
+	// before return.
+	Limits *HandshakeLimits
 }
 
 // Upgrade zero-copy upgrades connection to WebSocket. It interprets given conn
@@ -443,9 +572,50 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		pbufio.PutWriter(bw)
 	}()
 
+	// Prepare stack-based handshake header list.
+	header := handshakeHeader{
+		0: u.Header,
+	}
+
+	// Apply deadlines of the handshake phases if configured.
+	limits := u.Limits
+	var nc net.Conn
+	if limits.hasTimeouts() {
+		nc, _ = conn.(net.Conn)
+	}
+	if nc != nil {
+		defer nc.SetDeadline(noDeadline)
+		setReadTimeout(nc, limits.RequestLineTimeout)
+	}
+
+	// Read PROXY protocol header if configured.
+	var peer net.Addr
+	if u.ProxyProtocol != nil || len(u.TrustedProxies) > 0 {
+		if c, ok := conn.(net.Conn); ok {
+			peer = c.RemoteAddr()
+		}
+	}
+	if p := u.ProxyProtocol; p != nil {
+		hs.Proxy, err = p.read(br, peer)
+		if h := hs.Proxy; h != nil && h.Source != nil {
+			peer = h.Source
+		}
+	}
+	hs.RemoteAddr = peer
+
 	// Read HTTP request line like "GET /ws HTTP/1.1".
-	rl, err := readLine(br)
+	var rl []byte
+	if err == nil {
+		rl, err = readLineLimit(br, limits.lineLimit(0))
+	}
 	if err != nil {
+		if rej := limits.readError(err); rej != nil {
+			if nc != nil {
+				setWriteTimeout(nc, limits.WriteTimeout)
+			}
+			httpWriteRejection(bw, rej, header)
+			return hs, rej
+		}
 		return hs, err
 	}
 	// Parse request line data like HTTP version, uri and method.
@@ -454,11 +624,6 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		return hs, err
 	}
 
-	// Prepare stack-based handshake header list.
-	header := handshakeHeader{
-		0: u.Header,
-	}
-
 	// Parse and check HTTP request.
 	// As RFC6455 says:
 	//   The client's opening handshake consists of the following parts. If the
@@ -476,7 +641,14 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 	//
 	// Even if RFC says "1.1 or higher" without mentioning the part of the
 	// version, we apply it only to minor part.
+	var onProxyErr error
+	if onProxy := u.OnProxy; onProxy != nil && hs.Proxy != nil {
+		onProxyErr = onProxy(hs.Proxy)
+	}
 	switch {
+	case onProxyErr != nil:
+		err = onProxyErr
+
 	case req.major != 1 || req.minor < 1:
 		// Abort processing the whole request because we do not even know how
 		// to actually parse it.
@@ -497,16 +669,44 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		headerSeen byte
 
 		nonce = make([]byte, nonceSize)
+
+		// origin holds request values checked against u.Origin policy.
+		origin originRequest
+
+		// forwarded holds request values used to find the client address
+		// when request is made through trusted proxy.
+		forwarded forwardedRequest
+
+		// read is the number of request bytes read so far. It is used to
+		// check the request against the limits along with the number of
+		// header fields, offered subprotocols and extensions.
+		read       = len(rl) + 2
+		headers    int
+		protocols  int
+		extensions int
 	)
+	if nc != nil {
+		setReadTimeout(nc, limits.HeaderTimeout)
+	}
+	if p := u.Origin; err == nil && p != nil && p.TokenCookie != "" {
+		origin.param = []byte(btsQueryParam(req.uri, p.tokenParam()))
+	}
 	for err == nil {
-		line, e := readLine(br)
+		line, e := readLineLimit(br, limits.lineLimit(read))
 		if e != nil {
-			return hs, e
+			if err = limits.readError(e); err == nil {
+				return hs, e
+			}
+			break
 		}
 		if len(line) == 0 {
 			// Blank line, no more lines to read.
 			break
 		}
+		read += len(line) + 2
+		if err = limits.countHeaders(&headers, 1); err != nil {
+			break
+		}
 
 		k, v, ok := httpParseHeaderLine(line)
 		if !ok {
@@ -517,6 +717,9 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 		switch btsToString(k) {
 		case headerHostCanonical:
 			headerSeen |= headerSeenHost
+			if u.Origin != nil {
+				origin.host = append(origin.host[:0], v...)
+			}
 			if onHost := u.OnHost; onHost != nil {
 				err = onHost(v)
 			}
@@ -548,6 +751,9 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			}
 
 		case headerSecProtocolCanonical:
+			if err = limits.countProtocols(&protocols, v); err != nil {
+				break
+			}
 			if custom, check := u.ProtocolCustom, u.Protocol; hs.Protocol == "" && (custom != nil || check != nil) {
 				var ok bool
 				if custom != nil {
@@ -561,6 +767,9 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			}
 
 		case headerSecExtensionsCanonical:
+			if err = limits.countExtensions(&extensions, v); err != nil {
+				break
+			}
 			if f := u.Negotiate; err == nil && f != nil {
 				hs.Extensions, err = negotiateExtensions(v, hs.Extensions, f)
 			}
@@ -578,11 +787,23 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			}
 
 		default:
+			if p := u.Origin; p != nil {
+				origin.header(p, k, v)
+			}
+			if len(u.TrustedProxies) > 0 {
+				forwarded.header(k, v)
+			}
 			if onHeader := u.OnHeader; onHeader != nil {
 				err = onHeader(k, v)
 			}
 		}
 	}
+	if len(u.TrustedProxies) > 0 {
+		hs.RemoteAddr = forwarded.addr(u.TrustedProxies, hs.RemoteAddr)
+	}
+	if f := u.OnNegotiated; err == nil && f != nil && headerSeen == headerSeenAll {
+		hs.Extensions, err = f(hs.Protocol, hs.Extensions)
+	}
 	switch {
 	case err == nil && headerSeen != headerSeenAll:
 		switch {
@@ -620,21 +841,20 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 			panic("unknown headers state")
 		}
 
+	case err == nil && u.Origin != nil:
+		err = origin.check(u.Origin)
+		if err == nil && u.OnBeforeUpgrade != nil {
+			header[1], err = u.OnBeforeUpgrade()
+		}
+
 	case err == nil && u.OnBeforeUpgrade != nil:
 		header[1], err = u.OnBeforeUpgrade()
 	}
+	if nc != nil {
+		setWriteTimeout(nc, limits.WriteTimeout)
+	}
 	if err != nil {
-		var code int
-		if rej, ok := err.(*ConnectionRejectedError); ok {
-			code = rej.code
-			header[1] = rej.header
-		}
-		if code == 0 {
-			code = http.StatusInternalServerError
-		}
-		httpWriteResponseError(bw, err, code, header.WriteTo)
-		// Do not store Flush() error to not override already existing one.
-		_ = bw.Flush()
+		httpWriteRejection(bw, err, header)
 		return hs, err
 	}
 
@@ -644,6 +864,22 @@ func (u Upgrader) Upgrade(conn io.ReadWriter) (hs Handshake, err error) {
 	return hs, err
 }
 
+// httpWriteRejection writes the error response for err to bw and flushes
+// it.
+func httpWriteRejection(bw *bufio.Writer, err error, header handshakeHeader) {
+	var code int
+	if rej, ok := err.(*ConnectionRejectedError); ok {
+		code = rej.code
+		header[1] = rej.header
+	}
+	if code == 0 {
+		code = http.StatusInternalServerError
+	}
+	httpWriteResponseError(bw, err, code, header.WriteTo)
+	// Do not store Flush() error to not override already existing one.
+	_ = bw.Flush()
+}
+
 type handshakeHeader [2]HandshakeHeader
 
 func (hs handshakeHeader) WriteTo(w io.Writer) (n int64, err error) {
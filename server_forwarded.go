@@ -0,0 +1,125 @@
+package ws
+
+import (
+	"net"
+	"strconv"
+	"strings"
+)
+
+// forwardedRequest holds the values of Forwarded and X-Forwarded-For headers
+// of the request.
+type forwardedRequest struct {
+	forwarded []string
+	xff       []string
+}
+
+func (r *forwardedRequest) header(k, v []byte) {
+	switch btsToString(k) {
+	case headerForwardedCanonical:
+		r.forwarded = append(r.forwarded, string(v))
+	case headerXForwardedForCanonical:
+		r.xff = append(r.xff, string(v))
+	}
+}
+
+// addr returns the client address using the Forwarded or X-Forwarded-For
+// header values if the peer belongs to the trusted networks. Forwarded
+// header takes precedence over X-Forwarded-For.
+//
+// Nodes are walked from the nearest one and the first node which does not
+// belong to the trusted networks is returned. If a node address is unknown
+// or obfuscated, the last known node is returned.
+func (r *forwardedRequest) addr(trusted []*net.IPNet, peer net.Addr) net.Addr {
+	if peer == nil || !netsContainAddr(trusted, peer) {
+		return peer
+	}
+	var nodes []string
+	if len(r.forwarded) > 0 {
+		for _, v := range r.forwarded {
+			nodes = appendForwardedFor(nodes, v)
+		}
+	} else {
+		for _, v := range r.xff {
+			for _, node := range strings.Split(v, ",") {
+				nodes = append(nodes, strings.TrimSpace(node))
+			}
+		}
+	}
+	addr := peer
+	for i := len(nodes) - 1; i >= 0; i-- {
+		a := parseForwardedNode(nodes[i])
+		if a == nil {
+			break
+		}
+		addr = a
+		if !netsContainAddr(trusted, a) {
+			break
+		}
+	}
+	return addr
+}
+
+// appendForwardedFor appends the "for" parameter values of the Forwarded
+// header elements to nodes. Elements without the parameter are appended as
+// empty strings.
+//
+// See https://tools.ietf.org/html/rfc7239#section-4
+func appendForwardedFor(nodes []string, v string) []string {
+	for len(v) > 0 {
+		var (
+			elem  string
+			quote bool
+			i     int
+		)
+		for ; i < len(v); i++ {
+			if c := v[i]; c == '"' {
+				quote = !quote
+			} else if c == ',' && !quote {
+				break
+			}
+		}
+		elem, v = v[:i], v[i:]
+		if len(v) > 0 {
+			v = v[1:]
+		}
+
+		var node string
+		for _, pair := range strings.Split(elem, ";") {
+			eq := strings.IndexByte(pair, '=')
+			if eq == -1 {
+				continue
+			}
+			if strings.EqualFold(strings.TrimSpace(pair[:eq]), "for") {
+				node = strings.Trim(strings.TrimSpace(pair[eq+1:]), `"`)
+				break
+			}
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// parseForwardedNode parses node like "192.0.2.43", "192.0.2.43:80",
+// "[2001:db8::17]:4711" or "2001:db8::17". It returns nil if node is
+// unknown or obfuscated.
+func parseForwardedNode(node string) *net.TCPAddr {
+	host, port := node, ""
+	switch {
+	case strings.HasPrefix(node, "["):
+		end := strings.IndexByte(node, ']')
+		if end == -1 {
+			return nil
+		}
+		host, port = node[1:end], strings.TrimPrefix(node[end+1:], ":")
+	case strings.Count(node, ":") == 1:
+		i := strings.IndexByte(node, ':')
+		host, port = node[:i], node[i+1:]
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil
+	}
+	// Ignore obfuscated ports.
+	p, _ := strconv.ParseUint(port, 10, 16)
+	return &net.TCPAddr{IP: ip, Port: int(p)}
+}
@@ -0,0 +1,72 @@
+package ws
+
+import (
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestForwardedRequestAddr(t *testing.T) {
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, test := range []struct {
+		name      string
+		peer      net.Addr
+		forwarded []string
+		xff       []string
+		exp       net.Addr
+	}{
+		{
+			name: "untrusted peer",
+			peer: tcpAddr("192.0.2.1", 1000),
+			xff:  []string{"203.0.113.7"},
+			exp:  tcpAddr("192.0.2.1", 1000),
+		},
+		{
+			name: "no headers",
+			peer: tcpAddr("10.0.0.1", 1000),
+			exp:  tcpAddr("10.0.0.1", 1000),
+		},
+		{
+			name: "xff",
+			peer: tcpAddr("10.0.0.1", 1000),
+			xff:  []string{"198.51.100.1, 203.0.113.7", "10.0.0.2"},
+			exp:  tcpAddr("203.0.113.7", 0),
+		},
+		{
+			name: "xff all trusted",
+			peer: tcpAddr("10.0.0.1", 1000),
+			xff:  []string{"10.0.0.3, 10.0.0.2"},
+			exp:  tcpAddr("10.0.0.3", 0),
+		},
+		{
+			name: "xff garbage",
+			peer: tcpAddr("10.0.0.1", 1000),
+			xff:  []string{"203.0.113.7, unknown, 10.0.0.2"},
+			exp:  tcpAddr("10.0.0.2", 0),
+		},
+		{
+			name:      "forwarded",
+			peer:      tcpAddr("10.0.0.1", 1000),
+			forwarded: []string{`for="[2001:db8::17]:4711";proto=https, for=10.0.0.2;by="a,b"`},
+			xff:       []string{"203.0.113.7"},
+			exp:       tcpAddr("2001:db8::17", 4711),
+		},
+		{
+			name:      "forwarded obfuscated",
+			peer:      tcpAddr("10.0.0.1", 1000),
+			forwarded: []string{"for=_hidden, for=198.51.100.17:_port"},
+			exp:       tcpAddr("198.51.100.17", 0),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := forwardedRequest{
+				forwarded: test.forwarded,
+				xff:       test.xff,
+			}
+			act := r.addr([]*net.IPNet{trusted}, test.peer)
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("unexpected addr: %v; want %v", act, test.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,220 @@
+package ws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gobwas/httphead"
+)
+
+// Errors used by upgraders with non-nil HandshakeLimits.
+var (
+	ErrHandshakeTooManyHeaders = RejectConnectionError(
+		RejectionStatus(http.StatusRequestHeaderFieldsTooLarge),
+		RejectionReason("handshake error: too many header fields"),
+	)
+	ErrHandshakeRequestTooLarge = RejectConnectionError(
+		RejectionStatus(http.StatusRequestHeaderFieldsTooLarge),
+		RejectionReason("handshake error: request is too large"),
+	)
+	ErrHandshakeTooManyExtensions = RejectConnectionError(
+		RejectionStatus(http.StatusBadRequest),
+		RejectionReason("handshake error: too many extensions offered"),
+	)
+	ErrHandshakeTooManyProtocols = RejectConnectionError(
+		RejectionStatus(http.StatusBadRequest),
+		RejectionReason("handshake error: too many subprotocols offered"),
+	)
+	ErrHandshakeTimeout = RejectConnectionError(
+		RejectionStatus(http.StatusRequestTimeout),
+		RejectionReason("handshake error: request timeout"),
+	)
+)
+
+// errLineTooLong is returned by readLineLimit() when line exceeds the limit.
+var errLineTooLong = errors.New("line is too long")
+
+// HandshakeLimits contains limits applied by upgraders to the handshake
+// request. Zero value of any field means no limit.
+type HandshakeLimits struct {
+	// MaxHeaders is the maximum number of request header fields.
+	// Request with more fields is rejected with ErrHandshakeTooManyHeaders.
+	MaxHeaders int
+
+	// MaxRequestSize is the maximum size in bytes of the request line and
+	// header fields, including line terminators. Request of greater size is
+	// rejected with ErrHandshakeRequestTooLarge.
+	//
+	// Note that Upgrader stops reading the request as soon as the limit is
+	// exceeded, while HTTPUpgrader only checks already received request.
+	MaxRequestSize int
+
+	// MaxExtensions is the maximum number of extension offers in the request.
+	// Request with more offers is rejected with ErrHandshakeTooManyExtensions
+	// before they are passed to the negotiation callbacks.
+	MaxExtensions int
+
+	// MaxProtocols is the maximum number of subprotocols in the request.
+	// Request with more subprotocols is rejected with
+	// ErrHandshakeTooManyProtocols.
+	MaxProtocols int
+
+	// RequestLineTimeout is the maximum amount of time spent while reading
+	// the request line (and PROXY protocol header, if configured).
+	//
+	// It is used only by Upgrader with net.Conn.
+	RequestLineTimeout time.Duration
+
+	// HeaderTimeout is the maximum amount of time spent while reading the
+	// request header fields.
+	//
+	// It is used only by Upgrader with net.Conn.
+	HeaderTimeout time.Duration
+
+	// WriteTimeout is the maximum amount of time spent while writing the
+	// response. If set, HTTPUpgrader uses it instead of its Timeout field.
+	//
+	// It is used only by Upgrader with net.Conn and by HTTPUpgrader.
+	WriteTimeout time.Duration
+}
+
+func (l *HandshakeLimits) hasTimeouts() bool {
+	return l != nil && (l.RequestLineTimeout != 0 ||
+		l.HeaderTimeout != 0 ||
+		l.WriteTimeout != 0)
+}
+
+// lineLimit returns the maximum size of the next request line when n bytes
+// of the request were already read. It returns -1 if there is no limit.
+func (l *HandshakeLimits) lineLimit(n int) int {
+	if l == nil || l.MaxRequestSize == 0 {
+		return -1
+	}
+	if n > l.MaxRequestSize {
+		return 0
+	}
+	return l.MaxRequestSize - n
+}
+
+// readError returns rejection error for the request read error if it is
+// caused by the limits. Otherwise it returns nil.
+func (l *HandshakeLimits) readError(err error) error {
+	if l == nil {
+		return nil
+	}
+	if err == errLineTooLong {
+		return ErrHandshakeRequestTooLarge
+	}
+	if ne, ok := err.(net.Error); ok && ne.Timeout() && l.hasTimeouts() {
+		return ErrHandshakeTimeout
+	}
+	return nil
+}
+
+// countHeaders increments the number of seen header fields n and checks it
+// against the limit.
+func (l *HandshakeLimits) countHeaders(n *int, delta int) error {
+	*n += delta
+	if l != nil && l.MaxHeaders > 0 && *n > l.MaxHeaders {
+		return ErrHandshakeTooManyHeaders
+	}
+	return nil
+}
+
+// countProtocols increments the number of offered subprotocols n by the
+// number of subprotocols in the header value v and checks it against the
+// limit.
+func (l *HandshakeLimits) countProtocols(n *int, v []byte) error {
+	if l == nil || l.MaxProtocols == 0 {
+		return nil
+	}
+	httphead.ScanTokens(v, func([]byte) bool {
+		*n++
+		return true
+	})
+	if *n > l.MaxProtocols {
+		return ErrHandshakeTooManyProtocols
+	}
+	return nil
+}
+
+// countExtensions increments the number of offered extensions n by the
+// number of extensions in the header value v and checks it against the
+// limit.
+func (l *HandshakeLimits) countExtensions(n *int, v []byte) error {
+	if l == nil || l.MaxExtensions == 0 {
+		return nil
+	}
+	var count int
+	httphead.ScanOptions(v, func(i int, _, _, _ []byte) httphead.Control {
+		count = i + 1
+		return httphead.ControlContinue
+	})
+	if *n += count; *n > l.MaxExtensions {
+		return ErrHandshakeTooManyExtensions
+	}
+	return nil
+}
+
+// checkRequest checks the http.Request against the limits.
+func (l *HandshakeLimits) checkRequest(r *http.Request) (err error) {
+	if l == nil {
+		return nil
+	}
+	var (
+		headers int
+		// Request line and blank line terminating the header.
+		size = len(r.Method) + len(r.RequestURI) + len(r.Proto) + 4 + 2
+	)
+	if r.Host != "" {
+		// Note that net/http removes the Host header from r.Header.
+		headers++
+		size += len(headerHost) + len(r.Host) + 4
+	}
+	for k, vs := range r.Header {
+		if err = l.countHeaders(&headers, len(vs)); err != nil {
+			return err
+		}
+		for _, v := range vs {
+			size += len(k) + len(v) + 4
+		}
+	}
+	if l.MaxRequestSize > 0 && size > l.MaxRequestSize {
+		return ErrHandshakeRequestTooLarge
+	}
+	var n int
+	for _, v := range r.Header[headerSecProtocolCanonical] {
+		if err = l.countProtocols(&n, strToBytes(v)); err != nil {
+			return err
+		}
+	}
+	n = 0
+	for _, v := range r.Header[headerSecExtensionsCanonical] {
+		if err = l.countExtensions(&n, strToBytes(v)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setReadTimeout sets the read deadline of conn to be t from now. If t is
+// zero, it clears the deadline.
+func setReadTimeout(conn net.Conn, t time.Duration) {
+	var d time.Time
+	if t != 0 {
+		d = time.Now().Add(t)
+	}
+	conn.SetReadDeadline(d)
+}
+
+// setWriteTimeout sets the write deadline of conn to be t from now. If t is
+// zero, it clears the deadline.
+func setWriteTimeout(conn net.Conn, t time.Duration) {
+	var d time.Time
+	if t != 0 {
+		d = time.Now().Add(t)
+	}
+	conn.SetWriteDeadline(d)
+}
@@ -0,0 +1,198 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/httphead"
+)
+
+const limitsRequest = "" +
+	"GET /ws HTTP/1.1\r\n" +
+	"Host: example.org\r\n" +
+	"Upgrade: websocket\r\n" +
+	"Connection: Upgrade\r\n" +
+	"Sec-WebSocket-Version: 13\r\n" +
+	"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+	"Sec-WebSocket-Protocol: a, b\r\n" +
+	"Sec-WebSocket-Protocol: c\r\n" +
+	"Sec-WebSocket-Extensions: foo, bar; x=\"1,2\"\r\n" +
+	"Sec-WebSocket-Extensions: baz\r\n" +
+	"\r\n"
+
+func TestHandshakeLimits(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		limits HandshakeLimits
+		expErr error
+	}{
+		{
+			name: "no limits",
+		},
+		{
+			name: "exact",
+			limits: HandshakeLimits{
+				MaxHeaders:     9,
+				MaxRequestSize: len(limitsRequest),
+				MaxProtocols:   3,
+				MaxExtensions:  3,
+			},
+		},
+		{
+			name:   "headers",
+			limits: HandshakeLimits{MaxHeaders: 8},
+			expErr: ErrHandshakeTooManyHeaders,
+		},
+		{
+			name:   "size",
+			limits: HandshakeLimits{MaxRequestSize: len(limitsRequest) - 1},
+			expErr: ErrHandshakeRequestTooLarge,
+		},
+		{
+			name:   "request line size",
+			limits: HandshakeLimits{MaxRequestSize: 10},
+			expErr: ErrHandshakeRequestTooLarge,
+		},
+		{
+			name:   "protocols",
+			limits: HandshakeLimits{MaxProtocols: 2},
+			expErr: ErrHandshakeTooManyProtocols,
+		},
+		{
+			name:   "extensions",
+			limits: HandshakeLimits{MaxExtensions: 2},
+			expErr: ErrHandshakeTooManyExtensions,
+		},
+	} {
+		check := func(t *testing.T, err error, resp []byte) {
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			status := http.StatusSwitchingProtocols
+			if rej, ok := err.(*ConnectionRejectedError); ok {
+				status = rej.code
+			}
+			res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != status {
+				t.Errorf("unexpected response status: %d; want %d", res.StatusCode, status)
+			}
+		}
+		t.Run(test.name+"/Upgrader", func(t *testing.T) {
+			var out bytes.Buffer
+			u := Upgrader{
+				Limits:   &test.limits,
+				Protocol: func([]byte) bool { return false },
+				Negotiate: func(httphead.Option) (httphead.Option, error) {
+					return httphead.Option{}, nil
+				},
+			}
+			_, err := u.Upgrade(struct {
+				io.Reader
+				io.Writer
+			}{
+				strings.NewReader(limitsRequest),
+				&out,
+			})
+			check(t, err, out.Bytes())
+		})
+		t.Run(test.name+"/HTTPUpgrader", func(t *testing.T) {
+			if test.name == "request line size" {
+				t.Skip("request line is always read by net/http")
+			}
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(limitsRequest)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := newRecorder()
+			u := HTTPUpgrader{
+				Limits: &test.limits,
+			}
+			_, _, _, err = u.Upgrade(req, res)
+			check(t, err, res.Bytes())
+		})
+	}
+}
+
+func TestHandshakeLimitsTimeout(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		in     string
+		limits HandshakeLimits
+	}{
+		{
+			name:   "request line",
+			in:     "GET /ws HTTP/1.1",
+			limits: HandshakeLimits{RequestLineTimeout: 50 * time.Millisecond},
+		},
+		{
+			name:   "headers",
+			in:     "GET /ws HTTP/1.1\r\nHost: example.org\r\n",
+			limits: HandshakeLimits{HeaderTimeout: 50 * time.Millisecond},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client, server := net.Pipe()
+			defer client.Close()
+			defer server.Close()
+
+			done := make(chan error, 1)
+			go func() {
+				u := Upgrader{
+					Limits: &test.limits,
+				}
+				_, err := u.Upgrade(server)
+				done <- err
+			}()
+			if _, err := io.WriteString(client, test.in); err != nil {
+				t.Fatal(err)
+			}
+			client.SetReadDeadline(time.Now().Add(time.Second))
+			res, err := http.ReadResponse(bufio.NewReader(client), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != http.StatusRequestTimeout {
+				t.Errorf("unexpected response status: %d", res.StatusCode)
+			}
+			if err := <-done; err != ErrHandshakeTimeout {
+				t.Errorf("unexpected error: %v; want %v", err, ErrHandshakeTimeout)
+			}
+		})
+	}
+}
+
+func TestReadLineLimit(t *testing.T) {
+	for _, test := range []struct {
+		in     string
+		limit  int
+		exp    string
+		expErr error
+	}{
+		{"abc\r\n", -1, "abc", nil},
+		{"abc\r\n", 5, "abc", nil},
+		{"abc\r\n", 4, "", errLineTooLong},
+		{"abc\n", 4, "abc", nil},
+		{strings.Repeat("a", 100) + "\r\n", 64, "", errLineTooLong},
+		{strings.Repeat("a", 100) + "\r\n", 102, strings.Repeat("a", 100), nil},
+	} {
+		// Use small buffer to check lines longer than the buffer.
+		br := bufio.NewReaderSize(strings.NewReader(test.in), 16)
+		act, err := readLineLimit(br, test.limit)
+		if err != test.expErr {
+			t.Errorf("readLineLimit(%q, %d): unexpected error: %v", test.in, test.limit, err)
+			continue
+		}
+		if err == nil && string(act) != test.exp {
+			t.Errorf("readLineLimit(%q, %d) = %q; want %q", test.in, test.limit, act, test.exp)
+		}
+	}
+}
@@ -0,0 +1,280 @@
+package ws
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Errors used by upgraders with non-nil OriginPolicy.
+var (
+	ErrHandshakeBadOrigin = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason(fmt.Sprintf("handshake error: bad %q header", headerOrigin)),
+	)
+	ErrHandshakeBadCSRFToken = RejectConnectionError(
+		RejectionStatus(http.StatusForbidden),
+		RejectionReason("handshake error: bad anti-CSRF token"),
+	)
+)
+
+// OriginPolicy contains options for checking the Origin header of the
+// handshake request to protect from Cross-Site WebSocket Hijacking.
+//
+// By default only requests with Origin header having the same host as the
+// Host header are allowed.
+//
+// See https://tools.ietf.org/html/rfc6455#section-10.2
+type OriginPolicy struct {
+	// Allow is a list of the origin patterns which are allowed in addition to
+	// the same host origin.
+	//
+	// Pattern could be either a full origin such as "https://example.org",
+	// or the host with an optional port such as "example.org:8080", which
+	// matches origins with any scheme. In both cases host could be prefixed
+	// with "*." wildcard to match any subdomain of the host (but not the
+	// host itself). Single "*" pattern matches any origin.
+	//
+	// Pattern without port matches only origins with the default port of
+	// their scheme, which could be given explicitly as in
+	// "https://example.org:443". Port could be "*" to match any port, as in
+	// "*.example.org:*".
+	Allow []string
+
+	// Check is an optional callback that is used instead of the same host
+	// check and Allow patterns matching. It receives the value of the Origin
+	// header and the value of the Host header. If it returns false then
+	// request is rejected with ErrHandshakeBadOrigin.
+	//
+	// The arguments are only valid until the callback returns.
+	Check func(origin, host []byte) bool
+
+	// RequireOrigin makes requests without Origin header to be rejected.
+	// Note that non-browser clients usually do not send Origin header.
+	RequireOrigin bool
+
+	// TokenCookie is an optional name of the cookie holding the anti-CSRF
+	// token. If it is non-empty, then the request must contain the same
+	// non-empty token in the TokenParam query parameter. Otherwise it is
+	// rejected with ErrHandshakeBadCSRFToken.
+	TokenCookie string
+
+	// TokenParam is the name of the query parameter holding the anti-CSRF
+	// token. If it is empty, then TokenCookie is used.
+	TokenParam string
+}
+
+func (p *OriginPolicy) tokenParam() string {
+	if p.TokenParam != "" {
+		return p.TokenParam
+	}
+	return p.TokenCookie
+}
+
+// checkOrigin checks the origin of the request with the given Host header
+// value. The seen argument reports whether request had the Origin header.
+func (p *OriginPolicy) checkOrigin(origin, host []byte, seen bool) error {
+	if !seen {
+		if p.RequireOrigin {
+			return ErrHandshakeBadOrigin
+		}
+		return nil
+	}
+	if check := p.Check; check != nil {
+		if !check(origin, host) {
+			return ErrHandshakeBadOrigin
+		}
+		return nil
+	}
+	scheme, authority, ok := splitOrigin(btsToString(origin))
+	if !ok {
+		return ErrHandshakeBadOrigin
+	}
+	ohost, oport := splitAuthority(scheme, authority)
+	// NOTE: Host header doesn't carry the scheme, thus the default port of
+	// the origin scheme is used for it too.
+	hhost, hport := splitAuthority(scheme, btsToString(host))
+	if strings.EqualFold(ohost, hhost) && oport == hport {
+		return nil
+	}
+	for _, pattern := range p.Allow {
+		if matchOrigin(pattern, scheme, ohost, oport) {
+			return nil
+		}
+	}
+	return ErrHandshakeBadOrigin
+}
+
+// checkToken checks the anti-CSRF token received in the query parameter
+// against the token received in the cookie.
+func (p *OriginPolicy) checkToken(param, cookie []byte) error {
+	if p.TokenCookie == "" {
+		return nil
+	}
+	if len(cookie) == 0 || subtle.ConstantTimeCompare(param, cookie) != 1 {
+		return ErrHandshakeBadCSRFToken
+	}
+	return nil
+}
+
+// checkRequest checks the http.Request against the policy.
+func (p *OriginPolicy) checkRequest(r *http.Request) error {
+	origin, seen := r.Header[headerOriginCanonical]
+	var o string
+	if seen && len(origin) > 0 {
+		o = origin[0]
+	}
+	if err := p.checkOrigin(strToBytes(o), strToBytes(r.Host), seen); err != nil {
+		return err
+	}
+	if p.TokenCookie == "" {
+		return nil
+	}
+	var cookie string
+	if c, err := r.Cookie(p.TokenCookie); err == nil {
+		cookie = c.Value
+	}
+	param := r.URL.Query().Get(p.tokenParam())
+	return p.checkToken(strToBytes(param), strToBytes(cookie))
+}
+
+// originRequest holds the request values used by Upgrader to check the
+// request against OriginPolicy. Values are copied since header lines are not
+// valid after the next line is read.
+type originRequest struct {
+	host   []byte
+	origin []byte
+	param  []byte
+	cookie []byte
+
+	originSeen bool
+	cookieSeen bool
+}
+
+func (r *originRequest) header(p *OriginPolicy, k, v []byte) {
+	switch btsToString(k) {
+	case headerOriginCanonical:
+		if !r.originSeen {
+			r.originSeen = true
+			r.origin = append(r.origin[:0], v...)
+		}
+	case headerCookieCanonical:
+		if p.TokenCookie == "" || r.cookieSeen {
+			return
+		}
+		if c, ok := btsCookieValue(v, p.TokenCookie); ok {
+			r.cookieSeen = true
+			r.cookie = append(r.cookie[:0], c...)
+		}
+	}
+}
+
+func (r *originRequest) check(p *OriginPolicy) error {
+	if err := p.checkOrigin(r.origin, r.host, r.originSeen); err != nil {
+		return err
+	}
+	return p.checkToken(r.param, r.cookie)
+}
+
+// splitOrigin splits serialized origin like "https://example.org:8080" into
+// scheme and authority parts.
+func splitOrigin(origin string) (scheme, authority string, ok bool) {
+	i := strings.Index(origin, "://")
+	if i <= 0 {
+		return "", "", false
+	}
+	scheme, authority = origin[:i], origin[i+3:]
+	if authority == "" || strings.IndexAny(authority, "/?#") != -1 {
+		return "", "", false
+	}
+	return scheme, authority, true
+}
+
+// splitAuthority splits authority like "example.org:8080" into host and port
+// parts. Port is empty if it is omitted or is the default port of the scheme.
+func splitAuthority(scheme, authority string) (host, port string) {
+	host = authority
+	if i := strings.LastIndexByte(authority, ':'); i > strings.LastIndexByte(authority, ']') {
+		host, port = authority[:i], authority[i+1:]
+	}
+	if port == defaultPort(scheme) {
+		port = ""
+	}
+	return host, port
+}
+
+func defaultPort(scheme string) string {
+	switch strings.ToLower(scheme) {
+	case "http", "ws":
+		return "80"
+	case "https", "wss":
+		return "443"
+	}
+	return ""
+}
+
+// matchOrigin reports whether origin with given scheme, host and port
+// (as returned by splitAuthority()) matches the pattern.
+func matchOrigin(pattern, scheme, host, port string) bool {
+	if pattern == "*" {
+		return true
+	}
+	if s, a, ok := splitOrigin(pattern); ok {
+		if !strings.EqualFold(s, scheme) {
+			return false
+		}
+		pattern = a
+	}
+	phost, pport := splitAuthority(scheme, pattern)
+	if pport != "*" && pport != port {
+		return false
+	}
+	if strings.HasPrefix(phost, "*.") {
+		suffix := phost[1:]
+		return len(host) > len(suffix) &&
+			strings.EqualFold(host[len(host)-len(suffix):], suffix)
+	}
+	return strings.EqualFold(phost, host)
+}
+
+// btsQueryParam returns the value of the query parameter with given name from
+// the request uri.
+func btsQueryParam(uri []byte, name string) string {
+	i := bytes.IndexByte(uri, '?')
+	if i == -1 {
+		return ""
+	}
+	query := uri[i+1:]
+	if j := bytes.IndexByte(query, '#'); j != -1 {
+		query = query[:j]
+	}
+	values, _ := url.ParseQuery(string(query))
+	return values.Get(name)
+}
+
+// btsCookieValue returns the value of the cookie with given name from the
+// Cookie header value.
+func btsCookieValue(header []byte, name string) (value []byte, ok bool) {
+	for len(header) > 0 {
+		var pair []byte
+		if i := bytes.IndexByte(header, ';'); i != -1 {
+			pair, header = header[:i], header[i+1:]
+		} else {
+			pair, header = header, nil
+		}
+		pair = bytes.TrimSpace(pair)
+		eq := bytes.IndexByte(pair, '=')
+		if eq == -1 || btsToString(pair[:eq]) != name {
+			continue
+		}
+		value = pair[eq+1:]
+		if n := len(value); n > 1 && value[0] == '"' && value[n-1] == '"' {
+			value = value[1 : n-1]
+		}
+		return value, true
+	}
+	return nil, false
+}
@@ -0,0 +1,219 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOriginPolicy(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		uri    string
+		header string
+		policy OriginPolicy
+		expErr error
+	}{
+		{
+			name: "no origin",
+		},
+		{
+			name:   "no origin required",
+			policy: OriginPolicy{RequireOrigin: true},
+			expErr: ErrHandshakeBadOrigin,
+		},
+		{
+			name:   "same host",
+			header: "Origin: https://EXAMPLE.org\r\n",
+		},
+		{
+			name:   "same host default port",
+			header: "Origin: https://example.org:443\r\n",
+		},
+		{
+			name:   "allowed with port",
+			header: "Origin: https://app.example.com:443\r\n",
+			policy: OriginPolicy{Allow: []string{"*.example.com"}},
+		},
+		{
+			name:   "another port",
+			header: "Origin: https://example.org:8080\r\n",
+			expErr: ErrHandshakeBadOrigin,
+		},
+		{
+			name:   "cross origin",
+			header: "Origin: https://evil.com\r\n",
+			expErr: ErrHandshakeBadOrigin,
+		},
+		{
+			name:   "malformed",
+			header: "Origin: null\r\n",
+			expErr: ErrHandshakeBadOrigin,
+		},
+		{
+			name:   "allowed",
+			header: "Origin: https://app.example.com\r\n",
+			policy: OriginPolicy{Allow: []string{"*.example.com"}},
+		},
+		{
+			name:   "check",
+			header: "Origin: https://evil.com\r\n",
+			policy: OriginPolicy{Check: func(origin, host []byte) bool {
+				return string(origin) == "https://evil.com" && string(host) == "example.org"
+			}},
+		},
+		{
+			name:   "token",
+			uri:    "/ws?csrf=t0ken",
+			header: "Cookie: a=1; csrf=t0ken\r\n",
+			policy: OriginPolicy{TokenCookie: "csrf"},
+		},
+		{
+			name:   "token param",
+			uri:    "/ws?x=1&token=t0k%3Den",
+			header: "Cookie: csrf=\"t0k=en\"\r\n",
+			policy: OriginPolicy{TokenCookie: "csrf", TokenParam: "token"},
+		},
+		{
+			name:   "token mismatch",
+			uri:    "/ws?csrf=t0ken",
+			header: "Cookie: csrf=other\r\n",
+			policy: OriginPolicy{TokenCookie: "csrf"},
+			expErr: ErrHandshakeBadCSRFToken,
+		},
+		{
+			name:   "token no cookie",
+			uri:    "/ws?csrf=",
+			policy: OriginPolicy{TokenCookie: "csrf"},
+			expErr: ErrHandshakeBadCSRFToken,
+		},
+	} {
+		uri := test.uri
+		if uri == "" {
+			uri = "/ws"
+		}
+		raw := "GET " + uri + " HTTP/1.1\r\n" +
+			"Host: example.org\r\n" +
+			"Upgrade: websocket\r\n" +
+			"Connection: Upgrade\r\n" +
+			"Sec-WebSocket-Version: 13\r\n" +
+			"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+			test.header +
+			"\r\n"
+
+		check := func(t *testing.T, err error, resp []byte) {
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			status := http.StatusSwitchingProtocols
+			if err != nil {
+				status = http.StatusForbidden
+			}
+			res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(resp)), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.StatusCode != status {
+				t.Errorf("unexpected response status: %d; want %d", res.StatusCode, status)
+			}
+		}
+		t.Run(test.name+"/Upgrader", func(t *testing.T) {
+			var headers []string
+			u := Upgrader{
+				Origin: &test.policy,
+				OnHeader: func(k, v []byte) error {
+					headers = append(headers, string(k))
+					return nil
+				},
+			}
+			conn := bytes.NewBufferString(raw)
+			_, err := u.Upgrade(conn)
+			check(t, err, conn.Bytes())
+			if test.header != "" && len(headers) == 0 {
+				t.Errorf("OnHeader was not called")
+			}
+		})
+		t.Run(test.name+"/HTTPUpgrader", func(t *testing.T) {
+			req, err := http.ReadRequest(bufio.NewReader(strings.NewReader(raw)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res := newRecorder()
+			u := HTTPUpgrader{
+				Origin: &test.policy,
+			}
+			_, _, _, err = u.Upgrade(req, res)
+			check(t, err, res.Bytes())
+		})
+	}
+}
+
+func TestMatchOrigin(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		origin  string
+		exp     bool
+	}{
+		{"*", "https://example.org", true},
+		{"example.org", "https://example.org", true},
+		{"example.org", "http://EXAMPLE.ORG", true},
+		{"example.org", "https://example.org:8080", false},
+		{"example.org:8080", "https://example.org:8080", true},
+		{"https://example.org", "https://example.org", true},
+		{"https://example.org", "http://example.org", false},
+		{"*.example.org", "https://a.example.org", true},
+		{"*.example.org", "https://a.b.example.org", true},
+		{"*.example.org", "https://example.org", false},
+		{"*.example.org", "https://evilexample.org", false},
+		{"https://*.example.org", "https://a.example.org", true},
+		{"https://*.example.org", "http://a.example.org", false},
+		{"https://example.org", "https://example.org:443", true},
+		{"https://example.org:443", "https://example.org", true},
+		{"example.org:443", "https://example.org", true},
+		{"example.org:443", "http://example.org", false},
+		{"example.org", "http://example.org:80", true},
+		{"*.example.org", "https://a.example.org:443", true},
+		{"*.example.org", "https://a.example.org:8443", false},
+		{"*.example.org:8443", "https://a.example.org:8443", true},
+		{"*.example.org:*", "https://a.example.org:8443", true},
+		{"*.example.org:*", "https://a.example.org", true},
+		{"https://*.example.org:*", "http://a.example.org:8080", false},
+		{"[::1]:8080", "http://[::1]:8080", true},
+		{"[::1]", "http://[::1]:8080", false},
+	} {
+		scheme, authority, ok := splitOrigin(test.origin)
+		if !ok {
+			t.Fatalf("can not split origin %q", test.origin)
+		}
+		host, port := splitAuthority(scheme, authority)
+		if act := matchOrigin(test.pattern, scheme, host, port); act != test.exp {
+			t.Errorf("matchOrigin(%q, %q) = %t; want %t", test.pattern, test.origin, act, test.exp)
+		}
+	}
+}
+
+func TestBtsCookieValue(t *testing.T) {
+	for _, test := range []struct {
+		header string
+		name   string
+		exp    string
+		ok     bool
+	}{
+		{"a=1", "a", "1", true},
+		{"a=1; b=2", "b", "2", true},
+		{"a=1;b=\"2\"", "b", "2", true},
+		{"ab=1; b=", "b", "", true},
+		{"ab=1", "b", "", false},
+		{"", "b", "", false},
+	} {
+		act, ok := btsCookieValue([]byte(test.header), test.name)
+		if string(act) != test.exp || ok != test.ok {
+			t.Errorf(
+				"btsCookieValue(%q, %q) = %q, %t; want %q, %t",
+				test.header, test.name, act, ok, test.exp, test.ok,
+			)
+		}
+	}
+}
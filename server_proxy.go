@@ -0,0 +1,496 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Errors used by PROXY protocol header parsing.
+var (
+	ErrProxyHeaderMalformed = errors.New("malformed PROXY protocol header")
+	ErrProxyHeaderMissing   = errors.New("missing PROXY protocol header")
+)
+
+// Errors returned by ProxyConn.SyscallConn().
+var (
+	ErrProxyConnBuffered   = errors.New("PROXY protocol connection has buffered data")
+	ErrProxyConnNotSyscall = errors.New("PROXY protocol connection is not a syscall.Conn")
+)
+
+// ProxyTLVType represents the type of PROXY protocol v2 TLV vector.
+type ProxyTLVType byte
+
+// ProxyTLVType values defined by the PROXY protocol specification.
+const (
+	ProxyTLVALPN      ProxyTLVType = 0x01
+	ProxyTLVAuthority ProxyTLVType = 0x02
+	ProxyTLVCRC32C    ProxyTLVType = 0x03
+	ProxyTLVNoop      ProxyTLVType = 0x04
+	ProxyTLVUniqueID  ProxyTLVType = 0x05
+	ProxyTLVSSL       ProxyTLVType = 0x20
+	ProxyTLVNetNS     ProxyTLVType = 0x30
+)
+
+// ProxyTLV represents PROXY protocol v2 TLV vector.
+type ProxyTLV struct {
+	Type  ProxyTLVType
+	Value []byte
+}
+
+// ProxyHeader represents PROXY protocol header sent by a proxy or a load
+// balancer before the proxied connection data.
+//
+// See https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+type ProxyHeader struct {
+	// Version is the PROXY protocol version, that is, 1 or 2.
+	Version int
+
+	// Local reports whether the connection was made by the proxy on its own
+	// behalf (e.g. for health checking) or addresses are unknown. In this
+	// case Source and Destination are nil.
+	Local bool
+
+	// Source and Destination are the original addresses of the proxied
+	// connection, that is, the client and the proxy server addresses.
+	Source, Destination net.Addr
+
+	// TLVs contains additional information sent within v2 header.
+	TLVs []ProxyTLV
+}
+
+// TLV returns the value of the first TLV vector with type t.
+func (h *ProxyHeader) TLV(t ProxyTLVType) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == t {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ServerName returns the host name requested by the client, which is sent
+// by proxies within the authority TLV. For TLS connections it is the SNI
+// value.
+func (h *ProxyHeader) ServerName() string {
+	v, _ := h.TLV(ProxyTLVAuthority)
+	return string(v)
+}
+
+// ALPN returns the application protocol negotiated by the proxy with the
+// client.
+func (h *ProxyHeader) ALPN() string {
+	v, _ := h.TLV(ProxyTLVALPN)
+	return string(v)
+}
+
+// ProxyProtocol contains options for reading PROXY protocol header.
+type ProxyProtocol struct {
+	// Optional allows connections without PROXY protocol header.
+	// By default such connections are rejected with ErrProxyHeaderMissing.
+	Optional bool
+
+	// From is an optional list of networks of proxies which are allowed to
+	// send PROXY protocol header. Connections from other peers are handled
+	// as having no header. If From is empty, any peer is allowed.
+	//
+	// Note that it is checked only when the peer address is known, that is,
+	// when Upgrader receives net.Conn.
+	From []*net.IPNet
+}
+
+// read reads PROXY protocol header from br. Peer is the address of the
+// remote side of the connection, if known.
+func (p *ProxyProtocol) read(br *bufio.Reader, peer net.Addr) (h *ProxyHeader, err error) {
+	if len(p.From) == 0 || peer == nil || netsContainAddr(p.From, peer) {
+		h, err = readProxyHeader(br)
+	}
+	if err == nil && h == nil && !p.Optional {
+		err = ErrProxyHeaderMissing
+	}
+	return h, err
+}
+
+var (
+	proxySignatureV1 = []byte("PROXY ")
+	proxySignatureV2 = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// proxyMaxHeaderSizeV1 is the maximum size of v1 header line.
+	proxyMaxHeaderSizeV1 = 107
+	// proxyHeaderSizeV2 is the size of v2 header fixed part.
+	proxyHeaderSizeV2 = 16
+)
+
+// readProxyHeader reads PROXY protocol header of any version from br.
+// It returns nil header and nil error if there is no header.
+func readProxyHeader(br *bufio.Reader) (*ProxyHeader, error) {
+	p, err := br.Peek(len(proxySignatureV2))
+	switch {
+	case bytes.Equal(p, proxySignatureV2):
+		return readProxyHeaderV2(br)
+	case bytes.HasPrefix(p, proxySignatureV1):
+		return readProxyHeaderV1(br)
+	case err != nil && (bytes.HasPrefix(proxySignatureV1, p) || bytes.HasPrefix(proxySignatureV2, p)):
+		// Not enough data to decide.
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return nil, nil
+}
+
+// readProxyHeaderV1 reads PROXY protocol v1 text header like
+// "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n".
+func readProxyHeaderV1(br *bufio.Reader) (*ProxyHeader, error) {
+	// Header line is limited, thus we can not read more than the limit.
+	var line []byte
+	for len(line) <= proxyMaxHeaderSizeV1 {
+		b, err := br.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	n := len(line)
+	if n > proxyMaxHeaderSizeV1 || n < 2 || line[n-2] != '\r' {
+		return nil, ErrProxyHeaderMalformed
+	}
+	fields := strings.Split(string(line[len(proxySignatureV1):n-2]), " ")
+
+	h := ProxyHeader{Version: 1}
+	switch fields[0] {
+	case "UNKNOWN":
+		// Receiver must ignore everything else.
+		h.Local = true
+		return &h, nil
+	case "TCP4", "TCP6":
+	default:
+		return nil, ErrProxyHeaderMalformed
+	}
+	if len(fields) != 5 {
+		return nil, ErrProxyHeaderMalformed
+	}
+	v4 := fields[0] == "TCP4"
+	src, ok1 := parseProxyAddrV1(fields[1], fields[3], v4)
+	dst, ok2 := parseProxyAddrV1(fields[2], fields[4], v4)
+	if !ok1 || !ok2 {
+		return nil, ErrProxyHeaderMalformed
+	}
+	h.Source, h.Destination = src, dst
+	return &h, nil
+}
+
+func parseProxyAddrV1(host, port string, v4 bool) (*net.TCPAddr, bool) {
+	ip := net.ParseIP(host)
+	if ip == nil || strings.Contains(host, ":") == v4 {
+		return nil, false
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, false
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, true
+}
+
+// readProxyHeaderV2 reads PROXY protocol v2 binary header.
+func readProxyHeaderV2(br *bufio.Reader) (*ProxyHeader, error) {
+	var head [proxyHeaderSizeV2]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil {
+		return nil, err
+	}
+	var (
+		version = head[12] >> 4
+		command = head[12] & 0x0f
+		family  = head[13] >> 4
+		proto   = head[13] & 0x0f
+		length  = binary.BigEndian.Uint16(head[14:])
+	)
+	if version != 2 || command > 1 || family > 3 || proto > 2 {
+		return nil, ErrProxyHeaderMalformed
+	}
+	// Read the whole header to be able to check its checksum.
+	buf := make([]byte, proxyHeaderSizeV2+int(length))
+	copy(buf, head[:])
+	if _, err := io.ReadFull(br, buf[proxyHeaderSizeV2:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	p := buf[proxyHeaderSizeV2:]
+
+	var size int
+	switch family {
+	case 1: // AF_INET.
+		size = 2*net.IPv4len + 4
+	case 2: // AF_INET6.
+		size = 2*net.IPv6len + 4
+	case 3: // AF_UNIX.
+		size = 2 * 108
+	}
+	if len(p) < size {
+		return nil, ErrProxyHeaderMalformed
+	}
+	h := ProxyHeader{
+		Version: 2,
+		Local:   command == 0 || family == 0 || proto == 0,
+	}
+	if !h.Local {
+		h.Source, h.Destination = parseProxyAddrV2(family, proto, p[:size])
+	}
+
+	var crc []byte
+	for p = p[size:]; len(p) > 0; {
+		if len(p) < 3 {
+			return nil, ErrProxyHeaderMalformed
+		}
+		t := ProxyTLVType(p[0])
+		n := int(binary.BigEndian.Uint16(p[1:3]))
+		if len(p) < 3+n {
+			return nil, ErrProxyHeaderMalformed
+		}
+		v := p[3 : 3+n : 3+n]
+		if t == ProxyTLVCRC32C {
+			if n != 4 {
+				return nil, ErrProxyHeaderMalformed
+			}
+			crc = v
+		}
+		h.TLVs = append(h.TLVs, ProxyTLV{
+			Type:  t,
+			Value: v,
+		})
+		p = p[3+n:]
+	}
+	if crc != nil {
+		exp := binary.BigEndian.Uint32(crc)
+		// Checksum is calculated with zero value of the checksum TLV.
+		binary.BigEndian.PutUint32(crc, 0)
+		act := crc32.Checksum(buf, crc32.MakeTable(crc32.Castagnoli))
+		binary.BigEndian.PutUint32(crc, exp)
+		if act != exp {
+			return nil, ErrProxyHeaderMalformed
+		}
+	}
+	return &h, nil
+}
+
+func parseProxyAddrV2(family, proto byte, p []byte) (src, dst net.Addr) {
+	if family == 3 {
+		network := "unix"
+		if proto == 2 {
+			network = "unixgram"
+		}
+		return &net.UnixAddr{
+			Net:  network,
+			Name: string(trimZero(p[:108])),
+		}, &net.UnixAddr{
+			Net:  network,
+			Name: string(trimZero(p[108:])),
+		}
+	}
+	n := net.IPv4len
+	if family == 2 {
+		n = net.IPv6len
+	}
+	var (
+		// Use To16() to get the same representation as net.ParseIP() does.
+		srcIP   = net.IP(p[:n]).To16()
+		dstIP   = net.IP(p[n : 2*n]).To16()
+		srcPort = int(binary.BigEndian.Uint16(p[2*n:]))
+		dstPort = int(binary.BigEndian.Uint16(p[2*n+2:]))
+	)
+	if proto == 2 {
+		return &net.UDPAddr{IP: srcIP, Port: srcPort}, &net.UDPAddr{IP: dstIP, Port: dstPort}
+	}
+	return &net.TCPAddr{IP: srcIP, Port: srcPort}, &net.TCPAddr{IP: dstIP, Port: dstPort}
+}
+
+func trimZero(p []byte) []byte {
+	if i := bytes.IndexByte(p, 0); i != -1 {
+		return p[:i]
+	}
+	return p
+}
+
+// DefaultProxyHeaderTimeout is the default value of
+// ProxyListener.ReadHeaderTimeout.
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+// ProxyListener is a net.Listener which connections read PROXY protocol
+// header before any other data.
+//
+// Accepted connections are of type *ProxyConn. Their RemoteAddr() and
+// LocalAddr() methods return addresses received within the header. That is,
+// unlike Upgrader.ProxyProtocol, it makes the addresses available to any code
+// which uses the connection, e.g. to loggers or http.Server.
+type ProxyListener struct {
+	net.Listener
+
+	// Protocol contains options for reading the header.
+	Protocol ProxyProtocol
+
+	// ReadHeaderTimeout is the maximum amount of time spent while reading
+	// the header. If it is zero, then DefaultProxyHeaderTimeout is used. If
+	// it is negative, then there is no timeout.
+	//
+	// Note that the header is read lazily, and RemoteAddr() and LocalAddr()
+	// methods of accepted connections are blocked until it is read. This
+	// timeout bounds that blocking.
+	ReadHeaderTimeout time.Duration
+}
+
+// Accept implements net.Listener.
+func (ln *ProxyListener) Accept() (net.Conn, error) {
+	conn, err := ln.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	timeout := ln.ReadHeaderTimeout
+	if timeout == 0 {
+		timeout = DefaultProxyHeaderTimeout
+	}
+	return &ProxyConn{
+		Conn:     conn,
+		protocol: &ln.Protocol,
+		timeout:  timeout,
+	}, nil
+}
+
+// proxyReadBufferSize is the size of the buffer used by ProxyConn. It must
+// be enough to peek v1 header line.
+const proxyReadBufferSize = 256
+
+// ProxyConn is a net.Conn returned by ProxyListener.
+//
+// The header is read lazily on the first call to Read(), RemoteAddr(),
+// LocalAddr() or Header(). That is, those methods block until the header is
+// read or ReadHeaderTimeout of the listener expires, which makes the header
+// read to fail. Note that after the header is read, the read deadline of the
+// connection is cleared.
+//
+// ProxyConn implements syscall.Conn if the underlying connection does, thus
+// it could be used with pollers such as wspoll.Reactor once the data
+// buffered while reading the header is consumed.
+type ProxyConn struct {
+	net.Conn
+
+	protocol *ProxyProtocol
+	timeout  time.Duration
+
+	once   sync.Once
+	br     *bufio.Reader
+	header *ProxyHeader
+	err    error
+}
+
+func (c *ProxyConn) init() {
+	c.once.Do(func() {
+		if t := c.timeout; t > 0 {
+			c.Conn.SetReadDeadline(time.Now().Add(t))
+			defer c.Conn.SetReadDeadline(noDeadline)
+		}
+		c.br = bufio.NewReaderSize(c.Conn, proxyReadBufferSize)
+		c.header, c.err = c.protocol.read(c.br, c.Conn.RemoteAddr())
+	})
+}
+
+// Header returns the PROXY protocol header received from the connection.
+// Returned header is nil if the connection had no header.
+func (c *ProxyConn) Header() (*ProxyHeader, error) {
+	c.init()
+	return c.header, c.err
+}
+
+// Read implements io.Reader.
+func (c *ProxyConn) Read(p []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.br.Buffered() == 0 {
+		// Buffer is drained: read directly, so SyscallConn() users don't
+		// miss the data.
+		return c.Conn.Read(p)
+	}
+	return c.br.Read(p)
+}
+
+// SyscallConn implements syscall.Conn. It returns the raw connection of the
+// underlying connection, or ErrProxyConnBuffered if data received along with
+// the header is not read yet. It blocks until the header is read.
+//
+// Note that reads made through the returned connection bypass ProxyConn.
+func (c *ProxyConn) SyscallConn() (syscall.RawConn, error) {
+	c.init()
+	if c.err != nil {
+		return nil, c.err
+	}
+	if c.br.Buffered() > 0 {
+		return nil, ErrProxyConnBuffered
+	}
+	sc, ok := c.Conn.(syscall.Conn)
+	if !ok {
+		return nil, ErrProxyConnNotSyscall
+	}
+	return sc.SyscallConn()
+}
+
+// RemoteAddr returns the source address received within the header, or the
+// remote address of the underlying connection. It blocks until the header is
+// read.
+func (c *ProxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if h := c.header; h != nil && h.Source != nil {
+		return h.Source
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// LocalAddr returns the destination address received within the header, or
+// the local address of the underlying connection. It blocks until the header
+// is read.
+func (c *ProxyConn) LocalAddr() net.Addr {
+	c.init()
+	if h := c.header; h != nil && h.Destination != nil {
+		return h.Destination
+	}
+	return c.Conn.LocalAddr()
+}
+
+// netsContainAddr reports whether ip of addr belongs to any of given
+// networks.
+func netsContainAddr(nets []*net.IPNet, addr net.Addr) bool {
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		ip = a.IP
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.IPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
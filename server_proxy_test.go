@@ -0,0 +1,396 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadProxyHeader(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		in     []byte
+		exp    *ProxyHeader
+		expErr error
+	}{
+		{
+			name: "no header",
+			in:   []byte("GET / HTTP/1.1\r\n"),
+		},
+		{
+			name: "v1 tcp4",
+			in:   []byte("PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n"),
+			exp: &ProxyHeader{
+				Version:     1,
+				Source:      tcpAddr("192.0.2.1", 56324),
+				Destination: tcpAddr("192.0.2.2", 443),
+			},
+		},
+		{
+			name: "v1 tcp6",
+			in:   []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			exp: &ProxyHeader{
+				Version:     1,
+				Source:      tcpAddr("2001:db8::1", 56324),
+				Destination: tcpAddr("2001:db8::2", 443),
+			},
+		},
+		{
+			name: "v1 unknown",
+			in:   []byte("PROXY UNKNOWN whatever\r\n"),
+			exp: &ProxyHeader{
+				Version: 1,
+				Local:   true,
+			},
+		},
+		{
+			name:   "v1 family mismatch",
+			in:     []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 443\r\n"),
+			expErr: ErrProxyHeaderMalformed,
+		},
+		{
+			name:   "v1 bad port",
+			in:     []byte("PROXY TCP4 192.0.2.1 192.0.2.2 65536 443\r\n"),
+			expErr: ErrProxyHeaderMalformed,
+		},
+		{
+			name:   "v1 too long",
+			in:     []byte("PROXY UNKNOWN " + strings.Repeat("x", 100) + "\r\n"),
+			expErr: ErrProxyHeaderMalformed,
+		},
+		{
+			name:   "v1 truncated",
+			in:     []byte("PROX"),
+			expErr: io.ErrUnexpectedEOF,
+		},
+		{
+			name: "v2 tcp4",
+			in: proxyHeaderV2(0x21, 0x11, []byte{
+				192, 0, 2, 1,
+				192, 0, 2, 2,
+				0xdc, 0x04,
+				0x01, 0xbb,
+			}, ProxyTLV{ProxyTLVAuthority, []byte("example.org")}),
+			exp: &ProxyHeader{
+				Version:     2,
+				Source:      tcpAddr("192.0.2.1", 56324),
+				Destination: tcpAddr("192.0.2.2", 443),
+				TLVs: []ProxyTLV{
+					{ProxyTLVAuthority, []byte("example.org")},
+				},
+			},
+		},
+		{
+			name: "v2 local",
+			in:   proxyHeaderV2(0x20, 0x00, nil),
+			exp: &ProxyHeader{
+				Version: 2,
+				Local:   true,
+			},
+		},
+		{
+			name:   "v2 bad version",
+			in:     proxyHeaderV2(0x11, 0x11, make([]byte, 12)),
+			expErr: ErrProxyHeaderMalformed,
+		},
+		{
+			name:   "v2 short address",
+			in:     proxyHeaderV2(0x21, 0x21, make([]byte, 12)),
+			expErr: ErrProxyHeaderMalformed,
+		},
+		{
+			name:   "v2 truncated",
+			in:     proxyHeaderV2(0x21, 0x11, make([]byte, 12))[:20],
+			expErr: io.ErrUnexpectedEOF,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			br := bufio.NewReader(bytes.NewReader(test.in))
+			act, err := readProxyHeader(br)
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if !reflect.DeepEqual(act, test.exp) {
+				t.Errorf("unexpected header:\nact: %+v\nexp: %+v", act, test.exp)
+			}
+		})
+	}
+}
+
+func TestReadProxyHeaderV2Checksum(t *testing.T) {
+	addr := make([]byte, 12)
+	crc := ProxyTLV{ProxyTLVCRC32C, make([]byte, 4)}
+	p := proxyHeaderV2(0x21, 0x11, addr, crc)
+	sum := crc32.Checksum(p, crc32.MakeTable(crc32.Castagnoli))
+	binary.BigEndian.PutUint32(p[len(p)-4:], sum)
+
+	h, err := readProxyHeader(bufio.NewReader(bytes.NewReader(p)))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v, _ := h.TLV(ProxyTLVCRC32C); binary.BigEndian.Uint32(v) != sum {
+		t.Errorf("checksum TLV value was not restored")
+	}
+
+	p[len(p)-1]++
+	if _, err := readProxyHeader(bufio.NewReader(bytes.NewReader(p))); err != ErrProxyHeaderMalformed {
+		t.Errorf("unexpected error: %v; want %v", err, ErrProxyHeaderMalformed)
+	}
+}
+
+func TestUpgraderProxyProtocol(t *testing.T) {
+	const request = "" +
+		"GET /ws HTTP/1.1\r\n" +
+		"Host: example.org\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"X-Forwarded-For: 203.0.113.7, 10.0.0.2\r\n" +
+		"\r\n"
+
+	_, trusted, _ := net.ParseCIDR("10.0.0.0/8")
+	for _, test := range []struct {
+		name     string
+		in       string
+		protocol *ProxyProtocol
+		trusted  []*net.IPNet
+		expAddr  net.Addr
+		expErr   error
+	}{
+		{
+			name:     "header",
+			in:       "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n" + request,
+			protocol: &ProxyProtocol{},
+			expAddr:  tcpAddr("192.0.2.1", 56324),
+		},
+		{
+			name:     "missing",
+			in:       request,
+			protocol: &ProxyProtocol{},
+			expErr:   ErrProxyHeaderMissing,
+		},
+		{
+			name:     "optional",
+			in:       request,
+			protocol: &ProxyProtocol{Optional: true},
+		},
+		{
+			name:     "forwarded untrusted",
+			in:       "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\n" + request,
+			protocol: &ProxyProtocol{},
+			trusted:  []*net.IPNet{trusted},
+			expAddr:  tcpAddr("192.0.2.1", 56324),
+		},
+		{
+			name:     "forwarded trusted",
+			in:       "PROXY TCP4 10.0.0.1 192.0.2.2 56324 443\r\n" + request,
+			protocol: &ProxyProtocol{},
+			trusted:  []*net.IPNet{trusted},
+			expAddr:  tcpAddr("203.0.113.7", 0),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var (
+				onProxy *ProxyHeader
+				onHost  bool
+			)
+			u := Upgrader{
+				ProxyProtocol:  test.protocol,
+				TrustedProxies: test.trusted,
+				OnProxy: func(h *ProxyHeader) error {
+					if onHost {
+						t.Errorf("OnProxy called after OnHost")
+					}
+					onProxy = h
+					return nil
+				},
+				OnHost: func([]byte) error {
+					onHost = true
+					return nil
+				},
+			}
+			var out bytes.Buffer
+			hs, err := u.Upgrade(struct {
+				io.Reader
+				io.Writer
+			}{
+				strings.NewReader(test.in),
+				&out,
+			})
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if err != nil {
+				return
+			}
+			if onProxy != hs.Proxy {
+				t.Errorf("OnProxy received unexpected header: %+v", onProxy)
+			}
+			if !reflect.DeepEqual(hs.RemoteAddr, test.expAddr) {
+				t.Errorf("unexpected remote addr: %v; want %v", hs.RemoteAddr, test.expAddr)
+			}
+		})
+	}
+}
+
+func TestProxyListener(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := &ProxyListener{Listener: ln}
+	defer pln.Close()
+
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		io.WriteString(conn, "PROXY TCP6 2001:db8::1 2001:db8::2 56324 443\r\nhello")
+	}()
+
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if act, exp := conn.RemoteAddr(), tcpAddr("2001:db8::1", 56324); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected remote addr: %v; want %v", act, exp)
+	}
+	if act, exp := conn.LocalAddr(), tcpAddr("2001:db8::2", 443); !reflect.DeepEqual(act, exp) {
+		t.Errorf("unexpected local addr: %v; want %v", act, exp)
+	}
+	bts, err := io.ReadAll(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(bts) != "hello" {
+		t.Errorf("unexpected data: %q", bts)
+	}
+	if h, err := conn.(*ProxyConn).Header(); err != nil || h.Version != 1 {
+		t.Errorf("unexpected header: %+v, %v", h, err)
+	}
+}
+
+func TestProxyConnSyscallConn(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := &ProxyListener{Listener: ln}
+	defer pln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	io.WriteString(client, "PROXY TCP4 192.0.2.1 192.0.2.2 56324 443\r\nhello")
+
+	c, err := pln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	conn := c.(*ProxyConn)
+
+	if _, err := conn.Header(); err != nil {
+		t.Fatal(err)
+	}
+	if conn.br.Buffered() > 0 {
+		if _, err := conn.SyscallConn(); err != ErrProxyConnBuffered {
+			t.Errorf("unexpected error: %v; want %v", err, ErrProxyConnBuffered)
+		}
+	}
+	p := make([]byte, 5)
+	if _, err := io.ReadFull(conn, p); err != nil || string(p) != "hello" {
+		t.Fatalf("unexpected data: %q, %v", p, err)
+	}
+	rc, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatalf("unexpected SyscallConn() error: %v", err)
+	}
+	if err := rc.Control(func(uintptr) {}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Data received after the buffer is drained must not be buffered.
+	io.WriteString(client, "world")
+	if _, err := io.ReadFull(conn, p[:1]); err != nil {
+		t.Fatal(err)
+	}
+	if n := conn.br.Buffered(); n != 0 {
+		t.Errorf("unexpected buffered data: %d bytes", n)
+	}
+}
+
+func TestProxyListenerTimeout(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pln := &ProxyListener{
+		Listener:          ln,
+		ReadHeaderTimeout: 50 * time.Millisecond,
+	}
+	defer pln.Close()
+
+	client, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	conn, err := pln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	done := make(chan net.Addr, 1)
+	go func() {
+		done <- conn.RemoteAddr()
+	}()
+	select {
+	case addr := <-done:
+		if act, exp := addr.String(), client.LocalAddr().String(); act != exp {
+			t.Errorf("unexpected remote addr: %v; want %v", act, exp)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("RemoteAddr() is blocked")
+	}
+	if _, err := conn.(*ProxyConn).Header(); err == nil {
+		t.Errorf("expected header read error")
+	}
+}
+
+func proxyHeaderV2(command, family byte, addr []byte, tlvs ...ProxyTLV) []byte {
+	p := append([]byte(nil), proxySignatureV2...)
+	p = append(p, command, family, 0, 0)
+	p = append(p, addr...)
+	for _, tlv := range tlvs {
+		p = append(p, byte(tlv.Type), 0, 0)
+		binary.BigEndian.PutUint16(p[len(p)-2:], uint16(len(tlv.Value)))
+		p = append(p, tlv.Value...)
+	}
+	binary.BigEndian.PutUint16(p[14:], uint16(len(p)-proxyHeaderSizeV2))
+	return p
+}
+
+func tcpAddr(host string, port int) *net.TCPAddr {
+	return &net.TCPAddr{
+		IP:   net.ParseIP(host),
+		Port: port,
+	}
+}
@@ -0,0 +1,193 @@
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/httphead"
+)
+
+// upgradeStream upgrades HTTP/2 (or higher) stream to the websocket
+// connection as described in RFC8441.
+//
+// See https://tools.ietf.org/html/rfc8441#section-5
+func (u HTTPUpgrader) upgradeStream(r *http.Request, w http.ResponseWriter) (conn net.Conn, rw *bufio.ReadWriter, hs Handshake, err error) {
+	// The request MUST use the CONNECT method with :protocol pseudo-header
+	// set to "websocket". Note that Upgrade, Connection and Sec-WebSocket-Key
+	// headers are not used with HTTP/2.
+	if r.Method != http.MethodConnect {
+		err = ErrHandshakeBadMethod
+	} else if p := httpGetHeader(r.Header, headerConnectProtocol); p != "websocket" && !strings.EqualFold(p, "websocket") {
+		err = ErrHandshakeBadConnectProtocol
+	} else if r.Host == "" {
+		err = ErrHandshakeBadHost
+	} else if v := httpGetHeader(r.Header, headerSecVersionCanonical); v != "13" {
+		// See the comment for the same check in Upgrade().
+		if v != "" {
+			err = ErrHandshakeUpgradeRequired
+		} else {
+			err = ErrHandshakeBadSecVersion
+		}
+	}
+	if err == nil {
+		err = u.Limits.checkRequest(r)
+	}
+	if p := u.Origin; err == nil && p != nil {
+		err = p.checkRequest(r)
+	}
+	if err == nil {
+		err = u.negotiate(r.Header, &hs)
+	}
+
+	// Clear deadlines set by server.
+	_ = responseSetReadDeadline(w, noDeadline)
+	_ = responseSetWriteDeadline(w, noDeadline)
+	if t := u.writeTimeout(); t != 0 {
+		_ = responseSetWriteDeadline(w, time.Now().Add(t))
+		defer responseSetWriteDeadline(w, noDeadline)
+	}
+
+	header := w.Header()
+	for k, v := range u.Header {
+		header[k] = v
+	}
+	if err != nil {
+		code := http.StatusInternalServerError
+		if rej, ok := err.(*ConnectionRejectedError); ok {
+			if rej.code != 0 {
+				code = rej.code
+			}
+			if rej.header != nil {
+				httpMergeHeader(header, rej.header)
+			}
+		}
+		httpError(w, err.Error(), code)
+		return nil, nil, hs, err
+	}
+	if hs.Protocol != "" {
+		header.Set(headerSecProtocol, hs.Protocol)
+	}
+	if len(hs.Extensions) > 0 {
+		var buf bytes.Buffer
+		httphead.WriteOptions(&buf, hs.Extensions)
+		header.Set(headerSecExtensions, buf.String())
+	}
+	w.WriteHeader(http.StatusOK)
+	if err = responseFlush(w); err != nil {
+		return nil, nil, hs, err
+	}
+
+	conn = &streamConn{
+		r:    r,
+		w:    w,
+		body: r.Body,
+	}
+	rw = bufio.NewReadWriter(
+		bufio.NewReaderSize(conn, DefaultServerReadBufferSize),
+		bufio.NewWriterSize(conn, DefaultServerWriteBufferSize),
+	)
+	return conn, rw, hs, nil
+}
+
+// httpMergeHeader writes h into the dst mapping.
+func httpMergeHeader(dst http.Header, h HandshakeHeader) {
+	var buf bytes.Buffer
+	if _, err := h.WriteTo(&buf); err != nil {
+		return
+	}
+	buf.WriteString(crlf)
+	m, err := textproto.NewReader(bufio.NewReader(&buf)).ReadMIMEHeader()
+	if err != nil {
+		return
+	}
+	for k, v := range m {
+		dst[k] = v
+	}
+}
+
+// streamConn is a net.Conn implementation backed by the HTTP/2 stream of
+// extended CONNECT request.
+type streamConn struct {
+	r    *http.Request
+	w    http.ResponseWriter
+	body io.ReadCloser
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Read implements io.Reader.
+func (c *streamConn) Read(p []byte) (int, error) {
+	return c.body.Read(p)
+}
+
+// Write implements io.Writer. It flushes every written chunk to the peer.
+func (c *streamConn) Write(p []byte) (n int, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return 0, net.ErrClosed
+	}
+	n, err = c.w.Write(p)
+	if err == nil {
+		err = responseFlush(c.w)
+	}
+	return n, err
+}
+
+// Close closes the request body. Note that the stream is closed for writing
+// only when the http.Handler returns.
+func (c *streamConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+	c.closed = true
+	return c.body.Close()
+}
+
+// LocalAddr implements net.Conn.
+func (c *streamConn) LocalAddr() net.Addr {
+	if addr, ok := c.r.Context().Value(http.LocalAddrContextKey).(net.Addr); ok {
+		return addr
+	}
+	return streamAddr("")
+}
+
+// RemoteAddr implements net.Conn.
+func (c *streamConn) RemoteAddr() net.Addr {
+	return streamAddr(c.r.RemoteAddr)
+}
+
+// SetDeadline implements net.Conn.
+func (c *streamConn) SetDeadline(t time.Time) error {
+	if err := responseSetReadDeadline(c.w, t); err != nil {
+		return err
+	}
+	return responseSetWriteDeadline(c.w, t)
+}
+
+// SetReadDeadline implements net.Conn.
+func (c *streamConn) SetReadDeadline(t time.Time) error {
+	return responseSetReadDeadline(c.w, t)
+}
+
+// SetWriteDeadline implements net.Conn.
+func (c *streamConn) SetWriteDeadline(t time.Time) error {
+	return responseSetWriteDeadline(c.w, t)
+}
+
+// streamAddr is a net.Addr implementation holding the address reported by
+// net/http.
+type streamAddr string
+
+func (a streamAddr) Network() string { return "tcp" }
+func (a streamAddr) String() string  { return string(a) }
@@ -0,0 +1,148 @@
+package ws
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+func TestHTTPUpgraderStream(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		upgrader  HTTPUpgrader
+		method    string
+		header    http.Header
+		expStatus int
+		expHeader http.Header
+		expErr    error
+	}{
+		{
+			name:   "base",
+			method: http.MethodConnect,
+			header: http.Header{
+				headerConnectProtocol:     {"websocket"},
+				headerSecVersionCanonical: {"13"},
+			},
+			expStatus: http.StatusOK,
+			expHeader: http.Header{},
+		},
+		{
+			name: "negotiation",
+			upgrader: HTTPUpgrader{
+				Header: http.Header{
+					"X-Server": {"gobwas"},
+				},
+				Protocol: func(p string) bool {
+					return p == "b"
+				},
+				Negotiate: func(opt httphead.Option) (httphead.Option, error) {
+					if string(opt.Name) == "foo" {
+						return opt, nil
+					}
+					return httphead.Option{}, nil
+				},
+			},
+			method: http.MethodConnect,
+			header: http.Header{
+				headerConnectProtocol:        {"websocket"},
+				headerSecVersionCanonical:    {"13"},
+				headerSecProtocolCanonical:   {"a, b"},
+				headerSecExtensionsCanonical: {"foo;x=1, bar"},
+			},
+			expStatus: http.StatusOK,
+			expHeader: http.Header{
+				"X-Server":                   {"gobwas"},
+				headerSecProtocolCanonical:   {"b"},
+				headerSecExtensionsCanonical: {"foo;x=1"},
+			},
+		},
+		{
+			name:   "bad method",
+			method: http.MethodGet,
+			header: http.Header{
+				headerConnectProtocol:     {"websocket"},
+				headerSecVersionCanonical: {"13"},
+			},
+			expStatus: http.StatusMethodNotAllowed,
+			expErr:    ErrHandshakeBadMethod,
+		},
+		{
+			name:   "bad protocol",
+			method: http.MethodConnect,
+			header: http.Header{
+				headerConnectProtocol:     {"webtransport"},
+				headerSecVersionCanonical: {"13"},
+			},
+			expStatus: http.StatusBadRequest,
+			expErr:    ErrHandshakeBadConnectProtocol,
+		},
+		{
+			name:   "bad version",
+			method: http.MethodConnect,
+			header: http.Header{
+				headerConnectProtocol:     {"websocket"},
+				headerSecVersionCanonical: {"14"},
+			},
+			expStatus: http.StatusUpgradeRequired,
+			expHeader: http.Header{
+				headerSecVersionCanonical: {"13"},
+			},
+			expErr: ErrHandshakeUpgradeRequired,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, "https://example.org/chat", nil)
+			req.Proto = "HTTP/2.0"
+			req.ProtoMajor, req.ProtoMinor = 2, 0
+			req.Header = test.header
+			req.Body = io.NopCloser(bytes.NewReader(
+				MustCompileFrame(MaskFrame(NewTextFrame([]byte("ping")))),
+			))
+			res := httptest.NewRecorder()
+
+			conn, _, hs, err := test.upgrader.Upgrade(req, res)
+			if err != test.expErr {
+				t.Fatalf("unexpected error: %v; want %v", err, test.expErr)
+			}
+			if act, exp := res.Code, test.expStatus; act != exp {
+				t.Errorf("unexpected status code: %d; want %d", act, exp)
+			}
+			if res.Header().Get(headerSecAccept) != "" {
+				t.Errorf("unexpected %q header", headerSecAccept)
+			}
+			for k := range test.expHeader {
+				if act, exp := res.Header().Get(k), test.expHeader.Get(k); act != exp {
+					t.Errorf("unexpected %q header: %q; want %q", k, act, exp)
+				}
+			}
+			if err != nil {
+				return
+			}
+			if act, exp := hs.Protocol, test.expHeader.Get(headerSecProtocol); act != exp {
+				t.Errorf("unexpected handshake protocol: %q; want %q", act, exp)
+			}
+
+			f, err := ReadFrame(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f = UnmaskFrameInPlace(f); string(f.Payload) != "ping" {
+				t.Errorf("unexpected payload: %q", f.Payload)
+			}
+			if err := WriteFrame(conn, NewTextFrame([]byte("pong"))); err != nil {
+				t.Fatal(err)
+			}
+			f, err = ReadFrame(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(f.Payload) != "pong" {
+				t.Errorf("unexpected payload: %q", f.Payload)
+			}
+		})
+	}
+}
@@ -149,9 +149,19 @@ func canonicalizeHeaderKey(k []byte) {
 // NOTE: it may return copied flag to notify that returned buffer is safe to
 // use.
 func readLine(br *bufio.Reader) ([]byte, error) {
+	return readLineLimit(br, -1)
+}
+
+// readLineLimit is like readLine() but returns errLineTooLong if the line
+// including its terminator exceeds limit bytes. Negative limit means no
+// limit.
+func readLineLimit(br *bufio.Reader, limit int) ([]byte, error) {
 	var line []byte
 	for {
 		bts, err := br.ReadSlice('\n')
+		if limit >= 0 && len(line)+len(bts) > limit {
+			return line, errLineTooLong
+		}
 		if err == bufio.ErrBufferFull {
 			// Copy bytes because next read will discard them.
 			line = append(line, bts...)
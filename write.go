@@ -3,6 +3,7 @@ package ws
 import (
 	"encoding/binary"
 	"io"
+	"net"
 )
 
 // Header size length bounds in bytes.
@@ -60,15 +61,24 @@ func WriteHeaderBuffer(w io.Writer, h Header, bts []byte) error {
 		return io.ErrShortBuffer
 	}
 
-	bts = bts[:MaxHeaderSize]
+	n, err := putHeader(bts[:MaxHeaderSize], h)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(bts[:n])
 
+	return err
+}
+
+// putHeader puts binary representation of h into bts. It returns number of
+// bytes used. Provided slice must be at least 14 bytes long.
+func putHeader(bts []byte, h Header) (n int, err error) {
 	bts[0] = h.Rsv<<4 | byte(h.OpCode)
 
 	if h.Fin {
 		bts[0] |= bit0
 	}
 
-	var n int
 	switch {
 	case h.Length <= len7:
 		bts[1] = byte(h.Length)
@@ -85,7 +95,7 @@ func WriteHeaderBuffer(w io.Writer, h Header, bts []byte) error {
 		n = 10
 
 	default:
-		return ErrHeaderLengthUnexpected
+		return 0, ErrHeaderLengthUnexpected
 	}
 
 	if h.Masked {
@@ -93,18 +103,53 @@ func WriteHeaderBuffer(w io.Writer, h Header, bts []byte) error {
 		n += copy(bts[n:], h.Mask[:])
 	}
 
-	_, err := w.Write(bts[:n])
-
-	return err
+	return n, nil
 }
 
 // WriteFrame writes frame binary representation into w.
+//
+// Header and payload are written as net.Buffers. That is, if w is a
+// *net.TCPConn or *net.UnixConn, they are sent by a single writev(2) system
+// call without copying the payload. Other writers receive header and payload
+// by separate Write() calls.
 func WriteFrame(w io.Writer, f Frame) error {
-	err := WriteHeader(w, f.Header)
+	// Keep header and buffers together to make single allocation.
+	var v struct {
+		hdr  [MaxHeaderSize]byte
+		buf  [2][]byte
+		bufs net.Buffers
+	}
+	n, err := putHeader(v.hdr[:], f.Header)
 	if err != nil {
 		return err
 	}
-	_, err = w.Write(f.Payload)
+	v.buf[0] = v.hdr[:n]
+	v.buf[1] = f.Payload
+	v.bufs = v.buf[:]
+	_, err = v.bufs.WriteTo(w)
+	return err
+}
+
+// WriteFrames writes binary representation of given frames into w. As
+// WriteFrame() does, it uses net.Buffers, thus all frames are sent by a single
+// writev(2) system call if w supports it.
+func WriteFrames(w io.Writer, fs ...Frame) error {
+	var (
+		hdr  = make([]byte, len(fs)*MaxHeaderSize)
+		bufs = make(net.Buffers, 0, len(fs)*2)
+	)
+	for _, f := range fs {
+		n, err := putHeader(hdr, f.Header)
+		if err != nil {
+			return err
+		}
+		bufs = append(bufs, hdr[:n:n])
+		if len(f.Payload) > 0 {
+			bufs = append(bufs, f.Payload)
+		}
+		hdr = hdr[n:]
+	}
+	_, err := bufs.WriteTo(w)
 	return err
 }
 
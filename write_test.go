@@ -3,7 +3,9 @@ package ws
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"testing"
 )
 
@@ -28,6 +30,91 @@ func TestWriteHeader(t *testing.T) {
 	}
 }
 
+func TestWriteFrames(t *testing.T) {
+	frames := []Frame{
+		NewTextFrame([]byte("hello, world!")),
+		NewPingFrame(nil),
+		MaskFrameWith(NewBinaryFrame(bytes.Repeat([]byte{'x'}, 300)), [4]byte{1, 2, 3, 4}),
+		NewBinaryFrame(bytes.Repeat([]byte{'y'}, 70000)),
+	}
+	var exp []byte
+	for _, f := range frames {
+		exp = append(exp, MustCompileFrame(f)...)
+	}
+	for _, test := range []struct {
+		name  string
+		write func(io.Writer) error
+	}{
+		{
+			name: "WriteFrame",
+			write: func(w io.Writer) error {
+				for _, f := range frames {
+					if err := WriteFrame(w, f); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name: "WriteFrames",
+			write: func(w io.Writer) error {
+				return WriteFrames(w, frames...)
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := test.write(&buf); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), exp) {
+				t.Errorf("unexpected bytes written to buffer")
+			}
+		})
+		t.Run(test.name+"/conn", func(t *testing.T) {
+			server, client := tcpPair(t)
+			done := make(chan error, 1)
+			go func() {
+				done <- test.write(client)
+				client.Close()
+			}()
+			act, err := ioutil.ReadAll(server)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, exp) {
+				t.Errorf("unexpected bytes received from connection")
+			}
+		})
+	}
+}
+
+func tcpPair(t *testing.T) (server, client net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
 func BenchmarkWriteHeader(b *testing.B) {
 	for _, bench := range RWBenchCases {
 		b.Run(bench.label, func(b *testing.B) {
@@ -55,3 +142,15 @@ func BenchmarkWriteHeader(b *testing.B) {
 		})
 	}
 }
+
+func BenchmarkWriteFrame(b *testing.B) {
+	payload := make([]byte, 64<<10)
+	f := NewBinaryFrame(payload)
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := WriteFrame(ioutil.Discard, f); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
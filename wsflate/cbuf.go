@@ -7,10 +7,11 @@ import (
 // cbuf is a tiny proxy-buffer that writes all but 4 last bytes to the
 // destination.
 type cbuf struct {
-	buf [4]byte
-	n   int
-	dst io.Writer
-	err error
+	buf     [4]byte
+	n       int
+	dst     io.Writer
+	err     error
+	written int64 // number of bytes written to dst.
 }
 
 // Write implements io.Writer interface.
@@ -36,7 +37,9 @@ func (c *cbuf) Write(p []byte) (int, error) {
 
 func (c *cbuf) flush(p []byte) {
 	if c.err == nil {
-		_, c.err = c.dst.Write(p)
+		var n int
+		n, c.err = c.dst.Write(p)
+		c.written += int64(n)
 	}
 }
 
@@ -53,13 +56,15 @@ func (c *cbuf) split(p []byte) (head, tail []byte) {
 func (c *cbuf) reset(dst io.Writer) {
 	c.n = 0
 	c.err = nil
+	c.written = 0
 	c.buf = [4]byte{0, 0, 0, 0}
 	c.dst = dst
 }
 
 type suffixedReader struct {
 	r      io.Reader
-	pos    int // position in the suffix.
+	n      int64 // number of bytes read from r.
+	pos    int   // position in the suffix.
 	suffix [9]byte
 
 	rx struct{ io.Reader }
@@ -86,6 +91,7 @@ func (r *suffixedReader) iface() io.Reader {
 func (r *suffixedReader) Read(p []byte) (n int, err error) {
 	if r.r != nil {
 		n, err = r.r.Read(p)
+		r.n += int64(n)
 		if err == io.EOF {
 			err = nil
 			r.r = nil
@@ -107,6 +113,9 @@ func (r *suffixedReader) ReadByte() (b byte, err error) {
 			panic("wsflate: internal error: incorrect use of suffixedReader")
 		}
 		b, err = br.ReadByte()
+		if err == nil {
+			r.n++
+		}
 		if err == io.EOF {
 			err = nil
 			r.r = nil
@@ -123,6 +132,7 @@ func (r *suffixedReader) ReadByte() (b byte, err error) {
 
 func (r *suffixedReader) reset(src io.Reader) {
 	r.r = src
+	r.n = 0
 	r.pos = 0
 }
 
@@ -0,0 +1,185 @@
+package wsflate
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+)
+
+// ClientExtension contains logic of compression extension parameters
+// negotiation made by the client during HTTP WebSocket handshake.
+// It is a client side counterpart of Extension.
+//
+// It might be reused between different handshakes (but not concurrently)
+// with Reset() being called after each.
+type ClientExtension struct {
+	// Parameters is specification of extension parameters client is going to
+	// offer in the most preferred offer.
+	//
+	// Note that if ClientMaxWindowBits is set, it must be supported by the
+	// compressor used to write messages.
+	Parameters Parameters
+
+	// NoFallback disables the less preferred offers, which are made without
+	// parameters that server may decline.
+	NoFallback bool
+
+	// Pool is an optional pool of compression contexts. If it is non-nil and
+	// its memory budget is exceeded, then offers are made with
+	// "client_no_context_takeover" and (in the most preferred offer)
+	// "server_no_context_takeover" parameters.
+	Pool *Pool
+
+	// Dictionaries is an optional registry of preset dictionaries. If it is
+	// non-nil and Parameters has no DictionaryID, the preferred dictionary
+	// registered for the Protocol is offered.
+	Dictionaries *Dictionaries
+
+	// Protocol is the subprotocol the client is going to use. It is used to
+	// look up the dictionaries.
+	Protocol string
+
+	offers   []Parameters
+	accepted bool
+	params   Parameters
+}
+
+// Offers returns the list of extension offers ordered by preference. It could
+// be used as ws.Dialer's Extensions field.
+//
+// The most preferred offer is made exactly as described by Parameters. Unless
+// NoFallback is set, it is followed by offers without the server_* parameters
+// and without any parameters at all, so server which declines some of the
+// requested parameters is still able to accept the extension. Note that the
+// last offer is made without dictionary as well.
+func (c *ClientExtension) Offers() []httphead.Option {
+	params := c.Parameters
+	if c.Pool != nil && c.Pool.Exceeded() {
+		params.ClientNoContextTakeover = true
+		params.ServerNoContextTakeover = true
+	}
+	if params.DictionaryID == "" && c.Dictionaries != nil {
+		if d, ok := c.Dictionaries.Preferred(c.Protocol); ok {
+			params.DictionaryID = d.ID
+		}
+	}
+	c.offers = c.offers[:0]
+	c.offer(params)
+	if !c.NoFallback {
+		p := params
+		p.ServerNoContextTakeover = false
+		p.ServerMaxWindowBits = 0
+		c.offer(p)
+		c.offer(Parameters{})
+	}
+	opts := make([]httphead.Option, len(c.offers))
+	for i, p := range c.offers {
+		opts[i] = p.Option()
+	}
+	return opts
+}
+
+func (c *ClientExtension) offer(p Parameters) {
+	for _, o := range c.offers {
+		if o == p {
+			return
+		}
+	}
+	c.offers = append(c.offers, p)
+}
+
+// Negotiate parses given HTTP header option received within server's
+// handshake response and checks it against the offers accordingly to RFC 7692
+// section 7.1. It could be used as ws.Dialer's OnExtension field.
+//
+// It returns nil error for options with other extension names.
+func (c *ClientExtension) Negotiate(opt httphead.Option) (err error) {
+	if !bytes.Equal(opt.Name, ExtensionNameBytes) {
+		return nil
+	}
+	if c.accepted {
+		return fmt.Errorf("wsflate: duplicate %q extension in response", ExtensionName)
+	}
+	if len(c.offers) == 0 {
+		// Offers() were not called; assume that only Parameters were
+		// offered.
+		c.offer(c.Parameters)
+	}
+	var resp Parameters
+	if err := resp.Parse(opt); err != nil {
+		return err
+	}
+	if resp.ClientMaxWindowBits == 1 {
+		// The "client_max_window_bits" extension parameter in a response
+		// must have a value.
+		return paramError("invalid", clientMaxWindowBitsBytes, nil)
+	}
+	for _, offer := range c.offers {
+		if responds(offer, resp) {
+			c.accepted = true
+			c.params = resp
+			return nil
+		}
+	}
+	return fmt.Errorf("wsflate: %q extension response does not match any offer", ExtensionName)
+}
+
+// Accepted returns parameters parsed during last negotiation and a flag that
+// reports whether they were accepted.
+//
+// The ClientNoContextTakeover and ClientMaxWindowBits parameters describe the
+// messages written by the client, while the ServerNoContextTakeover and
+// ServerMaxWindowBits describe the messages written by the server.
+func (c *ClientExtension) Accepted() (_ Parameters, accepted bool) {
+	return c.params, c.accepted
+}
+
+// Rsv implements ws.RsvExtension. See Extension.Rsv().
+func (c *ClientExtension) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
+// Reset resets extension for further reuse.
+func (c *ClientExtension) Reset() {
+	c.accepted = false
+	c.params = Parameters{}
+	c.offers = c.offers[:0]
+}
+
+// responds reports whether resp is a valid response to the offer.
+func responds(offer, resp Parameters) bool {
+	// A server accepts offered dictionary by including its ID in the
+	// response.
+	if offer.DictionaryID != resp.DictionaryID {
+		return false
+	}
+	// A server accepts an extension negotiation offer with
+	// "server_no_context_takeover" parameter by including it in the
+	// response.
+	if offer.ServerNoContextTakeover && !resp.ServerNoContextTakeover {
+		return false
+	}
+	// A server accepts an extension negotiation offer with
+	// "server_max_window_bits" parameter by including it in the response
+	// with the same or smaller value as the offer.
+	if offer.ServerMaxWindowBits.Defined() {
+		if !resp.ServerMaxWindowBits.Defined() ||
+			resp.ServerMaxWindowBits > offer.ServerMaxWindowBits {
+			return false
+		}
+	}
+	// If a received response has the "client_max_window_bits" parameter but
+	// the offer didn't include it, the client must fail the connection.
+	if resp.ClientMaxWindowBits.Defined() {
+		if !offer.ClientMaxWindowBits.Defined() {
+			return false
+		}
+		if offer.ClientMaxWindowBits != 1 &&
+			resp.ClientMaxWindowBits > offer.ClientMaxWindowBits {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,222 @@
+package wsflate
+
+import (
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+func TestClientExtensionOffers(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		ext  ClientExtension
+		exp  []Parameters
+	}{
+		{
+			name: "default",
+			exp:  []Parameters{{}},
+		},
+		{
+			name: "fallback",
+			ext: ClientExtension{
+				Parameters: Parameters{
+					ServerNoContextTakeover: true,
+					ClientNoContextTakeover: true,
+					ServerMaxWindowBits:     10,
+					ClientMaxWindowBits:     1,
+				},
+			},
+			exp: []Parameters{
+				{
+					ServerNoContextTakeover: true,
+					ClientNoContextTakeover: true,
+					ServerMaxWindowBits:     10,
+					ClientMaxWindowBits:     1,
+				},
+				{
+					ClientNoContextTakeover: true,
+					ClientMaxWindowBits:     1,
+				},
+				{},
+			},
+		},
+		{
+			name: "no fallback",
+			ext: ClientExtension{
+				Parameters: Parameters{
+					ServerNoContextTakeover: true,
+				},
+				NoFallback: true,
+			},
+			exp: []Parameters{
+				{ServerNoContextTakeover: true},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			opts := test.ext.Offers()
+			if act, exp := len(opts), len(test.exp); act != exp {
+				t.Fatalf("unexpected number of offers: %d; want %d", act, exp)
+			}
+			for i, opt := range opts {
+				var act Parameters
+				if err := act.Parse(opt); err != nil {
+					t.Fatalf("can not parse #%d offer: %v", i, err)
+				}
+				if exp := test.exp[i]; act != exp {
+					t.Errorf("unexpected #%d offer: %+v; want %+v", i, act, exp)
+				}
+			}
+		})
+	}
+}
+
+func TestClientExtensionNegotiate(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		params     Parameters
+		noFallback bool
+		resp       []httphead.Option
+		exp        Parameters
+		expOK      bool
+		expErr     bool
+	}{
+		{
+			name: "other extension",
+			resp: []httphead.Option{
+				httphead.NewOption("foo", nil),
+			},
+		},
+		{
+			name: "accept",
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, nil),
+			},
+			expOK: true,
+		},
+		{
+			name: "accept with parameters",
+			params: Parameters{
+				ServerNoContextTakeover: true,
+				ServerMaxWindowBits:     12,
+				ClientMaxWindowBits:     1,
+			},
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					serverNoContextTakeover: "",
+					serverMaxWindowBits:     "10",
+					clientMaxWindowBits:     "15",
+					clientNoContextTakeover: "",
+				}),
+			},
+			exp: Parameters{
+				ServerNoContextTakeover: true,
+				ClientNoContextTakeover: true,
+				ServerMaxWindowBits:     10,
+				ClientMaxWindowBits:     15,
+			},
+			expOK: true,
+		},
+		{
+			name: "accept fallback",
+			params: Parameters{
+				ServerMaxWindowBits: 10,
+			},
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, nil),
+			},
+			expOK: true,
+		},
+		{
+			name: "greater server window",
+			params: Parameters{
+				ServerMaxWindowBits: 10,
+			},
+			noFallback: true,
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					serverMaxWindowBits: "11",
+				}),
+			},
+			expErr: true,
+		},
+		{
+			name: "greater client window",
+			params: Parameters{
+				ClientMaxWindowBits: 10,
+			},
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					clientMaxWindowBits: "11",
+				}),
+			},
+			expErr: true,
+		},
+		{
+			name: "client window not offered",
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					clientMaxWindowBits: "10",
+				}),
+			},
+			expErr: true,
+		},
+		{
+			name: "client window without value",
+			params: Parameters{
+				ClientMaxWindowBits: 1,
+			},
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					clientMaxWindowBits: "",
+				}),
+			},
+			expErr: true,
+		},
+		{
+			name: "unknown parameter",
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, map[string]string{
+					"foo": "bar",
+				}),
+			},
+			expErr: true,
+		},
+		{
+			name: "duplicate",
+			resp: []httphead.Option{
+				httphead.NewOption(ExtensionName, nil),
+				httphead.NewOption(ExtensionName, nil),
+			},
+			expErr: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ext := ClientExtension{
+				Parameters: test.params,
+				NoFallback: test.noFallback,
+			}
+			ext.Offers()
+
+			var err error
+			for _, opt := range test.resp {
+				if err = ext.Negotiate(opt); err != nil {
+					break
+				}
+			}
+			if act, exp := err != nil, test.expErr; act != exp {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if err != nil {
+				return
+			}
+			params, ok := ext.Accepted()
+			if ok != test.expOK {
+				t.Fatalf("unexpected accepted flag: %t; want %t", ok, test.expOK)
+			}
+			if params != test.exp {
+				t.Errorf("unexpected parameters: %+v; want %+v", params, test.exp)
+			}
+		})
+	}
+}
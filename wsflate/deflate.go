@@ -0,0 +1,388 @@
+package wsflate
+
+import (
+	"io"
+)
+
+const (
+	minMatchLength = 3
+	maxMatchLength = 258
+	maxMatchChain  = 64
+
+	maxStoredBlockSize = 65535
+	endOfBlock         = 256
+)
+
+var (
+	lengthBase = [29]uint16{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 13, 15, 17, 19, 23, 27, 31,
+		35, 43, 51, 59, 67, 83, 99, 115, 131, 163, 195, 227, 258,
+	}
+	lengthExtra = [29]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 1, 1, 1, 1, 2, 2, 2, 2,
+		3, 3, 3, 3, 4, 4, 4, 4, 5, 5, 5, 5, 0,
+	}
+	distBase = [30]uint16{
+		1, 2, 3, 4, 5, 7, 9, 13, 17, 25, 33, 49, 65, 97, 129, 193,
+		257, 385, 513, 769, 1025, 1537, 2049, 3073, 4097, 6145,
+		8193, 12289, 16385, 24577,
+	}
+	distExtra = [30]uint8{
+		0, 0, 0, 0, 1, 1, 2, 2, 3, 3, 4, 4, 5, 5, 6, 6,
+		7, 7, 8, 8, 9, 9, 10, 10, 11, 11, 12, 12, 13, 13,
+	}
+)
+
+// fixedLiteral holds bit-reversed codes and their sizes of the fixed Huffman
+// literal/length alphabet (see RFC 1951 section 3.2.6).
+var fixedLiteral [288]struct {
+	code uint16
+	size uint8
+}
+
+// lengthCode maps match length minus minMatchLength to the index in the
+// lengthBase table.
+var lengthCode [maxMatchLength - minMatchLength + 1]uint8
+
+func init() {
+	for i := range fixedLiteral {
+		var (
+			code int
+			size uint8
+		)
+		switch {
+		case i < 144:
+			code, size = 0x30+i, 8
+		case i < 256:
+			code, size = 0x190+i-144, 9
+		case i < 280:
+			code, size = i-256, 7
+		default:
+			code, size = 0xc0+i-280, 8
+		}
+		fixedLiteral[i].code = reverseBits(uint16(code), size)
+		fixedLiteral[i].size = size
+	}
+	for i := range lengthBase {
+		n := maxMatchLength + 1
+		if i+1 < len(lengthBase) {
+			n = int(lengthBase[i+1])
+		}
+		for x := int(lengthBase[i]); x < n; x++ {
+			lengthCode[x-minMatchLength] = uint8(i)
+		}
+	}
+}
+
+func reverseBits(x uint16, n uint8) (r uint16) {
+	for i := uint8(0); i < n; i++ {
+		r = r<<1 | x&1
+		x >>= 1
+	}
+	return r
+}
+
+func distCode(d int) int {
+	i := len(distBase) - 1
+	for int(distBase[i]) > d {
+		i--
+	}
+	return i
+}
+
+// token is either a literal byte (if length is zero) or a back-reference.
+type token struct {
+	length uint16
+	dist   uint16
+	lit    byte
+}
+
+// windowCompressor is a Compressor producing deflate stream with
+// back-references limited by the window size.
+//
+// It buffers at most window bytes of input and, if context takeover is
+// enabled, keeps at most window bytes of history.
+type windowCompressor struct {
+	dst      io.Writer
+	window   int
+	takeover bool
+
+	// buf holds the history (first hist bytes) followed by the pending input.
+	buf  []byte
+	hist int
+	// preset is the preset dictionary the history starts with.
+	preset []byte
+
+	hashShift uint
+	head      []int32
+	prev      []int32
+	tokens    []token
+	bw        bitWriter
+}
+
+// NewCompressor returns a Compressor which limits the LZ77 window to the
+// given number of bits. If bits are not defined, the maximum window size is
+// used.
+//
+// If takeover is true, then compressor keeps the last window bytes of the
+// previous message after Reset() and uses them to compress the next message.
+// That is, takeover must be false if the "no_context_takeover" parameter was
+// negotiated for the compressing side.
+//
+// Note that compressor encodes blocks with fixed Huffman codes (or stores
+// them as is), so its compression ratio is generally worse than compress/flate
+// one. It is intended to be used when peer negotiated window smaller than the
+// maximum.
+func NewCompressor(w io.Writer, bits WindowBits, takeover bool) Compressor {
+	return NewCompressorDict(w, bits, takeover, nil)
+}
+
+// NewCompressorDict is like NewCompressor but primes the compressor with the
+// preset dictionary dict. Without context takeover dictionary is used for
+// each message; otherwise only for the first one.
+func NewCompressorDict(w io.Writer, bits WindowBits, takeover bool, dict []byte) Compressor {
+	if !isValidBits(int(bits)) {
+		bits = 15
+	}
+	window := bits.Bytes()
+	c := &windowCompressor{
+		window:    window,
+		takeover:  takeover,
+		buf:       make([]byte, 0, 2*window),
+		hashShift: 32 - uint(bits),
+		head:      make([]int32, window),
+		prev:      make([]int32, 0, 2*window),
+		preset:    lastBytes(dict, window),
+	}
+	c.buf = append(c.buf, c.preset...)
+	c.hist = len(c.preset)
+	c.Reset(w)
+	return c
+}
+
+// Reset implements WriteResetter.
+// Any not flushed data is lost. The history is kept only if context
+// takeover is enabled.
+func (c *windowCompressor) Reset(w io.Writer) {
+	c.dst = w
+	c.bw.reset(w)
+	c.buf = c.buf[:c.hist]
+	if !c.takeover {
+		c.buf = append(c.buf[:0], c.preset...)
+		c.hist = len(c.preset)
+	}
+}
+
+// Write implements io.Writer.
+func (c *windowCompressor) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		free := c.hist + c.window - len(c.buf)
+		if free == 0 {
+			if err = c.compress(); err != nil {
+				return n, err
+			}
+			continue
+		}
+		m := min(free, len(p))
+		c.buf = append(c.buf, p[:m]...)
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Flush compresses pending data and writes it to the underlying writer
+// followed by the sync marker.
+func (c *windowCompressor) Flush() error {
+	if err := c.compress(); err != nil {
+		return err
+	}
+	// Empty non-final stored block.
+	c.bw.writeBits(0, 3)
+	c.bw.align()
+	c.bw.writeBytes(compressionTail[:])
+	return c.bw.flush()
+}
+
+func (c *windowCompressor) compress() error {
+	if len(c.buf) == c.hist {
+		return nil
+	}
+	c.tokenize()
+	c.writeBlock()
+	if n := len(c.buf); n > c.window {
+		copy(c.buf, c.buf[n-c.window:])
+		c.buf = c.buf[:c.window]
+	}
+	c.hist = len(c.buf)
+	return c.bw.flush()
+}
+
+func (c *windowCompressor) hash(p []byte) uint32 {
+	x := uint32(p[0])<<16 | uint32(p[1])<<8 | uint32(p[2])
+	return (x * 2654435761) >> c.hashShift
+}
+
+func (c *windowCompressor) insert(i int) {
+	if i+minMatchLength > len(c.buf) {
+		return
+	}
+	h := c.hash(c.buf[i:])
+	c.prev[i] = c.head[h]
+	c.head[h] = int32(i)
+}
+
+// tokenize fills tokens for the pending input.
+func (c *windowCompressor) tokenize() {
+	for i := range c.head {
+		c.head[i] = -1
+	}
+	c.prev = c.prev[:len(c.buf)]
+	c.tokens = c.tokens[:0]
+	for i := 0; i < c.hist; i++ {
+		c.insert(i)
+	}
+	for i := c.hist; i < len(c.buf); {
+		length, dist := c.match(i)
+		if length < minMatchLength {
+			c.tokens = append(c.tokens, token{lit: c.buf[i]})
+			c.insert(i)
+			i++
+			continue
+		}
+		c.tokens = append(c.tokens, token{
+			length: uint16(length),
+			dist:   uint16(dist),
+		})
+		for end := i + length; i < end; i++ {
+			c.insert(i)
+		}
+	}
+}
+
+// match returns the longest match for the data at position i, which starts
+// no further than window bytes back.
+func (c *windowCompressor) match(i int) (length, dist int) {
+	p := c.buf[i:]
+	if len(p) < minMatchLength {
+		return 0, 0
+	}
+	if len(p) > maxMatchLength {
+		p = p[:maxMatchLength]
+	}
+	j := int(c.head[c.hash(p)])
+	for chain := maxMatchChain; chain > 0 && j >= 0 && i-j <= c.window; chain-- {
+		var n int
+		for q := c.buf[j:]; n < len(p) && q[n] == p[n]; {
+			n++
+		}
+		if n > length {
+			length, dist = n, i-j
+			if n == len(p) {
+				break
+			}
+		}
+		j = int(c.prev[j])
+	}
+	return length, dist
+}
+
+func (c *windowCompressor) writeBlock() {
+	pending := c.buf[c.hist:]
+
+	fixed := 3 + uint(fixedLiteral[endOfBlock].size)
+	for _, t := range c.tokens {
+		if t.length == 0 {
+			fixed += uint(fixedLiteral[t.lit].size)
+			continue
+		}
+		lc := lengthCode[t.length-minMatchLength]
+		dc := distCode(int(t.dist))
+		fixed += uint(fixedLiteral[257+int(lc)].size) + uint(lengthExtra[lc]) +
+			5 + uint(distExtra[dc])
+	}
+	// Stored block requires alignment of up to 7 bits, four bytes of LEN and
+	// NLEN fields and the data itself.
+	stored := 3 + 7 + 32 + 8*uint(len(pending))
+	if len(pending) <= maxStoredBlockSize && stored < fixed {
+		c.bw.writeBits(0, 3)
+		c.bw.align()
+		n := len(pending)
+		c.bw.writeBytes([]byte{
+			byte(n), byte(n >> 8),
+			^byte(n), ^byte(n >> 8),
+		})
+		c.bw.writeBytes(pending)
+		return
+	}
+
+	// Non-final block with fixed Huffman codes.
+	c.bw.writeBits(2, 3)
+	for _, t := range c.tokens {
+		if t.length == 0 {
+			c.writeLiteral(int(t.lit))
+			continue
+		}
+		lc := int(lengthCode[t.length-minMatchLength])
+		c.writeLiteral(257 + lc)
+		c.bw.writeBits(uint64(t.length-lengthBase[lc]), uint(lengthExtra[lc]))
+
+		dc := distCode(int(t.dist))
+		c.bw.writeBits(uint64(reverseBits(uint16(dc), 5)), 5)
+		c.bw.writeBits(uint64(t.dist-distBase[dc]), uint(distExtra[dc]))
+	}
+	c.writeLiteral(endOfBlock)
+}
+
+func (c *windowCompressor) writeLiteral(x int) {
+	c.bw.writeBits(uint64(fixedLiteral[x].code), uint(fixedLiteral[x].size))
+}
+
+// bitWriter writes bits in deflate order (least significant bit first).
+type bitWriter struct {
+	w     io.Writer
+	bits  uint64
+	nbits uint
+	buf   []byte
+	err   error
+}
+
+func (b *bitWriter) reset(w io.Writer) {
+	b.w = w
+	b.bits = 0
+	b.nbits = 0
+	b.buf = b.buf[:0]
+	b.err = nil
+}
+
+func (b *bitWriter) writeBits(x uint64, n uint) {
+	b.bits |= x << b.nbits
+	b.nbits += n
+	for b.nbits >= 8 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits >>= 8
+		b.nbits -= 8
+	}
+}
+
+func (b *bitWriter) align() {
+	if b.nbits > 0 {
+		b.buf = append(b.buf, byte(b.bits))
+		b.bits = 0
+		b.nbits = 0
+	}
+}
+
+func (b *bitWriter) writeBytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+// flush writes buffered bytes to the underlying writer. Bits which do not
+// form a whole byte stay buffered.
+func (b *bitWriter) flush() error {
+	if b.err == nil && len(b.buf) > 0 {
+		_, b.err = b.w.Write(b.buf)
+	}
+	b.buf = b.buf[:0]
+	return b.err
+}
@@ -0,0 +1,102 @@
+package wsflate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	random := make([]byte, 100000)
+	rand.New(rand.NewSource(42)).Read(random)
+	text := bytes.Repeat([]byte("hello, deflate window! "), 5000)
+
+	for _, bits := range []WindowBits{0, 8, 9, 12, 15} {
+		for _, takeover := range []bool{false, true} {
+			name := fmt.Sprintf("bits=%d/takeover=%t", bits, takeover)
+			t.Run(name, func(t *testing.T) {
+				messages := [][]byte{
+					nil,
+					[]byte("a"),
+					text[:1000],
+					text,
+					random,
+					append(text[:3000:3000], random[:3000]...),
+				}
+				var buf bytes.Buffer
+				w := NewWriter(nil, func(w io.Writer) Compressor {
+					return NewCompressor(w, bits, takeover)
+				})
+				r := NewReader(nil, func(r io.Reader) Decompressor {
+					return NewDecompressor(r, bits, takeover)
+				})
+				for i, msg := range messages {
+					buf.Reset()
+					w.Reset(&buf)
+					// Write in pieces to check buffering.
+					for p := msg; len(p) > 0; {
+						n := min(len(p), 777)
+						if _, err := w.Write(p[:n]); err != nil {
+							t.Fatalf("#%d: Write() error: %v", i, err)
+						}
+						p = p[n:]
+					}
+					if err := w.Flush(); err != nil {
+						t.Fatalf("#%d: Flush() error: %v", i, err)
+					}
+					r.Reset(&buf)
+					act, err := ioutil.ReadAll(r)
+					if err != nil {
+						t.Fatalf("#%d: read error: %v", i, err)
+					}
+					if !bytes.Equal(act, msg) {
+						t.Fatalf("#%d: unexpected message of %d bytes; want %d", i, len(act), len(msg))
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestCompressorWindow(t *testing.T) {
+	for _, bits := range []WindowBits{8, 10, 15} {
+		t.Run(fmt.Sprintf("bits=%d", bits), func(t *testing.T) {
+			window := bits.Bytes()
+
+			// Data repeats with the period slightly greater than window, thus
+			// compressor must not refer the previous period.
+			period := make([]byte, window+10)
+			rand.New(rand.NewSource(42)).Read(period)
+			// Data which repeats within the window.
+			short := bytes.Repeat([]byte("abcdef"), window/6)
+
+			c := NewCompressor(ioutil.Discard, bits, true).(*windowCompressor)
+			var matched bool
+			for i := 0; i < 8; i++ {
+				for _, p := range [][]byte{period, short} {
+					if _, err := c.Write(p); err != nil {
+						t.Fatal(err)
+					}
+					if err := c.compress(); err != nil {
+						t.Fatal(err)
+					}
+					for _, tok := range c.tokens {
+						if int(tok.dist) > window {
+							t.Fatalf("back-reference distance %d exceeds window %d", tok.dist, window)
+						}
+						matched = matched || tok.length > 0
+					}
+					if len(c.buf) > window {
+						t.Fatalf("history size %d exceeds window %d", len(c.buf), window)
+					}
+				}
+			}
+			if !matched {
+				t.Fatalf("no back-references were made")
+			}
+		})
+	}
+}
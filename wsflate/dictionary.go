@@ -0,0 +1,123 @@
+package wsflate
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/gobwas/httphead"
+)
+
+// ErrUnknownDictionary is returned when negotiated preset dictionary is not
+// registered.
+var ErrUnknownDictionary = errors.New("wsflate: unknown preset dictionary")
+
+// Dictionary is a preset dictionary used to prime compression contexts of
+// both peers. It is negotiated by its ID with the private "x_dictionary_id"
+// extension parameter.
+//
+// Dictionaries are most useful for small messages with repetitive content
+// (such as JSON with a fixed schema) sent without context takeover.
+type Dictionary struct {
+	// ID identifies the dictionary. It must be a valid HTTP token.
+	ID string
+
+	// Data is the content of the dictionary. Only its last MaxLZ77WindowSize
+	// bytes (or less, if smaller window is negotiated) are used.
+	Data []byte
+}
+
+// Dictionaries is a registry of preset dictionaries per subprotocol.
+// Dictionaries of connections without subprotocol are registered with empty
+// protocol name.
+//
+// It is safe to use Dictionaries from multiple goroutines concurrently.
+type Dictionaries struct {
+	mu sync.RWMutex
+	m  map[string][]Dictionary
+}
+
+// Register registers dictionary for the given subprotocol. Dictionaries
+// registered first are more preferred when making client offers. If
+// dictionary with the same ID is already registered for the protocol, it is
+// replaced.
+//
+// It panics if dictionary ID is not a valid HTTP token.
+func (d *Dictionaries) Register(protocol string, dict Dictionary) {
+	if !isToken(dict.ID) {
+		panic(fmt.Sprintf("wsflate: invalid dictionary id: %q", dict.ID))
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.m == nil {
+		d.m = make(map[string][]Dictionary)
+	}
+	ds := d.m[protocol]
+	for i := range ds {
+		if ds[i].ID == dict.ID {
+			ds[i] = dict
+			return
+		}
+	}
+	d.m[protocol] = append(ds, dict)
+}
+
+// Lookup returns the data of dictionary registered for the given subprotocol
+// with the given ID.
+func (d *Dictionaries) Lookup(protocol, id string) ([]byte, bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, dict := range d.m[protocol] {
+		if dict.ID == id {
+			return dict.Data, true
+		}
+	}
+	return nil, false
+}
+
+// registered reports whether dictionary with the given ID is registered for
+// any subprotocol.
+func (d *Dictionaries) registered(id string) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	for _, ds := range d.m {
+		for _, dict := range ds {
+			if dict.ID == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Preferred returns the first dictionary registered for the given
+// subprotocol.
+func (d *Dictionaries) Preferred(protocol string) (_ Dictionary, ok bool) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	ds := d.m[protocol]
+	if len(ds) == 0 {
+		return Dictionary{}, false
+	}
+	return ds[0], true
+}
+
+func isToken(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !httphead.OctetTypes[s[i]].IsToken() {
+			return false
+		}
+	}
+	return true
+}
+
+// lastBytes returns at most n last bytes of p.
+func lastBytes(p []byte, n int) []byte {
+	if len(p) > n {
+		return p[len(p)-n:]
+	}
+	return p
+}
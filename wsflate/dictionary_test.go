@@ -0,0 +1,185 @@
+package wsflate
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+)
+
+func TestDictionaries(t *testing.T) {
+	var d Dictionaries
+	if _, ok := d.Preferred(""); ok {
+		t.Fatalf("empty registry has preferred dictionary")
+	}
+	d.Register("chat", Dictionary{ID: "a", Data: []byte("a")})
+	d.Register("chat", Dictionary{ID: "b", Data: []byte("b")})
+	d.Register("chat", Dictionary{ID: "a", Data: []byte("aa")})
+	d.Register("", Dictionary{ID: "c", Data: []byte("c")})
+
+	if p, ok := d.Preferred("chat"); !ok || p.ID != "a" {
+		t.Errorf("unexpected preferred dictionary: %+v", p)
+	}
+	for _, test := range []struct {
+		protocol string
+		id       string
+		exp      string
+		ok       bool
+	}{
+		{"chat", "a", "aa", true},
+		{"chat", "b", "b", true},
+		{"chat", "c", "", false},
+		{"", "c", "c", true},
+		{"other", "a", "", false},
+	} {
+		act, ok := d.Lookup(test.protocol, test.id)
+		if ok != test.ok || string(act) != test.exp {
+			t.Errorf(
+				"Lookup(%q, %q) = %q, %t; want %q, %t",
+				test.protocol, test.id, act, ok, test.exp, test.ok,
+			)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("no panic on invalid dictionary id")
+		}
+	}()
+	d.Register("chat", Dictionary{ID: "a b"})
+}
+
+func TestDictionaryNegotiation(t *testing.T) {
+	var d Dictionaries
+	d.Register("json", Dictionary{ID: "v1", Data: []byte("{}")})
+	d.Register("xml", Dictionary{ID: "v2", Data: []byte("<>")})
+
+	for _, test := range []struct {
+		name       string
+		client     string // protocol of client.
+		server     string // protocol selected by server.
+		noFallback bool
+		exp        string
+		reject     bool
+	}{
+		{name: "match", client: "json", server: "json", exp: "v1"},
+		{name: "fallback", client: "json", server: "xml", exp: ""},
+		{name: "none", client: "csv", server: "json", exp: ""},
+		{name: "reject", client: "json", server: "xml", noFallback: true, reject: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := ClientExtension{
+				Dictionaries: &d,
+				Protocol:     test.client,
+				NoFallback:   test.noFallback,
+			}
+			s := Extension{
+				Dictionaries: &d,
+			}
+			var accepted []httphead.Option
+			for _, offer := range c.Offers() {
+				resp, err := s.Negotiate(offer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Size() > 0 {
+					accepted = append(accepted, resp)
+				}
+			}
+			accepted, err := s.Negotiated(test.server, accepted)
+			if test.reject {
+				if _, ok := err.(*ws.ConnectionRejectedError); !ok {
+					t.Fatalf("unexpected error: %v; want rejection", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(accepted) != 1 {
+				t.Fatalf("unexpected accepted options: %v", accepted)
+			}
+			if err := c.Negotiate(accepted[0]); err != nil {
+				t.Fatal(err)
+			}
+			cp, _ := c.Accepted()
+			sp, _ := s.Accepted()
+			if cp.DictionaryID != test.exp || sp.DictionaryID != test.exp {
+				t.Errorf(
+					"unexpected dictionary ids: client %q, server %q; want %q",
+					cp.DictionaryID, sp.DictionaryID, test.exp,
+				)
+			}
+		})
+	}
+}
+
+func TestDictionaryResponse(t *testing.T) {
+	c := ClientExtension{
+		Parameters: Parameters{DictionaryID: "v1"},
+		NoFallback: true,
+	}
+	c.Offers()
+	err := c.Negotiate(Parameters{DictionaryID: "v2"}.Option())
+	if err == nil {
+		t.Errorf("no error for response with other dictionary")
+	}
+}
+
+func TestCompressorDict(t *testing.T) {
+	dict := []byte(`{"type":"event","payload":{"id":0,"name":""}}`)
+	msg := []byte(`{"type":"event","payload":{"id":42,"name":"gopher"}}`)
+
+	for _, bits := range []WindowBits{9, 15} {
+		var buf bytes.Buffer
+		c := NewCompressorDict(&buf, bits, false, dict)
+		d := NewDecompressorDict(nil, bits, false, dict)
+		for i := 0; i < 2; i++ {
+			buf.Reset()
+			c.(WriteResetter).Reset(&buf)
+			if _, err := c.Write(msg); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			if n := buf.Len(); n >= len(msg)/2 {
+				t.Errorf("bits=%d: message is not compressed with dictionary: %d bytes", bits, n)
+			}
+			d.(ReadResetter).Reset(&buf)
+			act, err := ioutil.ReadAll(d)
+			if err != nil && err != io.ErrUnexpectedEOF {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(act, msg) {
+				t.Errorf("bits=%d: unexpected message: %q", bits, act)
+			}
+		}
+	}
+}
+
+func TestDecompressorDictContextTakeover(t *testing.T) {
+	dict := []byte("hello, dictionary!")
+	var buf bytes.Buffer
+	c := NewCompressorDict(&buf, 15, true, dict)
+	d := NewDecompressorDict(nil, 15, true, dict)
+	for _, msg := range []string{"hello, dictionary!", "hello, again!"} {
+		buf.Reset()
+		c.(WriteResetter).Reset(&buf)
+		c.Write([]byte(msg))
+		if err := c.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		d.(ReadResetter).Reset(&buf)
+		act, err := ioutil.ReadAll(d)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if string(act) != msg {
+			t.Errorf("unexpected message: %q; want %q", act, msg)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package wsflate
 
 import (
 	"bytes"
+	"net/http"
 
 	"github.com/gobwas/httphead"
 	"github.com/gobwas/ws"
@@ -16,8 +17,26 @@ type Extension struct {
 	// accept.
 	Parameters Parameters
 
+	// Pool is an optional pool of compression contexts. If it is non-nil and
+	// its memory budget is exceeded, then no context takeover is negotiated
+	// for both sides regardless of Parameters.
+	Pool *Pool
+
+	// Dictionaries is an optional registry of preset dictionaries. Offers
+	// with the dictionary parameter are accepted by Negotiate() only if the
+	// dictionary with offered ID is registered for some subprotocol. Since
+	// subprotocol might be not selected yet at that moment, Negotiated()
+	// must be used to check the dictionary against the selected one.
+	Dictionaries *Dictionaries
+
 	accepted bool
 	params   Parameters
+
+	// fallback is the first acceptable offer without dictionary received
+	// after the offer with dictionary was accepted.
+	fallback       Parameters
+	fallbackAccept Parameters
+	hasFallback    bool
 }
 
 // Negotiate parses given HTTP header option and returns (if any) header option
@@ -29,27 +48,81 @@ func (n *Extension) Negotiate(opt httphead.Option) (accept httphead.Option, err
 	if !bytes.Equal(opt.Name, ExtensionNameBytes) {
 		return accept, nil
 	}
-	if n.accepted {
+	if n.accepted && (n.params.DictionaryID == "" || n.hasFallback) {
 		// Negotiate might be called multiple times during upgrade.
 		// We stick to first one accepted extension since they must be passed
 		// in ordered by preference.
 		return accept, nil
 	}
-
-	want := n.Parameters
-
+	var params Parameters
 	// NOTE: Parse() resets params inside, so no worries.
-	if err := n.params.Parse(opt); err != nil {
+	if err := params.Parse(opt); err != nil {
 		return accept, err
 	}
+	want, ok := n.accept(&params)
+	switch {
+	case !ok:
+		return accept, nil
+
+	case n.accepted:
+		// Offer with dictionary was accepted already. Remember this one to
+		// fall back to if the dictionary is not registered for the selected
+		// subprotocol.
+		if params.DictionaryID == "" {
+			n.fallback = params
+			n.fallbackAccept = want
+			n.hasFallback = true
+		}
+		return accept, nil
+	}
+	n.accepted = true
+	n.params = params
+	return want.Option(), nil
+}
+
+// Negotiated checks the dictionary of accepted offer against the selected
+// subprotocol. Its signature matches ws.Upgrader.OnNegotiated field.
+//
+// If dictionary is not registered for the subprotocol, accepted offer is
+// replaced by the next acceptable offer without dictionary, if any.
+// Otherwise the upgrade is rejected with 400 Bad Request status.
+func (n *Extension) Negotiated(protocol string, accepted []httphead.Option) ([]httphead.Option, error) {
+	id := n.params.DictionaryID
+	if !n.accepted || id == "" {
+		return accepted, nil
+	}
+	if n.Dictionaries != nil {
+		if _, ok := n.Dictionaries.Lookup(protocol, id); ok {
+			return accepted, nil
+		}
+	}
+	if !n.hasFallback {
+		return accepted, ws.RejectConnectionError(
+			ws.RejectionStatus(http.StatusBadRequest),
+			ws.RejectionReason(ErrUnknownDictionary.Error()),
+		)
+	}
+	n.params = n.fallback
+	for i, opt := range accepted {
+		if bytes.Equal(opt.Name, ExtensionNameBytes) {
+			accepted[i] = n.fallbackAccept.Option()
+		}
+	}
+	return accepted, nil
+}
+
+// accept checks offered parameters and returns parameters of the response.
+// It may modify offered parameters to reflect the response.
+func (n *Extension) accept(offer *Parameters) (want Parameters, ok bool) {
+	want = n.Parameters
 	{
-		offer := n.params.ServerMaxWindowBits
+		offer := offer.ServerMaxWindowBits
 		want := want.ServerMaxWindowBits
 		if offer > want {
 			// A server declines an extension negotiation offer
 			// with this parameter if the server doesn't support
 			// it.
-			return accept, nil
+			return Parameters{}, false
 		}
 	}
 	{
@@ -57,23 +130,36 @@ func (n *Extension) Negotiate(opt httphead.Option) (accept httphead.Option, err
 		// "client_max_window_bits" extension parameter, the server MAY
 		// include the "client_max_window_bits" extension parameter in the
 		// corresponding extension negotiation response to the offer.
-		offer := n.params.ClientMaxWindowBits
+		offer := offer.ClientMaxWindowBits
 		want := want.ClientMaxWindowBits
 		if want > offer {
-			return accept, nil
+			return Parameters{}, false
 		}
 	}
 	{
-		offer := n.params.ServerNoContextTakeover
+		offer := offer.ServerNoContextTakeover
 		want := want.ServerNoContextTakeover
 		if offer && !want {
-			return accept, nil
+			return Parameters{}, false
 		}
 	}
+	if id := offer.DictionaryID; id != "" {
+		if n.Dictionaries == nil || !n.Dictionaries.registered(id) {
+			return Parameters{}, false
+		}
+	}
+	// Dictionary is used only if it was offered.
+	want.DictionaryID = offer.DictionaryID
 
-	n.accepted = true
-
-	return want.Option(), nil
+	if n.Pool != nil && n.Pool.Exceeded() {
+		// A server may include both "no_context_takeover" parameters in the
+		// response even if they were not offered.
+		want.ServerNoContextTakeover = true
+		want.ClientNoContextTakeover = true
+		offer.ServerNoContextTakeover = true
+		offer.ClientNoContextTakeover = true
+	}
+	return want, true
 }
 
 // Accepted returns parameters parsed during last negotiation and a flag that
@@ -82,10 +168,18 @@ func (n *Extension) Accepted() (_ Parameters, accepted bool) {
 	return n.params, n.accepted
 }
 
+// Rsv implements ws.RsvExtension. permessage-deflate uses the RSV1 bit.
+func (n *Extension) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
 // Reset resets extension for further reuse.
 func (n *Extension) Reset() {
 	n.accepted = false
 	n.params = Parameters{}
+	n.fallback = Parameters{}
+	n.fallbackAccept = Parameters{}
+	n.hasFallback = false
 }
 
 var ErrUnexpectedCompressionBit = ws.ProtocolError(
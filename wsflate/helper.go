@@ -72,6 +72,10 @@ func DecompressFrameBuffer(buf Buffer, f ws.Frame) (ws.Frame, error) {
 type Helper struct {
 	Compressor   func(w io.Writer) Compressor
 	Decompressor func(r io.Reader) Decompressor
+
+	// Limits contains limits applied to the decompressed data. Exceeding
+	// them makes decompression methods return *LimitError.
+	Limits Limits
 }
 
 // Buffer is an interface representing some bytes buffering object.
@@ -185,6 +189,7 @@ func (h *Helper) CompressTo(w io.Writer, p []byte) (err error) {
 // Returned bytes are bytes returned by buf.Bytes().
 func (h *Helper) DecompressTo(w io.Writer, p []byte) (err error) {
 	fr := NewReader(bytes.NewReader(p), h.Decompressor)
+	fr.SetLimits(h.Limits)
 	if _, err = io.Copy(w, fr); err != nil {
 		return err
 	}
@@ -0,0 +1,133 @@
+package wsflate
+
+import (
+	"compress/flate"
+	"io"
+)
+
+// inflater is a Decompressor that keeps the history of decompressed bytes
+// between messages if peer uses context takeover.
+type inflater struct {
+	fr   io.ReadCloser
+	hist history
+}
+
+// NewDecompressor returns a Decompressor for data compressed with the LZ77
+// window of the given number of bits. If bits are not defined, the maximum
+// window size is assumed.
+//
+// If takeover is true, then decompressor keeps the last window bytes of
+// decompressed data and uses them as the preset dictionary after Reset().
+// Note that compress/flate reader used under the hood allocates the maximum
+// window regardless of bits; only the kept history is sized to the window.
+// That is, takeover must be false if the "no_context_takeover" parameter was
+// negotiated for the compressing side.
+func NewDecompressor(r io.Reader, bits WindowBits, takeover bool) Decompressor {
+	return NewDecompressorDict(r, bits, takeover, nil)
+}
+
+// NewDecompressorDict is like NewDecompressor but primes the decompressor
+// with the preset dictionary dict. Without context takeover dictionary is
+// used for each message; otherwise only for the first one.
+func NewDecompressorDict(r io.Reader, bits WindowBits, takeover bool, dict []byte) Decompressor {
+	dict = lastBytes(dict, MaxLZ77WindowSize)
+	d := &inflater{
+		fr: flate.NewReaderDict(r, dict),
+	}
+	d.hist.preset = dict
+	if takeover {
+		d.hist.init(bits)
+		d.hist.remember(dict)
+	}
+	return d
+}
+
+// Read implements io.Reader.
+func (d *inflater) Read(p []byte) (n int, err error) {
+	n, err = d.fr.Read(p)
+	d.hist.remember(p[:n])
+	return n, err
+}
+
+// Reset implements ReadResetter.
+func (d *inflater) Reset(r io.Reader) {
+	d.fr.(flate.Resetter).Reset(r, d.hist.dict())
+}
+
+// Close implements io.Closer.
+func (d *inflater) Close() error {
+	return d.fr.Close()
+}
+
+// history holds the last window bytes of decompressed data in a ring
+// buffer of window bytes. Zero value holds nothing.
+type history struct {
+	window int
+	buf    []byte
+	pos    int  // Position of the next byte written to buf.
+	full   bool // Whether buf was wrapped around.
+	// preset is the preset dictionary used when no history is kept.
+	preset []byte
+}
+
+func (h *history) init(bits WindowBits) {
+	h.window = MaxLZ77WindowSize
+	if isValidBits(int(bits)) {
+		h.window = bits.Bytes()
+	}
+	h.buf = make([]byte, h.window)
+	h.pos = 0
+	h.full = false
+}
+
+// size returns the number of bytes held by h.
+func (h *history) size() int64 {
+	return int64(cap(h.buf))
+}
+
+// dict returns the last window bytes of decompressed data or the preset
+// dictionary if no history is kept. Returned slice is valid until the next
+// remember() call.
+func (h *history) dict() []byte {
+	if h.window == 0 {
+		return h.preset
+	}
+	if !h.full {
+		return h.buf[:h.pos]
+	}
+	if h.pos != 0 {
+		// Make the oldest byte the first one by rotating buf in place.
+		reverse(h.buf[:h.pos])
+		reverse(h.buf[h.pos:])
+		reverse(h.buf)
+		h.pos = 0
+	}
+	return h.buf
+}
+
+func (h *history) remember(p []byte) {
+	if h.window == 0 || len(p) == 0 {
+		return
+	}
+	if len(p) >= h.window {
+		copy(h.buf, p[len(p)-h.window:])
+		h.pos = 0
+		h.full = true
+		return
+	}
+	n := copy(h.buf[h.pos:], p)
+	if n < len(p) {
+		copy(h.buf, p[n:])
+	}
+	h.pos += len(p)
+	if h.pos >= h.window {
+		h.pos -= h.window
+		h.full = true
+	}
+}
+
+func reverse(p []byte) {
+	for i, j := 0, len(p)-1; i < j; i, j = i+1, j-1 {
+		p[i], p[j] = p[j], p[i]
+	}
+}
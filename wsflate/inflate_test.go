@@ -0,0 +1,113 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestDecompressorContextTakeover(t *testing.T) {
+	const bits = 9
+	var (
+		buf bytes.Buffer
+		in  []*bytes.Buffer
+	)
+	// Compress messages with the same compressor to make each message refer
+	// bytes of the previous one.
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	messages := [][]byte{
+		bytes.Repeat([]byte("x"), 2000),
+		[]byte("hello, context takeover!"),
+		[]byte("hello, context takeover! hello, context takeover!"),
+	}
+	for _, msg := range messages {
+		buf.Reset()
+		fw.Write(msg)
+		fw.Flush()
+		in = append(in, bytes.NewBuffer(append([]byte(nil), buf.Bytes()...)))
+	}
+
+	r := NewReader(nil, func(r io.Reader) Decompressor {
+		return NewDecompressor(r, bits, true)
+	})
+	for i, exp := range messages {
+		r.Reset(in[i])
+		act, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(act, exp) {
+			t.Errorf("#%d: unexpected message: %q; want %q", i, act, exp)
+		}
+		d := r.d.(*inflater)
+		if n, max := cap(d.hist.buf), WindowBits(bits).Bytes(); n > max {
+			t.Errorf("#%d: history buffer of %d bytes exceeds %d", i, n, max)
+		}
+	}
+}
+
+func TestHistoryRemember(t *testing.T) {
+	h := history{
+		window: 4,
+		buf:    make([]byte, 4),
+	}
+	var all []byte
+	for _, p := range []string{"a", "bcd", "efg", "hijklmn", "o", "pq", "r", "stu"} {
+		h.remember([]byte(p))
+		all = append(all, p...)
+		exp := all
+		if n := len(exp); n > h.window {
+			exp = exp[n-h.window:]
+		}
+		if act := h.dict(); !bytes.Equal(act, exp) {
+			t.Errorf("unexpected history after %q: %q; want %q", p, act, exp)
+		}
+		if cap(h.buf) != 4 {
+			t.Errorf("history buffer was reallocated")
+		}
+	}
+}
+
+func TestHistoryRememberRandom(t *testing.T) {
+	var h history
+	h.init(8)
+	var all []byte
+	for i := 0; i < 1000; i++ {
+		p := make([]byte, rand.Intn(2*h.window))
+		rand.Read(p)
+		h.remember(p)
+		all = append(all, p...)
+		if rand.Intn(2) == 0 {
+			// Do not rotate the buffer each time.
+			continue
+		}
+		exp := all
+		if n := len(exp); n > h.window {
+			exp = exp[n-h.window:]
+		}
+		if act := h.dict(); !bytes.Equal(act, exp) {
+			t.Fatalf("#%d: unexpected history", i)
+		}
+	}
+}
+
+func TestDecompressorHistorySize(t *testing.T) {
+	msg := bytes.Repeat([]byte("hello, window! "), 5000)
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	fw.Write(msg)
+	fw.Flush()
+
+	for bits := WindowBits(8); bits <= 15; bits++ {
+		d := NewDecompressor(bytes.NewReader(buf.Bytes()), bits, true).(*inflater)
+		if _, err := io.Copy(ioutil.Discard, d); err != nil && err != io.ErrUnexpectedEOF {
+			t.Fatal(err)
+		}
+		if act, exp := d.hist.size(), int64(bits.Bytes()); act != exp {
+			t.Errorf("bits %d: retained %d history bytes; want %d", bits, act, exp)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package wsflate
+
+import (
+	"fmt"
+
+	"github.com/gobwas/ws"
+)
+
+// Limits contains limits applied to the decompressed data of a message.
+// Zero value of any field means no limit.
+type Limits struct {
+	// MaxMessageSize is the maximum number of decompressed bytes of a
+	// message.
+	MaxMessageSize int64
+
+	// MaxRatio is the maximum ratio of decompressed bytes to compressed
+	// bytes of a message. It is checked against the number of compressed
+	// bytes consumed so far, that is, while data streams through.
+	//
+	// The ratio is not checked until MinRatioSize bytes of the message are
+	// decompressed, since the beginning of a benign message could have a
+	// much higher ratio than the whole message.
+	MaxRatio int64
+}
+
+// MinRatioSize is the number of decompressed bytes of a message after which
+// Limits.MaxRatio is checked.
+const MinRatioSize = 64 << 10
+
+// LimitError is returned when decompressed data of a message exceeds one of
+// the Limits. Connection should be closed with ws.StatusMessageTooBig status
+// code after receiving it.
+type LimitError struct {
+	// Ratio reports whether MaxRatio limit was exceeded. Otherwise
+	// MaxMessageSize limit was exceeded.
+	Ratio bool
+
+	// Limit is the value of exceeded limit.
+	Limit int64
+
+	// Size and Compressed are the numbers of decompressed and compressed
+	// bytes of the message read when the limit was exceeded.
+	Size       int64
+	Compressed int64
+}
+
+// Error implements error interface.
+func (e *LimitError) Error() string {
+	if e.Ratio {
+		return fmt.Sprintf(
+			"wsflate: decompression ratio exceeds %d: %d bytes from %d compressed bytes",
+			e.Limit, e.Size, e.Compressed,
+		)
+	}
+	return fmt.Sprintf(
+		"wsflate: decompressed message exceeds %d bytes",
+		e.Limit,
+	)
+}
+
+// StatusCode returns WebSocket close status code appropriate for the error.
+func (e *LimitError) StatusCode() ws.StatusCode {
+	return ws.StatusMessageTooBig
+}
+
+// check returns non-nil error if size decompressed bytes read from compressed
+// ones exceed the limits.
+func (l Limits) check(size, compressed int64) error {
+	if l.MaxMessageSize > 0 && size > l.MaxMessageSize {
+		return &LimitError{
+			Limit:      l.MaxMessageSize,
+			Size:       size,
+			Compressed: compressed,
+		}
+	}
+	if l.MaxRatio > 0 && size > MinRatioSize && size > l.MaxRatio*compressed {
+		return &LimitError{
+			Ratio:      true,
+			Limit:      l.MaxRatio,
+			Size:       size,
+			Compressed: compressed,
+		}
+	}
+	return nil
+}
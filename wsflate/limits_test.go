@@ -0,0 +1,138 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+func TestReaderLimits(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 1<<20)
+	compressed := compress(data)
+	ratio := int64(len(data) / len(compressed))
+
+	for _, test := range []struct {
+		name     string
+		limits   Limits
+		expRatio bool
+		expErr   bool
+		expSize  int
+	}{
+		{
+			name:    "no limits",
+			expSize: len(data),
+		},
+		{
+			name:    "exact size",
+			limits:  Limits{MaxMessageSize: int64(len(data))},
+			expSize: len(data),
+		},
+		{
+			name:    "size",
+			limits:  Limits{MaxMessageSize: 1000},
+			expErr:  true,
+			expSize: 1000,
+		},
+		{
+			name:    "ratio",
+			limits:  Limits{MaxRatio: 10},
+			expErr:  true,
+			expSize: -1,
+
+			expRatio: true,
+		},
+		{
+			name:    "sufficient ratio",
+			limits:  Limits{MaxRatio: 2 * ratio},
+			expSize: len(data),
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := NewReader(bytes.NewReader(compressed), func(r io.Reader) Decompressor {
+				return flate.NewReader(r)
+			})
+			r.SetLimits(test.limits)
+			act, err := ioutil.ReadAll(r)
+			if !test.expErr {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if len(act) != test.expSize {
+					t.Fatalf("unexpected size: %d; want %d", len(act), test.expSize)
+				}
+				return
+			}
+			e, ok := err.(*LimitError)
+			if !ok {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if e.Ratio != test.expRatio {
+				t.Errorf("unexpected error: %v", e)
+			}
+			if e.StatusCode() != ws.StatusMessageTooBig {
+				t.Errorf("unexpected status code: %v", e.StatusCode())
+			}
+			if test.expSize >= 0 && len(act) != test.expSize {
+				t.Errorf("unexpected size: %d; want %d", len(act), test.expSize)
+			}
+			if len(act) >= len(data) {
+				t.Errorf("message was read completely")
+			}
+			if _, err := r.Read(make([]byte, 1)); err != e {
+				t.Errorf("unexpected subsequent error: %v", err)
+			}
+
+			// Limits must be applied for the next message as well.
+			r.Reset(bytes.NewReader(compressed))
+			if _, err := ioutil.ReadAll(r); err == nil {
+				t.Errorf("no error after Reset()")
+			}
+		})
+	}
+}
+
+func TestReaderLimitsRatioThreshold(t *testing.T) {
+	// Message starts with highly compressible data, but its overall ratio
+	// is low.
+	data := bytes.Repeat([]byte{'a'}, MinRatioSize/2)
+	tail := make([]byte, 4*MinRatioSize)
+	rand.New(rand.NewSource(42)).Read(tail)
+	data = append(data, tail...)
+
+	r := NewReader(bytes.NewReader(compress(data)), func(r io.Reader) Decompressor {
+		return flate.NewReader(r)
+	})
+	r.SetLimits(Limits{MaxRatio: 10})
+	act, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !bytes.Equal(act, data) {
+		t.Errorf("unexpected data")
+	}
+}
+
+func TestHelperLimits(t *testing.T) {
+	f := ws.NewTextFrame(compress(bytes.Repeat([]byte{'a'}, 4096)))
+	f.Header, _ = SetBit(f.Header)
+	h := DefaultHelper
+	h.Limits.MaxMessageSize = 1024
+	if _, err := h.DecompressFrame(f); err == nil {
+		t.Fatalf("no error")
+	} else if _, ok := err.(*LimitError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func compress(p []byte) []byte {
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(p)
+	fw.Flush()
+	return bytes.TrimSuffix(buf.Bytes(), compressionTail[:])
+}
@@ -14,6 +14,7 @@ const (
 	clientNoContextTakeover = "client_no_context_takeover"
 	serverMaxWindowBits     = "server_max_window_bits"
 	clientMaxWindowBits     = "client_max_window_bits"
+	dictionaryID            = "x_dictionary_id"
 )
 
 var (
@@ -23,6 +24,7 @@ var (
 	clientNoContextTakeoverBytes = []byte(clientNoContextTakeover)
 	serverMaxWindowBitsBytes     = []byte(serverMaxWindowBits)
 	clientMaxWindowBitsBytes     = []byte(clientMaxWindowBits)
+	dictionaryIDBytes            = []byte(dictionaryID)
 )
 
 var windowBits [8][]byte
@@ -39,6 +41,10 @@ type Parameters struct {
 	ClientNoContextTakeover bool
 	ServerMaxWindowBits     WindowBits
 	ClientMaxWindowBits     WindowBits
+
+	// DictionaryID is the ID of preset dictionary used by both sides. It is
+	// encoded as the private "x_dictionary_id" parameter. See Dictionary.
+	DictionaryID string
 }
 
 // WindowBits specifies window size accordingly to RFC.
@@ -74,6 +80,7 @@ func (p *Parameters) Parse(opt httphead.Option) (err error) {
 		serverMaxWindowBitsSeen
 		clientNoContextTakeoverSeen
 		serverNoContextTakeoverSeen
+		dictionaryIDSeen
 	)
 
 	// Reset to not mix parsed data from previous Parse() calls.
@@ -136,6 +143,18 @@ func (p *Parameters) Parse(opt httphead.Option) (err error) {
 			seen |= serverNoContextTakeoverSeen
 			p.ServerNoContextTakeover = true
 
+		case dictionaryID:
+			if len(val) == 0 {
+				err = paramError("invalid", key, val)
+				return false
+			}
+			if seen&dictionaryIDSeen != 0 {
+				err = paramError("duplicate", key, val)
+				return false
+			}
+			seen |= dictionaryIDSeen
+			p.DictionaryID = string(val)
+
 		default:
 			err = paramError("unexpected", key, val)
 			return false
@@ -154,6 +173,9 @@ func (p Parameters) Option() httphead.Option {
 	setBool(&opt, clientNoContextTakeoverBytes, p.ClientNoContextTakeover)
 	setBits(&opt, serverMaxWindowBitsBytes, p.ServerMaxWindowBits)
 	setBits(&opt, clientMaxWindowBitsBytes, p.ClientMaxWindowBits)
+	if p.DictionaryID != "" {
+		opt.Parameters.Set(dictionaryIDBytes, []byte(p.DictionaryID))
+	}
 	return opt
 }
 
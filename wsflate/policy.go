@@ -0,0 +1,175 @@
+package wsflate
+
+import (
+	"math"
+	"sync"
+)
+
+// Default values used by Policy when corresponding fields are zero.
+const (
+	DefaultSampleSize    = 512
+	DefaultProbeInterval = 16
+)
+
+// Policy makes per-message decisions whether to compress outgoing messages.
+//
+// Policy holds the compression statistics of a single connection, thus it
+// must not be shared between connections. Its methods must not be called
+// concurrently, except the Stats() method.
+type Policy struct {
+	// MinSize is the minimum payload size of a message to be compressed.
+	// Smaller messages are sent uncompressed.
+	MinSize int
+
+	// MaxEntropy is the maximum Shannon entropy (in bits per byte, from 0 to
+	// 8) of the sampled payload for a message to be compressed. Messages with
+	// higher entropy (such as already compressed or encrypted data) are sent
+	// uncompressed. Zero means no entropy estimation.
+	MaxEntropy float64
+
+	// SampleSize is the number of leading payload bytes used for entropy
+	// estimation. If zero, DefaultSampleSize is used.
+	SampleSize int
+
+	// MaxRatio is the maximum running ratio of compressed size to the
+	// original size of messages. When compression doesn't pay off that much,
+	// Policy turns it off, compressing only one probe message per
+	// ProbeInterval messages to refresh the ratio. Zero means no limit.
+	MaxRatio float64
+
+	// ProbeInterval is the number of messages after which compression is
+	// tried again when it was turned off due to MaxRatio. If zero,
+	// DefaultProbeInterval is used.
+	ProbeInterval int
+
+	ratio float64 // running ratio.
+	known bool    // ratio is known.
+	skips int     // messages skipped since ratio exceeded MaxRatio.
+	last  bool    // last decision.
+
+	mu    sync.Mutex
+	stats PolicyStats
+}
+
+// PolicyStats contains Policy counters.
+type PolicyStats struct {
+	// Compressed and Skipped are the numbers of messages sent compressed and
+	// uncompressed.
+	Compressed int64
+	Skipped    int64
+
+	// CompressedBytes and SkippedBytes are the numbers of payload bytes of
+	// messages sent compressed and uncompressed.
+	CompressedBytes int64
+	SkippedBytes    int64
+
+	// OutputBytes is the number of bytes compressed messages were compressed
+	// into.
+	OutputBytes int64
+}
+
+// Decide decides whether the next message should be compressed and marks s
+// accordingly. Size is the payload size of the message or -1 if it is not
+// known yet (e.g. message is written in a streaming manner). Sample is the
+// payload or its leading bytes; it might be nil.
+//
+// Result of writing the message must be reported by Record() call.
+func (p *Policy) Decide(s *MessageState, size int, sample []byte) bool {
+	p.last = p.decide(size, sample)
+	s.SetCompressed(p.last)
+	return p.last
+}
+
+func (p *Policy) decide(size int, sample []byte) bool {
+	if size >= 0 && size < p.MinSize {
+		return false
+	}
+	if p.MaxRatio > 0 && p.known && p.ratio > p.MaxRatio {
+		if p.skips++; p.skips < p.probeInterval() {
+			return false
+		}
+		p.skips = 0
+		return true
+	}
+	if p.MaxEntropy > 0 && len(sample) > 0 {
+		if n := p.sampleSize(); len(sample) > n {
+			sample = sample[:n]
+		}
+		if entropy(sample) > p.MaxEntropy {
+			return false
+		}
+	}
+	return true
+}
+
+// Record reports the result of writing the message after Decide() call. Size
+// is the payload size of the message and compressed is the size of its
+// compressed payload, which is ignored if message was sent uncompressed.
+func (p *Policy) Record(size, compressed int) {
+	p.mu.Lock()
+	if !p.last {
+		p.stats.Skipped++
+		p.stats.SkippedBytes += int64(size)
+	} else {
+		p.stats.Compressed++
+		p.stats.CompressedBytes += int64(size)
+		p.stats.OutputBytes += int64(compressed)
+	}
+	p.mu.Unlock()
+	if !p.last || size == 0 {
+		return
+	}
+	r := float64(compressed) / float64(size)
+	if !p.known {
+		p.ratio = r
+		p.known = true
+		return
+	}
+	// Exponential moving average of ratios.
+	p.ratio += (r - p.ratio) / 4
+}
+
+// Ratio returns the running ratio of compressed size to the original size of
+// messages. It returns false if no compressed messages were recorded yet.
+func (p *Policy) Ratio() (float64, bool) {
+	return p.ratio, p.known
+}
+
+// Stats returns Policy counters. It is safe to call it concurrently with
+// other methods.
+func (p *Policy) Stats() PolicyStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stats
+}
+
+func (p *Policy) sampleSize() int {
+	if p.SampleSize > 0 {
+		return p.SampleSize
+	}
+	return DefaultSampleSize
+}
+
+func (p *Policy) probeInterval() int {
+	if p.ProbeInterval > 0 {
+		return p.ProbeInterval
+	}
+	return DefaultProbeInterval
+}
+
+// entropy returns Shannon entropy of p in bits per byte.
+func entropy(p []byte) (e float64) {
+	var freq [256]int
+	for _, b := range p {
+		freq[b]++
+	}
+	n := float64(len(p))
+	for _, f := range freq {
+		if f == 0 {
+			continue
+		}
+		x := float64(f) / n
+		e -= x * math.Log2(x)
+	}
+	return e
+}
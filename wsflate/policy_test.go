@@ -0,0 +1,125 @@
+package wsflate
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestPolicyDecide(t *testing.T) {
+	random := make([]byte, 4096)
+	rand.New(rand.NewSource(42)).Read(random)
+	text := bytes.Repeat([]byte("hello, policy! "), 100)
+
+	for _, test := range []struct {
+		name   string
+		policy *Policy
+		size   int
+		sample []byte
+		exp    bool
+	}{
+		{
+			name:   "default",
+			policy: &Policy{},
+			size:   1,
+			exp:    true,
+		},
+		{
+			name:   "small",
+			policy: &Policy{MinSize: 100},
+			size:   99,
+			exp:    false,
+		},
+		{
+			name:   "unknown size",
+			policy: &Policy{MinSize: 100},
+			size:   -1,
+			exp:    true,
+		},
+		{
+			name:   "random",
+			policy: &Policy{MaxEntropy: 7},
+			size:   len(random),
+			sample: random,
+			exp:    false,
+		},
+		{
+			name:   "text",
+			policy: &Policy{MaxEntropy: 7},
+			size:   len(text),
+			sample: text,
+			exp:    true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var s MessageState
+			act := test.policy.Decide(&s, test.size, test.sample)
+			if act != test.exp {
+				t.Errorf("unexpected decision: %t; want %t", act, test.exp)
+			}
+			if s.IsCompressed() != act {
+				t.Errorf("message state was not updated")
+			}
+		})
+	}
+}
+
+func TestPolicyRatio(t *testing.T) {
+	p := Policy{
+		MaxRatio:      0.9,
+		ProbeInterval: 4,
+	}
+	var s MessageState
+
+	// Compression doesn't pay off.
+	if !p.Decide(&s, 100, nil) {
+		t.Fatalf("first message is not compressed")
+	}
+	p.Record(100, 101)
+
+	var decisions []bool
+	for i := 0; i < 8; i++ {
+		c := p.Decide(&s, 100, nil)
+		decisions = append(decisions, c)
+		if c {
+			// Probe message compresses well.
+			p.Record(100, 10)
+		} else {
+			p.Record(100, 0)
+		}
+	}
+	exp := []bool{false, false, false, true, true, true, true, true}
+	for i := range exp {
+		if decisions[i] != exp[i] {
+			t.Fatalf("unexpected decisions: %v; want %v", decisions, exp)
+		}
+	}
+
+	stats := p.Stats()
+	if stats.Compressed != 6 || stats.Skipped != 3 {
+		t.Errorf("unexpected message counters: %+v", stats)
+	}
+	if stats.CompressedBytes != 600 || stats.SkippedBytes != 300 || stats.OutputBytes != 151 {
+		t.Errorf("unexpected byte counters: %+v", stats)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	all := make([]byte, 256)
+	for i := range all {
+		all[i] = byte(i)
+	}
+	for _, test := range []struct {
+		in  []byte
+		exp float64
+	}{
+		{bytes.Repeat([]byte{'a'}, 100), 0},
+		{[]byte("abababab"), 1},
+		{all, 8},
+	} {
+		if act := entropy(test.in); math.Abs(act-test.exp) > 1e-9 {
+			t.Errorf("entropy(%q) = %v; want %v", test.in, act, test.exp)
+		}
+	}
+}
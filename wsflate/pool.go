@@ -0,0 +1,432 @@
+package wsflate
+
+import (
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// Estimated memory used by compress/flate instances.
+const (
+	DefaultCompressorSize   = 600 << 10
+	DefaultDecompressorSize = 40 << 10
+)
+
+// Pool shares compressor and decompressor instances between connections.
+//
+// Instances are taken from the pool at the beginning of a message and, unless
+// context takeover is used, returned back at the end of it. That is, idle
+// connections without context takeover hold no compression contexts at all.
+//
+// Instances used with context takeover are held between messages. Memory
+// held by them could be limited with the MaxMemory budget: when it is
+// exceeded, the least recently used idle instances are evicted. Eviction of
+// a compressor makes the next message to be compressed without context, while
+// decompressor is restored with the history kept by the connection.
+//
+// That history (the last window bytes of decompressed data, see WindowBits)
+// is required to decompress the next message, thus it is accounted in the
+// budget as well, but is never evicted. Extension and ClientExtension with
+// non-nil Pool negotiate no context takeover while the budget is exceeded.
+//
+// Pool must not be copied after first use.
+type Pool struct {
+	// Compressor is the constructor of compressors. Compressor instances
+	// must implement WriteResetter to be reused. If Compressor is nil,
+	// compress/flate writer with flate.BestSpeed level is used.
+	Compressor func(w io.Writer) Compressor
+
+	// Decompressor is the constructor of decompressors. Decompressor
+	// instances must implement ReadResetter or flate.Resetter to be reused,
+	// and flate.Resetter to be used with context takeover. If Decompressor is
+	// nil, compress/flate reader is used.
+	Decompressor func(r io.Reader) Decompressor
+
+	// MaxMemory is the memory budget in bytes for the instances held by
+	// connections with context takeover and for the history kept by their
+	// decompressors. Zero means no limit.
+	MaxMemory int64
+
+	// CompressorSize and DecompressorSize are the estimated memory sizes of
+	// instances. If zero, DefaultCompressorSize and DefaultDecompressorSize
+	// are used.
+	CompressorSize   int64
+	DecompressorSize int64
+
+	compressors   sync.Pool
+	decompressors sync.Pool
+
+	mu   sync.Mutex
+	used int64
+	// head and tail of the list of idle contexts, ordered from the most to
+	// the least recently used.
+	head *poolContext
+	tail *poolContext
+}
+
+// poolContext holds an instance taken from the pool by a connection.
+//
+// If context is idle, it is owned by the pool and might be evicted.
+// Otherwise it is owned by the connection.
+type poolContext struct {
+	x          interface{}
+	compressor bool
+	size       int64
+	held       bool // memory of x is accounted.
+	idle       bool
+	prev, next *poolContext
+}
+
+// Exceeded reports whether the budget has no room for another instance.
+func (p *Pool) Exceeded() bool {
+	if p.MaxMemory <= 0 {
+		return false
+	}
+	size := p.compressorSize()
+	if s := p.decompressorSize(); s > size {
+		size = s
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.used+size > p.MaxMemory
+}
+
+// NewCompressor returns a Compressor which takes compressor instances from
+// the pool. Its signature (with takeover bound) matches NewWriter()
+// constructor argument.
+//
+// If takeover is false, instance is returned to the pool on each Flush()
+// call. Otherwise it is held until Close() call or eviction.
+func (p *Pool) NewCompressor(w io.Writer, takeover bool) Compressor {
+	c := &pooledCompressor{
+		pool:     p,
+		takeover: takeover,
+	}
+	c.ctx.compressor = true
+	c.ctx.size = p.compressorSize()
+	c.Reset(w)
+	return c
+}
+
+// NewDecompressor returns a Decompressor which takes decompressor instances
+// from the pool. Its signature (with bits and takeover bound) matches
+// NewReader() constructor argument.
+//
+// If takeover is false, instance is returned to the pool at the end of each
+// message. Otherwise it is held until Close() call or eviction, while the
+// last window bytes of decompressed data are kept by the returned
+// Decompressor until Close() call.
+func (p *Pool) NewDecompressor(r io.Reader, bits WindowBits, takeover bool) Decompressor {
+	d := &pooledDecompressor{
+		pool:     p,
+		takeover: takeover,
+	}
+	d.ctx.size = p.decompressorSize()
+	if takeover {
+		d.hist.init(bits)
+		p.account(d.hist.size())
+	}
+	d.Reset(r)
+	return d
+}
+
+func (p *Pool) compressorSize() int64 {
+	if p.CompressorSize > 0 {
+		return p.CompressorSize
+	}
+	return DefaultCompressorSize
+}
+
+func (p *Pool) decompressorSize() int64 {
+	if p.DecompressorSize > 0 {
+		return p.DecompressorSize
+	}
+	return DefaultDecompressorSize
+}
+
+func (p *Pool) getCompressor(w io.Writer) Compressor {
+	if x, ok := p.compressors.Get().(Compressor); ok {
+		x.(WriteResetter).Reset(w)
+		return x
+	}
+	if p.Compressor != nil {
+		return p.Compressor(w)
+	}
+	// No error can be returned here as NewWriter() doc says.
+	f, _ := flate.NewWriter(w, flate.BestSpeed)
+	return f
+}
+
+func (p *Pool) getDecompressor(r io.Reader, dict []byte) Decompressor {
+	if x, ok := p.decompressors.Get().(Decompressor); ok {
+		if d, ok := x.(flate.Resetter); ok {
+			d.Reset(r, dict)
+		} else {
+			x.(ReadResetter).Reset(r)
+		}
+		return x
+	}
+	if p.Decompressor != nil {
+		d := p.Decompressor(r)
+		if len(dict) > 0 {
+			d.(flate.Resetter).Reset(r, dict)
+		}
+		return d
+	}
+	return flate.NewReaderDict(r, dict)
+}
+
+// put returns instance of ctx to the pool.
+func (p *Pool) put(ctx *poolContext) {
+	x := ctx.x
+	ctx.x = nil
+	if ctx.compressor {
+		if _, ok := x.(WriteResetter); ok {
+			p.compressors.Put(x)
+		}
+		return
+	}
+	switch x.(type) {
+	case ReadResetter, flate.Resetter:
+		p.decompressors.Put(x)
+	}
+}
+
+// hold accounts memory of the instance held by ctx and evicts idle contexts
+// if the budget is exceeded.
+func (p *Pool) hold(ctx *poolContext) {
+	if p.MaxMemory <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used += ctx.size
+	ctx.held = true
+	p.evict()
+}
+
+// account adds n bytes held by a connection outside of the pooled instances
+// to the budget and evicts idle contexts if the budget is exceeded.
+func (p *Pool) account(n int64) {
+	if p.MaxMemory <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.used += n
+	p.evict()
+}
+
+// evict evicts idle contexts until the budget is not exceeded. It must be
+// called with p.mu held.
+func (p *Pool) evict() {
+	for p.used > p.MaxMemory && p.tail != nil {
+		x := p.tail
+		p.unlink(x)
+		p.used -= x.size
+		x.held = false
+		p.put(x)
+	}
+}
+
+// release returns instance of ctx to the pool and stops its accounting.
+func (p *Pool) release(ctx *poolContext) {
+	if p.MaxMemory > 0 {
+		p.mu.Lock()
+		if ctx.idle {
+			p.unlink(ctx)
+		}
+		if ctx.held {
+			p.used -= ctx.size
+			ctx.held = false
+		}
+		p.mu.Unlock()
+	}
+	p.put(ctx)
+}
+
+// activate passes ownership of ctx to the connection. It returns instance
+// held by ctx, which is nil if it was evicted.
+func (p *Pool) activate(ctx *poolContext) interface{} {
+	if p.MaxMemory <= 0 {
+		return ctx.x
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if ctx.idle {
+		p.unlink(ctx)
+	}
+	return ctx.x
+}
+
+// deactivate passes ownership of ctx to the pool.
+func (p *Pool) deactivate(ctx *poolContext) {
+	if p.MaxMemory <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ctx.idle = true
+	ctx.prev = nil
+	ctx.next = p.head
+	if p.head != nil {
+		p.head.prev = ctx
+	}
+	p.head = ctx
+	if p.tail == nil {
+		p.tail = ctx
+	}
+}
+
+func (p *Pool) unlink(ctx *poolContext) {
+	if ctx.prev != nil {
+		ctx.prev.next = ctx.next
+	} else {
+		p.head = ctx.next
+	}
+	if ctx.next != nil {
+		ctx.next.prev = ctx.prev
+	} else {
+		p.tail = ctx.prev
+	}
+	ctx.prev = nil
+	ctx.next = nil
+	ctx.idle = false
+}
+
+// pooledCompressor is a Compressor returned by Pool.NewCompressor().
+type pooledCompressor struct {
+	pool     *Pool
+	takeover bool
+	ctx      poolContext
+	active   bool
+	// dest is the destination of compressed bytes. Held instances write into
+	// the pooledCompressor itself to let Reset() change the destination
+	// without losing the context.
+	dest io.Writer
+}
+
+// Reset implements WriteResetter.
+func (c *pooledCompressor) Reset(w io.Writer) {
+	c.dest = w
+	if c.active {
+		// Previous message was not flushed, so the context is dropped along
+		// with not flushed data.
+		c.active = false
+		c.pool.release(&c.ctx)
+	}
+}
+
+// Write implements io.Writer.
+func (c *pooledCompressor) Write(p []byte) (int, error) {
+	return c.compressor().Write(p)
+}
+
+// Flush implements Compressor.
+func (c *pooledCompressor) Flush() error {
+	err := c.compressor().Flush()
+	c.active = false
+	if c.takeover {
+		c.pool.deactivate(&c.ctx)
+	} else {
+		c.pool.put(&c.ctx)
+	}
+	return err
+}
+
+// Close returns held instance to the pool.
+func (c *pooledCompressor) Close() error {
+	c.active = false
+	c.pool.release(&c.ctx)
+	return nil
+}
+
+func (c *pooledCompressor) compressor() Compressor {
+	if !c.active {
+		c.active = true
+		if c.pool.activate(&c.ctx) == nil {
+			c.ctx.x = c.pool.getCompressor(writerOnly{c})
+			if c.takeover {
+				c.pool.hold(&c.ctx)
+			}
+		}
+	}
+	return c.ctx.x.(Compressor)
+}
+
+// writerOnly hides methods of pooledCompressor other than Write() from
+// compressor instances.
+type writerOnly struct {
+	c *pooledCompressor
+}
+
+func (w writerOnly) Write(p []byte) (int, error) {
+	return w.c.dest.Write(p)
+}
+
+// pooledDecompressor is a Decompressor returned by Pool.NewDecompressor().
+type pooledDecompressor struct {
+	pool     *Pool
+	takeover bool
+	ctx      poolContext
+	active   bool
+	hist     history
+	src      io.Reader
+	err      error
+}
+
+// Reset implements ReadResetter.
+func (d *pooledDecompressor) Reset(r io.Reader) {
+	if d.active {
+		// Previous message was not read completely.
+		d.done()
+	}
+	d.src = r
+	d.err = nil
+}
+
+// Read implements io.Reader.
+func (d *pooledDecompressor) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	if !d.active {
+		d.active = true
+		x := d.pool.activate(&d.ctx)
+		if x == nil {
+			d.ctx.x = d.pool.getDecompressor(d.src, d.hist.dict())
+			if d.takeover {
+				d.pool.hold(&d.ctx)
+			}
+		} else {
+			x.(flate.Resetter).Reset(d.src, d.hist.dict())
+		}
+	}
+	n, err = d.ctx.x.(Decompressor).Read(p)
+	d.hist.remember(p[:n])
+	if err != nil {
+		d.err = err
+		d.done()
+	}
+	return n, err
+}
+
+// Close returns held instance to the pool and drops the history.
+func (d *pooledDecompressor) Close() error {
+	d.active = false
+	d.pool.release(&d.ctx)
+	if n := d.hist.size(); n > 0 {
+		d.pool.account(-n)
+		d.hist = history{}
+	}
+	return nil
+}
+
+func (d *pooledDecompressor) done() {
+	d.active = false
+	if d.takeover {
+		d.pool.deactivate(&d.ctx)
+	} else {
+		d.pool.put(&d.ctx)
+	}
+}
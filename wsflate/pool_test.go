@@ -0,0 +1,295 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+func TestPoolNoContextTakeover(t *testing.T) {
+	var p Pool
+	var (
+		buf bytes.Buffer
+		c   *pooledCompressor
+		d   *pooledDecompressor
+	)
+	w := NewWriter(nil, func(w io.Writer) Compressor {
+		c = p.NewCompressor(w, false).(*pooledCompressor)
+		return c
+	})
+	r := NewReader(nil, func(r io.Reader) Decompressor {
+		d = p.NewDecompressor(r, 0, false).(*pooledDecompressor)
+		return d
+	})
+	for i := 0; i < 3; i++ {
+		msg := []byte(fmt.Sprintf("hello, pool #%d!", i))
+		buf.Reset()
+		w.Reset(&buf)
+		if _, err := w.Write(msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if c.ctx.x != nil {
+			t.Fatalf("compressor is held after Flush()")
+		}
+		r.Reset(&buf)
+		act, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(act, msg) {
+			t.Fatalf("unexpected message: %q; want %q", act, msg)
+		}
+		if d.ctx.x != nil {
+			t.Fatalf("decompressor is held after the end of message")
+		}
+	}
+}
+
+func TestPoolDecompressorEviction(t *testing.T) {
+	p := Pool{
+		// Room for the history of both connections and a single instance.
+		MaxMemory: 2*MaxLZ77WindowSize + DefaultDecompressorSize,
+	}
+	type conn struct {
+		fw *flate.Writer
+		r  *Reader
+		d  *pooledDecompressor
+	}
+	conns := make([]*conn, 2)
+	for i := range conns {
+		c := new(conn)
+		c.fw, _ = flate.NewWriter(nil, flate.BestCompression)
+		c.r = NewReader(nil, func(r io.Reader) Decompressor {
+			c.d = p.NewDecompressor(r, 0, true).(*pooledDecompressor)
+			return c.d
+		})
+		conns[i] = c
+	}
+	for i := 0; i < 4; i++ {
+		for j, c := range conns {
+			// Messages refer the previous ones of the same connection.
+			msg := bytes.Repeat([]byte(fmt.Sprintf("connection #%d message ", j)), i+1)
+
+			var buf bytes.Buffer
+			c.fw.Reset(&buf)
+			c.fw.Write(msg)
+			c.fw.Flush()
+			p := bytes.TrimSuffix(buf.Bytes(), compressionTail[:])
+
+			c.r.Reset(bytes.NewReader(p))
+			act, err := ioutil.ReadAll(c.r)
+			if err != nil {
+				t.Fatalf("#%d.%d: unexpected error: %v", i, j, err)
+			}
+			if !bytes.Equal(act, msg) {
+				t.Fatalf("#%d.%d: unexpected message: %q; want %q", i, j, act, msg)
+			}
+			if c.d.ctx.x == nil {
+				t.Fatalf("#%d.%d: decompressor is not held", i, j)
+			}
+			if other := conns[1-j]; other.d != nil && other.d.ctx.x != nil {
+				t.Fatalf("#%d.%d: other decompressor was not evicted", i, j)
+			}
+		}
+	}
+	if !p.Exceeded() {
+		t.Errorf("budget is not exceeded")
+	}
+	for _, c := range conns {
+		c.r.Close()
+	}
+	if p.used != 0 || p.head != nil || p.tail != nil {
+		t.Errorf("contexts are held after Close()")
+	}
+}
+
+func TestPoolDecompressorHistoryBudget(t *testing.T) {
+	const bits = 15
+	p := Pool{
+		MaxMemory: 4*DefaultDecompressorSize + 4*int64(WindowBits(bits).Bytes()),
+	}
+	var ds []*pooledDecompressor
+	// retained returns the number of bytes actually held by decompressors.
+	retained := func() (n int64) {
+		for _, d := range ds {
+			n += int64(cap(d.hist.buf))
+			if d.ctx.x != nil {
+				n += d.ctx.size
+			}
+		}
+		return n
+	}
+	msg := []byte("hello, history budget!")
+	for i := 0; i < 16; i++ {
+		var buf bytes.Buffer
+		fw, _ := flate.NewWriter(&buf, flate.BestSpeed)
+		fw.Write(msg)
+		fw.Flush()
+
+		r := NewReader(nil, func(r io.Reader) Decompressor {
+			d := p.NewDecompressor(r, bits, true).(*pooledDecompressor)
+			ds = append(ds, d)
+			return d
+		})
+		r.Reset(bytes.NewReader(bytes.TrimSuffix(buf.Bytes(), compressionTail[:])))
+		if _, err := ioutil.ReadAll(r); err != nil {
+			t.Fatal(err)
+		}
+		if act, exp := p.used, retained(); act != exp {
+			t.Fatalf("#%d: used budget is %d; want %d retained bytes", i, act, exp)
+		}
+	}
+	if n := retained(); n <= p.MaxMemory {
+		t.Fatalf("retained %d bytes are within the budget", n)
+	}
+	if !p.Exceeded() {
+		t.Errorf("budget is not exceeded")
+	}
+	for _, d := range ds {
+		d.Close()
+	}
+	if p.used != 0 || retained() != 0 {
+		t.Errorf("memory is held after Close(): %d used; %d retained", p.used, retained())
+	}
+}
+
+func TestPoolCompressorEviction(t *testing.T) {
+	p := Pool{
+		MaxMemory: DefaultCompressorSize,
+	}
+	type conn struct {
+		w *Writer
+		r *Reader
+	}
+	conns := make([]*conn, 2)
+	for i := range conns {
+		conns[i] = &conn{
+			w: NewWriter(nil, func(w io.Writer) Compressor {
+				return p.NewCompressor(w, true)
+			}),
+			r: NewReader(nil, func(r io.Reader) Decompressor {
+				return NewDecompressor(r, 0, true)
+			}),
+		}
+	}
+	for i := 0; i < 4; i++ {
+		for j, c := range conns {
+			msg := bytes.Repeat([]byte(fmt.Sprintf("connection #%d message ", j)), i+1)
+
+			var buf bytes.Buffer
+			c.w.Reset(&buf)
+			c.w.Write(msg)
+			if err := c.w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			c.r.Reset(&buf)
+			act, err := ioutil.ReadAll(c.r)
+			if err != nil {
+				t.Fatalf("#%d.%d: unexpected error: %v", i, j, err)
+			}
+			if !bytes.Equal(act, msg) {
+				t.Fatalf("#%d.%d: unexpected message: %q; want %q", i, j, act, msg)
+			}
+		}
+	}
+	if p.used != DefaultCompressorSize {
+		t.Errorf("unexpected used memory: %d", p.used)
+	}
+}
+
+func TestPoolNegotiation(t *testing.T) {
+	p := Pool{
+		MaxMemory: 1,
+	}
+	e := Extension{
+		Parameters: Parameters{},
+		Pool:       &p,
+	}
+	accept, err := e.Negotiate(httphead.NewOption(ExtensionName, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var act Parameters
+	if err := act.Parse(accept); err != nil {
+		t.Fatal(err)
+	}
+	exp := Parameters{
+		ServerNoContextTakeover: true,
+		ClientNoContextTakeover: true,
+	}
+	if act != exp {
+		t.Errorf("unexpected accepted parameters: %+v; want %+v", act, exp)
+	}
+
+	c := ClientExtension{
+		Pool: &p,
+	}
+	opts := c.Offers()
+	if err := act.Parse(opts[0]); err != nil {
+		t.Fatal(err)
+	}
+	if act != exp {
+		t.Errorf("unexpected offer parameters: %+v; want %+v", act, exp)
+	}
+	if err := c.Negotiate(httphead.NewOption(ExtensionName, map[string]string{
+		clientNoContextTakeover: "",
+	})); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestPoolConcurrent(t *testing.T) {
+	p := Pool{
+		MaxMemory: DefaultCompressorSize + DefaultDecompressorSize,
+	}
+	run := func(i int) error {
+		w := NewWriter(nil, func(w io.Writer) Compressor {
+			return p.NewCompressor(w, true)
+		})
+		r := NewReader(nil, func(r io.Reader) Decompressor {
+			return p.NewDecompressor(r, 0, true)
+		})
+		defer w.Close()
+		defer r.Close()
+		for j := 0; j < 50; j++ {
+			msg := bytes.Repeat([]byte(fmt.Sprintf("goroutine #%d ", i)), j+1)
+			var buf bytes.Buffer
+			w.Reset(&buf)
+			w.Write(msg)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			r.Reset(&buf)
+			act, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			if !bytes.Equal(act, msg) {
+				return fmt.Errorf("unexpected message: %q; want %q", act, msg)
+			}
+		}
+		return nil
+	}
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func(i int) {
+			errs <- run(i)
+		}(i)
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+	if p.used != 0 {
+		t.Errorf("unexpected used memory after Close(): %d", p.used)
+	}
+}
@@ -24,12 +24,16 @@ type ReadResetter interface {
 //
 // Reader might be reused for different io.Reader objects after its Reset()
 // method has been called.
+//
+// Reader reads data without any bound unless limits are set by SetLimits().
 type Reader struct {
-	src  io.Reader
-	ctor func(io.Reader) Decompressor
-	d    Decompressor
-	sr   suffixedReader
-	err  error
+	src    io.Reader
+	ctor   func(io.Reader) Decompressor
+	d      Decompressor
+	sr     suffixedReader
+	err    error
+	limits Limits
+	n      int64
 }
 
 // NewReader returns a new Reader.
@@ -48,6 +52,7 @@ func NewReader(r io.Reader, ctor func(io.Reader) Decompressor) *Reader {
 // Reset resets Reader to decompress data from src.
 func (r *Reader) Reset(src io.Reader) {
 	r.err = nil
+	r.n = 0
 	r.src = src
 	r.sr.reset(src)
 
@@ -58,12 +63,28 @@ func (r *Reader) Reset(src io.Reader) {
 	}
 }
 
+// SetLimits sets limits applied to decompressed data read after each
+// Reset(). Exceeding the limits makes Read() return *LimitError.
+func (r *Reader) SetLimits(l Limits) {
+	r.limits = l
+}
+
 // Read implements io.Reader.
 func (r *Reader) Read(p []byte) (n int, err error) {
 	if r.err != nil {
 		return 0, r.err
 	}
-	return r.d.Read(p)
+	n, err = r.d.Read(p)
+	r.n += int64(n)
+	if e := r.limits.check(r.n, r.sr.n); e != nil {
+		if max := r.limits.MaxMessageSize; max > 0 && r.n > max {
+			// Do not return bytes beyond the limit.
+			n -= int(r.n - max)
+		}
+		r.err = e
+		return n, e
+	}
+	return n, err
 }
 
 // Close closes Reader and a Decompressor instance used under the hood (if it
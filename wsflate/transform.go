@@ -0,0 +1,269 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+
+	"github.com/gobwas/ws"
+)
+
+// Transform applies permessage-deflate compression to message payloads. It
+// implements wsutil.Extension, wsutil.RecvTransform and wsutil.SendTransform
+// interfaces, so it could be used within wsutil.Chain.
+//
+// Unlike MessageState, Transform holds separate states of received and sent
+// messages.
+type Transform struct {
+	// Policy is an optional policy which decides whether to compress sent
+	// messages. If it is nil, all messages are compressed.
+	Policy *Policy
+
+	fr   *Reader
+	fw   *Writer
+	recv MessageState
+	send MessageState
+	w    transformWriter
+
+	// decided reports whether Decide() was called for the next sent message.
+	decided bool
+
+	// newReader and newWriter are used to create fr and fw on demand.
+	newReader func() *Reader
+	newWriter func() *Writer
+}
+
+// TransformOptions contains optional settings of Transform created by
+// NewTransformOptions().
+type TransformOptions struct {
+	// Pool is an optional pool of compression contexts. It is not used when
+	// Dictionary is non-nil.
+	Pool *Pool
+
+	// Dictionary is the preset dictionary negotiated with the parameters'
+	// DictionaryID. Use Dictionaries.Lookup() to get it for the negotiated
+	// subprotocol.
+	Dictionary []byte
+
+	// Limits contains limits applied to decompressed payload of received
+	// messages. See Reader.SetLimits().
+	Limits Limits
+
+	// Policy is used as Transform.Policy.
+	Policy *Policy
+}
+
+// NewTransform returns a Transform which uses fr to decompress received
+// messages and fw to compress sent messages. Both of them are reset for each
+// message.
+func NewTransform(fr *Reader, fw *Writer) *Transform {
+	t := &Transform{
+		fr: fr,
+		fw: fw,
+	}
+	t.w.t = t
+	return t
+}
+
+// NewTransformParams returns a Transform for parameters p negotiated for the
+// side described by state. It is the same as NewTransformOptions() with zero
+// options, thus preset dictionary is not supported here.
+func NewTransformParams(p Parameters, state ws.State) *Transform {
+	return NewTransformOptions(p, state, TransformOptions{})
+}
+
+// NewTransformOptions returns a Transform for parameters p negotiated for the
+// side described by state. Received messages are decompressed accordingly to
+// the peer's context takeover and window parameters, while sent messages are
+// compressed with compress/flate (or with NewCompressor() if peer negotiated
+// window smaller than the maximum) without context takeover.
+//
+// Compression contexts are created on demand and are released by
+// CloseReader() and CloseWriter().
+func NewTransformOptions(p Parameters, state ws.State, opts TransformOptions) *Transform {
+	var (
+		takeover bool
+		recvBits WindowBits
+		sendBits WindowBits
+	)
+	if state.ServerSide() {
+		takeover = !p.ClientNoContextTakeover
+		recvBits = p.ClientMaxWindowBits
+		sendBits = p.ServerMaxWindowBits
+	} else {
+		takeover = !p.ServerNoContextTakeover
+		recvBits = p.ServerMaxWindowBits
+		sendBits = p.ClientMaxWindowBits
+	}
+	var (
+		pool = opts.Pool
+		dict = opts.Dictionary
+	)
+	if dict != nil {
+		pool = nil
+	}
+	t := NewTransform(nil, nil)
+	t.Policy = opts.Policy
+	t.newReader = func() *Reader {
+		fr := NewReader(nil, func(r io.Reader) Decompressor {
+			if pool != nil {
+				return pool.NewDecompressor(r, recvBits, takeover)
+			}
+			return NewDecompressorDict(r, recvBits, takeover, dict)
+		})
+		fr.SetLimits(opts.Limits)
+		return fr
+	}
+	t.newWriter = func() *Writer {
+		return NewWriter(nil, func(w io.Writer) Compressor {
+			if sendBits > 1 && sendBits.Bytes() < MaxLZ77WindowSize {
+				// Peer is not able to resolve back-references beyond the
+				// negotiated window, which compress/flate doesn't limit.
+				return NewCompressorDict(w, sendBits, false, dict)
+			}
+			if dict != nil {
+				// NOTE: compress/flate doesn't find matches in preset
+				// dictionary for short messages on lower levels.
+				f, _ := flate.NewWriterDict(w, flate.BestCompression, dict)
+				return f
+			}
+			if pool != nil {
+				return pool.NewCompressor(w, false)
+			}
+			// As flate.NewWriter() docs says:
+			//   If level is in the range [-2, 9] then the error returned will
+			//   be nil.
+			f, _ := flate.NewWriter(w, flate.BestSpeed)
+			return f
+		})
+	}
+	return t
+}
+
+// Rsv returns the RSV1 bit, which marks compressed messages.
+func (t *Transform) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
+// UnsetBits updates the state of received message accordingly to the frame
+// header h. See MessageState.UnsetBits().
+func (t *Transform) UnsetBits(h ws.Header) (ws.Header, error) {
+	return t.recv.UnsetBits(h)
+}
+
+// SetBits sets RSV bits of the sent frame header h accordingly to the state
+// of sent message. See MessageState.SetBits().
+//
+// The state is reset after the final frame of a data message, so the next
+// message is not marked as compressed unless TransformWriter() is called for
+// it.
+func (t *Transform) SetBits(h ws.Header) (_ ws.Header, err error) {
+	h, err = t.send.SetBits(h)
+	if err == nil && h.Fin && h.OpCode.IsData() {
+		t.send.SetCompressed(false)
+	}
+	return h, err
+}
+
+// TransformReader returns a reader of decompressed payload read from r if
+// received message is compressed. Otherwise r is returned as is.
+func (t *Transform) TransformReader(r io.Reader) io.Reader {
+	if !t.recv.IsCompressed() {
+		return r
+	}
+	if t.fr == nil {
+		t.fr = t.newReader()
+	}
+	t.fr.Reset(r)
+	return t.fr
+}
+
+// Decide makes Policy decide whether to compress the next sent message of
+// known size and payload sample (see Policy.Decide()). Otherwise the decision
+// is made by TransformWriter() with unknown size.
+func (t *Transform) Decide(size int, sample []byte) {
+	t.decided = true
+	if p := t.Policy; p != nil {
+		p.Decide(&t.send, size, sample)
+	} else {
+		t.send.SetCompressed(true)
+	}
+}
+
+// TransformWriter returns a writer which compresses the payload of the sent
+// message into w, unless Policy decides to send it uncompressed. Returned
+// writer is valid until the next call.
+func (t *Transform) TransformWriter(w io.Writer) io.WriteCloser {
+	if !t.decided {
+		t.Decide(-1, nil)
+	}
+	t.decided = false
+	t.w.n = 0
+	t.w.w = w
+	if t.send.IsCompressed() {
+		if t.fw == nil {
+			t.fw = t.newWriter()
+		}
+		t.fw.Reset(w)
+		t.w.w = t.fw
+	}
+	return &t.w
+}
+
+// CloseReader releases the decompression context, e.g. returns it to the
+// Pool. It must not be called concurrently with reading of messages. Note
+// that Reader passed to NewTransform() is owned by the caller and is not
+// released.
+func (t *Transform) CloseReader() error {
+	if t.fr == nil || t.newReader == nil {
+		return nil
+	}
+	t.fr.Reset(bytes.NewReader(nil))
+	err := t.fr.Close()
+	t.fr = nil
+	return err
+}
+
+// CloseWriter releases the compression context dropping any data of
+// unfinished message. It must not be called concurrently with writing of
+// messages. Note that Writer passed to NewTransform() is owned by the caller
+// and is not released.
+func (t *Transform) CloseWriter() error {
+	if t.fw == nil || t.newWriter == nil {
+		return nil
+	}
+	t.fw.Reset(ioutil.Discard)
+	err := t.fw.Close()
+	t.fw = nil
+	return err
+}
+
+// transformWriter is a writer returned by Transform.TransformWriter().
+type transformWriter struct {
+	t *Transform
+	w io.Writer
+	n int
+}
+
+func (w *transformWriter) Write(p []byte) (n int, err error) {
+	n, err = w.w.Write(p)
+	w.n += n
+	return n, err
+}
+
+// Close flushes compressed data and records the result to the Policy.
+func (w *transformWriter) Close() (err error) {
+	compressed := w.t.send.IsCompressed()
+	if compressed {
+		err = w.t.fw.Flush()
+	}
+	if p := w.t.Policy; p != nil && err == nil {
+		var size int64
+		if compressed {
+			_, size = w.t.fw.Size()
+		}
+		p.Record(w.n, int(size))
+	}
+	return err
+}
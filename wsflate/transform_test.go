@@ -0,0 +1,127 @@
+package wsflate
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/gobwas/ws"
+)
+
+func TestTransform(t *testing.T) {
+	newTransform := func() *Transform {
+		fw := NewWriter(nil, func(w io.Writer) Compressor {
+			f, _ := flate.NewWriter(w, flate.BestSpeed)
+			return f
+		})
+		fr := NewReader(nil, func(r io.Reader) Decompressor {
+			return flate.NewReader(r)
+		})
+		return NewTransform(fr, fw)
+	}
+	random := make([]byte, 1024)
+	rand.New(rand.NewSource(42)).Read(random)
+
+	send := newTransform()
+	send.Policy = &Policy{
+		MaxRatio:      0.5,
+		ProbeInterval: 8,
+	}
+	recv := newTransform()
+	for i, exp := range []bool{true, false} {
+		var buf bytes.Buffer
+		w := send.TransformWriter(&buf)
+		if _, err := w.Write(random); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		h, err := send.SetBits(ws.Header{OpCode: ws.OpBinary})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Rsv1() != exp {
+			t.Errorf("#%d: unexpected compression bit: %t", i, h.Rsv1())
+		}
+		if _, err = recv.UnsetBits(h); err != nil {
+			t.Fatal(err)
+		}
+		act, err := ioutil.ReadAll(recv.TransformReader(&buf))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(act, random) {
+			t.Errorf("#%d: unexpected message", i)
+		}
+	}
+	stats := send.Policy.Stats()
+	if stats.Compressed != 1 || stats.Skipped != 1 {
+		t.Errorf("unexpected policy stats: %+v", stats)
+	}
+}
+
+func TestTransformParams(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		params Parameters
+	}{
+		{
+			name: "default",
+		},
+		{
+			name: "window",
+			params: Parameters{
+				ServerMaxWindowBits: 9,
+				ClientMaxWindowBits: 10,
+			},
+		},
+		{
+			name: "no context takeover",
+			params: Parameters{
+				ServerNoContextTakeover: true,
+				ClientNoContextTakeover: true,
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := NewTransformParams(test.params, ws.StateServerSide)
+			client := NewTransformParams(test.params, ws.StateClientSide)
+			for _, pair := range [][2]*Transform{
+				{server, client},
+				{client, server},
+			} {
+				send, recv := pair[0], pair[1]
+				for i := 0; i < 3; i++ {
+					msg := bytes.Repeat([]byte("transform "), 100*(i+1))
+
+					var buf bytes.Buffer
+					w := send.TransformWriter(&buf)
+					if _, err := w.Write(msg); err != nil {
+						t.Fatal(err)
+					}
+					if err := w.Close(); err != nil {
+						t.Fatal(err)
+					}
+					h, err := send.SetBits(ws.Header{OpCode: ws.OpText})
+					if err != nil {
+						t.Fatal(err)
+					}
+					if _, err = recv.UnsetBits(h); err != nil {
+						t.Fatal(err)
+					}
+					act, err := ioutil.ReadAll(recv.TransformReader(&buf))
+					if err != nil {
+						t.Fatal(err)
+					}
+					if !bytes.Equal(act, msg) {
+						t.Errorf("#%d: unexpected message", i)
+					}
+				}
+			}
+		})
+	}
+}
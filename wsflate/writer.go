@@ -55,6 +55,7 @@ type Writer struct {
 	c    Compressor
 	cbuf cbuf
 	err  error
+	n    int64
 }
 
 // NewWriter returns a new Writer.
@@ -74,6 +75,7 @@ func NewWriter(w io.Writer, ctor func(io.Writer) Compressor) *Writer {
 // Any not flushed data will be lost.
 func (w *Writer) Reset(dest io.Writer) {
 	w.err = nil
+	w.n = 0
 	w.cbuf.reset(dest)
 	if x, ok := w.c.(WriteResetter); ok {
 		x.Reset(&w.cbuf)
@@ -88,9 +90,19 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 		return 0, w.err
 	}
 	n, w.err = w.c.Write(p)
+	w.n += int64(n)
 	return n, w.err
 }
 
+// Size returns the number of bytes written to w and the number of compressed
+// bytes written to the destination since the last Reset() call.
+//
+// Note that compressed bytes might be buffered by Compressor until Flush()
+// call.
+func (w *Writer) Size() (n, compressed int64) {
+	return w.n, w.cbuf.written
+}
+
 // Flush writes any pending data into w.Dest.
 func (w *Writer) Flush() error {
 	if w.err != nil {
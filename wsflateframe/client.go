@@ -0,0 +1,75 @@
+package wsflateframe
+
+import (
+	"fmt"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+)
+
+// ClientExtension contains logic of per-frame compression extension
+// negotiation made by the client during HTTP WebSocket handshake.
+// It is a client side counterpart of Extension.
+//
+// It might be reused between different handshakes (but not concurrently)
+// with Reset() being called after each.
+type ClientExtension struct {
+	// Parameters is specification of extension parameters client offers to
+	// restrict server's compression.
+	Parameters Parameters
+
+	// Webkit makes the offer with WebkitExtensionName instead of
+	// ExtensionName.
+	Webkit bool
+
+	accepted bool
+	params   Parameters
+}
+
+// Offers returns the list of extension offers. It could be used as
+// ws.Dialer's Extensions field. To offer permessage-deflate as well, list
+// its offers first.
+func (c *ClientExtension) Offers() []httphead.Option {
+	opt := c.Parameters.Option()
+	if c.Webkit {
+		opt.Name = WebkitExtensionNameBytes
+	}
+	return []httphead.Option{opt}
+}
+
+// Negotiate parses given HTTP header option received within server's
+// handshake response. It could be used as ws.Dialer's OnExtension field.
+//
+// It returns nil error for options with other extension names.
+func (c *ClientExtension) Negotiate(opt httphead.Option) (err error) {
+	if !IsExtension(opt.Name) {
+		return nil
+	}
+	if c.accepted {
+		return fmt.Errorf("wsflateframe: duplicate %q extension in response", opt.Name)
+	}
+	if err := c.params.Parse(opt); err != nil {
+		return err
+	}
+	c.accepted = true
+	return nil
+}
+
+// Accepted returns parameters parsed during last negotiation and a flag that
+// reports whether they were accepted. Parameters describe the frames written
+// by the client; frames written by the server are described by the
+// ClientExtension's Parameters field.
+func (c *ClientExtension) Accepted() (_ Parameters, accepted bool) {
+	return c.params, c.accepted
+}
+
+// Rsv implements ws.RsvExtension. See Extension.Rsv().
+func (c *ClientExtension) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
+// Reset resets extension for further reuse.
+func (c *ClientExtension) Reset() {
+	c.accepted = false
+	c.params = Parameters{}
+}
@@ -0,0 +1,47 @@
+package wsflateframe
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+func TestClientExtension(t *testing.T) {
+	c := ClientExtension{
+		Parameters: Parameters{
+			NoContextTakeover: true,
+		},
+		Webkit: true,
+	}
+	offers := c.Offers()
+	if len(offers) != 1 || !bytes.Equal(offers[0].Name, WebkitExtensionNameBytes) {
+		t.Fatalf("unexpected offers: %v", offers)
+	}
+
+	var s Extension
+	s.Parameters.MaxWindowBits = 9
+	resp, err := s.Negotiate(offers[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(resp.Name, WebkitExtensionNameBytes) {
+		t.Errorf("unexpected response name: %q", resp.Name)
+	}
+	if p, ok := s.Accepted(); !ok || !p.NoContextTakeover {
+		t.Errorf("unexpected server parameters: %+v", p)
+	}
+
+	if err := c.Negotiate(httphead.NewOption("permessage-deflate", nil)); err != nil {
+		t.Errorf("unexpected error for other extension: %v", err)
+	}
+	if err := c.Negotiate(resp); err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := c.Accepted(); !ok || p.MaxWindowBits != 9 {
+		t.Errorf("unexpected client parameters: %+v", p)
+	}
+	if err := c.Negotiate(resp); err == nil {
+		t.Errorf("no error for duplicate extension")
+	}
+}
@@ -0,0 +1,159 @@
+package wsflateframe
+
+import (
+	"bytes"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// Extension contains logic of per-frame compression extension negotiation
+// made by the server during HTTP WebSocket handshake. Both ExtensionName and
+// WebkitExtensionName offers are accepted; the response is made with the
+// offered name.
+//
+// It might be reused between different upgrades (but not concurrently) with
+// Reset() being called after each.
+type Extension struct {
+	// Parameters is specification of extension parameters server sends in
+	// response to restrict client's compression.
+	Parameters Parameters
+
+	accepted bool
+	name     []byte
+	params   Parameters
+}
+
+// Negotiate parses given HTTP header option and returns (if any) header option
+// which describes accepted parameters. Its signature matches
+// ws.Upgrader.Negotiate field.
+//
+// It may return zero option (i.e. one which Size() returns 0) alongside with
+// nil error.
+func (n *Extension) Negotiate(opt httphead.Option) (accept httphead.Option, err error) {
+	if !IsExtension(opt.Name) {
+		return accept, nil
+	}
+	if n.accepted {
+		// We stick to first one accepted extension since they must be passed
+		// in ordered by preference.
+		return accept, nil
+	}
+	// NOTE: Parse() resets params inside, so no worries.
+	if err := n.params.Parse(opt); err != nil {
+		return accept, err
+	}
+	// Server is always able to follow client's requirements, since it is
+	// allowed to use smaller window and to reset context more often.
+	n.accepted = true
+	n.name = append(n.name[:0], opt.Name...)
+
+	accept = n.Parameters.Option()
+	accept.Name = n.name
+	return accept, nil
+}
+
+// Accepted returns parameters parsed during last negotiation and a flag that
+// reports whether they were accepted. Parameters describe the frames written
+// by the server; frames written by the client are described by the
+// Extension's Parameters field.
+func (n *Extension) Accepted() (_ Parameters, accepted bool) {
+	return n.params, n.accepted
+}
+
+// Rsv implements ws.RsvExtension. The per-frame compression uses the RSV1
+// bit of each compressed frame.
+func (n *Extension) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
+// Reset resets extension for further reuse.
+func (n *Extension) Reset() {
+	n.accepted = false
+	n.name = n.name[:0]
+	n.params = Parameters{}
+}
+
+// Negotiator negotiates permessage-deflate and per-frame compression
+// extensions together. Since both of them use the RSV1 bit, at most one of
+// them is accepted; permessage-deflate is preferred when both are accepted,
+// regardless of the order in which the client lists them.
+//
+// Both Negotiate and Negotiated methods must be used, as
+// ws.Upgrader.Negotiate and ws.Upgrader.OnNegotiated fields respectively (or
+// the same fields of ws.HTTPUpgrader), since the final decision can only be
+// made after all offers are seen.
+//
+// It might be reused between different upgrades (but not concurrently) with
+// Reset() being called after each.
+type Negotiator struct {
+	Flate *wsflate.Extension
+	Frame *Extension
+}
+
+// Negotiate implements the ws.Upgrader.Negotiate callback. Note that it may
+// accept both extensions; the one which is not preferred is dropped later by
+// Negotiated.
+func (n *Negotiator) Negotiate(opt httphead.Option) (accept httphead.Option, err error) {
+	switch {
+	case bytes.Equal(opt.Name, wsflate.ExtensionNameBytes):
+		if n.Flate == nil {
+			return accept, nil
+		}
+		return n.Flate.Negotiate(opt)
+
+	case IsExtension(opt.Name):
+		if n.Frame == nil {
+			return accept, nil
+		}
+		return n.Frame.Negotiate(opt)
+	}
+	return accept, nil
+}
+
+// Negotiated implements the ws.Upgrader.OnNegotiated callback. It removes
+// the per-frame compression extension from the accepted list if
+// permessage-deflate was accepted as well.
+func (n *Negotiator) Negotiated(protocol string, accepted []httphead.Option) ([]httphead.Option, error) {
+	if n.Flate != nil {
+		var err error
+		accepted, err = n.Flate.Negotiated(protocol, accepted)
+		if err != nil {
+			return accepted, err
+		}
+	}
+	if !n.flateAccepted() || n.Frame == nil {
+		return accepted, nil
+	}
+	if _, ok := n.Frame.Accepted(); !ok {
+		return accepted, nil
+	}
+	n.Frame.Reset()
+
+	filtered := accepted[:0]
+	for _, opt := range accepted {
+		if !IsExtension(opt.Name) {
+			filtered = append(filtered, opt)
+		}
+	}
+	return filtered, nil
+}
+
+// Reset resets both extensions for further reuse.
+func (n *Negotiator) Reset() {
+	if n.Flate != nil {
+		n.Flate.Reset()
+	}
+	if n.Frame != nil {
+		n.Frame.Reset()
+	}
+}
+
+func (n *Negotiator) flateAccepted() bool {
+	if n.Flate == nil {
+		return false
+	}
+	_, ok := n.Flate.Accepted()
+	return ok
+}
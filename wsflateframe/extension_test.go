@@ -0,0 +1,142 @@
+package wsflateframe
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+func TestNegotiator(t *testing.T) {
+	var (
+		pmd   = httphead.NewOption(wsflate.ExtensionName, nil)
+		frame = httphead.NewOption(ExtensionName, nil)
+		bad   = httphead.NewOption(wsflate.ExtensionName, map[string]string{
+			"server_max_window_bits": "15",
+		})
+	)
+	for _, test := range []struct {
+		name   string
+		offers []httphead.Option
+		exp    []byte // name of accepted extension.
+	}{
+		{
+			name:   "flate",
+			offers: []httphead.Option{pmd},
+			exp:    wsflate.ExtensionNameBytes,
+		},
+		{
+			name:   "frame",
+			offers: []httphead.Option{frame},
+			exp:    ExtensionNameBytes,
+		},
+		{
+			name:   "both",
+			offers: []httphead.Option{pmd, frame},
+			exp:    wsflate.ExtensionNameBytes,
+		},
+		{
+			name:   "frame first",
+			offers: []httphead.Option{frame, pmd},
+			exp:    wsflate.ExtensionNameBytes,
+		},
+		{
+			name:   "flate declined",
+			offers: []httphead.Option{bad, frame},
+			exp:    ExtensionNameBytes,
+		},
+		{
+			name:   "frame first flate declined",
+			offers: []httphead.Option{frame, bad},
+			exp:    ExtensionNameBytes,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			n := Negotiator{
+				Flate: &wsflate.Extension{
+					Parameters: wsflate.Parameters{
+						ServerMaxWindowBits: 10,
+					},
+				},
+				Frame: new(Extension),
+			}
+			var opts []httphead.Option
+			for _, offer := range test.offers {
+				resp, err := n.Negotiate(offer)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp.Size() > 0 {
+					opts = append(opts, resp)
+				}
+			}
+			opts, err := n.Negotiated("", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var accepted [][]byte
+			for _, opt := range opts {
+				accepted = append(accepted, opt.Name)
+			}
+			_, frameAccepted := n.Frame.Accepted()
+			if exp := bytes.Equal(test.exp, ExtensionNameBytes); frameAccepted != exp {
+				t.Errorf("unexpected frame extension accepted state: %t; want %t", frameAccepted, exp)
+			}
+			if test.exp == nil {
+				if len(accepted) != 0 {
+					t.Fatalf("unexpected accepted extensions: %q", accepted)
+				}
+				return
+			}
+			if len(accepted) != 1 || !bytes.Equal(accepted[0], test.exp) {
+				t.Fatalf("unexpected accepted extensions: %q; want %q", accepted, test.exp)
+			}
+		})
+	}
+}
+
+func TestNegotiatorUpgrade(t *testing.T) {
+	n := Negotiator{
+		Flate: &wsflate.Extension{
+			Parameters: wsflate.DefaultParameters,
+		},
+		Frame: new(Extension),
+	}
+	u := ws.Upgrader{
+		Negotiate:    n.Negotiate,
+		OnNegotiated: n.Negotiated,
+	}
+	req := "GET /chat HTTP/1.1\r\n" +
+		"Host: example.org\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" +
+		"Sec-WebSocket-Version: 13\r\n" +
+		"Sec-WebSocket-Extensions: deflate-frame\r\n" +
+		"Sec-WebSocket-Extensions: permessage-deflate\r\n" +
+		"\r\n"
+	var resp bytes.Buffer
+	hs, err := u.Upgrade(struct {
+		io.Reader
+		io.Writer
+	}{
+		strings.NewReader(req),
+		&resp,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(hs.Extensions); n != 1 || !bytes.Equal(hs.Extensions[0].Name, wsflate.ExtensionNameBytes) {
+		t.Fatalf("unexpected accepted extensions: %v", hs.Extensions)
+	}
+	if bytes.Contains(resp.Bytes(), ExtensionNameBytes) {
+		t.Fatalf("unexpected per-frame extension in response:\n%s", resp.Bytes())
+	}
+	if _, ok := n.Frame.Accepted(); ok {
+		t.Fatalf("per-frame extension is accepted")
+	}
+}
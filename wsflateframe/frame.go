@@ -0,0 +1,202 @@
+package wsflateframe
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+var ErrUnexpectedCompressionBit = ws.ProtocolError(
+	"control frame contains compression bit set",
+)
+
+// compressionTail is the sync flush marker removed from compressed frames.
+var compressionTail = [4]byte{0, 0, 0xff, 0xff}
+
+// FrameState holds frame compression state. It could be used as wsutil's
+// RecvExtension and SendExtension.
+//
+// Unlike wsflate.MessageState, the state is updated and consulted for each
+// data frame, including continuation frames.
+type FrameState struct {
+	compressed bool
+}
+
+// SetCompressed marks next frames as "compressed" or "uncompressed".
+func (s *FrameState) SetCompressed(v bool) {
+	s.compressed = v
+}
+
+// IsCompressed reports whether frame is "compressed".
+func (s *FrameState) IsCompressed() bool {
+	return s.compressed
+}
+
+// UnsetBits changes RSV bits of the given frame header h as if per-frame
+// compression extension was negotiated. It returns modified copy of h and
+// error if header is malformed.
+func (s *FrameState) UnsetBits(h ws.Header) (ws.Header, error) {
+	r1, r2, r3 := ws.RsvBits(h.Rsv)
+	switch {
+	case h.OpCode.IsData():
+		h.Rsv = ws.Rsv(false, r2, r3)
+		s.compressed = r1
+		return h, nil
+
+	case r1:
+		return h, ErrUnexpectedCompressionBit
+
+	default:
+		// NOTE: do not change the state of s.compressed since UnsetBits()
+		// might also be called for (intermediate) control frames.
+		return h, nil
+	}
+}
+
+// SetBits changes RSV bits of the frame header h which is being send as if
+// per-frame compression extension was negotiated. It returns modified copy of
+// h and error if header is malformed.
+func (s *FrameState) SetBits(h ws.Header) (ws.Header, error) {
+	r1, r2, r3 := ws.RsvBits(h.Rsv)
+	if r1 {
+		return h, ErrUnexpectedCompressionBit
+	}
+	if h.OpCode.IsData() && s.compressed {
+		h.Rsv = ws.Rsv(true, r2, r3)
+	}
+	return h, nil
+}
+
+// Compressor compresses payloads of frames one by one.
+type Compressor struct {
+	c        wsflate.Compressor
+	takeover bool
+	buf      bytes.Buffer
+}
+
+// NewCompressor returns a new Compressor which limits the LZ77 window to the
+// given number of bits. If takeover is false, compression context is reset
+// for each frame. Arguments usually come from the Parameters received from
+// the peer.
+func NewCompressor(bits wsflate.WindowBits, takeover bool) *Compressor {
+	c := &Compressor{
+		takeover: takeover,
+	}
+	if bits.Defined() && bits.Bytes() < wsflate.MaxLZ77WindowSize {
+		c.c = wsflate.NewCompressor(&c.buf, bits, takeover)
+	} else {
+		// As flate.NewWriter() docs says:
+		//   If level is in the range [-2, 9] then the error returned will
+		//   be nil.
+		c.c, _ = flate.NewWriter(&c.buf, flate.BestSpeed)
+	}
+	return c
+}
+
+// Compress compresses p and returns the compressed payload without the
+// trailing sync flush marker. Returned slice is valid until the next call.
+func (c *Compressor) Compress(p []byte) ([]byte, error) {
+	c.reset()
+	if _, err := c.c.Write(p); err != nil {
+		return nil, err
+	}
+	return c.flush()
+}
+
+// reset prepares c to compress the next frame.
+func (c *Compressor) reset() {
+	c.buf.Reset()
+	if !c.takeover {
+		c.c.(wsflate.WriteResetter).Reset(&c.buf)
+	}
+}
+
+// flush returns the payload compressed since the last reset() call without
+// the trailing sync flush marker.
+func (c *Compressor) flush() ([]byte, error) {
+	if err := c.c.Flush(); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(c.buf.Bytes(), compressionTail[:]), nil
+}
+
+// CompressFrame returns a copy of data frame f with compressed payload and
+// the RSV1 bit set. Control frames are returned as is.
+//
+// Note that payload of f must not be masked. Payload of returned frame is
+// valid until the next call.
+func (c *Compressor) CompressFrame(f ws.Frame) (ws.Frame, error) {
+	if !f.Header.OpCode.IsData() {
+		return f, nil
+	}
+	var s FrameState
+	s.SetCompressed(true)
+	h, err := s.SetBits(f.Header)
+	if err != nil {
+		return f, err
+	}
+	p, err := c.Compress(f.Payload)
+	if err != nil {
+		return f, err
+	}
+	h.Length = int64(len(p))
+	return ws.Frame{Header: h, Payload: p}, nil
+}
+
+// Decompressor decompresses payloads of frames one by one.
+type Decompressor struct {
+	r   *wsflate.Reader
+	src bytes.Reader
+	buf bytes.Buffer
+}
+
+// NewDecompressor returns a new Decompressor for frames compressed with the
+// LZ77 window of the given number of bits. If takeover is true, history of
+// decompressed data is kept between frames. Arguments usually come from the
+// Parameters sent to the peer.
+func NewDecompressor(bits wsflate.WindowBits, takeover bool) *Decompressor {
+	d := new(Decompressor)
+	d.r = wsflate.NewReader(nil, func(r io.Reader) wsflate.Decompressor {
+		return wsflate.NewDecompressor(r, bits, takeover)
+	})
+	return d
+}
+
+// SetLimits sets limits applied to the decompressed data of each frame.
+func (d *Decompressor) SetLimits(l wsflate.Limits) {
+	d.r.SetLimits(l)
+}
+
+// Decompress decompresses payload p of a compressed frame. Returned slice is
+// valid until the next call.
+func (d *Decompressor) Decompress(p []byte) ([]byte, error) {
+	d.src.Reset(p)
+	d.r.Reset(&d.src)
+	d.buf.Reset()
+	if _, err := d.buf.ReadFrom(d.r); err != nil {
+		return nil, err
+	}
+	return d.buf.Bytes(), nil
+}
+
+// DecompressFrame returns a copy of frame f with decompressed payload and the
+// RSV1 bit unset. Frames without RSV1 bit set are returned as is.
+//
+// Note that payload of f must be unmasked. Payload of returned frame is valid
+// until the next call.
+func (d *Decompressor) DecompressFrame(f ws.Frame) (ws.Frame, error) {
+	var s FrameState
+	h, err := s.UnsetBits(f.Header)
+	if err != nil || !s.IsCompressed() {
+		return f, err
+	}
+	p, err := d.Decompress(f.Payload)
+	if err != nil {
+		return f, err
+	}
+	h.Length = int64(len(p))
+	return ws.Frame{Header: h, Payload: p}, nil
+}
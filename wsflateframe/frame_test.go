@@ -0,0 +1,122 @@
+package wsflateframe
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+var (
+	_ wsutil.RecvExtension = (*FrameState)(nil)
+	_ wsutil.SendExtension = (*FrameState)(nil)
+)
+
+func TestFrameState(t *testing.T) {
+	var s FrameState
+	s.SetCompressed(true)
+	for _, op := range []ws.OpCode{ws.OpText, ws.OpContinuation} {
+		h, err := s.SetBits(ws.Header{OpCode: op})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.Rsv1() {
+			t.Errorf("%v: compression bit is not set", op)
+		}
+		var r FrameState
+		if h, err = r.UnsetBits(h); err != nil {
+			t.Fatal(err)
+		}
+		if h.Rsv1() || !r.IsCompressed() {
+			t.Errorf("%v: compression bit is not unset", op)
+		}
+	}
+	h, err := s.SetBits(ws.Header{OpCode: ws.OpPing})
+	if err != nil || h.Rsv1() {
+		t.Errorf("unexpected compression of control frame: %v", err)
+	}
+	h.Rsv = ws.Rsv(true, false, false)
+	if _, err = s.UnsetBits(h); err != ErrUnexpectedCompressionBit {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestCompressorRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		bits     wsflate.WindowBits
+		takeover bool
+	}{
+		{0, true},
+		{0, false},
+		{9, true},
+		{9, false},
+	} {
+		name := fmt.Sprintf("bits=%d,takeover=%t", test.bits, test.takeover)
+		t.Run(name, func(t *testing.T) {
+			c := NewCompressor(test.bits, test.takeover)
+			d := NewDecompressor(test.bits, test.takeover)
+			// Payload has no repetitions to be found without context takeover.
+			payload := make([]byte, 512)
+			rnd := rand.New(rand.NewSource(42))
+			for i := range payload {
+				payload[i] = 'a' + byte(rnd.Intn(26))
+			}
+			var first int
+			for i := 0; i < 3; i++ {
+				frame := ws.NewFrame(ws.OpText, i == 2, payload)
+				if i > 0 {
+					frame.Header.OpCode = ws.OpContinuation
+				}
+				cf, err := c.CompressFrame(frame)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !cf.Header.Rsv1() {
+					t.Fatalf("compression bit is not set")
+				}
+				n := len(cf.Payload)
+				switch {
+				case i == 0:
+					first = n
+				case test.takeover && n >= first:
+					t.Errorf("context is not taken over: %d bytes; first %d", n, first)
+				case !test.takeover && n != first:
+					t.Errorf("context is taken over: %d bytes; first %d", n, first)
+				}
+
+				df, err := d.DecompressFrame(cf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if df.Header.Rsv1() || df.Header.OpCode != frame.Header.OpCode {
+					t.Errorf("unexpected header: %+v", df.Header)
+				}
+				if !bytes.Equal(df.Payload, frame.Payload) {
+					t.Errorf("unexpected payload: %q", df.Payload)
+				}
+			}
+		})
+	}
+}
+
+func TestDecompressorLimits(t *testing.T) {
+	c := NewCompressor(0, false)
+	d := NewDecompressor(0, false)
+	d.SetLimits(wsflate.Limits{
+		MaxMessageSize: 100,
+	})
+	p, err := c.Compress(make([]byte, 1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Decompress(p); err == nil {
+		t.Fatalf("no error for exceeded limit")
+	}
+	if _, ok := err.(*wsflate.LimitError); !ok {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
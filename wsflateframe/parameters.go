@@ -0,0 +1,134 @@
+// Package wsflateframe implements the legacy per-frame compression extension
+// known as "deflate-frame" and "x-webkit-deflate-frame".
+//
+// Unlike permessage-deflate (see wsflate package), the extension compresses
+// each data frame on its own: every frame with the RSV1 bit set carries
+// deflate data terminated by the sync flush marker, which is removed before
+// sending. Both extensions use the RSV1 bit, thus only one of them can be
+// negotiated for a connection. Use Negotiator to negotiate them together.
+// Negotiated extension is applied by Transform, which importing this package
+// registers within wsutil.HandshakeChain().
+//
+// See https://tools.ietf.org/html/draft-tyoshino-hybi-websocket-perframe-deflate
+package wsflateframe
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws/wsflate"
+)
+
+const (
+	ExtensionName       = "deflate-frame"
+	WebkitExtensionName = "x-webkit-deflate-frame"
+
+	noContextTakeover = "no_context_takeover"
+	maxWindowBits     = "max_window_bits"
+)
+
+var (
+	ExtensionNameBytes       = []byte(ExtensionName)
+	WebkitExtensionNameBytes = []byte(WebkitExtensionName)
+
+	noContextTakeoverBytes = []byte(noContextTakeover)
+	maxWindowBitsBytes     = []byte(maxWindowBits)
+)
+
+// Parameters contains per-frame compression extension options.
+//
+// Parameters are always sent to restrict the peer's compression: those of
+// the client offer restrict the server and those of the server response
+// restrict the client.
+type Parameters struct {
+	// NoContextTakeover asks peer to reset compression context after each
+	// frame.
+	NoContextTakeover bool
+
+	// MaxWindowBits asks peer to limit the LZ77 window size.
+	MaxWindowBits wsflate.WindowBits
+}
+
+// Parse reads parameters from given HTTP header option. The option name is
+// not checked.
+//
+// It returns non-nil error if option contains unknown parameters, parameters
+// with invalid values or duplicate parameters.
+func (p *Parameters) Parse(opt httphead.Option) (err error) {
+	const (
+		noContextTakeoverSeen = 1 << iota
+		maxWindowBitsSeen
+	)
+
+	// Reset to not mix parsed data from previous Parse() calls.
+	*p = Parameters{}
+
+	var seen byte
+	opt.Parameters.ForEach(func(key, val []byte) (ok bool) {
+		switch string(key) {
+		case noContextTakeover:
+			if len(val) > 0 {
+				err = paramError("invalid", key, val)
+				return false
+			}
+			if seen&noContextTakeoverSeen != 0 {
+				err = paramError("duplicate", key, val)
+				return false
+			}
+			seen |= noContextTakeoverSeen
+			p.NoContextTakeover = true
+
+		case maxWindowBits:
+			if seen&maxWindowBitsSeen != 0 {
+				err = paramError("duplicate", key, val)
+				return false
+			}
+			seen |= maxWindowBitsSeen
+			n, ok := httphead.IntFromASCII(val)
+			if !ok || n < 8 || n > 15 {
+				err = paramError("invalid", key, val)
+				return false
+			}
+			p.MaxWindowBits = wsflate.WindowBits(n)
+
+		default:
+			err = paramError("unexpected", key, val)
+			return false
+		}
+		return true
+	})
+	return err
+}
+
+// Option encodes parameters into HTTP header option with ExtensionName.
+func (p Parameters) Option() httphead.Option {
+	opt := httphead.Option{
+		Name: ExtensionNameBytes,
+	}
+	if p.NoContextTakeover {
+		opt.Parameters.Set(noContextTakeoverBytes, nil)
+	}
+	if p.MaxWindowBits.Defined() {
+		bits := strconv.Itoa(int(p.MaxWindowBits))
+		opt.Parameters.Set(maxWindowBitsBytes, []byte(bits))
+	}
+	return opt
+}
+
+// IsExtension reports whether name is one of the per-frame compression
+// extension names.
+func IsExtension(name []byte) bool {
+	switch string(name) {
+	case ExtensionName, WebkitExtensionName:
+		return true
+	}
+	return false
+}
+
+func paramError(reason string, key, val []byte) error {
+	return fmt.Errorf(
+		"wsflateframe: %s extension parameter %q: %q",
+		reason, key, val,
+	)
+}
@@ -0,0 +1,76 @@
+package wsflateframe
+
+import (
+	"testing"
+
+	"github.com/gobwas/httphead"
+)
+
+func TestParameters(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		params map[string]string
+		exp    Parameters
+		err    bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "all",
+			params: map[string]string{
+				noContextTakeover: "",
+				maxWindowBits:     "10",
+			},
+			exp: Parameters{
+				NoContextTakeover: true,
+				MaxWindowBits:     10,
+			},
+		},
+		{
+			name: "invalid bits",
+			params: map[string]string{
+				maxWindowBits: "16",
+			},
+			err: true,
+		},
+		{
+			name: "invalid takeover",
+			params: map[string]string{
+				noContextTakeover: "1",
+			},
+			err: true,
+		},
+		{
+			name: "unexpected",
+			params: map[string]string{
+				"client_max_window_bits": "",
+			},
+			err: true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var act Parameters
+			err := act.Parse(httphead.NewOption(ExtensionName, test.params))
+			if test.err {
+				if err == nil {
+					t.Fatalf("want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if act != test.exp {
+				t.Fatalf("unexpected parameters: %+v; want %+v", act, test.exp)
+			}
+			// Encoded parameters must be parsed back to the same value.
+			if err := act.Parse(test.exp.Option()); err != nil {
+				t.Fatal(err)
+			}
+			if act != test.exp {
+				t.Errorf("unexpected parsed option: %+v; want %+v", act, test.exp)
+			}
+		})
+	}
+}
@@ -0,0 +1,261 @@
+package wsflateframe
+
+import (
+	"io"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+	"github.com/gobwas/ws/wsutil"
+)
+
+func init() {
+	wsutil.RegisterExtension(ExtensionName, newHandshakeTransform)
+	wsutil.RegisterExtension(WebkitExtensionName, newHandshakeTransform)
+}
+
+// Transform applies per-frame compression to message payloads. It implements
+// wsutil.Extension, wsutil.RecvTransform and wsutil.SendTransform interfaces,
+// so it could be used within wsutil.Chain.
+//
+// Each frame of received messages is decompressed on its own. Payload of sent
+// message is compressed into a single frame, which is written at the end of
+// message.
+//
+// Importing this package registers Transform for both extension names within
+// wsutil.HandshakeChain(). Note that ws.Handshake keeps only parameters of the
+// server's response, which restrict compression of the client. That is, on the
+// server side frames are sent uncompressed; use NewTransform() with
+// parameters returned by Extension.Accepted() to compress them.
+type Transform struct {
+	c    *Compressor // Nil if sent frames are not compressed.
+	d    *wsflate.Reader
+	bits wsflate.WindowBits // Window of the peer's compressor.
+	keep bool               // Whether the peer keeps compression context.
+
+	recv  FrameState
+	hdr   ws.Header // Header of the last received data frame.
+	fresh bool      // Whether hdr was not consumed by the reader yet.
+	r     frameReader
+
+	send FrameState
+	w    frameWriter
+}
+
+// NewTransform returns a Transform which compresses sent frames following
+// restrictions p received from the peer (see Extension.Accepted() and
+// ClientExtension.Accepted()). Received frames are decompressed with the
+// maximum window and context takeover, which fits any parameters sent to the
+// peer.
+func NewTransform(p Parameters) *Transform {
+	t := newTransform(Parameters{})
+	t.c = NewCompressor(p.MaxWindowBits, !p.NoContextTakeover)
+	return t
+}
+
+// newTransform returns a Transform which doesn't compress sent frames and
+// decompresses received frames accordingly to parameters p sent to the peer.
+func newTransform(p Parameters) *Transform {
+	t := &Transform{
+		bits: p.MaxWindowBits,
+		keep: !p.NoContextTakeover,
+	}
+	t.r.t = t
+	t.w.t = t
+	return t
+}
+
+func newHandshakeTransform(opt httphead.Option, state ws.State) (wsutil.Extension, error) {
+	var p Parameters
+	if err := p.Parse(opt); err != nil {
+		return nil, err
+	}
+	if state.ServerSide() {
+		// Parameters of the response restrict compression of the client.
+		return newTransform(p), nil
+	}
+	return NewTransform(p), nil
+}
+
+// Rsv returns the RSV1 bit, which marks compressed frames.
+func (t *Transform) Rsv() byte {
+	return ws.Rsv(true, false, false)
+}
+
+// UnsetBits updates the state of received frame accordingly to its header h.
+// See FrameState.UnsetBits().
+func (t *Transform) UnsetBits(h ws.Header) (_ ws.Header, err error) {
+	h, err = t.recv.UnsetBits(h)
+	if err == nil && h.OpCode.IsData() {
+		t.hdr = h
+		t.fresh = true
+	}
+	return h, err
+}
+
+// SetBits sets the RSV1 bit of the sent data frame header h if it carries
+// compressed payload. See FrameState.SetBits().
+func (t *Transform) SetBits(h ws.Header) (_ ws.Header, err error) {
+	s := t.send
+	if h.Length == 0 {
+		// Empty frames carry no compressed data.
+		s.SetCompressed(false)
+	}
+	h, err = s.SetBits(h)
+	if err == nil && h.Fin && h.OpCode.IsData() {
+		t.send.SetCompressed(false)
+	}
+	return h, err
+}
+
+// TransformReader returns a reader of payload read from r with compressed
+// frames decompressed.
+func (t *Transform) TransformReader(r io.Reader) io.Reader {
+	t.r.src.r = r
+	t.r.begin(nil)
+	return &t.r
+}
+
+// TransformWriter returns a writer which compresses the payload of the sent
+// message into w. Returned writer is valid until the next call.
+func (t *Transform) TransformWriter(w io.Writer) io.WriteCloser {
+	t.w.w = w
+	if t.c != nil {
+		t.c.reset()
+		t.send.SetCompressed(true)
+	}
+	return &t.w
+}
+
+func (t *Transform) decompressor() *wsflate.Reader {
+	if t.d == nil {
+		t.d = wsflate.NewReader(nil, func(r io.Reader) wsflate.Decompressor {
+			return wsflate.NewDecompressor(r, t.bits, t.keep)
+		})
+	}
+	return t.d
+}
+
+// frameReader reads payload of received message frame by frame.
+type frameReader struct {
+	t   *Transform
+	src frameSource
+	cur io.Reader // Reader of the current frame; nil if it was read.
+	fin bool
+	buf [512]byte
+}
+
+// begin starts reading of the frame which header was passed to UnsetBits()
+// last. Pre is the beginning of the frame payload which is already read.
+func (r *frameReader) begin(pre []byte) {
+	h := r.t.hdr
+	r.t.fresh = false
+	r.fin = h.Fin
+	r.src.pre = pre
+	r.src.n = h.Length - int64(len(pre))
+	r.cur = &r.src
+	if r.t.recv.IsCompressed() {
+		d := r.t.decompressor()
+		d.Reset(&r.src)
+		r.cur = d
+	}
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	for {
+		if r.cur == nil {
+			if r.fin {
+				return 0, io.EOF
+			}
+			if err := r.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err := r.cur.Read(p)
+		if err == io.EOF {
+			r.cur = nil
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, err
+		}
+	}
+}
+
+// next reads the beginning of the next frame of the message. Reading from the
+// message payload makes wsutil.Reader to read the frame header, which is then
+// passed to UnsetBits().
+func (r *frameReader) next() error {
+	for {
+		n, err := r.src.r.Read(r.buf[:])
+		if r.t.fresh {
+			r.begin(r.buf[:n])
+			if err == io.EOF {
+				// The whole final frame is read.
+				err = nil
+			}
+			return err
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// frameSource reads payload of a single frame.
+type frameSource struct {
+	r   io.Reader
+	pre []byte // Already read part of the payload.
+	n   int64  // Bytes left to read from r.
+}
+
+func (s *frameSource) Read(p []byte) (n int, err error) {
+	if len(s.pre) > 0 {
+		n = copy(p, s.pre)
+		s.pre = s.pre[n:]
+		return n, nil
+	}
+	if s.n <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > s.n {
+		p = p[:s.n]
+	}
+	n, err = s.r.Read(p)
+	s.n -= int64(n)
+	if err == io.EOF && s.n > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// frameWriter is a writer returned by Transform.TransformWriter().
+type frameWriter struct {
+	t *Transform
+	w io.Writer
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if w.t.c == nil {
+		return w.w.Write(p)
+	}
+	return w.t.c.c.Write(p)
+}
+
+// Close writes compressed payload as a single frame.
+func (w *frameWriter) Close() error {
+	if w.t.c == nil {
+		return nil
+	}
+	p, err := w.t.c.flush()
+	if err == nil {
+		_, err = w.w.Write(p)
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package wsflateframe
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+)
+
+var _ wsutil.Extension = (*Transform)(nil)
+
+func TestTransformHandshakeChain(t *testing.T) {
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			Parameters{MaxWindowBits: 10}.Option(),
+		},
+	}
+	server, err := wsutil.HandshakeChain(hs, ws.StateServerSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := wsutil.HandshakeChain(hs, ws.StateClientSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name       string
+		from, to   *wsutil.Chain
+		state      ws.State
+		compressed bool
+	}{
+		{"client", client, server, ws.StateClientSide, true},
+		{"server", server, client, ws.StateServerSide, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var msg []byte
+			for i := 0; i < 100; i++ {
+				msg = strconv.AppendInt(append(msg, "hello, frame #"...), int64(i), 10)
+			}
+			for i := 0; i < 3; i++ {
+				var buf bytes.Buffer
+				// Small buffer makes messages fragmented.
+				wr := wsutil.NewWriterSize(&buf, test.state|ws.StateExtended, ws.OpText, 64)
+				wr.SetExtensions(test.from)
+				if _, err := wr.Write(msg); err != nil {
+					t.Fatal(err)
+				}
+				if err := wr.Flush(); err != nil {
+					t.Fatal(err)
+				}
+				if n := buf.Len(); (n < len(msg)) != test.compressed {
+					t.Errorf("#%d: unexpected message size: %d", i, n)
+				}
+				rd := wsutil.Reader{
+					Source:     &buf,
+					State:      (test.state ^ (ws.StateClientSide | ws.StateServerSide)) | ws.StateExtended,
+					CheckUTF8:  true,
+					Extensions: []wsutil.RecvExtension{test.to},
+				}
+				if _, err := rd.NextFrame(); err != nil {
+					t.Fatal(err)
+				}
+				act, err := ioutil.ReadAll(&rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(act, msg) {
+					t.Errorf("#%d: unexpected message: %q", i, act)
+				}
+			}
+		})
+	}
+}
+
+func TestTransformReadFrames(t *testing.T) {
+	c := NewCompressor(0, true)
+	compress := func(f ws.Frame) ws.Frame {
+		f, err := c.CompressFrame(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Payload is valid until the next call.
+		f.Payload = append([]byte(nil), f.Payload...)
+		return f
+	}
+	var buf bytes.Buffer
+	ws.MustWriteFrame(&buf, compress(ws.NewFrame(ws.OpText, false, []byte("hello, "))))
+	ws.MustWriteFrame(&buf, ws.NewPingFrame([]byte("ping")))
+	ws.MustWriteFrame(&buf, ws.NewFrame(ws.OpContinuation, false, []byte("big ")))
+	ws.MustWriteFrame(&buf, ws.NewFrame(ws.OpContinuation, false, nil))
+	ws.MustWriteFrame(&buf, compress(ws.NewFrame(ws.OpContinuation, false, []byte("hello, "))))
+	ws.MustWriteFrame(&buf, compress(ws.NewFrame(ws.OpContinuation, true, []byte("world!"))))
+
+	var pings int
+	rd := wsutil.Reader{
+		Source:     &buf,
+		State:      ws.StateClientSide | ws.StateExtended,
+		Extensions: []wsutil.RecvExtension{NewTransform(Parameters{})},
+		OnIntermediate: func(h ws.Header, r io.Reader) error {
+			pings++
+			_, err := ioutil.ReadAll(r)
+			return err
+		},
+	}
+	if _, err := rd.NextFrame(); err != nil {
+		t.Fatal(err)
+	}
+	act, err := ioutil.ReadAll(&rd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := "hello, big hello, world!"; string(act) != exp {
+		t.Errorf("unexpected message: %q; want %q", act, exp)
+	}
+	if pings != 1 {
+		t.Errorf("unexpected number of intermediate frames: %d", pings)
+	}
+}
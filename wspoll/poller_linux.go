@@ -0,0 +1,100 @@
+package wspoll
+
+import "syscall"
+
+// events is the set of epoll events each connection is registered with.
+// EPOLLONESHOT disables the connection after the notification until it is
+// re-armed by rearm().
+const events = syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT
+
+// poller is a thin wrapper around epoll instance.
+type poller struct {
+	fd     int
+	wfd    [2]int // Pipe used to wake up wait().
+	events []syscall.EpollEvent
+}
+
+func newPoller(n int) (*poller, error) {
+	fd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &poller{
+		fd:     fd,
+		events: make([]syscall.EpollEvent, n),
+	}
+	if err = syscall.Pipe2(p.wfd[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	err = syscall.EpollCtl(fd, syscall.EPOLL_CTL_ADD, p.wfd[0], &syscall.EpollEvent{
+		Events: syscall.EPOLLIN,
+		Fd:     int32(p.wfd[0]),
+	})
+	if err != nil {
+		p.close()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *poller) add(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+func (p *poller) rearm(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (p *poller) remove(fd int) error {
+	// NOTE: event argument is ignored for EPOLL_CTL_DEL, but kernels before
+	// 2.6.9 require it to be non-nil.
+	return syscall.EpollCtl(p.fd, syscall.EPOLL_CTL_DEL, fd, &syscall.EpollEvent{})
+}
+
+func (p *poller) ctl(op, fd int) error {
+	return syscall.EpollCtl(p.fd, op, fd, &syscall.EpollEvent{
+		Events: events,
+		Fd:     int32(fd),
+	})
+}
+
+// wait waits for notifications and calls fn for each ready descriptor until
+// fn returns false or wakeup() is called. It returns non-nil error only if
+// waiting failed.
+func (p *poller) wait(fn func(fd int) bool) error {
+	for {
+		n, err := syscall.EpollWait(p.fd, p.events, -1)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for i := 0; i < n; i++ {
+			fd := int(p.events[i].Fd)
+			if fd == p.wfd[0] {
+				return nil
+			}
+			if !fn(fd) {
+				return nil
+			}
+		}
+	}
+}
+
+// wakeup makes wait() return.
+func (p *poller) wakeup() error {
+	_, err := syscall.Write(p.wfd[1], []byte{0})
+	if err == syscall.EAGAIN {
+		// Pipe is full, thus wait() will be woken up anyway.
+		err = nil
+	}
+	return err
+}
+
+func (p *poller) close() error {
+	syscall.Close(p.wfd[0])
+	syscall.Close(p.wfd[1])
+	return syscall.Close(p.fd)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package wspoll
+
+type poller struct{}
+
+func newPoller(int) (*poller, error) {
+	return nil, ErrNotSupported
+}
+
+func (p *poller) add(int) error             { return ErrNotSupported }
+func (p *poller) rearm(int) error           { return ErrNotSupported }
+func (p *poller) remove(int) error          { return ErrNotSupported }
+func (p *poller) wait(func(int) bool) error { return ErrNotSupported }
+func (p *poller) wakeup() error             { return ErrNotSupported }
+func (p *poller) close() error              { return ErrNotSupported }
@@ -0,0 +1,445 @@
+// Package wspoll implements a readiness notification reactor for upgraded
+// WebSocket connections.
+//
+// It allows to serve lots of mostly idle connections without a goroutine
+// (and its stack) per connection: instead of blocking in Read(), connection is
+// registered in the Reactor, which calls a handler only when there is some
+// data to read. Handlers are run by a bounded pool of worker goroutines.
+//
+// Currently only Linux (epoll) is supported. New() returns ErrNotSupported on
+// other platforms.
+//
+// Typical usage is:
+//
+//	r, err := wspoll.New(nil)
+//	if err != nil {
+//		// handle error.
+//	}
+//	conn, err := ln.Accept()
+//	if err != nil {
+//		// handle error.
+//	}
+//	if _, err := ws.Upgrade(conn); err != nil {
+//		// handle error.
+//	}
+//	err = r.Add(conn, func(conn net.Conn) {
+//		msg, op, err := wsutil.ReadClientData(conn)
+//		if err != nil {
+//			r.Remove(conn)
+//			conn.Close()
+//			return
+//		}
+//		wsutil.WriteServerMessage(conn, op, msg)
+//	})
+//
+// Note that readiness is tracked on the socket level. That is, handler must
+// not buffer the connection reads beyond the data it processes (e.g. with
+// bufio.Reader) since buffered data doesn't make connection readable again.
+// ws.ReadHeader() and wsutil.Reader read exactly the bytes of the frames they
+// return.
+package wspoll
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+)
+
+// Errors used by the Reactor.
+var (
+	ErrNotSupported = errors.New("wspoll: not supported on this platform")
+	ErrClosed       = errors.New("wspoll: reactor closed")
+	ErrRegistered   = errors.New("wspoll: connection already registered")
+	ErrNotFound     = errors.New("wspoll: connection not registered")
+	ErrNotOneShot   = errors.New("wspoll: connection is not registered as one-shot")
+	ErrNoDescriptor = errors.New("wspoll: connection has no file descriptor")
+)
+
+// Handler is called by the Reactor when registered connection becomes
+// readable (or is closed by the peer).
+type Handler func(conn net.Conn)
+
+// Config contains options of the Reactor.
+type Config struct {
+	// Workers is the maximum number of handlers run concurrently.
+	// If zero, DefaultWorkers is used.
+	Workers int
+
+	// Queue is the maximum number of readable connections waiting for a free
+	// worker. When queue is full, Reactor stops receiving readiness
+	// notifications until some worker becomes free.
+	// If zero, Workers value is used.
+	Queue int
+
+	// Events is the maximum number of readiness notifications received from
+	// the operating system at once.
+	// If zero, DefaultEvents is used.
+	Events int
+
+	// OnError is an optional callback which is called on errors which can't
+	// be returned to the caller, e.g. when connection could not be re-armed
+	// after the handler returns. Such connection is removed from the Reactor.
+	OnError func(conn net.Conn, err error)
+}
+
+// Default values used when Config fields are zero.
+const (
+	DefaultWorkers = 256
+	DefaultEvents  = 128
+)
+
+func (c *Config) workers() int {
+	if c != nil && c.Workers > 0 {
+		return c.Workers
+	}
+	return DefaultWorkers
+}
+
+func (c *Config) queue() int {
+	if c != nil && c.Queue > 0 {
+		return c.Queue
+	}
+	return c.workers()
+}
+
+func (c *Config) events() int {
+	if c != nil && c.Events > 0 {
+		return c.Events
+	}
+	return DefaultEvents
+}
+
+// desc describes registered connection.
+type desc struct {
+	fd      int
+	conn    net.Conn
+	handler Handler
+	oneShot bool
+
+	mu      sync.Mutex
+	armed   bool // Notification is expected from the poller.
+	running bool // Handler is scheduled or running.
+	resume  bool // Resume() was called while handler was running.
+	removed bool
+}
+
+// Reactor calls handlers of registered connections when they become
+// readable.
+//
+// Each connection is registered in one-shot mode: after notification is
+// received, connection is not watched until its handler returns. Thus at most
+// one handler is run for a connection at a time. By default, connection is
+// re-armed right after its handler returns; connections registered with
+// AddOneShot() must be re-armed explicitly with Resume().
+//
+// Readable connections are queued and served by workers in the order they
+// became readable. Since connection is queued again only after its handler
+// returns, busy connections can't starve the others.
+type Reactor struct {
+	poller  *poller
+	onError func(net.Conn, error)
+
+	mu    sync.Mutex
+	descs map[int]*desc
+
+	work    chan *desc    // Queue of readable connections.
+	sem     chan struct{} // Limits the number of workers.
+	workers sync.WaitGroup
+	done    chan struct{}
+	loop    chan struct{} // Closed when polling loop exits.
+	once    sync.Once
+	err     error
+}
+
+// New creates and starts new Reactor configured by c. Nil c means default
+// configuration.
+func New(c *Config) (*Reactor, error) {
+	p, err := newPoller(c.events())
+	if err != nil {
+		return nil, err
+	}
+	r := &Reactor{
+		poller: p,
+		descs:  make(map[int]*desc),
+		work:   make(chan *desc, c.queue()),
+		sem:    make(chan struct{}, c.workers()),
+		done:   make(chan struct{}),
+		loop:   make(chan struct{}),
+	}
+	if c != nil {
+		r.onError = c.OnError
+	}
+	go r.wait()
+	return r, nil
+}
+
+// Add registers conn in the Reactor. The handler h is called each time conn
+// becomes readable; conn is re-armed after h returns.
+//
+// Connection must be removed with Remove() before it is closed.
+func (r *Reactor) Add(conn net.Conn, h Handler) error {
+	return r.add(conn, h, false)
+}
+
+// AddOneShot is like Add() but conn is not re-armed after h returns. Resume()
+// must be called to receive the next notification. It allows to continue
+// message processing asynchronously, outside of the handler.
+func (r *Reactor) AddOneShot(conn net.Conn, h Handler) error {
+	return r.add(conn, h, true)
+}
+
+// Resume re-arms conn registered with AddOneShot(), so its handler is called
+// when conn becomes readable again. It does nothing if conn is already armed.
+// If it is called while the handler is being run, conn is re-armed after the
+// handler returns.
+func (r *Reactor) Resume(conn net.Conn) error {
+	d, err := r.lookup(conn)
+	if err != nil {
+		return err
+	}
+	if !d.oneShot {
+		return ErrNotOneShot
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	switch {
+	case d.removed:
+		return ErrNotFound
+	case d.armed:
+		return nil
+	case d.running:
+		d.resume = true
+		return nil
+	case r.closed():
+		return ErrClosed
+	}
+	if err := r.poller.rearm(d.fd); err != nil {
+		return err
+	}
+	d.armed = true
+	return nil
+}
+
+// Remove deregisters conn. Handler of conn is not called after Remove()
+// returns, unless it is already being run.
+//
+// Note that Remove() doesn't close conn.
+func (r *Reactor) Remove(conn net.Conn) error {
+	d, err := r.lookup(conn)
+	if err != nil {
+		return err
+	}
+	return r.remove(d)
+}
+
+// Close stops the Reactor and releases its resources. Registered connections
+// are not closed; handlers being run are not interrupted, but Close waits for
+// them to return. Thus Close must not be called from a handler. Subsequent
+// calls return ErrClosed.
+func (r *Reactor) Close() (err error) {
+	err = ErrClosed
+	r.once.Do(func() {
+		r.mu.Lock()
+		close(r.done)
+		r.descs = nil
+		r.mu.Unlock()
+
+		if err = r.poller.wakeup(); err != nil {
+			return
+		}
+		<-r.loop
+		// Workers use the poller to re-arm connections.
+		r.workers.Wait()
+		err = r.poller.close()
+	})
+	return err
+}
+
+// Err returns an error which stopped the Reactor, if any.
+func (r *Reactor) Err() error {
+	select {
+	case <-r.loop:
+		return r.err
+	default:
+		return nil
+	}
+}
+
+func (r *Reactor) add(conn net.Conn, h Handler, oneShot bool) error {
+	fd, err := fileDescriptor(conn)
+	if err != nil {
+		return err
+	}
+	d := &desc{
+		fd:      fd,
+		conn:    conn,
+		handler: h,
+		oneShot: oneShot,
+		armed:   true,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.descs == nil {
+		return ErrClosed
+	}
+	if prev := r.descs[fd]; prev != nil && prev.conn == conn {
+		return ErrRegistered
+	}
+	// NOTE: descriptor might be left in the map if connection was closed
+	// without Remove(). In that case the descriptor number might be reused,
+	// and the kernel already removed it from the poller.
+	if err := r.poller.add(fd); err != nil {
+		return err
+	}
+	r.descs[fd] = d
+	return nil
+}
+
+func (r *Reactor) remove(d *desc) error {
+	r.mu.Lock()
+	if r.descs[d.fd] == d {
+		delete(r.descs, d.fd)
+	}
+	r.mu.Unlock()
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.removed {
+		return ErrNotFound
+	}
+	d.removed = true
+	return r.poller.remove(d.fd)
+}
+
+func (r *Reactor) lookup(conn net.Conn) (*desc, error) {
+	fd, err := fileDescriptor(conn)
+	if err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.descs == nil {
+		return nil, ErrClosed
+	}
+	d := r.descs[fd]
+	if d == nil || d.conn != conn {
+		return nil, ErrNotFound
+	}
+	return d, nil
+}
+
+// wait is the polling loop.
+func (r *Reactor) wait() {
+	defer close(r.loop)
+	r.err = r.poller.wait(func(fd int) bool {
+		r.mu.Lock()
+		d := r.descs[fd]
+		r.mu.Unlock()
+		if d == nil {
+			return true
+		}
+		d.mu.Lock()
+		ok := d.armed && !d.removed
+		d.armed = false
+		d.running = ok
+		d.mu.Unlock()
+		if ok {
+			return r.schedule(d)
+		}
+		return true
+	})
+}
+
+// schedule passes d to a worker. It blocks if all workers are busy and the
+// queue is full. It returns false if reactor was closed.
+func (r *Reactor) schedule(d *desc) bool {
+	if r.closed() {
+		return false
+	}
+	select {
+	case r.sem <- struct{}{}:
+		r.workers.Add(1)
+		go r.worker(d)
+		return true
+	default:
+	}
+	select {
+	case r.work <- d:
+		return true
+	case <-r.done:
+		return false
+	}
+}
+
+func (r *Reactor) worker(d *desc) {
+	defer func() {
+		<-r.sem
+		r.workers.Done()
+	}()
+	for {
+		r.serve(d)
+		select {
+		case d = <-r.work:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+func (r *Reactor) serve(d *desc) {
+	d.mu.Lock()
+	removed := d.removed
+	d.mu.Unlock()
+	if removed {
+		// Connection was removed while waiting in the queue.
+		return
+	}
+
+	d.handler(d.conn)
+
+	d.mu.Lock()
+	var err error
+	if !d.removed && (!d.oneShot || d.resume) && !r.closed() {
+		if err = r.poller.rearm(d.fd); err == nil {
+			d.armed = true
+		}
+	}
+	d.running = false
+	d.resume = false
+	d.mu.Unlock()
+
+	if err != nil {
+		// Most likely connection was closed without Remove() call.
+		r.remove(d)
+		if r.onError != nil {
+			r.onError(d.conn, err)
+		}
+	}
+}
+
+// closed reports whether Close() was called.
+func (r *Reactor) closed() bool {
+	select {
+	case <-r.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func fileDescriptor(conn net.Conn) (fd int, err error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return 0, ErrNoDescriptor
+	}
+	rc, err := sc.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	err = rc.Control(func(v uintptr) {
+		fd = int(v)
+	})
+	return fd, err
+}
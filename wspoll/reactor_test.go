@@ -0,0 +1,331 @@
+//go:build linux
+// +build linux
+
+package wspoll
+
+import (
+	"bytes"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws"
+)
+
+func TestReactorEcho(t *testing.T) {
+	r := newReactor(t, &Config{
+		Workers: 4,
+	})
+	defer closeReactor(t, r)
+	var clients []net.Conn
+	for i := 0; i < 16; i++ {
+		server, client := connPair(t)
+		err := r.Add(server, func(conn net.Conn) {
+			f, err := ws.ReadFrame(conn)
+			if err != nil {
+				t.Error(err)
+				r.Remove(conn)
+				return
+			}
+			if err := ws.WriteFrame(conn, f); err != nil {
+				t.Error(err)
+			}
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		clients = append(clients, client)
+	}
+	for i := 0; i < 3; i++ {
+		for j, client := range clients {
+			msg := []byte{byte(i), byte(j)}
+			if err := ws.WriteFrame(client, ws.NewBinaryFrame(msg)); err != nil {
+				t.Fatal(err)
+			}
+			f := readFrame(t, client)
+			if !bytes.Equal(f.Payload, msg) {
+				t.Errorf("unexpected echo: %v; want %v", f.Payload, msg)
+			}
+		}
+	}
+}
+
+func TestReactorWorkers(t *testing.T) {
+	const workers = 2
+	var (
+		running int32
+		max     int32
+		calls   = make(chan struct{}, 16)
+	)
+	r := newReactor(t, &Config{
+		Workers: workers,
+		Queue:   1,
+	})
+	defer closeReactor(t, r)
+	handler := func(conn net.Conn) {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			m := atomic.LoadInt32(&max)
+			if n <= m || atomic.CompareAndSwapInt32(&max, m, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		if _, err := ws.ReadFrame(conn); err != nil {
+			t.Error(err)
+		}
+		calls <- struct{}{}
+	}
+	for i := 0; i < cap(calls); i++ {
+		server, client := connPair(t)
+		if err := r.Add(server, handler); err != nil {
+			t.Fatal(err)
+		}
+		if err := ws.WriteFrame(client, ws.NewPingFrame(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < cap(calls); i++ {
+		waitCall(t, calls)
+	}
+	if m := atomic.LoadInt32(&max); m > workers {
+		t.Errorf("unexpected number of concurrent handlers: %d; want at most %d", m, workers)
+	}
+}
+
+func TestReactorOneShot(t *testing.T) {
+	r := newReactor(t, nil)
+	defer closeReactor(t, r)
+	calls := make(chan struct{}, 2)
+	server, client := connPair(t)
+	err := r.AddOneShot(server, func(conn net.Conn) {
+		if _, err := ws.ReadFrame(conn); err != nil {
+			t.Error(err)
+		}
+		calls <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := ws.WriteFrame(client, ws.NewPingFrame(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitCall(t, calls)
+	noCall(t, calls)
+
+	if err := r.Resume(server); err != nil {
+		t.Fatal(err)
+	}
+	waitCall(t, calls)
+
+	if err := r.Add(server, nil); err != ErrRegistered {
+		t.Errorf("unexpected Add() error: %v; want %v", err, ErrRegistered)
+	}
+	other, _ := connPair(t)
+	if err := r.Add(other, func(net.Conn) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Resume(other); err != ErrNotOneShot {
+		t.Errorf("unexpected Resume() error: %v; want %v", err, ErrNotOneShot)
+	}
+}
+
+func TestReactorResumeFromHandler(t *testing.T) {
+	r := newReactor(t, nil)
+	defer closeReactor(t, r)
+	calls := make(chan struct{}, 3)
+	server, client := connPair(t)
+	err := r.AddOneShot(server, func(conn net.Conn) {
+		if _, err := ws.ReadFrame(conn); err != nil {
+			t.Error(err)
+		}
+		// Resume() made before handler returns must not be lost.
+		if err := r.Resume(conn); err != nil {
+			t.Error(err)
+		}
+		calls <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cap(calls); i++ {
+		if err := ws.WriteFrame(client, ws.NewPingFrame(nil)); err != nil {
+			t.Fatal(err)
+		}
+		waitCall(t, calls)
+	}
+}
+
+func TestReactorRemove(t *testing.T) {
+	r := newReactor(t, nil)
+	defer closeReactor(t, r)
+	calls := make(chan struct{}, 1)
+	server, client := connPair(t)
+	err := r.Add(server, func(net.Conn) {
+		calls <- struct{}{}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Remove(server); err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteFrame(client, ws.NewPingFrame(nil)); err != nil {
+		t.Fatal(err)
+	}
+	noCall(t, calls)
+
+	if err := r.Remove(server); err != ErrNotFound {
+		t.Errorf("unexpected Remove() error: %v; want %v", err, ErrNotFound)
+	}
+	if err := r.Resume(server); err != ErrNotFound {
+		t.Errorf("unexpected Resume() error: %v; want %v", err, ErrNotFound)
+	}
+}
+
+func TestReactorClose(t *testing.T) {
+	r, err := New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, _ := connPair(t)
+	if err := r.Add(server, func(net.Conn) {}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Err(); err != nil {
+		t.Errorf("unexpected Err(): %v", err)
+	}
+	if err := r.Close(); err != ErrClosed {
+		t.Errorf("unexpected Close() error: %v; want %v", err, ErrClosed)
+	}
+	if err := r.Add(server, func(net.Conn) {}); err != ErrClosed {
+		t.Errorf("unexpected Add() error: %v; want %v", err, ErrClosed)
+	}
+	if err := r.Add(&net.UnixConn{}, func(net.Conn) {}); err == nil {
+		t.Errorf("expected Add() error for not connected conn")
+	}
+}
+
+func TestReactorCloseRunningHandler(t *testing.T) {
+	errs := make(chan error, 1)
+	r := newReactor(t, &Config{
+		OnError: func(_ net.Conn, err error) {
+			errs <- err
+		},
+	})
+	var (
+		calls   = make(chan struct{}, 1)
+		release = make(chan struct{})
+		closed  = make(chan error, 1)
+	)
+	server, client := connPair(t)
+	err := r.Add(server, func(conn net.Conn) {
+		if _, err := ws.ReadFrame(conn); err != nil {
+			t.Error(err)
+		}
+		calls <- struct{}{}
+		<-release
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ws.WriteFrame(client, ws.NewPingFrame(nil)); err != nil {
+		t.Fatal(err)
+	}
+	waitCall(t, calls)
+
+	go func() {
+		closed <- r.Close()
+	}()
+	select {
+	case err := <-closed:
+		t.Fatalf("Close() returned while handler is running: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case err := <-closed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Close() did not return after handler returned")
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("unexpected OnError() call: %v", err)
+	default:
+	}
+}
+
+func newReactor(t *testing.T, c *Config) *Reactor {
+	r, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// closeReactor closes r. It must be deferred, so r is closed before
+// connections created with connPair(); otherwise handlers are called when
+// connections are closed.
+func closeReactor(t *testing.T, r *Reactor) {
+	if err := r.Close(); err != nil {
+		t.Error(err)
+	}
+}
+
+func connPair(t *testing.T) (server, client net.Conn) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	client, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	server, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return server, client
+}
+
+func readFrame(t *testing.T, conn net.Conn) ws.Frame {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	f, err := ws.ReadFrame(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func waitCall(t *testing.T, calls <-chan struct{}) {
+	select {
+	case <-calls:
+	case <-time.After(time.Second):
+		t.Fatalf("handler was not called")
+	}
+}
+
+func noCall(t *testing.T, calls <-chan struct{}) {
+	select {
+	case <-calls:
+		t.Fatalf("unexpected handler call")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -0,0 +1,243 @@
+package wsutil
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// Extension is a negotiated WebSocket extension applied to frames. It
+// handles the RSV bits it uses and might also transform message payloads by
+// implementing RecvTransform and SendTransform interfaces.
+type Extension interface {
+	RecvExtension
+	SendExtension
+
+	// Rsv returns RSV bits used by the extension. See ws.RsvExtension.
+	Rsv() byte
+}
+
+// RecvTransform is an optional interface of Extension which transforms
+// payloads of received messages.
+type RecvTransform interface {
+	// TransformReader returns a reader of transformed payload of the message
+	// read from r. It is called for each data message after UnsetBits() was
+	// called for the first frame of it. It might return r as is.
+	TransformReader(r io.Reader) io.Reader
+}
+
+// SendTransform is an optional interface of Extension which transforms
+// payloads of sent messages.
+type SendTransform interface {
+	// TransformWriter returns a writer which writes transformed payload of
+	// the message to w. It is called for each data message before SetBits()
+	// is called for the first frame of it. Returned writer is closed at the
+	// end of message; its Close() method must flush buffered data to w, but
+	// must not close w.
+	TransformWriter(w io.Writer) io.WriteCloser
+}
+
+// Chain applies extensions in the negotiated order. It implements
+// RecvExtension, SendExtension and Extension interfaces itself.
+//
+// Sent messages are passed through extensions in the chain order, while
+// received messages are passed in the reverse order. See
+// https://tools.ietf.org/html/rfc6455#section-9.1
+type Chain struct {
+	xs  []Extension
+	rsv byte
+	w   chainWriter
+}
+
+// NewChain creates a chain of extensions ordered as in the handshake
+// response. It returns ws.ErrExtensionConflict if extensions use the same RSV
+// bits.
+func NewChain(xs ...Extension) (*Chain, error) {
+	c := &Chain{
+		xs: xs,
+	}
+	for _, x := range xs {
+		bits := x.Rsv()
+		if c.rsv&bits != 0 {
+			return nil, ws.ErrExtensionConflict
+		}
+		c.rsv |= bits
+	}
+	return c, nil
+}
+
+// ErrUnknownExtension is returned by HandshakeChain when no constructor is
+// registered for a negotiated extension.
+var ErrUnknownExtension = errors.New("wsutil: unknown extension")
+
+// ExtensionFunc creates an Extension from the option accepted during
+// handshake for the side described by state.
+type ExtensionFunc func(opt httphead.Option, state ws.State) (Extension, error)
+
+var registry = struct {
+	sync.RWMutex
+	m map[string]ExtensionFunc
+}{
+	m: map[string]ExtensionFunc{
+		wsflate.ExtensionName: newFlateExtension,
+	},
+}
+
+// RegisterExtension registers f as a constructor of extensions with given
+// name used by HandshakeChain. If constructor for the name is already
+// registered, it is replaced.
+//
+// permessage-deflate extension is registered by default. Packages
+// implementing other extensions register them on initialization, e.g.
+// wsflateframe registers the per-frame compression extension.
+func RegisterExtension(name string, f ExtensionFunc) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.m[name] = f
+}
+
+// HandshakeChain creates a chain of extensions negotiated during handshake hs
+// and used on the side described by state. Extensions are created by the
+// constructors registered with RegisterExtension(); ErrUnknownExtension is
+// returned if some of them is not registered.
+//
+// Returned chain is intended to be used by both Reader and Writer:
+//
+//	chain, err := wsutil.HandshakeChain(hs, ws.StateServerSide)
+//	if err != nil {
+//		// handle error.
+//	}
+//	state := ws.StateServerSide | ws.StateExtended
+//	rd := wsutil.Reader{
+//		Source:     conn,
+//		State:      state,
+//		Extensions: []wsutil.RecvExtension{chain},
+//	}
+//	wr := wsutil.NewWriter(conn, state, ws.OpText)
+//	wr.SetExtensions(chain)
+//
+// It returns wsflate.ErrUnknownDictionary if permessage-deflate was
+// negotiated with a preset dictionary. Conn should be used in that case.
+func HandshakeChain(hs ws.Handshake, state ws.State) (*Chain, error) {
+	xs := make([]Extension, 0, len(hs.Extensions))
+	for _, opt := range hs.Extensions {
+		x, err := newExtension(opt, state)
+		if err != nil {
+			return nil, err
+		}
+		xs = append(xs, x)
+	}
+	return NewChain(xs...)
+}
+
+// newExtension creates an Extension with the registered constructor.
+func newExtension(opt httphead.Option, state ws.State) (Extension, error) {
+	registry.RLock()
+	f := registry.m[string(opt.Name)]
+	registry.RUnlock()
+	if f == nil {
+		return nil, ErrUnknownExtension
+	}
+	return f(opt, state)
+}
+
+func newFlateExtension(opt httphead.Option, state ws.State) (Extension, error) {
+	var p wsflate.Parameters
+	if err := p.Parse(opt); err != nil {
+		return nil, err
+	}
+	if p.DictionaryID != "" {
+		return nil, wsflate.ErrUnknownDictionary
+	}
+	return wsflate.NewTransformParams(p, state), nil
+}
+
+// Rsv returns the union of RSV bits used by the chained extensions.
+func (c *Chain) Rsv() byte {
+	return c.rsv
+}
+
+// UnsetBits implements RecvExtension.
+func (c *Chain) UnsetBits(h ws.Header) (_ ws.Header, err error) {
+	for i := len(c.xs) - 1; i >= 0; i-- {
+		if h, err = c.xs[i].UnsetBits(h); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// SetBits implements SendExtension.
+func (c *Chain) SetBits(h ws.Header) (_ ws.Header, err error) {
+	for _, x := range c.xs {
+		if h, err = x.SetBits(h); err != nil {
+			return h, err
+		}
+	}
+	return h, nil
+}
+
+// TransformReader implements RecvTransform. It is the same as Reader().
+func (c *Chain) TransformReader(r io.Reader) io.Reader {
+	return c.Reader(r)
+}
+
+// TransformWriter implements SendTransform. It is the same as Writer().
+func (c *Chain) TransformWriter(w io.Writer) io.WriteCloser {
+	return c.Writer(w)
+}
+
+// Reader returns a reader of the message payload read from r transformed by
+// the extensions. It must be called after UnsetBits() was called for the
+// first frame of the message.
+func (c *Chain) Reader(r io.Reader) io.Reader {
+	for i := len(c.xs) - 1; i >= 0; i-- {
+		if t, ok := c.xs[i].(RecvTransform); ok {
+			r = t.TransformReader(r)
+		}
+	}
+	return r
+}
+
+// Writer returns a writer which writes the message payload transformed by
+// the extensions to w. It must be called before SetBits() is called for the
+// first frame of the message. Returned writer must be closed at the end of
+// message; it doesn't close w.
+//
+// Returned writer is valid until the next Writer() call.
+func (c *Chain) Writer(w io.Writer) io.WriteCloser {
+	c.w.ws = c.w.ws[:0]
+	for i := len(c.xs) - 1; i >= 0; i-- {
+		if t, ok := c.xs[i].(SendTransform); ok {
+			x := t.TransformWriter(w)
+			c.w.ws = append(c.w.ws, x)
+			w = x
+		}
+	}
+	c.w.w = w
+	return &c.w
+}
+
+// chainWriter writes to the first transform writer and closes all of them
+// starting from the first one.
+type chainWriter struct {
+	w  io.Writer
+	ws []io.WriteCloser // In reverse order.
+}
+
+func (c *chainWriter) Write(p []byte) (int, error) {
+	return c.w.Write(p)
+}
+
+func (c *chainWriter) Close() error {
+	for i := len(c.ws) - 1; i >= 0; i-- {
+		if err := c.ws[i].Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
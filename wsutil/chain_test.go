@@ -0,0 +1,387 @@
+package wsutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// xorExtension is a stub extension which xors payloads of messages with the
+// RSV2 bit set.
+type xorExtension struct {
+	key  byte
+	recv bool
+}
+
+func (x *xorExtension) Rsv() byte {
+	return ws.Rsv(false, true, false)
+}
+
+func (x *xorExtension) UnsetBits(h ws.Header) (ws.Header, error) {
+	r1, r2, r3 := ws.RsvBits(h.Rsv)
+	if h.OpCode.IsData() && h.OpCode != ws.OpContinuation {
+		x.recv = r2
+	}
+	h.Rsv = ws.Rsv(r1, false, r3)
+	return h, nil
+}
+
+func (x *xorExtension) SetBits(h ws.Header) (ws.Header, error) {
+	r1, _, r3 := ws.RsvBits(h.Rsv)
+	if h.OpCode.IsData() && h.OpCode != ws.OpContinuation {
+		h.Rsv = ws.Rsv(r1, true, r3)
+	}
+	return h, nil
+}
+
+func (x *xorExtension) TransformReader(r io.Reader) io.Reader {
+	if !x.recv {
+		return r
+	}
+	return &xorReader{r, x.key}
+}
+
+func (x *xorExtension) TransformWriter(w io.Writer) io.WriteCloser {
+	return &xorWriter{w, x.key}
+}
+
+type xorReader struct {
+	r   io.Reader
+	key byte
+}
+
+func (x *xorReader) Read(p []byte) (n int, err error) {
+	n, err = x.r.Read(p)
+	for i := range p[:n] {
+		p[i] ^= x.key
+	}
+	return n, err
+}
+
+type xorWriter struct {
+	w   io.Writer
+	key byte
+}
+
+func (x *xorWriter) Write(p []byte) (int, error) {
+	b := make([]byte, len(p))
+	for i := range p {
+		b[i] = p[i] ^ x.key
+	}
+	return x.w.Write(b)
+}
+
+func (x *xorWriter) Close() error {
+	return nil
+}
+
+func TestChainConflict(t *testing.T) {
+	_, err := NewChain(&xorExtension{}, &xorExtension{})
+	if err != ws.ErrExtensionConflict {
+		t.Errorf("unexpected error: %v; want %v", err, ws.ErrExtensionConflict)
+	}
+}
+
+func TestHandshakeChainRegistry(t *testing.T) {
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.DefaultParameters.Option(),
+			httphead.NewOption("x-xor", nil),
+		},
+	}
+	if _, err := HandshakeChain(hs, ws.StateServerSide); err != ErrUnknownExtension {
+		t.Fatalf("unexpected error: %v; want %v", err, ErrUnknownExtension)
+	}
+	RegisterExtension("x-xor", func(opt httphead.Option, state ws.State) (Extension, error) {
+		return &xorExtension{key: 0x5a}, nil
+	})
+	c, err := HandshakeChain(hs, ws.StateServerSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.xs[1].(*xorExtension); !ok || len(c.xs) != 2 {
+		t.Errorf("unexpected chain: %#v", c.xs)
+	}
+}
+
+func TestChain(t *testing.T) {
+	newChain := func() *Chain {
+		fw := wsflate.NewWriter(nil, func(w io.Writer) wsflate.Compressor {
+			f, _ := flate.NewWriter(w, flate.BestSpeed)
+			return f
+		})
+		fr := wsflate.NewReader(nil, func(r io.Reader) wsflate.Decompressor {
+			return flate.NewReader(r)
+		})
+		c, err := NewChain(wsflate.NewTransform(fr, fw), &xorExtension{key: 0x5a})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	var (
+		buf    bytes.Buffer
+		client = newChain()
+		server = newChain()
+	)
+	if act, exp := client.Rsv(), ws.Rsv(true, true, false); act != exp {
+		t.Errorf("unexpected rsv bits: %#x; want %#x", act, exp)
+	}
+	// Transforms are applied by hand here, thus only bit handling is passed
+	// to Reader and Writer.
+	wr := NewWriter(&buf, ws.StateServerSide|ws.StateExtended, 0)
+	wr.SetExtensions(SendExtensionFunc(server.SetBits))
+	rd := Reader{
+		Source:     &buf,
+		State:      ws.StateClientSide | ws.StateExtended,
+		Extensions: []RecvExtension{RecvExtensionFunc(client.UnsetBits)},
+	}
+	for _, msg := range []string{"hello, chain!", "hello, chain again!"} {
+		wr.ResetOp(ws.OpText)
+		w := server.Writer(wr)
+		if _, err := io.WriteString(w, msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := wr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(buf.Bytes(), []byte(msg)) {
+			t.Fatalf("message is not transformed")
+		}
+
+		h, err := rd.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.Rsv != 0 {
+			t.Errorf("rsv bits were not unset: %#x", h.Rsv)
+		}
+		act, err := ioutil.ReadAll(client.Reader(&rd))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(act) != msg {
+			t.Errorf("unexpected message: %q; want %q", act, msg)
+		}
+	}
+}
+
+func TestReaderWriterTransform(t *testing.T) {
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.Parameters{
+				ServerMaxWindowBits: 10,
+			}.Option(),
+		},
+	}
+	server, err := HandshakeChain(hs, ws.StateServerSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client, err := HandshakeChain(hs, ws.StateClientSide)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	// Small buffer makes compressed messages fragmented.
+	wr := NewWriterSize(&buf, ws.StateServerSide|ws.StateExtended, ws.OpText, 16)
+	wr.SetExtensions(server)
+
+	var pings int
+	rd := Reader{
+		Source:     &buf,
+		State:      ws.StateClientSide | ws.StateExtended,
+		CheckUTF8:  true,
+		Extensions: []RecvExtension{client},
+		OnIntermediate: func(h ws.Header, r io.Reader) error {
+			pings++
+			_, err := io.Copy(ioutil.Discard, r)
+			return err
+		},
+	}
+
+	messages := []string{
+		"привет, мир! ",
+		strings.Repeat("hello, transform! ", 100),
+		"",
+	}
+	for _, msg := range messages {
+		if _, err := io.WriteString(wr, msg); err != nil {
+			t.Fatal(err)
+		}
+		if err := wr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Insert ping frame between the fragments of the first message.
+	frames := splitFrames(t, buf.Bytes())
+	if len(frames) < len(messages)+1 {
+		t.Fatalf("messages are not fragmented")
+	}
+	var b bytes.Buffer
+	for i, f := range frames {
+		b.Write(f)
+		if i == 0 {
+			ws.WriteFrame(&b, ws.NewPingFrame(nil))
+		}
+	}
+	buf = b
+
+	for _, msg := range messages {
+		h, err := rd.NextFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if h.OpCode != ws.OpText || h.Rsv != 0 {
+			t.Errorf("unexpected header: %+v", h)
+		}
+		act, err := ioutil.ReadAll(&rd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(act) != msg {
+			t.Errorf("unexpected message: %q; want %q", act, msg)
+		}
+	}
+	if pings != 1 {
+		t.Errorf("unexpected number of intermediate frames: %d", pings)
+	}
+
+	t.Run("invalid utf8", func(t *testing.T) {
+		buf.Reset()
+		wr.Write([]byte{0xff, 0xfe})
+		if err := wr.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := rd.NextFrame(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(&rd); err != ErrInvalidUTF8 {
+			t.Errorf("unexpected error: %v; want %v", err, ErrInvalidUTF8)
+		}
+	})
+}
+
+// splitFrames splits raw bytes into encoded frames.
+func splitFrames(t *testing.T, raw []byte) (ret [][]byte) {
+	r := bytes.NewReader(raw)
+	for r.Len() > 0 {
+		start := len(raw) - r.Len()
+		f, err := ws.ReadFrame(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if start == 0 && f.Header.Fin {
+			t.Fatalf("first message is not fragmented")
+		}
+		ret = append(ret, raw[start:len(raw)-r.Len()])
+	}
+	return ret
+}
+
+func TestWriterWriteThroughTransform(t *testing.T) {
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.DefaultParameters.Option(),
+		},
+	}
+	for _, test := range []struct {
+		name  string
+		write func(*Writer) error
+		exp   []string
+		rsv   []bool // RSV1 bit of first frame of each message.
+	}{
+		{
+			name: "after message",
+			write: func(w *Writer) error {
+				if _, err := io.WriteString(w, "hello"); err != nil {
+					return err
+				}
+				if err := w.Flush(); err != nil {
+					return err
+				}
+				if _, err := w.WriteThrough([]byte("wor")); err != nil {
+					return err
+				}
+				if _, err := io.WriteString(w, "ld"); err != nil {
+					return err
+				}
+				return w.Flush()
+			},
+			exp: []string{"hello", "world"},
+			rsv: []bool{true, false},
+		},
+		{
+			name: "mid message",
+			write: func(w *Writer) error {
+				if _, err := io.WriteString(w, "hello"); err != nil {
+					return err
+				}
+				if _, err := w.WriteThrough([]byte("world")); err != ErrTransformed {
+					t.Errorf("unexpected WriteThrough() error: %v; want %v", err, ErrTransformed)
+				}
+				return w.Flush()
+			},
+			exp: []string{"hello"},
+			rsv: []bool{true},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server, err := HandshakeChain(hs, ws.StateServerSide)
+			if err != nil {
+				t.Fatal(err)
+			}
+			client, err := HandshakeChain(hs, ws.StateClientSide)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var buf bytes.Buffer
+			wr := NewWriter(&buf, ws.StateServerSide|ws.StateExtended, ws.OpText)
+			wr.SetExtensions(server)
+			if err := test.write(wr); err != nil {
+				t.Fatal(err)
+			}
+
+			var rsv []bool
+			for _, f := range frames(t, buf.Bytes()) {
+				if f.Header.OpCode != ws.OpContinuation {
+					r1, _, _ := ws.RsvBits(f.Header.Rsv)
+					rsv = append(rsv, r1)
+				}
+			}
+			if !reflect.DeepEqual(rsv, test.rsv) {
+				t.Errorf("unexpected RSV1 bits: %v; want %v", rsv, test.rsv)
+			}
+
+			rd := Reader{
+				Source:     &buf,
+				State:      ws.StateClientSide | ws.StateExtended,
+				Extensions: []RecvExtension{client},
+			}
+			for _, exp := range test.exp {
+				if _, err := rd.NextFrame(); err != nil {
+					t.Fatal(err)
+				}
+				act, err := ioutil.ReadAll(&rd)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(act) != exp {
+					t.Errorf("unexpected message: %q; want %q", act, exp)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,616 @@
+package wsutil
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+// ErrCloseSent is returned by Conn write methods when close frame has been
+// already sent to the peer.
+var ErrCloseSent = errors.New("close frame sent")
+
+// Conn is a message-oriented wrapper around WebSocket connection. It owns
+// the connection and glues together Reader, Writer and ControlHandler so the
+// most common use cases do not require any additional code.
+//
+// Conn handles ping, pong and close frames automatically while reading
+// messages. Extensions negotiated during handshake are applied to messages
+// transparently: permessage-deflate is configured by Flate* fields, while
+// other extensions are created as HandshakeChain() does.
+//
+// Conn's read methods must not be called concurrently. Write methods are
+// safe to be called from multiple goroutines concurrently with each other and
+// with read methods.
+//
+// Note that Conn is built on top of the lower-level API. Applications which
+// need more control over I/O may still use Reader and Writer directly.
+type Conn struct {
+	// CheckUTF8 enables UTF-8 checks for text messages payload.
+	// NewConn() sets it to true.
+	CheckUTF8 bool
+
+	// MaxFrameSize controls the maximum frame size in bytes that can be read.
+	// See Reader.MaxFrameSize for details.
+	MaxFrameSize int64
+
+	// Flate* fields configure permessage-deflate extension, if it was
+	// negotiated. They must be set before the first message is read or
+	// written; see wsflate.TransformOptions for details.
+	//
+	// FlateLimits contains limits applied to decompressed data of messages.
+	// Exceeding them makes read methods return *wsflate.LimitError and close
+	// the connection with ws.StatusMessageTooBig code.
+	FlateLimits wsflate.Limits
+
+	// FlatePool is an optional pool of compression contexts shared between
+	// connections.
+	FlatePool *wsflate.Pool
+
+	// FlatePolicy is an optional policy which decides whether to compress
+	// outgoing messages when permessage-deflate extension is used. If it is
+	// nil, all messages are compressed. Policy must not be shared between
+	// connections.
+	FlatePolicy *wsflate.Policy
+
+	// FlateDictionaries is an optional registry of preset dictionaries used
+	// when permessage-deflate extension is negotiated with a dictionary. The
+	// dictionary is looked up by its ID for the negotiated subprotocol. If it
+	// is not found, read and write methods return
+	// wsflate.ErrUnknownDictionary. Note that FlatePool is not used when
+	// dictionary is negotiated.
+	FlateDictionaries *wsflate.Dictionaries
+
+	// OnPing and OnPong are the optional callbacks that will be called on
+	// receipt of ping and pong frames respectively. The argument is only
+	// valid until the callback returns.
+	//
+	// Note that response to the ping frame is sent by Conn automatically.
+	OnPing, OnPong func(payload []byte)
+
+	conn  net.Conn
+	state ws.State
+	hs    ws.Handshake
+
+	// xonce guards setup of extensions, which is made before the first
+	// message is read or written, since Flate* fields are set after
+	// NewConn().
+	xonce sync.Once
+	xerr  error
+	fx    *wsflate.Transform
+
+	reading bool
+	rerr    error
+	src     bufferedSource
+	rd      Reader
+	mr      messageReader
+	ctl     [ws.MaxControlFramePayloadSize]byte
+	ctlr    bytes.Reader
+
+	// rmu guards fields below. It is held by read methods only shortly to
+	// synchronize release of the flate reader with Close().
+	rmu     sync.Mutex
+	rbusy   bool // Read method is in progress.
+	rclosed bool
+
+	// mu guards fields below. It is held by messageWriter until it is closed,
+	// thus messages are written one by one.
+	mu sync.Mutex
+	wr *Writer
+	mw messageWriter
+
+	// wmu guards fields below. It is held only while a single frame is
+	// written, thus control frames could be sent between fragments of a
+	// message.
+	wmu       sync.Mutex
+	wbusy     bool // Message writer is open.
+	wclosed   bool
+	closeSent bool
+}
+
+// NewConn creates a Conn that owns conn and works with it from the side
+// described by state. Extensions found in hs are applied automatically.
+//
+// If br is non-nil, it is used to read bytes already buffered during
+// handshake (see ws.Dialer.Dial() and ws.HTTPUpgrader.Upgrade()). Note that
+// br is not returned to any pool.
+func NewConn(conn net.Conn, br *bufio.Reader, state ws.State, hs ws.Handshake) *Conn {
+	c := &Conn{
+		CheckUTF8: true,
+
+		conn:  conn,
+		state: state,
+		hs:    hs,
+		src: bufferedSource{
+			br:   br,
+			conn: conn,
+		},
+	}
+	if len(hs.Extensions) > 0 {
+		c.state = c.state.Set(ws.StateExtended)
+	}
+	c.rd = Reader{
+		Source:         &c.src,
+		State:          c.state,
+		OnIntermediate: c.handleControl,
+	}
+	c.wr = NewWriter(connWriter{c}, c.state, 0)
+	c.wr.lock = &c.wmu
+	c.mr.c = c
+	c.mw.c = c
+	return c
+}
+
+// NewClientConn is a helper function that calls NewConn with
+// ws.StateClientSide. It is intended to be used with ws.Dial() results.
+func NewClientConn(conn net.Conn, br *bufio.Reader, hs ws.Handshake) *Conn {
+	return NewConn(conn, br, ws.StateClientSide, hs)
+}
+
+// NewServerConn is a helper function that calls NewConn with
+// ws.StateServerSide. It is intended to be used with ws.Upgrade() results.
+func NewServerConn(conn net.Conn, br *bufio.Reader, hs ws.Handshake) *Conn {
+	return NewConn(conn, br, ws.StateServerSide, hs)
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Handshake returns handshake info Conn was created with.
+func (c *Conn) Handshake() ws.Handshake {
+	return c.hs
+}
+
+// NextReader prepares next data message to be read. It returns message's
+// operation code and io.Reader to read the message payload from. Control
+// frames received before the data message are handled automatically.
+//
+// Returned reader is valid until next NextReader() or ReadMessage() call.
+// Unread bytes of the previous message are discarded.
+//
+// If peer closes the connection, ClosedError is returned.
+func (c *Conn) NextReader() (op ws.OpCode, r io.Reader, err error) {
+	if !c.beginRead() {
+		return 0, nil, net.ErrClosed
+	}
+	defer c.endRead()
+	if c.rerr != nil {
+		return 0, nil, c.rerr
+	}
+	if err = c.setupExtensions(); err != nil {
+		return 0, nil, err
+	}
+	if c.reading {
+		c.reading = false
+		if err = c.rd.Discard(); err != nil {
+			return 0, nil, c.readError(err)
+		}
+	}
+	c.rd.CheckUTF8 = c.CheckUTF8
+	c.rd.MaxFrameSize = c.MaxFrameSize
+	for {
+		h, err := c.rd.NextFrame()
+		if err != nil {
+			return 0, nil, c.readError(err)
+		}
+		if h.OpCode.IsControl() {
+			if err = c.handleControl(h, &c.rd); err != nil {
+				return 0, nil, c.readError(err)
+			}
+			continue
+		}
+		c.reading = true
+		c.mr.eof = false
+		return h.OpCode, &c.mr, nil
+	}
+}
+
+// ReadMessage reads next data message. It is a helper that calls NextReader()
+// and reads the whole message payload into a newly allocated slice.
+func (c *Conn) ReadMessage() (op ws.OpCode, p []byte, err error) {
+	op, r, err := c.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+	var buf bytes.Buffer
+	if _, err = buf.ReadFrom(r); err != nil {
+		return 0, nil, err
+	}
+	return op, buf.Bytes(), nil
+}
+
+// NextWriter returns a writer for the next message with given operation
+// code. The message is sent to the peer when returned writer is closed.
+//
+// Note that Conn is locked for other messages until the returned writer is
+// closed. Control frames (e.g. responses to pings received by read methods)
+// are still sent between fragments of the message.
+func (c *Conn) NextWriter(op ws.OpCode) (io.WriteCloser, error) {
+	return c.nextWriter(op, -1, nil)
+}
+
+// nextWriter returns a writer for the next message. Size is the payload size
+// of the message or -1 if it is not known; p is the payload or nil.
+func (c *Conn) nextWriter(op ws.OpCode, size int, p []byte) (io.WriteCloser, error) {
+	if err := c.setupExtensions(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.wmu.Lock()
+	if c.closeSent {
+		c.wmu.Unlock()
+		c.mu.Unlock()
+		return nil, ErrCloseSent
+	}
+	c.wbusy = true
+	c.wmu.Unlock()
+	c.wr.ResetOp(op)
+	if c.fx != nil {
+		c.fx.Decide(size, p)
+	}
+	c.mw.open = true
+	return &c.mw, nil
+}
+
+// WriteMessage writes message with given operation code and payload.
+// It may be called from multiple goroutines concurrently.
+func (c *Conn) WriteMessage(op ws.OpCode, p []byte) error {
+	w, err := c.nextWriter(op, len(p), p)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(p); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// WritePing writes ping frame with given payload. Note that p must have
+// length of ws.MaxControlFramePayloadSize bytes or less due to RFC.
+func (c *Conn) WritePing(p []byte) error {
+	return c.writeControl(ws.OpPing, p)
+}
+
+// WritePong writes unsolicited pong frame with given payload. Note that p
+// must have length of ws.MaxControlFramePayloadSize bytes or less due to RFC.
+func (c *Conn) WritePong(p []byte) error {
+	return c.writeControl(ws.OpPong, p)
+}
+
+// WriteClose writes close frame with given code and reason without closing
+// the underlying connection. The application is expected to continue reading
+// messages until ClosedError is received to complete the closing handshake.
+//
+// It returns ErrCloseSent if close frame was already sent.
+func (c *Conn) WriteClose(code ws.StatusCode, reason string) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return c.writeClose(code, reason)
+}
+
+// Close sends close frame with ws.StatusNormalClosure code (if it was not
+// sent yet) and closes the underlying connection. Compression contexts are
+// released, thus they are returned to FlatePool.
+func (c *Conn) Close() error {
+	c.wmu.Lock()
+	var err error
+	if !c.closeSent {
+		err = c.writeClose(ws.StatusNormalClosure, "")
+	}
+	c.wclosed = true
+	// Extensions must not be set up after Close(). Otherwise this waits for
+	// the setup in progress.
+	c.xonce.Do(func() { c.xerr = net.ErrClosed })
+	if !c.wbusy && c.fx != nil {
+		// Otherwise compression context is released by the message writer.
+		_ = c.fx.CloseWriter()
+	}
+	c.wmu.Unlock()
+	if e := c.conn.Close(); err == nil {
+		err = e
+	}
+	c.rmu.Lock()
+	c.rclosed = true
+	if !c.rbusy && c.fx != nil {
+		// Otherwise decompression context is released by the read method in
+		// progress.
+		_ = c.fx.CloseReader()
+	}
+	c.rmu.Unlock()
+	return err
+}
+
+// beginRead marks read method as being in progress. It returns false if Conn
+// is closed.
+func (c *Conn) beginRead() bool {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	if c.rclosed {
+		return false
+	}
+	c.rbusy = true
+	return true
+}
+
+// endRead marks read method as completed.
+func (c *Conn) endRead() {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+	c.rbusy = false
+	if c.rclosed && c.fx != nil {
+		_ = c.fx.CloseReader()
+	}
+}
+
+// endWrite marks message writer as closed.
+func (c *Conn) endWrite() {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.wbusy = false
+	if c.wclosed && c.fx != nil {
+		_ = c.fx.CloseWriter()
+	}
+}
+
+func (c *Conn) writeControl(op ws.OpCode, p []byte) error {
+	if len(p) > ws.MaxControlFramePayloadSize {
+		return ErrControlOverflow
+	}
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if c.closeSent {
+		return ErrCloseSent
+	}
+	return writeFrame(c.conn, c.state, op, true, p)
+}
+
+// writeClose writes close frame to the connection. It must be called with
+// c.wmu held.
+func (c *Conn) writeClose(code ws.StatusCode, reason string) error {
+	c.closeSent = true
+	return writeFrame(c.conn, c.state, ws.OpClose, true,
+		ws.NewCloseFrameBody(code, reason),
+	)
+}
+
+// handleControl reads control frame payload from src and handles it. It is
+// used both for control frames received between data messages and for
+// intermediate control frames received between fragments.
+func (c *Conn) handleControl(h ws.Header, src io.Reader) error {
+	p := c.ctl[:h.Length]
+	if _, err := io.ReadFull(src, p); err != nil {
+		return err
+	}
+	switch h.OpCode {
+	case ws.OpPing:
+		if cb := c.OnPing; cb != nil {
+			cb(p)
+		}
+	case ws.OpPong:
+		if cb := c.OnPong; cb != nil {
+			cb(p)
+		}
+		return nil
+	}
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if h.OpCode == ws.OpClose && c.closeSent {
+		// We have already sent close frame, so this is the response to it.
+		// It must not be responded again.
+		code, reason := ws.ParseCloseFrameData(p)
+		if code.Empty() {
+			code = ws.StatusNoStatusRcvd
+		}
+		return ClosedError{
+			Code:   code,
+			Reason: reason,
+		}
+	}
+	if c.closeSent {
+		// Do not respond to pings after close frame was sent.
+		return nil
+	}
+	c.ctlr.Reset(p)
+	err := (ControlHandler{
+		Src:                 &c.ctlr,
+		Dst:                 c.conn,
+		State:               c.state,
+		DisableSrcCiphering: true,
+	}).Handle(h)
+	if h.OpCode == ws.OpClose {
+		c.closeSent = true
+	}
+	return err
+}
+
+// readError saves given read error to be returned by all subsequent reads.
+// If error is caused by a protocol violation, it sends close frame with an
+// appropriate status code.
+func (c *Conn) readError(err error) error {
+	if c.rerr == nil {
+		c.rerr = err
+	}
+	var code ws.StatusCode
+	switch x := err.(type) {
+	case ws.ProtocolError:
+		code = ws.StatusProtocolError
+	case *wsflate.LimitError:
+		code = x.StatusCode()
+	}
+	switch err {
+	case ErrInvalidUTF8:
+		code = ws.StatusInvalidFramePayloadData
+	case ErrFrameTooLarge:
+		code = ws.StatusMessageTooBig
+	}
+	if code != 0 {
+		c.wmu.Lock()
+		if !c.closeSent {
+			// Ignore write error here, since the original error is more
+			// relevant.
+			_ = c.writeClose(code, "")
+		}
+		c.wmu.Unlock()
+	}
+	return err
+}
+
+// setupExtensions sets up Reader and Writer to apply extensions negotiated
+// during handshake. It is made once, before the first message is read or
+// written.
+func (c *Conn) setupExtensions() error {
+	c.xonce.Do(func() {
+		if len(c.hs.Extensions) == 0 {
+			return
+		}
+		xs := make([]Extension, 0, len(c.hs.Extensions))
+		for _, opt := range c.hs.Extensions {
+			var (
+				x   Extension
+				err error
+			)
+			if bytes.Equal(opt.Name, wsflate.ExtensionNameBytes) {
+				x, err = c.flateExtension(opt)
+			} else {
+				x, err = newExtension(opt, c.state)
+			}
+			if err != nil {
+				c.xerr = err
+				return
+			}
+			xs = append(xs, x)
+		}
+		chain, err := NewChain(xs...)
+		if err != nil {
+			c.xerr = err
+			return
+		}
+		c.rd.Extensions = []RecvExtension{chain}
+		c.wr.SetExtensions(chain)
+	})
+	return c.xerr
+}
+
+// flateExtension creates permessage-deflate extension configured by Flate*
+// fields.
+func (c *Conn) flateExtension(opt httphead.Option) (Extension, error) {
+	var p wsflate.Parameters
+	if err := p.Parse(opt); err != nil {
+		return nil, err
+	}
+	var dict []byte
+	if id := p.DictionaryID; id != "" {
+		var ok bool
+		if c.FlateDictionaries != nil {
+			dict, ok = c.FlateDictionaries.Lookup(c.hs.Protocol, id)
+		}
+		if !ok {
+			return nil, wsflate.ErrUnknownDictionary
+		}
+	}
+	c.fx = wsflate.NewTransformOptions(p, c.state, wsflate.TransformOptions{
+		Pool:       c.FlatePool,
+		Dictionary: dict,
+		Limits:     c.FlateLimits,
+		Policy:     c.FlatePolicy,
+	})
+	return c.fx, nil
+}
+
+type messageReader struct {
+	c   *Conn
+	eof bool
+}
+
+// Read implements io.Reader.
+func (m *messageReader) Read(p []byte) (n int, err error) {
+	if !m.c.beginRead() {
+		return 0, net.ErrClosed
+	}
+	defer m.c.endRead()
+	if m.c.rerr != nil {
+		return 0, m.c.rerr
+	}
+	if m.eof {
+		return 0, io.EOF
+	}
+	n, err = m.c.rd.Read(p)
+	switch err {
+	case nil:
+	case io.EOF:
+		m.eof = true
+		m.c.reading = false
+	default:
+		err = m.c.readError(err)
+	}
+	return n, err
+}
+
+type messageWriter struct {
+	c    *Conn
+	open bool
+}
+
+// Write implements io.Writer.
+func (m *messageWriter) Write(p []byte) (n int, err error) {
+	if !m.open {
+		return 0, ErrCloseSent
+	}
+	return m.c.wr.Write(p)
+}
+
+// Close flushes message to the connection and unlocks Conn for other writes.
+func (m *messageWriter) Close() (err error) {
+	if !m.open {
+		return nil
+	}
+	m.open = false
+	defer m.c.mu.Unlock()
+	defer m.c.endWrite()
+	return m.c.wr.Flush()
+}
+
+// connWriter writes frames of messages to the connection. It is called with
+// c.wmu held and fails once close frame is sent.
+type connWriter struct {
+	c *Conn
+}
+
+func (w connWriter) Write(p []byte) (int, error) {
+	if w.c.closeSent {
+		return 0, ErrCloseSent
+	}
+	return w.c.conn.Write(p)
+}
+
+// bufferedSource reads bytes buffered during handshake before reading from
+// the connection.
+type bufferedSource struct {
+	br   *bufio.Reader
+	conn io.Reader
+}
+
+func (s *bufferedSource) Read(p []byte) (int, error) {
+	if s.br != nil {
+		if s.br.Buffered() > 0 {
+			return s.br.Read(p)
+		}
+		s.br = nil
+	}
+	return s.conn.Read(p)
+}
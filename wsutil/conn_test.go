@@ -0,0 +1,626 @@
+package wsutil
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gobwas/httphead"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsflate"
+)
+
+func TestConnEcho(t *testing.T) {
+	RegisterExtension("x-xor", func(opt httphead.Option, state ws.State) (Extension, error) {
+		return &xorExtension{key: 0x5a}, nil
+	})
+	for _, test := range []struct {
+		name       string
+		extensions []httphead.Option
+	}{
+		{
+			name: "plain",
+		},
+		{
+			name: "deflate",
+			extensions: []httphead.Option{
+				wsflate.DefaultParameters.Option(),
+			},
+		},
+		{
+			name: "deflate context takeover",
+			extensions: []httphead.Option{
+				wsflate.Parameters{}.Option(),
+			},
+		},
+		{
+			name: "deflate and registered extension",
+			extensions: []httphead.Option{
+				wsflate.DefaultParameters.Option(),
+				httphead.NewOption("x-xor", nil),
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			hs := ws.Handshake{
+				Extensions: test.extensions,
+			}
+			client, server := connPair(t, hs)
+
+			done := make(chan error, 1)
+			go func() {
+				for {
+					op, p, err := server.ReadMessage()
+					if err != nil {
+						done <- err
+						return
+					}
+					if err := server.WriteMessage(op, p); err != nil {
+						done <- err
+						return
+					}
+				}
+			}()
+
+			var pongs int
+			client.OnPong = func([]byte) { pongs++ }
+
+			messages := [][]byte{
+				[]byte("hello, gopher!"),
+				bytes.Repeat([]byte("hello, gopher!"), 1024),
+				[]byte("hello, gopher!"),
+				nil,
+			}
+			for i, msg := range messages {
+				if err := client.WritePing([]byte("ping")); err != nil {
+					t.Fatal(err)
+				}
+				if err := client.WriteMessage(ws.OpText, msg); err != nil {
+					t.Fatal(err)
+				}
+				op, act, err := client.ReadMessage()
+				if err != nil {
+					t.Fatalf("#%d: unexpected ReadMessage() error: %v", i, err)
+				}
+				if op != ws.OpText {
+					t.Errorf("#%d: unexpected op code: %v", i, op)
+				}
+				if !bytes.Equal(act, msg) {
+					t.Errorf("#%d: unexpected echo payload", i)
+				}
+			}
+			if act, exp := pongs, len(messages); act != exp {
+				t.Errorf("unexpected number of pongs: %d; want %d", act, exp)
+			}
+
+			if err := client.WriteClose(ws.StatusGoingAway, "bye"); err != nil {
+				t.Fatal(err)
+			}
+			// NOTE: ControlHandler echoes only the status code.
+			_, _, err := client.ReadMessage()
+			if exp := (ClosedError{Code: ws.StatusGoingAway}); err != exp {
+				t.Errorf("unexpected client error: %v; want %v", err, exp)
+			}
+			if err := <-done; err != (ClosedError{ws.StatusGoingAway, "bye"}) {
+				t.Errorf("unexpected server error: %v", err)
+			}
+			if err := client.WriteMessage(ws.OpText, nil); err != ErrCloseSent {
+				t.Errorf("unexpected write error after close: %v", err)
+			}
+		})
+	}
+}
+
+func TestConnConcurrentWrite(t *testing.T) {
+	client, server := connPair(t, ws.Handshake{})
+
+	const (
+		writers  = 8
+		messages = 32
+	)
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < messages; j++ {
+				msg := fmt.Sprintf("%d:%d", i, j)
+				if err := server.WriteMessage(ws.OpBinary, []byte(msg)); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(i)
+	}
+	seen := make(map[string]bool)
+	for i := 0; i < writers*messages; i++ {
+		_, p, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		seen[string(p)] = true
+	}
+	wg.Wait()
+	if act, exp := len(seen), writers*messages; act != exp {
+		t.Errorf("unexpected number of unique messages: %d; want %d", act, exp)
+	}
+}
+
+func TestConnPingDuringNextWriter(t *testing.T) {
+	client, server := connPair(t, ws.Handshake{})
+
+	w, err := server.NextWriter(ws.OpText)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write([]byte("hello, ")); err != nil {
+		t.Fatal(err)
+	}
+	pong := make(chan []byte, 1)
+	client.OnPong = func(p []byte) {
+		pong <- append([]byte(nil), p...)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, p, err := client.ReadMessage()
+		if err == nil && string(p) != "hello, gopher!" {
+			err = fmt.Errorf("unexpected message: %q", p)
+		}
+		done <- err
+	}()
+	if err := client.WritePing([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.WriteMessage(ws.OpText, []byte("message")); err != nil {
+		t.Fatal(err)
+	}
+	// Ping must be responded while the writer is open.
+	read := make(chan error, 1)
+	go func() {
+		_, _, err := server.ReadMessage()
+		read <- err
+	}()
+	select {
+	case err := <-read:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ReadMessage() is blocked by open writer")
+	}
+	select {
+	case p := <-pong:
+		if string(p) != "ping" {
+			t.Errorf("unexpected pong payload: %q", p)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no pong received while writer is open")
+	}
+	if _, err := w.Write([]byte("gopher!")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConnProtocolError(t *testing.T) {
+	var buf bytes.Buffer
+	ws.MustWriteFrame(&buf, ws.NewTextFrame([]byte{0xff}))
+	var out bytes.Buffer
+	c := NewConn(stubNetConn{&buf, &out}, nil, ws.StateClientSide, ws.Handshake{})
+	if _, _, err := c.ReadMessage(); err != ErrInvalidUTF8 {
+		t.Fatalf("unexpected error: %v; want %v", err, ErrInvalidUTF8)
+	}
+	f, err := ws.ReadFrame(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = ws.UnmaskFrameInPlace(f)
+	if code, _ := ws.ParseCloseFrameData(f.Payload); code != ws.StatusInvalidFramePayloadData {
+		t.Errorf("unexpected close code: %v", code)
+	}
+	if _, _, err := c.ReadMessage(); err != ErrInvalidUTF8 {
+		t.Errorf("unexpected subsequent error: %v", err)
+	}
+}
+
+func TestConnRegisteredExtension(t *testing.T) {
+	RegisterExtension("x-xor", func(opt httphead.Option, state ws.State) (Extension, error) {
+		return &xorExtension{key: 0x5a}, nil
+	})
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			httphead.NewOption("x-xor", nil),
+		},
+	}
+	var out bytes.Buffer
+	c := NewConn(stubNetConn{new(bytes.Buffer), &out}, nil, ws.StateServerSide, hs)
+	msg := []byte("hello, extension!")
+	if err := c.WriteMessage(ws.OpText, msg); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ws.ReadFrame(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Equal(f.Payload, msg) {
+		t.Errorf("payload is not transformed by the extension")
+	}
+
+	hs.Extensions = append(hs.Extensions, httphead.NewOption("x-unknown", nil))
+	c = NewConn(stubNetConn{new(bytes.Buffer), &out}, nil, ws.StateServerSide, hs)
+	if err := c.WriteMessage(ws.OpText, msg); err != ErrUnknownExtension {
+		t.Errorf("unexpected error: %v; want %v", err, ErrUnknownExtension)
+	}
+}
+
+func TestConnCompressedMessage(t *testing.T) {
+	// Ensure that messages written by Conn can be decompressed by the
+	// wsflate helpers.
+	var buf bytes.Buffer
+	c := NewConn(stubNetConn{nil, &buf}, nil, ws.StateServerSide, ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.DefaultParameters.Option(),
+		},
+	})
+	msg := bytes.Repeat([]byte("compress me "), 100)
+	if err := c.WriteMessage(ws.OpText, msg); err != nil {
+		t.Fatal(err)
+	}
+	f, err := ws.ReadFrame(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Header.Rsv1() {
+		t.Fatalf("compression bit is not set")
+	}
+	if f.Header.Length >= int64(len(msg)) {
+		t.Errorf("message is not compressed: %d bytes", f.Header.Length)
+	}
+	h := wsflate.Helper{
+		Decompressor: func(r io.Reader) wsflate.Decompressor {
+			return flate.NewReader(r)
+		},
+	}
+	f, err = h.DecompressFrame(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(f.Payload, msg) {
+		t.Errorf("unexpected decompressed payload")
+	}
+}
+
+func TestConnCompressedWindow(t *testing.T) {
+	var buf bytes.Buffer
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.Parameters{
+				ServerMaxWindowBits: 9,
+			}.Option(),
+		},
+	}
+	server := NewConn(stubNetConn{nil, &buf}, nil, ws.StateServerSide, hs)
+	client := NewConn(stubNetConn{&buf, ioutil.Discard}, nil, ws.StateClientSide, hs)
+
+	msg := bytes.Repeat([]byte("compress me within the window "), 100)
+	if err := server.WriteMessage(ws.OpText, msg); err != nil {
+		t.Fatal(err)
+	}
+	if n := buf.Len(); n >= len(msg) {
+		t.Errorf("message is not compressed: %d bytes", n)
+	}
+	_, act, err := client.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(act, msg) {
+		t.Errorf("unexpected message")
+	}
+}
+
+func TestConnFlateDictionary(t *testing.T) {
+	var dicts wsflate.Dictionaries
+	dicts.Register("json", wsflate.Dictionary{
+		ID:   "v1",
+		Data: []byte(`{"type":"event","payload":{"id":0,"name":""}}`),
+	})
+	msg := []byte(`{"type":"event","payload":{"id":42,"name":"gopher"}}`)
+
+	for _, bits := range []wsflate.WindowBits{0, 9} {
+		t.Run(fmt.Sprintf("bits=%d", bits), func(t *testing.T) {
+			var buf bytes.Buffer
+			hs := ws.Handshake{
+				Protocol: "json",
+				Extensions: []httphead.Option{
+					wsflate.Parameters{
+						ServerNoContextTakeover: true,
+						ClientNoContextTakeover: true,
+						ServerMaxWindowBits:     bits,
+						DictionaryID:            "v1",
+					}.Option(),
+				},
+			}
+			server := NewConn(stubNetConn{nil, &buf}, nil, ws.StateServerSide, hs)
+			server.FlateDictionaries = &dicts
+			client := NewConn(stubNetConn{&buf, ioutil.Discard}, nil, ws.StateClientSide, hs)
+			client.FlateDictionaries = &dicts
+
+			for i := 0; i < 2; i++ {
+				if err := server.WriteMessage(ws.OpText, msg); err != nil {
+					t.Fatal(err)
+				}
+				if n := buf.Len(); n >= len(msg)/2 {
+					t.Errorf("message is not compressed with dictionary: %d bytes", n)
+				}
+				_, act, err := client.ReadMessage()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(act, msg) {
+					t.Errorf("unexpected message: %q", act)
+				}
+			}
+		})
+	}
+	t.Run("unknown", func(t *testing.T) {
+		hs := ws.Handshake{
+			Protocol: "xml",
+			Extensions: []httphead.Option{
+				wsflate.Parameters{DictionaryID: "v1"}.Option(),
+			},
+		}
+		c := NewConn(stubNetConn{nil, ioutil.Discard}, nil, ws.StateServerSide, hs)
+		c.FlateDictionaries = &dicts
+		if err := c.WriteMessage(ws.OpText, msg); err != wsflate.ErrUnknownDictionary {
+			t.Errorf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestConnFlateLimits(t *testing.T) {
+	var (
+		in  bytes.Buffer
+		out bytes.Buffer
+		buf bytes.Buffer
+	)
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write(bytes.Repeat([]byte("a"), 1<<20))
+	fw.Flush()
+	p := bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})
+	f := ws.NewTextFrame(p)
+	f.Header, _ = wsflate.SetBit(f.Header)
+	ws.MustWriteFrame(&in, f)
+
+	c := NewConn(stubNetConn{&in, &out}, nil, ws.StateClientSide, ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.DefaultParameters.Option(),
+		},
+	})
+	c.FlateLimits.MaxMessageSize = 1 << 10
+
+	_, _, err := c.ReadMessage()
+	if _, ok := err.(*wsflate.LimitError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f, err = ws.ReadFrame(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f = ws.UnmaskFrameInPlace(f)
+	if code, _ := ws.ParseCloseFrameData(f.Payload); code != ws.StatusMessageTooBig {
+		t.Errorf("unexpected close code: %v", code)
+	}
+}
+
+func TestConnFlatePool(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		pool wsflate.Pool
+	)
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.Parameters{}.Option(),
+		},
+	}
+	server := NewConn(stubNetConn{nil, &buf}, nil, ws.StateServerSide, hs)
+	server.FlatePool = &pool
+	client := NewConn(stubNetConn{&buf, ioutil.Discard}, nil, ws.StateClientSide, hs)
+	client.FlatePool = &pool
+
+	for i := 0; i < 3; i++ {
+		msg := bytes.Repeat([]byte("pooled "), 10*(i+1))
+		if err := server.WriteMessage(ws.OpText, msg); err != nil {
+			t.Fatal(err)
+		}
+		_, act, err := client.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(act, msg) {
+			t.Errorf("#%d: unexpected message: %q", i, act)
+		}
+	}
+}
+
+func TestConnFlatePoolRelease(t *testing.T) {
+	pool := wsflate.Pool{
+		MaxMemory: wsflate.DefaultCompressorSize + wsflate.DefaultDecompressorSize,
+	}
+	hs := ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.Parameters{}.Option(),
+		},
+	}
+	for i := 0; i < 5; i++ {
+		if pool.Exceeded() {
+			t.Fatalf("#%d: pool budget is exceeded before connection", i)
+		}
+		client, server := connPair(t, hs)
+		client.FlatePool = &pool
+		server.FlatePool = &pool
+
+		msg := bytes.Repeat([]byte("pooled "), 100)
+		if err := client.WriteMessage(ws.OpText, msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := server.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		if err := server.WriteMessage(ws.OpText, msg); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := client.ReadMessage(); err != nil {
+			t.Fatal(err)
+		}
+		// Both connections hold decompression contexts with context
+		// takeover.
+		if !pool.Exceeded() {
+			t.Fatalf("#%d: pool budget is not used", i)
+		}
+		client.Close()
+		server.Close()
+	}
+	if pool.Exceeded() {
+		t.Fatalf("pool budget is exceeded after all connections are closed")
+	}
+}
+
+func TestConnFlatePolicy(t *testing.T) {
+	var buf bytes.Buffer
+	c := NewConn(stubNetConn{nil, &buf}, nil, ws.StateServerSide, ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.DefaultParameters.Option(),
+		},
+	})
+	c.FlatePolicy = &wsflate.Policy{
+		MinSize: 64,
+	}
+	small := []byte("small")
+	large := bytes.Repeat([]byte("large "), 100)
+	for _, msg := range [][]byte{small, large} {
+		if err := c.WriteMessage(ws.OpText, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, exp := range []struct {
+		compressed bool
+		size       int
+	}{
+		{false, len(small)},
+		{true, -1},
+	} {
+		f, err := ws.ReadFrame(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Header.Rsv1() != exp.compressed {
+			t.Errorf("unexpected compression bit: %t", f.Header.Rsv1())
+		}
+		if exp.size >= 0 && int(f.Header.Length) != exp.size {
+			t.Errorf("unexpected payload length: %d", f.Header.Length)
+		}
+	}
+	stats := c.FlatePolicy.Stats()
+	if stats.Compressed != 1 || stats.Skipped != 1 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+	if stats.OutputBytes == 0 || stats.OutputBytes >= int64(len(large)) {
+		t.Errorf("unexpected output bytes: %d", stats.OutputBytes)
+	}
+}
+
+func TestConnReadContextTakeover(t *testing.T) {
+	var (
+		in  bytes.Buffer
+		buf bytes.Buffer
+	)
+	// Compress messages with the same compressor to make the second message
+	// reference bytes of the first one.
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	messages := [][]byte{
+		[]byte("hello, context takeover!"),
+		[]byte("hello, context takeover! hello, context takeover!"),
+	}
+	for _, msg := range messages {
+		buf.Reset()
+		fw.Write(msg)
+		fw.Flush()
+		p := bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})
+		f := ws.NewTextFrame(p)
+		f.Header, _ = wsflate.SetBit(f.Header)
+		ws.MustWriteFrame(&in, f)
+	}
+	c := NewConn(stubNetConn{&in, ioutil.Discard}, nil, ws.StateClientSide, ws.Handshake{
+		Extensions: []httphead.Option{
+			wsflate.Parameters{}.Option(),
+		},
+	})
+	for i, exp := range messages {
+		_, act, err := c.ReadMessage()
+		if err != nil {
+			t.Fatalf("#%d: unexpected error: %v", i, err)
+		}
+		if !bytes.Equal(act, exp) {
+			t.Errorf("#%d: unexpected message: %q; want %q", i, act, exp)
+		}
+	}
+}
+
+func connPair(tb testing.TB, hs ws.Handshake) (client, server *Conn) {
+	// NOTE: we do not use net.Pipe() here since it is not buffered and
+	// automatic responses to control frames might lead to deadlocks.
+	ln, err := net.Listen("tcp", "localhost:")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer ln.Close()
+
+	dial := make(chan net.Conn, 1)
+	go func() {
+		conn, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			tb.Error(err)
+		}
+		dial <- conn
+	}()
+	s, err := ln.Accept()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	c := <-dial
+	if c == nil {
+		tb.FailNow()
+	}
+	tb.Cleanup(func() {
+		c.Close()
+		s.Close()
+	})
+	client = NewClientConn(c, nil, hs)
+	server = NewServerConn(s, nil, hs)
+	return client, server
+}
+
+type stubNetConn struct {
+	io.Reader
+	io.Writer
+}
+
+func (stubNetConn) Close() error { return nil }
+
+func (stubNetConn) LocalAddr() net.Addr  { return nil }
+func (stubNetConn) RemoteAddr() net.Addr { return nil }
+
+func (stubNetConn) SetDeadline(t time.Time) error      { return nil }
+func (stubNetConn) SetReadDeadline(t time.Time) error  { return nil }
+func (stubNetConn) SetWriteDeadline(t time.Time) error { return nil }
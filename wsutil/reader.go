@@ -30,6 +30,9 @@ type FrameHandlerFunc func(ws.Header, io.Reader) error
 // WebSocket frames. It also takes care on fragmented frames and possibly
 // intermediate control frames between them.
 //
+// If some of Extensions implement RecvTransform interface, Read() returns
+// message payload transformed by them (e.g. decompressed).
+//
 // Note that Reader's methods are not goroutine safe.
 type Reader struct {
 	Source io.Reader
@@ -44,7 +47,11 @@ type Reader struct {
 
 	// Extensions is a list of negotiated extensions for reader Source.
 	// It is used to meet the specs and clear appropriate bits in fragment
-	// header RSV segment.
+	// header RSV segment. Extensions implementing RecvTransform are also
+	// applied to payload of data messages in reverse order.
+	//
+	// Note that when message is transformed, UTF-8 checks are made over the
+	// transformed bytes.
 	Extensions []RecvExtension
 
 	// MaxFrameSize controls the maximum frame size in bytes
@@ -63,6 +70,10 @@ type Reader struct {
 	utf8   UTF8Reader                 // Used to check UTF8 sequences if CheckUTF8 is true.
 	tmp    [ws.MaxHeaderSize - 2]byte // Used for reading headers.
 	cr     *CipherReader              // Used by NextFrame() to unmask frame payload.
+
+	xr    io.Reader     // Used to read transformed message payload.
+	xutf8 UTF8Reader    // Used to check UTF8 sequences of transformed payload.
+	pr    payloadReader // Used as a source of transformations.
 }
 
 // NewReader creates new frame reader that reads from r keeping given state to
@@ -96,6 +107,30 @@ func NewServerSideReader(r io.Reader) *Reader {
 // The error is ErrNoFrameAdvance if no NextFrame() call was made before
 // reading next message bytes.
 func (r *Reader) Read(p []byte) (n int, err error) {
+	if r.xr == nil {
+		return r.readPayload(p)
+	}
+	n, err = r.xr.Read(p)
+	if err != io.EOF {
+		return n, err
+	}
+	if r.xr == &r.xutf8 && !r.xutf8.Valid() {
+		n = r.xutf8.Accepted()
+		err = ErrInvalidUTF8
+	}
+	r.xr = nil
+	if r.frame != nil || r.fragmented() {
+		// Transformation completed before the end of the message; skip the
+		// rest of it to keep the stream consistent.
+		if derr := r.Discard(); derr != nil {
+			err = derr
+		}
+	}
+	return n, err
+}
+
+// readPayload reads raw (not transformed) payload of the message.
+func (r *Reader) readPayload(p []byte) (n int, err error) {
 	if r.frame == nil {
 		if !r.fragmented() {
 			// Every new Read() must be preceded by NextFrame() call.
@@ -159,6 +194,7 @@ func (r *Reader) Discard() (err error) {
 		}
 	}
 	r.reset()
+	r.xr = nil
 	return err
 }
 
@@ -227,8 +263,12 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 		}
 	} else {
 		r.opCode = hdr.OpCode
+		r.xr = nil
+		if hdr.OpCode.IsData() && hdr.OpCode != ws.OpContinuation {
+			r.xr = r.transform(hdr)
+		}
 	}
-	if r.CheckUTF8 && (hdr.OpCode == ws.OpText || (r.fragmented() && r.opCode == ws.OpText)) {
+	if r.CheckUTF8 && r.xr == nil && (hdr.OpCode == ws.OpText || (r.fragmented() && r.opCode == ws.OpText)) {
 		r.utf8.Source = frame
 		frame = &r.utf8
 	}
@@ -251,6 +291,35 @@ func (r *Reader) NextFrame() (hdr ws.Header, err error) {
 	return hdr, err
 }
 
+// transform returns reader of transformed payload of the message started
+// with frame h. It returns nil if no extensions transform the message.
+func (r *Reader) transform(h ws.Header) io.Reader {
+	r.pr.r = r
+	var x io.Reader = &r.pr
+	for i := len(r.Extensions) - 1; i >= 0; i-- {
+		if t, ok := r.Extensions[i].(RecvTransform); ok {
+			x = t.TransformReader(x)
+		}
+	}
+	if x == &r.pr {
+		return nil
+	}
+	if r.CheckUTF8 && h.OpCode == ws.OpText {
+		r.xutf8.Reset(x)
+		x = &r.xutf8
+	}
+	return x
+}
+
+// payloadReader reads raw payload of the message.
+type payloadReader struct {
+	r *Reader
+}
+
+func (p *payloadReader) Read(b []byte) (int, error) {
+	return p.r.readPayload(b)
+}
+
 func (r *Reader) fragmented() bool {
 	return r.State.Fragmented()
 }
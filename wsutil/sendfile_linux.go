@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package wsutil
+
+import (
+	"io"
+	"net"
+)
+
+// sendfileDest reports whether w moves data from regular files with
+// sendfile(2) in its ReadFrom() method.
+func sendfileDest(w io.Writer) bool {
+	_, ok := w.(*net.TCPConn)
+	return ok
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package wsutil
+
+import "io"
+
+func sendfileDest(w io.Writer) bool {
+	return false
+}
@@ -1,8 +1,10 @@
 package wsutil
 
 import (
+	"encoding/binary"
 	"fmt"
 	"io"
+	"unicode/utf8"
 )
 
 // ErrInvalidUTF8 is returned by UTF8 reader on invalid utf8 sequence.
@@ -52,14 +54,25 @@ func (u *UTF8Reader) Read(p []byte) (n int, err error) {
 
 	accepted := 0
 	s, c := u.state, u.codep
-	for i := 0; i < n; i++ {
+	for i := 0; i < n; {
+		if s == utf8Accept {
+			// Skip complete sequences quickly while there are no code points
+			// in progress.
+			if k := validPrefix(p[i:n]); k > 0 {
+				i += k
+				accepted = i
+				continue
+			}
+		}
 		c, s = decode(s, c, p[i])
+		i++
 		if s == utf8Reject {
 			u.state = s
+			u.accepted = accepted
 			return accepted, ErrInvalidUTF8
 		}
 		if s == utf8Accept {
-			accepted = i + 1
+			accepted = i
 		}
 	}
 	u.state, u.codep = s, c
@@ -79,6 +92,27 @@ func (u *UTF8Reader) Accepted() int {
 	return u.accepted
 }
 
+// asciiPrefix returns the length of the ASCII prefix of p. It checks 16 or 8
+// bytes at a time.
+func asciiPrefix(p []byte) (n int) {
+	const mask = 0x8080808080808080
+	for ; n+16 <= len(p); n += 16 {
+		w1 := binary.LittleEndian.Uint64(p[n:])
+		w2 := binary.LittleEndian.Uint64(p[n+8:])
+		if (w1|w2)&mask != 0 {
+			break
+		}
+	}
+	for ; n+8 <= len(p); n += 8 {
+		if binary.LittleEndian.Uint64(p[n:])&mask != 0 {
+			break
+		}
+	}
+	for ; n < len(p) && p[n] < utf8.RuneSelf; n++ {
+	}
+	return n
+}
+
 // Below is port of UTF-8 decoder from http://bjoern.hoehrmann.de/utf-8/decoder/dfa/
 //
 // Copyright (c) 2008-2009 Bjoern Hoehrmann <bjoern@hoehrmann.de>
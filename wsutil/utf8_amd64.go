@@ -0,0 +1,56 @@
+//go:build !purego
+// +build !purego
+
+package wsutil
+
+import (
+	"unicode/utf8"
+
+	"github.com/gobwas/ws/internal/cpu"
+)
+
+// hasAVX2 reports whether validAVX2 could be used. It is a variable to
+// test the fallback.
+var hasAVX2 = cpu.HasAVX2
+
+// validPrefix returns the length of the prefix of p which consists of
+// complete valid UTF-8 sequences. It may return less than that; zero means
+// that p must be checked byte by byte.
+//
+// Payloads of at least 64 bytes are validated by 32 bytes blocks with AVX2
+// instructions (if available) in the way described in "Validating UTF-8 In
+// Less Than One Instruction Per Byte" by John Keiser and Daniel Lemire.
+func validPrefix(p []byte) int {
+	if !hasAVX2 || len(p) < 64 {
+		return asciiPrefix(p)
+	}
+	n := len(p) &^ 31
+	if !validAVX2(p[:n]) {
+		// Let the caller find out the invalid sequence.
+		return asciiPrefix(p)
+	}
+	return completePrefix(p[:n])
+}
+
+// completePrefix returns the length of p without the last UTF-8 sequence if it
+// could be incomplete. That is, it cuts the last lead byte within the last
+// three bytes of p and the bytes following it. All sequences of p must be
+// valid.
+func completePrefix(p []byte) int {
+	for i := len(p) - 1; i >= 0 && i >= len(p)-utf8.UTFMax+1; i-- {
+		if !utf8.RuneStart(p[i]) {
+			continue
+		}
+		if p[i] < utf8.RuneSelf {
+			return len(p)
+		}
+		return i
+	}
+	return len(p)
+}
+
+// validAVX2 reports whether p contains only valid UTF-8 sequences, except the
+// last one which could be incomplete. Length of p must be a multiple of 32.
+//
+//go:noescape
+func validAVX2(p []byte) bool
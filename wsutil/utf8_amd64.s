@@ -0,0 +1,105 @@
+//go:build !purego
+// +build !purego
+
+#include "textflag.h"
+
+// Lookup tables of the validation algorithm. Each table is repeated twice
+// since VPSHUFB looks up within 128-bit lanes.
+//
+// Error bits are:
+//   0x01 too short:      11______ 0_______ or 11______ 11______
+//   0x02 too long:       0_______ 10______
+//   0x04 overlong 3:     11100000 100_____
+//   0x08 too large:      11110100 1001____ and above
+//   0x10 surrogate:      11101101 101_____
+//   0x20 overlong 2:     1100000_ 10______
+//   0x40 too large 1000: 11110101 1000____ and above
+//   0x40 overlong 4:     11110000 1000____
+//   0x80 two conts:      10______ 10______
+
+// Indexed by the high nibble of the previous byte.
+DATA utf8Byte1High<>+0x00(SB)/8, $0x0202020202020202
+DATA utf8Byte1High<>+0x08(SB)/8, $0x4915012180808080
+DATA utf8Byte1High<>+0x10(SB)/8, $0x0202020202020202
+DATA utf8Byte1High<>+0x18(SB)/8, $0x4915012180808080
+GLOBL utf8Byte1High<>(SB), RODATA|NOPTR, $32
+
+// Indexed by the low nibble of the previous byte.
+DATA utf8Byte1Low<>+0x00(SB)/8, $0xcbcbcb8b8383a3e7
+DATA utf8Byte1Low<>+0x08(SB)/8, $0xcbcbdbcbcbcbcbcb
+DATA utf8Byte1Low<>+0x10(SB)/8, $0xcbcbcb8b8383a3e7
+DATA utf8Byte1Low<>+0x18(SB)/8, $0xcbcbdbcbcbcbcbcb
+GLOBL utf8Byte1Low<>(SB), RODATA|NOPTR, $32
+
+// Indexed by the high nibble of the current byte.
+DATA utf8Byte2High<>+0x00(SB)/8, $0x0101010101010101
+DATA utf8Byte2High<>+0x08(SB)/8, $0x01010101babaaee6
+DATA utf8Byte2High<>+0x10(SB)/8, $0x0101010101010101
+DATA utf8Byte2High<>+0x18(SB)/8, $0x01010101babaaee6
+GLOBL utf8Byte2High<>(SB), RODATA|NOPTR, $32
+
+DATA utf8Consts<>+0x00(SB)/4, $0x8070600f
+GLOBL utf8Consts<>(SB), RODATA|NOPTR, $4
+
+// func validAVX2(p []byte) bool
+TEXT ·validAVX2(SB), NOSPLIT, $0-25
+	MOVQ p_base+0(FP), SI
+	MOVQ p_len+8(FP), CX
+
+	VMOVDQU utf8Byte1High<>(SB), Y14
+	VMOVDQU utf8Byte1Low<>(SB), Y13
+	VMOVDQU utf8Byte2High<>(SB), Y12
+
+	// Y15 is the nibble mask. Y11 and Y10 are used to find the third and
+	// the fourth bytes of sequences; Y9 is the high bit mask.
+	VPBROADCASTB utf8Consts<>+0(SB), Y15
+	VPBROADCASTB utf8Consts<>+1(SB), Y11
+	VPBROADCASTB utf8Consts<>+2(SB), Y10
+	VPBROADCASTB utf8Consts<>+3(SB), Y9
+
+	// Y8 accumulates errors; Y7 holds the previous block.
+	VPXOR Y8, Y8, Y8
+	VPXOR Y7, Y7, Y7
+
+loop:
+	CMPQ    CX, $32
+	JB      done
+	VMOVDQU (SI), Y0
+
+	// Get the previous one, two and three bytes for each byte.
+	VPERM2I128 $0x21, Y0, Y7, Y1
+	VPALIGNR   $15, Y1, Y0, Y2
+	VPALIGNR   $14, Y1, Y0, Y3
+	VPALIGNR   $13, Y1, Y0, Y4
+
+	// Special cases of the two bytes sequences.
+	VPSRLW  $4, Y2, Y5
+	VPAND   Y15, Y5, Y5
+	VPSHUFB Y5, Y14, Y5
+	VPAND   Y15, Y2, Y6
+	VPSHUFB Y6, Y13, Y6
+	VPAND   Y6, Y5, Y5
+	VPSRLW  $4, Y0, Y6
+	VPAND   Y15, Y6, Y6
+	VPSHUFB Y6, Y12, Y6
+	VPAND   Y6, Y5, Y5
+
+	// Continuations which must be the third or the fourth bytes.
+	VPSUBUSB Y11, Y3, Y3
+	VPSUBUSB Y10, Y4, Y4
+	VPOR     Y4, Y3, Y3
+	VPAND    Y9, Y3, Y3
+
+	VPXOR Y3, Y5, Y5
+	VPOR  Y5, Y8, Y8
+
+	VMOVDQA Y0, Y7
+	ADDQ    $32, SI
+	SUBQ    $32, CX
+	JMP     loop
+
+done:
+	VPTEST     Y8, Y8
+	SETEQ      ret+24(FP)
+	VZEROUPPER
+	RET
@@ -0,0 +1,82 @@
+//go:build !purego
+// +build !purego
+
+package wsutil
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestUTF8ReaderNoAVX2(t *testing.T) {
+	defer func(v bool) {
+		hasAVX2 = v
+	}(hasAVX2)
+	hasAVX2 = false
+	testUTF8ReaderRandom(t)
+}
+
+func TestValidAVX2(t *testing.T) {
+	if !hasAVX2 {
+		t.Skip("AVX2 is not supported")
+	}
+	buf := bytes.Repeat([]byte{'x'}, 64)
+	check := func(seq ...byte) {
+		// Put the sequence across 128-bit lanes and at the end of the
+		// buffer.
+		for _, pos := range []int{14, 64 - len(seq)} {
+			copy(buf[pos:], seq)
+			act := validAVX2(buf)
+			// Valid sequence might be incomplete at the end of buffer.
+			if invalid, _, _ := utf8Naive(buf); !invalid && !act {
+				t.Fatalf("validAVX2(%x at %d) = false; want true", seq, pos)
+			}
+			// The last sequence is checked by the caller.
+			if invalid, _, _ := utf8Naive(buf[:completePrefix(buf)]); invalid && act {
+				t.Fatalf("validAVX2(%x at %d) = true; want false", seq, pos)
+			}
+			copy(buf[pos:], bytes.Repeat([]byte{'x'}, len(seq)))
+		}
+	}
+	for a := 0; a < 256; a++ {
+		for b := 0; b < 256; b++ {
+			check(byte(a), byte(b))
+		}
+	}
+	for a := 0xe0; a < 0xf0; a++ {
+		for b := 0x80; b < 0xc0; b++ {
+			for c := 0; c < 256; c++ {
+				check(byte(a), byte(b), byte(c))
+			}
+		}
+	}
+	edges := []byte{0x00, 0x7f, 0x80, 0x8f, 0x90, 0xbf, 0xc0, 0xff}
+	for a := 0xf0; a < 0xf8; a++ {
+		for b := 0x80; b < 0xc0; b++ {
+			for _, c := range edges {
+				for _, d := range edges {
+					check(byte(a), byte(b), c, d)
+				}
+			}
+		}
+	}
+	// Sequences split by 128-bit lanes and blocks.
+	for _, seq := range []string{"é", "€", "😀", "\xf4\x8f\xbf\xbf"} {
+		for pos := 0; pos <= len(buf)-len(seq); pos++ {
+			copy(buf[pos:], seq)
+			if !validAVX2(buf) {
+				t.Fatalf("validAVX2(%x at %d) = false; want true", seq, pos)
+			}
+			copy(buf[pos:], "xxxx"[:len(seq)])
+		}
+	}
+	for _, seq := range []string{"\xc3\x28", "\xe2\x28\xa1", "\xed\xa0\x80", "\xf4\x90\x80\x80", "\x80"} {
+		for pos := 0; pos <= len(buf)-len(seq)-1; pos++ {
+			copy(buf[pos:], seq)
+			if validAVX2(buf) {
+				t.Fatalf("validAVX2(%x at %d) = true; want false", seq, pos)
+			}
+			copy(buf[pos:], "xxxx"[:len(seq)])
+		}
+	}
+}
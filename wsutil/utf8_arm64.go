@@ -0,0 +1,24 @@
+//go:build !purego
+// +build !purego
+
+package wsutil
+
+// validPrefix returns the length of the prefix of p which consists of
+// complete valid UTF-8 sequences. It may return less than that; zero means
+// that p must be checked byte by byte.
+//
+// ASCII runs of payloads of at least 64 bytes are checked by 64 bytes blocks
+// with NEON instructions.
+func validPrefix(p []byte) int {
+	if len(p) < 64 {
+		return asciiPrefix(p)
+	}
+	n := asciiNEON(p)
+	return n + asciiPrefix(p[n:])
+}
+
+// asciiNEON returns the length of the ASCII prefix of p rounded down to 64
+// bytes.
+//
+//go:noescape
+func asciiNEON(p []byte) int
@@ -0,0 +1,32 @@
+//go:build !purego
+// +build !purego
+
+#include "textflag.h"
+
+// func asciiNEON(p []byte) int
+TEXT ·asciiNEON(SB), NOSPLIT, $0-32
+	MOVD p_base+0(FP), R0
+	MOVD p_len+8(FP), R1
+	AND  $~63, R1, R1
+	MOVD $0, R2
+	MOVD $0x8080808080808080, R5
+
+loop:
+	CMP  R1, R2
+	BEQ  done
+	VLD1 (R0), [V0.B16, V1.B16, V2.B16, V3.B16]
+	VORR V0.B16, V1.B16, V0.B16
+	VORR V2.B16, V3.B16, V2.B16
+	VORR V0.B16, V2.B16, V0.B16
+	VMOV V0.D[0], R3
+	VMOV V0.D[1], R4
+	ORR  R3, R4, R3
+	TST  R5, R3
+	BNE  done
+	ADD  $64, R0, R0
+	ADD  $64, R2, R2
+	B    loop
+
+done:
+	MOVD R2, ret+24(FP)
+	RET
@@ -0,0 +1,11 @@
+//go:build purego || (!amd64 && !arm64)
+// +build purego !amd64,!arm64
+
+package wsutil
+
+// validPrefix returns the length of the prefix of p which consists of
+// complete valid UTF-8 sequences. It may return less than that; zero means
+// that p must be checked byte by byte.
+func validPrefix(p []byte) int {
+	return asciiPrefix(p)
+}
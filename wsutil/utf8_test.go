@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"testing"
 	"unicode/utf8"
 )
@@ -180,6 +181,16 @@ func BenchmarkUTF8Reader(b *testing.B) {
 			data: bytes.Repeat([]byte("x"), 1024),
 			chop: 128,
 		},
+		{
+			label: "ascii",
+			data:  bytes.Repeat([]byte("x"), 1<<16),
+			chop:  4096,
+		},
+		{
+			label: "cyrillic",
+			data:  bytes.Repeat([]byte("привет, мир! "), 1<<12),
+			chop:  4096,
+		},
 		{
 			data: append(
 				bytes.Repeat([]byte("x"), 1024),
@@ -193,6 +204,7 @@ func BenchmarkUTF8Reader(b *testing.B) {
 		},
 	} {
 		b.Run(fmt.Sprintf("%s#%d", bench.label, i), func(b *testing.B) {
+			b.SetBytes(int64(len(bench.data)))
 			for i := 0; i < b.N; i++ {
 				cr := &chopReader{
 					src: bytes.NewReader(bench.data),
@@ -207,3 +219,78 @@ func BenchmarkUTF8Reader(b *testing.B) {
 		})
 	}
 }
+
+func TestUTF8ReaderRandom(t *testing.T) {
+	testUTF8ReaderRandom(t)
+}
+
+func testUTF8ReaderRandom(t *testing.T) {
+	runes := []rune{'x', 'é', 'ж', '€', '日', '😀', utf8.MaxRune}
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 500; i++ {
+		var data []byte
+		for n := rnd.Intn(512); len(data) < n; {
+			var buf [utf8.UTFMax]byte
+			m := utf8.EncodeRune(buf[:], runes[rnd.Intn(len(runes))])
+			for j := rnd.Intn(16); j >= 0; j-- {
+				data = append(data, buf[:m]...)
+			}
+		}
+		if len(data) > 0 && i%2 == 1 {
+			// Corrupt some byte.
+			data[rnd.Intn(len(data))] = byte(rnd.Intn(256))
+		}
+		expErr, expN, expValid := utf8Naive(data)
+		// Bytes of incomplete sequence might be returned by previous Read()
+		// before the invalid byte is read.
+		maxN := expN + 1
+		for maxN < len(data) && maxN-expN < utf8.UTFMax && !utf8.RuneStart(data[maxN]) {
+			maxN++
+		}
+
+		cr := &chopReader{
+			src: bytes.NewReader(data),
+			sz:  1 + rnd.Intn(256),
+		}
+		r := NewUTF8Reader(cr)
+		var (
+			n   int
+			err error
+			p   = make([]byte, len(data)+1)
+		)
+		for err == nil {
+			var m int
+			m, err = r.Read(p[n:])
+			n += m
+		}
+		if err == io.EOF {
+			err = nil
+		}
+		if (err != nil) != expErr {
+			t.Fatalf("#%d: unexpected error: %v; %x", i, err, data)
+		}
+		if err != nil && (n < expN || n > maxN) {
+			t.Fatalf("#%d: received error at %d; want at [%d, %d]; %x", i, n, expN, maxN, data)
+		}
+		if act := r.Valid(); act != expValid {
+			t.Fatalf("#%d: Valid() = %t; want %t; %x", i, act, expValid, data)
+		}
+	}
+}
+
+// utf8Naive checks data byte by byte. It returns whether data contains
+// invalid sequence and the number of valid bytes before it. Valid reports
+// whether data is valid at all.
+func utf8Naive(data []byte) (invalid bool, n int, valid bool) {
+	var s, c uint32
+	for i, b := range data {
+		c, s = decode(s, c, b)
+		if s == utf8Reject {
+			return true, n, false
+		}
+		if s == utf8Accept {
+			n = i + 1
+		}
+	}
+	return false, n, s == utf8Accept
+}
@@ -3,6 +3,8 @@ package wsutil
 import (
 	"fmt"
 	"io"
+	"os"
+	"sync"
 
 	"github.com/gobwas/pool"
 	"github.com/gobwas/pool/pbytes"
@@ -19,6 +21,12 @@ var (
 	// Writer.FlushFragment() to make buffer empty.
 	ErrNotEmpty = fmt.Errorf("writer not empty")
 
+	// ErrTransformed is returned by Writer.WriteThrough() to indicate that
+	// payload of the current message is transformed by extensions, so raw
+	// bytes could not be written in the middle of it. That is, caller should
+	// call Writer.Flush() to finish the message first.
+	ErrTransformed = fmt.Errorf("writer is in the middle of transformed message")
+
 	// ErrControlOverflow is returned by ControlWriter.Write() to indicate that
 	// no more data could be written to the underlying io.Writer because
 	// MaxControlFramePayloadSize limit is reached.
@@ -130,6 +138,11 @@ func PutWriter(w *Writer) {
 //
 // After all data has been written, the client should call the Flush() method
 // to guarantee all data has been forwarded to the underlying io.Writer.
+//
+// If some of extensions implement SendTransform interface, Write() and
+// ReadFrom() transform (e.g. compress) the message payload with them, while
+// Flush() flushes the transformed data. Note that WriteThrough() writes bytes
+// as is, and so does the rest of the message started by it.
 type Writer struct {
 	// dest specifies a destination of buffer flushes.
 	dest io.Writer
@@ -145,9 +158,24 @@ type Writer struct {
 	// header RSV segment.
 	extensions []SendExtension
 
+	// transforms reports whether some of extensions transform payload.
+	transforms bool
+	// xw is the transforming writer of the current message; it is nil or
+	// points to xc.
+	xw io.WriteCloser
+	xc chainWriter
+	pw payloadWriter
+
 	// noFlush reports whether buffer must grow instead of being flushed.
 	noFlush bool
 
+	// rand is an optional source of masking keys.
+	rand io.Reader
+
+	// lock is an optional lock held while each frame is written to dest.
+	// It is used by Conn to send control frames between message fragments.
+	lock sync.Locker
+
 	// Raw representation of the buffer, including reserved header bytes.
 	raw []byte
 
@@ -238,7 +266,11 @@ func (w *Writer) Reset(dest io.Writer, state ws.State, op ws.OpCode) {
 	w.dirty = false
 	w.fseq = 0
 	w.extensions = w.extensions[:0]
+	w.transforms = false
+	w.xw = nil
 	w.noFlush = false
+	w.rand = nil
+	w.lock = nil
 }
 
 // ResetOp is an quick version of Reset().
@@ -249,11 +281,26 @@ func (w *Writer) ResetOp(op ws.OpCode) {
 	w.n = 0
 	w.dirty = false
 	w.fseq = 0
+	w.xw = nil
 }
 
 // SetExtensions adds xs as extensions to be used during writes.
+// Extensions implementing SendTransform are applied to payload of messages in
+// the given order.
 func (w *Writer) SetExtensions(xs ...SendExtension) {
 	w.extensions = xs
+	w.transforms = false
+	for _, x := range xs {
+		if _, ok := x.(SendTransform); ok {
+			w.transforms = true
+		}
+	}
+}
+
+// SetRand sets r as the source of masking keys for frames written by the
+// client side Writer. If r is nil, then ws.DefaultRand is used.
+func (w *Writer) SetRand(r io.Reader) {
+	w.rand = r
 }
 
 // DisableFlush denies Writer to write fragments.
@@ -284,6 +331,14 @@ func (w *Writer) Buffered() int {
 // with payload of N bytes will not fit into that buffer. Writer reserves some
 // space to fit WebSocket header data.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	if w.transforming() {
+		return w.transform().Write(p)
+	}
+	return w.write(p)
+}
+
+// write writes raw payload of the message.
+func (w *Writer) write(p []byte) (n int, err error) {
 	// Even empty p may make a sense.
 	w.dirty = true
 
@@ -299,7 +354,7 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 			// io.Writer when writing frame header.
 			//
 			// On large buffers additional write is better than copying.
-			nn, _ = w.WriteThrough(p)
+			nn, _ = w.writeThrough(p)
 		} else {
 			nn = copy(w.buf[w.n:], p)
 			w.n += nn
@@ -376,10 +431,27 @@ func (w *Writer) Grow(n int) {
 
 // WriteThrough writes data bypassing the buffer.
 // Note that Writer's buffer must be empty before calling WriteThrough().
+//
+// Frame header and data are written by ws.WriteFrame(), that is, by a single
+// writev(2) system call if destination is a *net.TCPConn. On the client side
+// data is copied to be masked anyway.
+//
+// If some of extensions transform payload, WriteThrough() returns
+// ErrTransformed in the middle of a transformed message. Otherwise the message
+// is sent as is, that is, without transformation of its further payload.
 func (w *Writer) WriteThrough(p []byte) (n int, err error) {
 	if w.err != nil {
 		return 0, w.err
 	}
+	if w.xw != nil {
+		return 0, ErrTransformed
+	}
+	return w.writeThrough(p)
+}
+
+// writeThrough writes p as a frame bypassing the buffer. Unlike
+// WriteThrough() it is also used to write transformed payload.
+func (w *Writer) writeThrough(p []byte) (n int, err error) {
 	if w.Buffered() != 0 {
 		return 0, ErrNotEmpty
 	}
@@ -402,13 +474,19 @@ func (w *Writer) WriteThrough(p []byte) (n int, err error) {
 		defer pbytes.Put(payload)
 		copy(payload, p)
 
+		mask, err := ws.NewMaskFrom(w.rand)
+		if err != nil {
+			return 0, err
+		}
 		frame.Payload = payload
-		frame = ws.MaskFrameInPlace(frame)
+		frame = ws.MaskFrameInPlaceWith(frame, mask)
 	} else {
 		frame.Payload = p
 	}
 
+	w.lockFrame()
 	w.err = ws.WriteFrame(w.dest, frame)
+	w.unlockFrame()
 	if w.err == nil {
 		n = len(p)
 	}
@@ -420,7 +498,23 @@ func (w *Writer) WriteThrough(p []byte) (n int, err error) {
 }
 
 // ReadFrom implements io.ReaderFrom.
+//
+// On Linux, if destination is a *net.TCPConn, frames are not masked and not
+// transformed, and src is an *os.File of regular file (possibly wrapped by an
+// *io.LimitedReader), then ReadFrom() writes src as a single frame bypassing
+// the buffer. Frame payload is moved by sendfile(2) system call without
+// copying it to user space. If the file is truncated while being sent,
+// ReadFrom() fails with io.ErrUnexpectedEOF since the frame can't be
+// completed. Other sources, including sockets, are buffered as usual: their
+// size is not known in advance, since io.LimitedReader.N is only an upper
+// bound, thus they are not spliced.
 func (w *Writer) ReadFrom(src io.Reader) (n int64, err error) {
+	if w.transforming() {
+		return io.Copy(w.transform(), src)
+	}
+	if r, size, ok := w.directSource(src); ok {
+		return w.readFromDirect(r, size)
+	}
 	var nn int
 	for err == nil {
 		if w.Available() == 0 {
@@ -462,11 +556,95 @@ func (w *Writer) ReadFrom(src io.Reader) (n int64, err error) {
 	return n, err
 }
 
+// directSource reports whether src could be written by readFromDirect(). It
+// returns src or its underlying reader and the size of payload.
+func (w *Writer) directSource(src io.Reader) (r *io.LimitedReader, size int64, ok bool) {
+	if w.err != nil || w.noFlush || w.state.ClientSide() || !sendfileDest(w.dest) {
+		return nil, 0, false
+	}
+	switch v := src.(type) {
+	case *os.File:
+		size, ok = fileRemaining(v)
+		r = &io.LimitedReader{R: v, N: size}
+	case *io.LimitedReader:
+		// Size of other sources (e.g. sockets) is not known even if they are
+		// limited: they could have less than N bytes.
+		f, isFile := v.R.(*os.File)
+		if !isFile {
+			break
+		}
+		// Do not wrap it twice, thus destination could unwrap it.
+		r = v
+		if size, ok = fileRemaining(f); ok && v.N < size {
+			size = v.N
+		}
+	}
+	// Small payload is cheaper to be buffered.
+	return r, size, ok && size > int64(w.Available())
+}
+
+// readFromDirect writes size bytes from r as a single frame bypassing the
+// buffer.
+func (w *Writer) readFromDirect(r *io.LimitedReader, size int64) (n int64, err error) {
+	if err = w.FlushFragment(); err != nil {
+		return 0, err
+	}
+	header := ws.Header{
+		OpCode: w.opCode(),
+		Fin:    false,
+		Length: size,
+	}
+	for _, x := range w.extensions {
+		header, err = x.SetBits(header)
+		if err != nil {
+			return 0, err
+		}
+	}
+	w.lockFrame()
+	defer w.unlockFrame()
+	if w.err = ws.WriteHeader(w.dest, header); w.err != nil {
+		return 0, w.err
+	}
+	w.dirty = true
+	w.fseq++
+
+	// NOTE: r.N might be greater than size, so limit it temporarily.
+	rest := r.N - size
+	r.N = size
+	n, w.err = w.dest.(io.ReaderFrom).ReadFrom(r)
+	r.N += rest
+	if w.err == nil && n < size {
+		// Frame is broken; there is no way to recover.
+		w.err = io.ErrUnexpectedEOF
+	}
+	return n, w.err
+}
+
+// fileRemaining returns number of bytes left to read from regular file f.
+func fileRemaining(f *os.File) (int64, bool) {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return 0, false
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil || off > fi.Size() {
+		return 0, false
+	}
+	return fi.Size() - off, true
+}
+
 // Flush writes any buffered data to the underlying io.Writer.
 // It sends the frame with "fin" flag set to true.
 //
 // If no Write() or ReadFrom() was made, then Flush() does nothing.
 func (w *Writer) Flush() error {
+	if w.xw != nil && w.err == nil {
+		// Flush transformed data into the buffer.
+		if err := w.xw.Close(); err != nil {
+			w.err = err
+		}
+		w.xw = nil
+	}
 	if (!w.dirty && w.Buffered() == 0) || w.err != nil {
 		return w.err
 	}
@@ -510,7 +688,10 @@ func (w *Writer) flushFragment(fin bool) (err error) {
 	}
 	if w.state.ClientSide() {
 		header.Masked = true
-		header.Mask = ws.NewMask()
+		header.Mask, err = ws.NewMaskFrom(w.rand)
+		if err != nil {
+			return err
+		}
 		ws.Cipher(payload, header.Mask, 0)
 	}
 	// Write header to the header segment of the raw buffer.
@@ -525,10 +706,59 @@ func (w *Writer) flushFragment(fin bool) (err error) {
 		// Must never be reached.
 		panic("dump header error: " + err.Error())
 	}
+	w.lockFrame()
 	_, err = w.dest.Write(w.raw[skip : offset+w.n])
+	w.unlockFrame()
 	return err
 }
 
+func (w *Writer) lockFrame() {
+	if w.lock != nil {
+		w.lock.Lock()
+	}
+}
+
+func (w *Writer) unlockFrame() {
+	if w.lock != nil {
+		w.lock.Unlock()
+	}
+}
+
+// transforming reports whether payload of the current message must be
+// transformed. It is false for the message started by WriteThrough().
+func (w *Writer) transforming() bool {
+	return w.transforms && w.err == nil && (w.xw != nil || w.fseq == 0)
+}
+
+// transform returns the transforming writer of the current message.
+func (w *Writer) transform() io.WriteCloser {
+	if w.xw != nil {
+		return w.xw
+	}
+	w.pw.w = w
+	w.xc.ws = w.xc.ws[:0]
+	var x io.Writer = &w.pw
+	for i := len(w.extensions) - 1; i >= 0; i-- {
+		if t, ok := w.extensions[i].(SendTransform); ok {
+			c := t.TransformWriter(x)
+			w.xc.ws = append(w.xc.ws, c)
+			x = c
+		}
+	}
+	w.xc.w = x
+	w.xw = &w.xc
+	return w.xw
+}
+
+// payloadWriter writes raw payload of the message.
+type payloadWriter struct {
+	w *Writer
+}
+
+func (p *payloadWriter) Write(b []byte) (int, error) {
+	return p.w.write(b)
+}
+
 func (w *Writer) opCode() ws.OpCode {
 	if w.fseq > 0 {
 		return ws.OpContinuation
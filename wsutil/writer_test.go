@@ -5,7 +5,10 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"net"
 	"reflect"
+	"runtime"
 	"strconv"
 	"testing"
 	"unsafe"
@@ -593,6 +596,221 @@ func TestWriterNoPreemtiveFlush(t *testing.T) {
 	}
 }
 
+func TestWriterSetRand(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, ws.StateClientSide, ws.OpText)
+	w.SetRand(ws.NewDeterministicRand(42))
+	if _, err := w.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.WriteThrough([]byte("world")); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := ws.NewDeterministicRand(42)
+	for _, f := range frames(t, buf.Bytes()) {
+		mask, _ := ws.NewMaskFrom(exp)
+		if !f.Header.Masked || f.Header.Mask != mask {
+			t.Errorf("unexpected frame mask: %x; want %x", f.Header.Mask, mask)
+		}
+	}
+}
+
+func TestWriterWriteThroughConn(t *testing.T) {
+	conn, peer := tcpPair(t)
+	var (
+		data = bytes.Repeat([]byte("hello, world! "), 1000)
+		orig = append([]byte(nil), data...)
+		done = make(chan error, 1)
+	)
+	go func() {
+		w := NewWriter(conn, ws.StateClientSide, ws.OpBinary)
+		_, err := w.WriteThrough(data)
+		if err == nil {
+			err = w.Flush()
+		}
+		conn.Close()
+		done <- err
+	}()
+	bts, err := ioutil.ReadAll(peer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, orig) {
+		t.Fatalf("WriteThrough() modified given data")
+	}
+	var act []byte
+	for _, f := range frames(t, bts) {
+		if !f.Header.Masked {
+			t.Fatalf("unexpected unmasked frame")
+		}
+		f = ws.UnmaskFrameInPlace(f)
+		act = append(act, f.Payload...)
+	}
+	if !bytes.Equal(act, data) {
+		t.Errorf("unexpected data received")
+	}
+}
+
+func TestWriterReadFromConn(t *testing.T) {
+	data := make([]byte, 100000)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	file, err := ioutil.TempFile(t.TempDir(), "payload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	direct := runtime.GOOS == "linux"
+	for _, test := range []struct {
+		name   string
+		state  ws.State
+		prefix []byte
+		source func(t *testing.T) io.Reader
+		direct bool
+	}{
+		{
+			name:  "file",
+			state: ws.StateServerSide,
+			source: func(t *testing.T) io.Reader {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				return file
+			},
+			direct: direct,
+		},
+		{
+			name:   "file/offset",
+			state:  ws.StateServerSide,
+			prefix: data[:10],
+			source: func(t *testing.T) io.Reader {
+				if _, err := file.Seek(10, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				return file
+			},
+			direct: direct,
+		},
+		{
+			name:  "limited file",
+			state: ws.StateServerSide,
+			source: func(t *testing.T) io.Reader {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				return io.LimitReader(file, int64(len(data))+100)
+			},
+			direct: direct,
+		},
+		{
+			name:  "short limited conn",
+			state: ws.StateServerSide,
+			source: func(t *testing.T) io.Reader {
+				src, peer := tcpPair(t)
+				go func() {
+					peer.Write(data)
+					peer.Close()
+				}()
+				return io.LimitReader(src, int64(len(data))+100)
+			},
+		},
+		{
+			name:  "client",
+			state: ws.StateClientSide,
+			source: func(t *testing.T) io.Reader {
+				if _, err := file.Seek(0, io.SeekStart); err != nil {
+					t.Fatal(err)
+				}
+				return file
+			},
+		},
+		{
+			name:  "reader",
+			state: ws.StateServerSide,
+			source: func(t *testing.T) io.Reader {
+				return bytes.NewReader(data)
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			conn, peer := tcpPair(t)
+			src := test.source(t)
+			done := make(chan error, 1)
+			go func() {
+				w := NewWriter(conn, test.state, ws.OpBinary)
+				_, err := w.Write(test.prefix)
+				if err == nil {
+					_, err = w.ReadFrom(src)
+				}
+				if err == nil {
+					err = w.Flush()
+				}
+				conn.Close()
+				done <- err
+			}()
+			bts, err := ioutil.ReadAll(peer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+			var (
+				act  []byte
+				size int
+			)
+			for _, f := range frames(t, bts) {
+				if f.Header.Masked {
+					f = ws.UnmaskFrameInPlace(f)
+				}
+				if n := len(f.Payload); n > size {
+					size = n
+				}
+				act = append(act, f.Payload...)
+			}
+			if !bytes.Equal(act, data) {
+				t.Errorf("unexpected data received")
+			}
+			if n := len(data) - len(test.prefix); test.direct && size != n {
+				t.Errorf("unexpected max frame size: %d; want %d", size, n)
+			}
+		})
+	}
+}
+
+func tcpPair(t *testing.T) (a, b net.Conn) {
+	ln, err := net.Listen("tcp", "localhost:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	a, err = net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err = ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		a.Close()
+		b.Close()
+	})
+	return a, b
+}
+
 type writeCounter struct {
 	n int
 }
@@ -52,6 +52,21 @@ Package is also exports tools for buffered writing:
 		// handle error
 	}
 
+For message-oriented communication there is a Conn type, which handles
+control frames, fragmentation and permessage-deflate compression negotiated
+during the handshake:
+
+	conn := wsutil.NewServerConn(netConn, br, hs)
+	for {
+		op, msg, err := conn.ReadMessage()
+		if err != nil {
+			// handle error
+		}
+		if err := conn.WriteMessage(op, msg); err != nil {
+			// handle error
+		}
+	}
+
 For more utils and helpers see the documentation.
 */
 package wsutil